@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"dns-server/internal/bench"
+	"dns-server/internal/client"
+	"dns-server/internal/soak"
+
+	"github.com/miekg/dns"
+)
+
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	useTCP := fs.Bool("tcp", false, "query over TCP")
+	corpusPath := fs.String("corpus", "", "file of queries, one name and optional type per line, instead of generated names")
+	names := fs.Int("names", 1000, "how many distinct names to generate")
+	domain := fs.String("domain", "example.com", "domain the generated names are under")
+	types := fs.String("types", "A,AAAA", "comma separated types of the generated queries")
+	zipf := fs.Float64("zipf", 0, "Zipf exponent of the name popularity, above 1 (0 = every name equally often)")
+	qps := fs.Float64("qps", 0, "queries per second at the start (0 = as fast as answers come)")
+	rampTo := fs.Float64("ramp-to", 0, "queries per second reached at the end, ramping linearly from -qps (0 = constant)")
+	duration := fs.Duration("duration", 10*time.Second, "how long to send queries")
+	concurrency := fs.Int("concurrency", 50, "queries in flight at most")
+	timeout := fs.Duration("timeout", 2*time.Second, "query timeout; slower answers count as errors")
+	maxErrorRate := fs.Float64("max-error-rate", 0, "exit with status 1 above this error rate (0 = never)")
+	jsonOutput := fs.Bool("json", false, "print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s bench [flags] [@server]\n", appName)
+		fmt.Fprintf(fs.Output(), "  server may be host[:port], tcp://, tls:// or an https:// DoH URL\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(reorderArgs(fs, args))
+
+	serverSpec := "127.0.0.1:53"
+	switch {
+	case fs.NArg() > 1 || fs.NArg() == 1 && !strings.HasPrefix(fs.Arg(0), "@"):
+		fs.Usage()
+		return 2
+	case fs.NArg() == 1:
+		serverSpec = fs.Arg(0)[1:]
+	}
+	if *useTCP && !strings.Contains(serverSpec, "://") {
+		serverSpec = "tcp://" + serverSpec
+	}
+	server, err := client.ParseServer(serverSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bench: %v\n", err)
+		return 2
+	}
+	if *duration <= 0 || *concurrency <= 0 || *timeout <= 0 {
+		fmt.Fprintln(os.Stderr, "bench: -duration, -concurrency and -timeout must be positive")
+		return 2
+	}
+	if *qps < 0 || *rampTo < 0 {
+		fmt.Fprintln(os.Stderr, "bench: -qps and -ramp-to must not be negative")
+		return 2
+	}
+	if *rampTo == 0 {
+		*rampTo = *qps
+	}
+
+	var questions []dns.Question
+	if *corpusPath != "" {
+		questions, err = soak.ReadCorpus(*corpusPath)
+	} else {
+		questions, err = generateQuestions(*names, *domain, *types)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bench: %v\n", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	report, err := bench.Run(ctx, &bench.Options{
+		Server:      server,
+		Questions:   questions,
+		Zipf:        *zipf,
+		StartQPS:    *qps,
+		EndQPS:      *rampTo,
+		Duration:    *duration,
+		Concurrency: *concurrency,
+		Timeout:     *timeout,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bench: %v\n", err)
+		return 1
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		fmt.Printf("%d queries to %s in %s: %.0f qps, %d errors (%.4f), %d skipped\n",
+			report.Sent, server, report.Duration.Round(time.Millisecond), report.QPS, report.Errors, report.ErrorRate, report.Skipped)
+		rcodes := make([]string, 0, len(report.Rcodes))
+		for rcode, count := range report.Rcodes {
+			rcodes = append(rcodes, fmt.Sprintf("%s %d", rcode, count))
+		}
+		slices.Sort(rcodes)
+		fmt.Printf("answers: %s\n", strings.Join(rcodes, ", "))
+		fmt.Printf("latency p50 %s p90 %s p99 %s p99.9 %s max %s\n",
+			report.P50.Round(time.Microsecond), report.P90.Round(time.Microsecond), report.P99.Round(time.Microsecond),
+			report.P999.Round(time.Microsecond), report.Max.Round(time.Microsecond))
+	}
+
+	if *maxErrorRate > 0 && report.ErrorRate > *maxErrorRate {
+		return 1
+	}
+	return 0
+}
+
+// generateQuestions asks for count names under domain, each with every one
+// of types.
+func generateQuestions(count int, domain, types string) ([]dns.Question, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("-names must be positive")
+	}
+	var qtypes []uint16
+	for _, name := range strings.Split(types, ",") {
+		qtype, ok := dns.StringToType[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %s", name)
+		}
+		qtypes = append(qtypes, qtype)
+	}
+
+	questions := make([]dns.Question, 0, count*len(qtypes))
+	for i := range count {
+		name := dns.Fqdn(fmt.Sprintf("host-%d.%s", i, strings.Trim(domain, ".")))
+		for _, qtype := range qtypes {
+			questions = append(questions, dns.Question{Name: name, Qtype: qtype, Qclass: dns.ClassINET})
+		}
+	}
+	return questions, nil
+}
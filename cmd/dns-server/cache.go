@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"dns-server/internal/admin"
+)
+
+func runCache(args []string) int {
+	fs := flag.NewFlagSet("cache", flag.ExitOnError)
+	adminAddr := fs.String("admin", "127.0.0.1:8053", "admin API address")
+	qtype := fs.String("type", "", "only entries of this type")
+	jsonOutput := fs.Bool("json", false, "print the entries as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s cache [flags] list [pattern] | show <name> | delete <name> | purge <suffix>\n", appName)
+		fmt.Fprintf(fs.Output(), "       %s cache [flags] dump <file> | load <file> | flush\n", appName)
+		fmt.Fprintf(fs.Output(), "  pattern is a glob such as *.example.com; purge also removes every name below suffix.\n")
+		fmt.Fprintf(fs.Output(), "  dump and load use the cache file format; - is stdout or stdin\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(reorderArgs(fs, args))
+
+	client := admin.NewClient(*adminAddr)
+
+	var (
+		entries []admin.CacheEntry
+		err     error
+	)
+
+	switch fs.Arg(0) {
+	case "list", "":
+		pattern := fs.Arg(1)
+		if pattern == "" {
+			pattern = "*"
+		}
+		entries, err = client.CacheEntries(pattern, *qtype)
+	case "show":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return 2
+		}
+		entries, err = client.CacheEntry(fs.Arg(1), *qtype)
+	case "delete", "purge":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return 2
+		}
+		var deleted int
+		if deleted, err = client.DeleteCache(fs.Arg(1), *qtype, fs.Arg(0) == "purge"); err == nil {
+			fmt.Printf("%d entries deleted\n", deleted)
+			return 0
+		}
+	case "flush":
+		var deleted int
+		if deleted, err = client.FlushCache(); err == nil {
+			fmt.Printf("%d entries deleted\n", deleted)
+			return 0
+		}
+	case "dump":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return 2
+		}
+		if err = dumpCache(client, fs.Arg(1)); err == nil {
+			return 0
+		}
+	case "load":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return 2
+		}
+		var loaded int
+		if loaded, err = loadCache(client, fs.Arg(1)); err == nil {
+			fmt.Printf("%d entries loaded\n", loaded)
+			return 0
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cache %s failed: %v\n", fs.Arg(0), err)
+		return 1
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(entries)
+		return 0
+	}
+
+	for _, e := range entries {
+		state := fmt.Sprintf("ttl %ds", e.TTL)
+		if e.Stale {
+			state = fmt.Sprintf("stale %ds", -e.TTL)
+		}
+		fmt.Printf("%-40s %-6s %-8s %-12s %d hits\n", e.Name, e.Type, e.Rcode, state, e.Hits)
+		for _, rr := range append(append(e.Answer, e.Authority...), e.Additional...) {
+			fmt.Printf("  %s %d %s %s\n", rr.Name, rr.TTL, rr.Type, strings.TrimSpace(rr.Data))
+		}
+	}
+
+	return 0
+}
+
+func dumpCache(client *admin.Client, path string) error {
+	if path == "-" {
+		return client.ExportCache(os.Stdout)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := client.ExportCache(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func loadCache(client *admin.Client, path string) (int, error) {
+	if path == "-" {
+		return client.ImportCache(os.Stdin)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return client.ImportCache(file)
+}
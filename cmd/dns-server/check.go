@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"dns-server/internal/config"
+)
+
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	path := fs.String("config", "config.toml", "path to configuration file")
+	jsonOutput := fs.Bool("json", false, "print issues as JSON")
+	strict := fs.Bool("strict", false, "treat warnings as errors")
+	var overrides stringList
+	fs.Var(&overrides, "set", "override a config value as key=value (repeatable)")
+	fs.Parse(args)
+
+	// check what the server would run with, DNS_ variables included
+	loader := config.NewConfigLoader()
+	loader.SetEnvironment(os.Environ())
+	loader.SetOverrides(overrides)
+	cfg, err := loader.Load(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *path, err)
+		return 1
+	}
+
+	issues := config.Lint(cfg)
+
+	if *jsonOutput {
+		if issues == nil {
+			issues = []config.LintIssue{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(issues)
+	} else {
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+		fmt.Printf("%s: %d issue(s)\n", *path, len(issues))
+	}
+
+	if config.HasErrors(issues) || (*strict && len(issues) > 0) {
+		return 1
+	}
+	return 0
+}
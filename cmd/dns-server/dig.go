@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"dns-server/internal/client"
+
+	"github.com/miekg/dns"
+)
+
+type digRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+	TTL   uint32 `json:"ttl"`
+	Data  string `json:"data"`
+}
+
+type digResult struct {
+	Server     string      `json:"server"`
+	Question   string      `json:"question"`
+	Type       string      `json:"type"`
+	Status     string      `json:"status"`
+	Flags      []string    `json:"flags"`
+	RTTMillis  float64     `json:"rtt_ms"`
+	Answer     []digRecord `json:"answer"`
+	Authority  []digRecord `json:"authority"`
+	Additional []digRecord `json:"additional"`
+}
+
+func runDig(args []string) int {
+	fs := flag.NewFlagSet("dig", flag.ExitOnError)
+	useTCP := fs.Bool("tcp", false, "query over TCP")
+	useTLS := fs.Bool("tls", false, "query over DNS-over-TLS")
+	useHTTPS := fs.Bool("https", false, "query over DNS-over-HTTPS")
+	jsonOutput := fs.Bool("json", false, "print the response as JSON")
+	timeout := fs.Duration("timeout", 5*time.Second, "query timeout")
+	noRecurse := fs.Bool("norecurse", false, "clear the RD bit")
+	dnssec := fs.Bool("dnssec", false, "set the DO bit")
+	authData := fs.Bool("ad", false, "set the AD bit")
+	checkingDisabled := fs.Bool("cd", false, "set the CD bit")
+	nsid := fs.Bool("nsid", false, "request the server's NSID (RFC 5001)")
+	insecure := fs.Bool("insecure", false, "skip TLS certificate verification for DoT/DoH")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s dig [flags] <name> [type] [@server]\n", appName)
+		fmt.Fprintf(fs.Output(), "  server may be host[:port], tcp://, tls:// or an https:// DoH URL\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(reorderArgs(fs, args))
+
+	var name, serverSpec string
+	qtype := dns.TypeA
+
+	for _, arg := range fs.Args() {
+		switch {
+		case strings.HasPrefix(arg, "@"):
+			serverSpec = arg[1:]
+		case dns.StringToType[strings.ToUpper(arg)] != 0 && name != "":
+			qtype = dns.StringToType[strings.ToUpper(arg)]
+		case name == "":
+			name = arg
+		default:
+			fs.Usage()
+			return 2
+		}
+	}
+
+	if name == "" {
+		fs.Usage()
+		return 2
+	}
+
+	if serverSpec == "" {
+		serverSpec = "127.0.0.1:53"
+	}
+	if !strings.Contains(serverSpec, "://") {
+		switch {
+		case *useHTTPS:
+			serverSpec = "https://" + serverSpec
+		case *useTLS:
+			serverSpec = "tls://" + serverSpec
+		case *useTCP:
+			serverSpec = "tcp://" + serverSpec
+		}
+	}
+
+	server, err := client.ParseServer(serverSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dig: %v\n", err)
+		return 2
+	}
+
+	msg := &dns.Msg{}
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.RecursionDesired = !*noRecurse
+	msg.AuthenticatedData = *authData
+	msg.CheckingDisabled = *checkingDisabled
+	msg.SetEdns0(dns.DefaultMsgSize, *dnssec)
+	if *nsid {
+		opt := msg.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	c := client.New(*timeout)
+	if *insecure {
+		c.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	response, rtt, err := c.Exchange(ctx, msg, server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dig: %v\n", err)
+		return 1
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(newDigResult(response, server, rtt))
+		return 0
+	}
+
+	fmt.Println(response.String())
+	fmt.Printf(";; Query time: %d msec\n", rtt.Milliseconds())
+	fmt.Printf(";; SERVER: %s\n", server)
+	fmt.Printf(";; WHEN: %s\n", time.Now().Format(time.RFC1123))
+	fmt.Printf(";; MSG SIZE  rcvd: %d\n", response.Len())
+	return 0
+}
+
+// reorderArgs moves flags after positional arguments to the front so that
+// "dig example.com MX -json" works like dig does.
+func reorderArgs(fs *flag.FlagSet, args []string) []string {
+	var flags, positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+
+		flags = append(flags, arg)
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		if f := fs.Lookup(name); f != nil {
+			if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && bf.IsBoolFlag() {
+				continue
+			}
+			if i+1 < len(args) {
+				i++
+				flags = append(flags, args[i])
+			}
+		}
+	}
+
+	return append(flags, positional...)
+}
+
+func newDigResult(msg *dns.Msg, server client.Server, rtt time.Duration) digResult {
+	result := digResult{
+		Server:     server.String(),
+		Status:     dns.RcodeToString[msg.Rcode],
+		Flags:      []string{},
+		RTTMillis:  float64(rtt.Microseconds()) / 1000,
+		Answer:     digRecords(msg.Answer),
+		Authority:  digRecords(msg.Ns),
+		Additional: digRecords(msg.Extra),
+	}
+
+	if len(msg.Question) > 0 {
+		result.Question = msg.Question[0].Name
+		result.Type = dns.TypeToString[msg.Question[0].Qtype]
+	}
+
+	for _, flag := range []struct {
+		name string
+		set  bool
+	}{
+		{"qr", msg.Response},
+		{"aa", msg.Authoritative},
+		{"tc", msg.Truncated},
+		{"rd", msg.RecursionDesired},
+		{"ra", msg.RecursionAvailable},
+		{"ad", msg.AuthenticatedData},
+		{"cd", msg.CheckingDisabled},
+	} {
+		if flag.set {
+			result.Flags = append(result.Flags, flag.name)
+		}
+	}
+
+	return result
+}
+
+func digRecords(rrs []dns.RR) []digRecord {
+	records := make([]digRecord, 0, len(rrs))
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+
+		hdr := rr.Header()
+		records = append(records, digRecord{
+			Name:  hdr.Name,
+			Type:  dns.TypeToString[hdr.Rrtype],
+			Class: dns.ClassToString[hdr.Class],
+			TTL:   hdr.Ttl,
+			Data:  strings.TrimPrefix(rr.String(), hdr.String()),
+		})
+	}
+	return records
+}
@@ -1,23 +1,14 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
+	"runtime"
+	"strings"
 
 	"dns-server/internal/config"
-	"dns-server/internal/server"
-	"dns-server/pkg/logger"
-
-	"github.com/sirupsen/logrus"
-)
-
-var (
-	configPath = flag.String("config", "config.toml", "path to configuration file")
-	version    = flag.Bool("version", false, "show version information")
+	"dns-server/internal/features"
 )
 
 const (
@@ -25,49 +16,104 @@ const (
 	appVersion = "1.0.0"
 )
 
+var commands = []struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}{
+	{"serve", "run the DNS server (the default without a command)", runServe},
+	{"check", "validate and lint a config file", runCheck},
+	{"version", "show version information and compiled-in features", runVersion},
+	{"query", "query a DNS server, by default the local one", runDig},
+	{"dig", "same as query", runDig},
+	{"cache", "list, show, delete, dump, load or flush cache entries of a running server", runCache},
+	{"records", "list, add or delete dynamic records of a running server", runRecords},
+	{"profile", "list, enable or disable record profiles of a running server", runProfile},
+	{"state", "show the runtime state of a running server", runState},
+	{"replay", "replay a capture against a config and diff the answers", runReplay},
+	{"soak", "replay a corpus under a chaos profile and check SLOs", runSoak},
+	{"bench", "send generated query load to a server and report latency", runBench},
+}
+
 func main() {
-	flag.Parse()
+	// flags without a command start the server, as before there were commands
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
 
-	if *version {
-		fmt.Printf("%s version %s\n", appName, appVersion)
+	if command == "help" {
+		usage()
 		os.Exit(0)
 	}
+	for _, c := range commands {
+		if c.name == command {
+			os.Exit(c.run(args))
+		}
+	}
 
-	configLoader := config.NewTOMLConfigLoader()
-	cfg, err := configLoader.Load(*configPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
-		os.Exit(1)
+	fmt.Fprintf(os.Stderr, "%s: unknown command %q\n\n", appName, command)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [command] [flags]\n\ncommands:\n", appName)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.summary)
 	}
+	fmt.Fprintf(os.Stderr, "\nrun %s <command> -h for the flags of a command\n", appName)
+}
 
-	log := logger.NewLogger(&cfg.Logging)
+// stringList collects the values of a flag given more than once.
+type stringList []string
 
-	log.WithFields(logrus.Fields{
-		"version":     appVersion,
-		"config_file": *configPath,
-	}).Info("starting DNS server")
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
 
-	srv, err := server.NewServer(cfg, log)
-	if err != nil {
-		log.WithError(err).Fatal("failed to create server")
-	}
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func runVersion(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	path := fs.String("config", "config.toml", "path to configuration file")
+	verbose := fs.Bool("verbose", false, "also list features and whether the config enables them")
+	fs.Parse(args)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	printVersion(*path, *verbose)
+	return 0
+}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+func printVersion(path string, verbose bool) {
+	fmt.Printf("%s version %s\n", appName, appVersion)
+	if verbose {
+		printFeatures(path)
+	}
+}
 
-	go func() {
-		sig := <-sigChan
-		log.WithField("signal", sig.String()).Info("received shutdown signal")
-		cancel()
-	}()
+// printFeatures lists the compiled-in features, marking the ones the config
+// at path enables when it can be loaded.
+func printFeatures(path string) {
+	fmt.Printf("%s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
 
-	if err := srv.Start(ctx); err != nil {
-		log.WithError(err).Fatal("failed to start server")
+	cfg, err := config.NewConfigLoader().Load(path)
+	if err != nil {
+		fmt.Println("features:")
+		for _, name := range features.Names() {
+			fmt.Printf("  %s\n", name)
+		}
+		return
 	}
 
-	srv.Wait()
-	log.Info("DNS server shutdown complete")
+	fmt.Printf("features (+ enabled by %s):\n", path)
+	for _, feature := range features.List(cfg) {
+		mark := "-"
+		if feature.Enabled {
+			mark = "+"
+		}
+		fmt.Printf("  %s %s\n", mark, feature.Name)
+	}
 }
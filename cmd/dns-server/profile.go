@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"dns-server/internal/admin"
+	"dns-server/internal/resolver"
+)
+
+func runProfile(args []string) int {
+	fs := flag.NewFlagSet("profile", flag.ExitOnError)
+	adminAddr := fs.String("admin", "127.0.0.1:8053", "admin API address")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s profile [-admin addr] list|enable|disable [name]\n", appName)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	client := admin.NewClient(*adminAddr)
+
+	var (
+		profiles []resolver.ProfileStatus
+		err      error
+	)
+
+	switch fs.Arg(0) {
+	case "list", "":
+		profiles, err = client.Profiles()
+	case "enable", "disable":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return 2
+		}
+		profiles, err = client.SetProfileEnabled(fs.Arg(1), fs.Arg(0) == "enable")
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "profile %s failed: %v\n", fs.Arg(0), err)
+		return 1
+	}
+
+	for _, p := range profiles {
+		state := "disabled"
+		if p.Enabled {
+			state = "enabled"
+		}
+		fmt.Printf("%-24s %-8s %d records\n", p.Name, state, p.Records)
+	}
+
+	return 0
+}
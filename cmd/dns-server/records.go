@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"dns-server/internal/admin"
+)
+
+func runRecords(args []string) int {
+	fs := flag.NewFlagSet("records", flag.ExitOnError)
+	adminAddr := fs.String("admin", "127.0.0.1:8053", "admin API address")
+	ttl := fs.Uint("ttl", 300, "TTL of added records")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s records [flags] list | add <name> <type> <data> | del <name> <type> <data>\n", appName)
+		fs.PrintDefaults()
+	}
+	fs.Parse(reorderArgs(fs, args))
+
+	client := admin.NewClient(*adminAddr)
+
+	var (
+		records []admin.Record
+		err     error
+	)
+
+	switch fs.Arg(0) {
+	case "list", "":
+		records, err = client.Records()
+	case "add", "del":
+		if fs.NArg() < 4 {
+			fs.Usage()
+			return 2
+		}
+		record := admin.Record{
+			Name: fs.Arg(1),
+			Type: strings.ToUpper(fs.Arg(2)),
+			TTL:  uint32(*ttl),
+			Data: strings.Join(fs.Args()[3:], " "),
+		}
+		records, err = client.UpdateRecords([]admin.Record{record}, fs.Arg(0) == "add")
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "records %s failed: %v\n", fs.Arg(0), err)
+		return 1
+	}
+
+	for _, r := range records {
+		fmt.Printf("%-40s %-6d %-6s %s\n", r.Name, r.TTL, r.Type, strings.TrimSpace(r.Data))
+	}
+	return 0
+}
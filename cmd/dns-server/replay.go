@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"dns-server/internal/config"
+	"dns-server/internal/replay"
+
+	"github.com/sirupsen/logrus"
+)
+
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	path := fs.String("config", "config.toml", "path to configuration file")
+	port := fs.Uint("port", 53, "DNS server port in the capture")
+	jsonOutput := fs.Bool("json", false, "print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s replay [flags] <capture.pcap>\n", appName)
+		fs.PrintDefaults()
+	}
+	fs.Parse(reorderArgs(fs, args))
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := config.NewConfigLoader().Load(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	exchanges, err := replay.ReadExchanges(file, uint16(*port))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 1
+	}
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	report := replay.Run(cfg, exchanges, log)
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, d := range report.Differences {
+			fmt.Printf("%s %s from %s: %s -> %s\n", d.Question, d.Type, d.Client, d.OldRcode, d.NewRcode)
+			for _, rr := range d.Removed {
+				fmt.Printf("  - %s\n", strings.ReplaceAll(rr, "\t", " "))
+			}
+			for _, rr := range d.Added {
+				fmt.Printf("  + %s\n", strings.ReplaceAll(rr, "\t", " "))
+			}
+		}
+		fmt.Printf("%d queries, %d identical, %d changed, %d without recorded answer\n",
+			report.Queries, report.Identical, len(report.Differences), report.Unanswered)
+	}
+
+	if len(report.Differences) > 0 {
+		return 1
+	}
+	return 0
+}
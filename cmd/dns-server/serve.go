@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/handoff"
+	"dns-server/internal/pidfile"
+	"dns-server/internal/server"
+	"dns-server/pkg/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+// upgradeTimeout is how long a new process gets to start serving on the
+// sockets handed to it.
+const upgradeTimeout = 30 * time.Second
+
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	path := fs.String("config", "config.toml", "path to configuration file")
+	var overrides stringList
+	fs.Var(&overrides, "set", "override a config value as key=value, e.g. server.port=5353 (repeatable)")
+	// -version predates the version subcommand
+	version := fs.Bool("version", false, "show version information")
+	verbose := fs.Bool("verbose", false, "with -version, also list features and whether the config enables them")
+	fs.Parse(args)
+
+	if *version {
+		printVersion(*path, *verbose)
+		return 0
+	}
+
+	configLoader := config.NewConfigLoader()
+	configLoader.SetEnvironment(os.Environ())
+	configLoader.SetOverrides(overrides)
+	cfg, err := configLoader.Load(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	logs, err := logger.NewManager(&cfg.Logging)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set up logging: %v\n", err)
+		return 1
+	}
+	defer logs.Close()
+	log := logs.Root()
+
+	log.WithFields(logrus.Fields{
+		"version":     appVersion,
+		"config_file": *path,
+	}).Info("starting DNS server")
+
+	for _, issue := range config.Lint(cfg) {
+		entry := log.WithFields(logrus.Fields{
+			"scope":    issue.Scope,
+			"severity": issue.Severity,
+		})
+		if issue.Severity == config.SeverityInfo {
+			entry.Info(issue.Message)
+		} else {
+			entry.Warn(issue.Message)
+		}
+	}
+
+	var pid *pidfile.File
+	if cfg.Server.PIDFile != "" {
+		if pid, err = pidfile.Acquire(cfg.Server.PIDFile); err != nil {
+			log.WithError(err).Fatal("another instance appears to be running")
+		}
+		defer func() {
+			// after an upgrade the pidfile belongs to the new process
+			if pid == nil {
+				return
+			}
+			if err := pid.Release(); err != nil {
+				log.WithError(err).Warn("failed to release pidfile")
+			}
+		}()
+	}
+
+	srv, err := server.NewServer(cfg, logs)
+	if err != nil {
+		log.WithError(err).Fatal("failed to create server")
+	}
+	srv.SetConfigPath(*path)
+	srv.SetConfigLoader(configLoader)
+	srv.SetVersion(appVersion)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}, upgradeSignals...)...)
+
+	go func() {
+		for sig := range sigChan {
+			if sig == syscall.SIGHUP {
+				if err := srv.ReopenLogs(); err != nil {
+					log.WithError(err).Error("failed to reopen log output")
+				}
+				continue
+			}
+			if slices.Contains(upgradeSignals, sig) {
+				if !upgrade(srv, &pid, cfg.Server.PIDFile, log) {
+					continue
+				}
+				cancel()
+				return
+			}
+
+			log.WithField("signal", sig.String()).Info("received shutdown signal")
+			cancel()
+			return
+		}
+	}()
+
+	if handoff.Inherited() {
+		log.Info("taking over listeners from the previous process")
+	}
+	if err := srv.Start(ctx); err != nil {
+		log.WithError(err).Fatal("failed to start server")
+	}
+	if err := handoff.Ready(); err != nil {
+		log.WithError(err).Warn("failed to tell the previous process this one is serving")
+	}
+
+	srv.Wait()
+	log.Info("DNS server shutdown complete")
+	return 0
+}
+
+// upgrade hands the server's sockets to a new process and reports whether
+// it took over. The pidfile is released for the new process to acquire,
+// and taken back if the upgrade fails.
+func upgrade(srv *server.Server, pid **pidfile.File, path string, log *logrus.Logger) bool {
+	if *pid != nil {
+		if err := (*pid).Release(); err != nil {
+			log.WithError(err).Warn("failed to release pidfile")
+		}
+		*pid = nil
+	}
+
+	if _, err := srv.Upgrade(upgradeTimeout); err != nil {
+		log.WithError(err).Error("still serving from this process")
+		if path != "" {
+			acquired, err := pidfile.Acquire(path)
+			if err != nil {
+				log.WithError(err).Warn("failed to take the pidfile back")
+			}
+			*pid = acquired
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// Upgrades need inheritable sockets, which only unix systems have.
+var upgradeSignals []os.Signal
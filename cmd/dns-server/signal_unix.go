@@ -0,0 +1,12 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals ask a running server to hand its sockets to a new process
+// started from the binary on disk.
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/soak"
+	"dns-server/pkg/logger"
+)
+
+func runSoak(args []string) int {
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	path := fs.String("config", "config.toml", "configuration with the [chaos] profile to run under")
+	corpusPath := fs.String("corpus", "", "query corpus, overriding chaos.corpus")
+	duration := fs.Duration("duration", 0, "how long to replay the corpus, overriding chaos.duration")
+	jsonOutput := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	if _, err := os.Stat(*path); err != nil {
+		fmt.Fprintf(os.Stderr, "soak: %v\n", err)
+		return 1
+	}
+	cfg, err := config.NewConfigLoader().Load(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %v\n", err)
+		return 1
+	}
+	if *corpusPath != "" {
+		cfg.Chaos.Corpus = *corpusPath
+	}
+	if *duration > 0 {
+		cfg.Chaos.Duration = *duration
+	}
+	if cfg.Chaos.Corpus == "" {
+		fmt.Fprintln(os.Stderr, "soak: no corpus; set chaos.corpus or -corpus")
+		return 2
+	}
+
+	corpus, err := soak.ReadCorpus(cfg.Chaos.Corpus)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "soak: %v\n", err)
+		return 1
+	}
+
+	// the server logs only errors, and to stderr, so the report stays
+	// readable
+	cfg.Logging.Level = "error"
+	cfg.Logging.Output = "stderr"
+	logs, err := logger.NewManager(&cfg.Logging)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to set up logging: %v\n", err)
+		return 1
+	}
+	defer logs.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	report, err := soak.Run(ctx, cfg, corpus, logs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "soak: %v\n", err)
+		return 1
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		fmt.Printf("%d queries in %s: %d answered, %d SERVFAIL, %d errors\n",
+			report.Queries, report.Duration.Round(time.Millisecond), report.Answered, report.Servfail, report.Errors)
+		fmt.Printf("success rate %.4f, SERVFAIL rate %.4f, latency p50 %s p99 %s max %s\n",
+			report.SuccessRate, report.ServfailRate, report.P50.Round(time.Microsecond), report.P99.Round(time.Microsecond), report.Max.Round(time.Microsecond))
+		fmt.Printf("server: %d cache hits, %d upstream, %d stale, %d fallback, %d failures\n",
+			report.Server.CacheHits, report.Server.UpstreamAnswers, report.Server.StaleAnswers, report.Server.FallbackAnswers, report.Server.Failures)
+		for i, u := range report.Upstreams {
+			fmt.Printf("upstream %d: %d queries, %d answered, %d dropped, %d SERVFAIL, %d malformed, %d delayed, %d during outage\n",
+				i, u.Queries, u.Answered, u.Dropped, u.Servfail, u.Malformed, u.Delayed, u.Outage)
+		}
+		for _, v := range report.Violations {
+			fmt.Printf("SLO violated: %s\n", v)
+		}
+	}
+
+	if report.Failed() {
+		return 1
+	}
+	return 0
+}
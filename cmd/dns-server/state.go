@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"dns-server/internal/admin"
+)
+
+func runState(args []string) int {
+	fs := flag.NewFlagSet("state", flag.ExitOnError)
+	adminAddr := fs.String("admin", "127.0.0.1:8053", "admin API address")
+	diff := fs.Bool("diff", false, "diff the runtime state against the config file on disk")
+	fs.Parse(args)
+
+	path := "/state"
+	if *diff {
+		path = "/state/diff"
+	}
+
+	var out json.RawMessage
+	if err := admin.NewClient(*adminAddr).Do(http.MethodGet, path, nil, &out); err != nil {
+		fmt.Fprintf(os.Stderr, "state: %v\n", err)
+		return 1
+	}
+
+	var pretty any
+	json.Unmarshal(out, &pretty)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(pretty)
+
+	if *diff {
+		var result struct {
+			InSync bool `json:"in_sync"`
+		}
+		if json.Unmarshal(out, &result) == nil && !result.InSync {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,103 @@
+package acl
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+var errDenied = errors.New("client not allowed")
+
+// List decides which client addresses a listener serves. Deny entries win
+// over allow entries; an empty allow list allows every address.
+type List struct {
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	dropped atomic.Uint64
+}
+
+func New(allow, deny []string) (*List, error) {
+	l := &List{}
+	for _, entry := range allow {
+		network, err := config.ParseNetwork(entry)
+		if err != nil {
+			return nil, err
+		}
+		l.allow = append(l.allow, network)
+	}
+	for _, entry := range deny {
+		network, err := config.ParseNetwork(entry)
+		if err != nil {
+			return nil, err
+		}
+		l.deny = append(l.deny, network)
+	}
+	return l, nil
+}
+
+func (l *List) Allowed(addr net.Addr) bool {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	default:
+		return false
+	}
+
+	for _, network := range l.deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(l.allow) == 0 {
+		return true
+	}
+	for _, network := range l.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dropped counts packets and connections refused so far.
+func (l *List) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// Decorate is a dns.Server DecorateReader. Datagrams from denied clients are
+// discarded and their connections closed before the message is unpacked, so
+// they never reach the handler and get no response.
+func (l *List) Decorate(next dns.Reader) dns.Reader {
+	return &reader{Reader: next, list: l}
+}
+
+type reader struct {
+	dns.Reader
+	list *List
+}
+
+func (r *reader) ReadTCP(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	if !r.list.Allowed(conn.RemoteAddr()) {
+		r.list.dropped.Add(1)
+		return nil, errDenied
+	}
+	return r.Reader.ReadTCP(conn, timeout)
+}
+
+func (r *reader) ReadUDP(conn *net.UDPConn, timeout time.Duration) ([]byte, *dns.SessionUDP, error) {
+	for {
+		msg, session, err := r.Reader.ReadUDP(conn, timeout)
+		if err != nil || r.list.Allowed(session.RemoteAddr()) {
+			return msg, session, err
+		}
+		r.list.dropped.Add(1)
+	}
+}
@@ -0,0 +1,107 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"dns-server/internal/cache"
+	"dns-server/internal/config"
+	"dns-server/internal/handoff"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Server struct {
+	config *config.AdminConfig
+	mux    *http.ServeMux
+	server *http.Server
+	logger *logrus.Logger
+	wg     sync.WaitGroup
+}
+
+func NewServer(cfg *config.AdminConfig, logger *logrus.Logger) *Server {
+	mux := http.NewServeMux()
+
+	return &Server{
+		config: cfg,
+		mux:    mux,
+		server: &http.Server{
+			Addr:              net.JoinHostPort(cfg.BindAddress, fmt.Sprint(cfg.Port)),
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+		logger: logger,
+	}
+}
+
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) HandleFunc(pattern string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+func (s *Server) Addr() string {
+	return s.server.Addr
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	listener, err := handoff.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.server.Addr, err)
+	}
+
+	s.logger.WithField("address", s.server.Addr).Info("starting admin API")
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.WithError(err).Error("admin API stopped")
+		}
+	}()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := s.server.Shutdown(shutdownCtx); err != nil {
+			s.logger.WithError(err).Error("error during admin API shutdown")
+		}
+	}()
+
+	return nil
+}
+
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// localChanged drops every cached answer after the local records changed.
+// Local answers are cached alongside upstream ones, and an entry does not
+// record which local records it came from, so a change anywhere may have
+// made any of them stale.
+func localChanged(dnsCache cache.Cache) {
+	dnsCache.Clear()
+}
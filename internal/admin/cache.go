@@ -0,0 +1,240 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"dns-server/internal/cache"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+type CacheEntry struct {
+	Key        string    `json:"key"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Rcode      string    `json:"rcode"`
+	TTL        int64     `json:"ttl"`
+	Stale      bool      `json:"stale"`
+	Hits       int       `json:"hits"`
+	Size       int       `json:"size"`
+	StoredAt   time.Time `json:"stored_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Source     string    `json:"source,omitempty"`
+	Rank       string    `json:"rank"`
+	Answer     []Record  `json:"answer,omitempty"`
+	Authority  []Record  `json:"authority,omitempty"`
+	Additional []Record  `json:"additional,omitempty"`
+}
+
+type cacheDeleteResult struct {
+	Deleted int `json:"deleted"`
+}
+
+type cacheLoadResult struct {
+	Loaded int `json:"loaded"`
+}
+
+// RegisterCache serves the cache for debugging. GET /cache lists entries
+// whose name matches the glob in pattern, optionally of one type;
+// GET /cache/{name} decodes the entries for a name with the TTLs clients
+// would get now; DELETE /cache removes the entries for name, or for suffix
+// and every name below it, and POST /cache/flush all of them. GET
+// /cache/export and POST /cache/import move the entries in the cache file
+// format.
+func (s *Server) RegisterCache(dnsCache *cache.LRUCache) {
+	s.HandleFunc("GET /cache", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		pattern := normalizeName(query.Get("pattern"))
+		if pattern == "" {
+			pattern = "*"
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid pattern: %s", query.Get("pattern")))
+			return
+		}
+		qtype, err := parseType(query.Get("type"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		limit := 0
+		if value := query.Get("limit"); value != "" {
+			if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", value))
+				return
+			}
+		}
+
+		entries := dnsCache.Entries(func(name, entryType string) bool {
+			matched, _ := path.Match(pattern, name)
+			return matched && (qtype == "" || entryType == qtype)
+		})
+		if limit > 0 && len(entries) > limit {
+			entries = entries[:limit]
+		}
+
+		now := time.Now()
+		out := make([]CacheEntry, 0, len(entries))
+		for i := range entries {
+			out = append(out, describeEntry(&entries[i], now, false))
+		}
+		writeJSON(w, http.StatusOK, out)
+	})
+
+	s.HandleFunc("GET /cache/{name}", func(w http.ResponseWriter, r *http.Request) {
+		target := normalizeName(r.PathValue("name"))
+		qtype, err := parseType(r.URL.Query().Get("type"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		entries := dnsCache.Entries(func(name, entryType string) bool {
+			return name == target && (qtype == "" || entryType == qtype)
+		})
+		if len(entries) == 0 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no cache entry for %s", target))
+			return
+		}
+
+		now := time.Now()
+		out := make([]CacheEntry, 0, len(entries))
+		for i := range entries {
+			out = append(out, describeEntry(&entries[i], now, true))
+		}
+		writeJSON(w, http.StatusOK, out)
+	})
+
+	s.HandleFunc("DELETE /cache", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		target, suffix := normalizeName(query.Get("name")), normalizeName(query.Get("suffix"))
+		if (target == "") == (suffix == "") {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("exactly one of name or suffix is required"))
+			return
+		}
+		qtype, err := parseType(query.Get("type"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		deleted := dnsCache.DeleteMatching(func(name, entryType string) bool {
+			if qtype != "" && entryType != qtype {
+				return false
+			}
+			if suffix != "" {
+				return name == suffix || strings.HasSuffix(name, "."+suffix)
+			}
+			return name == target
+		})
+
+		s.logger.WithFields(logrus.Fields{
+			"name":    target,
+			"suffix":  suffix,
+			"type":    qtype,
+			"deleted": deleted,
+			"remote":  r.RemoteAddr,
+		}).Info("cache entries deleted via admin API")
+
+		writeJSON(w, http.StatusOK, cacheDeleteResult{Deleted: deleted})
+	})
+
+	s.HandleFunc("POST /cache/flush", func(w http.ResponseWriter, r *http.Request) {
+		deleted := dnsCache.Size()
+		dnsCache.Clear()
+
+		s.logger.WithFields(logrus.Fields{
+			"deleted": deleted,
+			"remote":  r.RemoteAddr,
+		}).Info("cache flushed via admin API")
+
+		writeJSON(w, http.StatusOK, cacheDeleteResult{Deleted: deleted})
+	})
+
+	s.HandleFunc("GET /cache/export", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := dnsCache.Dump(w); err != nil {
+			s.logger.WithError(err).Warn("failed to export cache")
+		}
+	})
+
+	s.HandleFunc("POST /cache/import", func(w http.ResponseWriter, r *http.Request) {
+		loaded, err := dnsCache.Load(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%w (%d entries loaded)", err, loaded))
+			return
+		}
+
+		s.logger.WithFields(logrus.Fields{
+			"loaded": loaded,
+			"remote": r.RemoteAddr,
+		}).Info("cache imported via admin API")
+
+		writeJSON(w, http.StatusOK, cacheLoadResult{Loaded: loaded})
+	})
+}
+
+func describeEntry(entry *cache.EntryInfo, now time.Time, records bool) CacheEntry {
+	remaining := entry.Remaining(now)
+	out := CacheEntry{
+		Key:       entry.Key,
+		Name:      entry.Name,
+		Type:      entry.Type,
+		Rcode:     dns.RcodeToString[entry.Response.Rcode],
+		TTL:       int64(remaining / time.Second),
+		Stale:     remaining < 0,
+		Hits:      entry.Hits,
+		Size:      entry.Size,
+		StoredAt:  entry.StoredAt,
+		ExpiresAt: entry.ExpiresAt,
+		Source:    entry.Source,
+		Rank:      entry.Rank.String(),
+	}
+	if records {
+		elapsed := uint32(now.Sub(entry.StoredAt) / time.Second)
+		out.Answer = describeRRs(entry.Response.Answer, elapsed)
+		out.Authority = describeRRs(entry.Response.Ns, elapsed)
+		out.Additional = describeRRs(entry.Response.Extra, elapsed)
+	}
+	return out
+}
+
+// describeRRs lists rrs with the TTLs lowered by elapsed seconds, as a
+// cache hit would return them.
+func describeRRs(rrs []dns.RR, elapsed uint32) []Record {
+	var out []Record
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeOPT {
+			continue
+		}
+		out = append(out, Record{
+			Name: strings.TrimSuffix(hdr.Name, "."),
+			Type: dns.TypeToString[hdr.Rrtype],
+			TTL:  hdr.Ttl - min(hdr.Ttl, elapsed),
+			Data: strings.TrimPrefix(rr.String(), hdr.String()),
+		})
+	}
+	return out
+}
+
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+func parseType(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	value = strings.ToUpper(value)
+	if _, ok := dns.StringToType[value]; !ok {
+		return "", fmt.Errorf("unknown type: %s", value)
+	}
+	return value, nil
+}
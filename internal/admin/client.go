@@ -0,0 +1,176 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"dns-server/internal/resolver"
+)
+
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+func NewClient(addr string) *Client {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+
+	return &Client{
+		baseURL: strings.TrimSuffix(addr, "/"),
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Client) Do(method, path string, body io.Reader, out any) error {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("admin request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("admin API returned %s: %s", resp.Status, apiErr.Error)
+		}
+		return fmt.Errorf("admin API returned %s", resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) Profiles() ([]resolver.ProfileStatus, error) {
+	var out []resolver.ProfileStatus
+	err := c.Do(http.MethodGet, "/profiles", nil, &out)
+	return out, err
+}
+
+func (c *Client) SetProfileEnabled(name string, enabled bool) ([]resolver.ProfileStatus, error) {
+	action := "disable"
+	if enabled {
+		action = "enable"
+	}
+
+	var out []resolver.ProfileStatus
+	err := c.Do(http.MethodPost, "/profiles/"+url.PathEscape(name)+"/"+action, nil, &out)
+	return out, err
+}
+
+// CacheEntries lists the cache entries whose name matches the glob pattern,
+// optionally of one type.
+func (c *Client) CacheEntries(pattern, qtype string) ([]CacheEntry, error) {
+	query := url.Values{}
+	query.Set("pattern", pattern)
+	if qtype != "" {
+		query.Set("type", qtype)
+	}
+
+	var out []CacheEntry
+	err := c.Do(http.MethodGet, "/cache?"+query.Encode(), nil, &out)
+	return out, err
+}
+
+func (c *Client) CacheEntry(name, qtype string) ([]CacheEntry, error) {
+	path := "/cache/" + url.PathEscape(name)
+	if qtype != "" {
+		path += "?type=" + url.QueryEscape(qtype)
+	}
+
+	var out []CacheEntry
+	err := c.Do(http.MethodGet, path, nil, &out)
+	return out, err
+}
+
+// DeleteCache removes the entries for name, or with suffix set for name and
+// every name below it, and returns how many were removed.
+func (c *Client) DeleteCache(name, qtype string, suffix bool) (int, error) {
+	query := url.Values{}
+	if suffix {
+		query.Set("suffix", name)
+	} else {
+		query.Set("name", name)
+	}
+	if qtype != "" {
+		query.Set("type", qtype)
+	}
+
+	var out cacheDeleteResult
+	err := c.Do(http.MethodDelete, "/cache?"+query.Encode(), nil, &out)
+	return out.Deleted, err
+}
+
+func (c *Client) FlushCache() (int, error) {
+	var out cacheDeleteResult
+	err := c.Do(http.MethodPost, "/cache/flush", nil, &out)
+	return out.Deleted, err
+}
+
+// ExportCache copies the entries of the cache, in the cache file format, to
+// w.
+func (c *Client) ExportCache(w io.Writer) error {
+	resp, err := c.http.Get(c.baseURL + "/cache/export")
+	if err != nil {
+		return fmt.Errorf("admin request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("admin API returned %s", resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// ImportCache adds the entries read from r, in the cache file format, and
+// returns how many were added.
+func (c *Client) ImportCache(r io.Reader) (int, error) {
+	var out cacheLoadResult
+	err := c.Do(http.MethodPost, "/cache/import", r, &out)
+	return out.Loaded, err
+}
+
+func (c *Client) Records() ([]Record, error) {
+	var out []Record
+	err := c.Do(http.MethodGet, "/records", nil, &out)
+	return out, err
+}
+
+// UpdateRecords adds or, without add, deletes the records and returns the
+// dynamic records after the change.
+func (c *Client) UpdateRecords(records []Record, add bool) ([]Record, error) {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+
+	method := http.MethodDelete
+	if add {
+		method = http.MethodPost
+	}
+
+	var out []Record
+	err = c.Do(method, "/records", bytes.NewReader(body), &out)
+	return out, err
+}
@@ -0,0 +1,25 @@
+package admin
+
+import (
+	"context"
+	_ "embed"
+	"net/http"
+)
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// RegisterDashboard serves a web page of live statistics at /dashboard,
+// which polls GET /dashboard/data for what data returns.
+func (s *Server) RegisterDashboard(data func(ctx context.Context) any) {
+	s.HandleFunc("GET /dashboard", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(dashboardPage)
+	})
+
+	s.HandleFunc("GET /dashboard/data", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, data(r.Context()))
+	})
+}
@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"dns-server/internal/cache"
+	"dns-server/internal/externaldns"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RegisterExternalDNS serves the external-dns webhook provider endpoints:
+// domain filter negotiation, listing records, applying changes and adjusting
+// endpoints.
+func (s *Server) RegisterExternalDNS(provider *externaldns.Provider, dnsCache cache.Cache) {
+	s.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		writeWebhook(w, http.StatusOK, provider.DomainFilter())
+	})
+
+	s.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	s.HandleFunc("GET /records", func(w http.ResponseWriter, r *http.Request) {
+		writeWebhook(w, http.StatusOK, provider.Records())
+	})
+
+	s.HandleFunc("POST /records", func(w http.ResponseWriter, r *http.Request) {
+		var changes externaldns.Changes
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := provider.ApplyChanges(&changes); err != nil {
+			s.logger.WithFields(logrus.Fields{
+				"error":  err,
+				"remote": r.RemoteAddr,
+			}).Warn("rejected external-dns changes")
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		localChanged(dnsCache)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	s.HandleFunc("POST /adjustendpoints", func(w http.ResponseWriter, r *http.Request) {
+		var endpoints []*externaldns.Endpoint
+		if err := json.NewDecoder(r.Body).Decode(&endpoints); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeWebhook(w, http.StatusOK, provider.AdjustEndpoints(endpoints))
+	})
+}
+
+func writeWebhook(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", externaldns.MediaType)
+	w.Header().Set("Vary", "Content-Type")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
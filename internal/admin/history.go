@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"dns-server/internal/history"
+	"dns-server/internal/querylog"
+)
+
+const defaultHistoryLimit = 100
+
+func (s *Server) RegisterHistory(recorder *history.Recorder) {
+	s.HandleFunc("GET /history", func(w http.ResponseWriter, r *http.Request) {
+		filter, err := historyFilter(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		entries, err := recorder.Query(r.Context(), filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if entries == nil {
+			entries = []querylog.Entry{}
+		}
+		writeJSON(w, http.StatusOK, entries)
+	})
+}
+
+// historyFilter reads client, name, since, until and limit from the query
+// string. since and until take RFC 3339 times or a duration back from now.
+func historyFilter(r *http.Request) (history.Filter, error) {
+	query := r.URL.Query()
+	filter := history.Filter{
+		Client: query.Get("client"),
+		Name:   query.Get("name"),
+		Limit:  defaultHistoryLimit,
+	}
+
+	var err error
+	if filter.Since, err = parseTime(query.Get("since")); err != nil {
+		return filter, fmt.Errorf("invalid since: %w", err)
+	}
+	if filter.Until, err = parseTime(query.Get("until")); err != nil {
+		return filter, fmt.Errorf("invalid until: %w", err)
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 {
+			return filter, fmt.Errorf("invalid limit: %s", limit)
+		}
+	}
+	return filter, nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-ago), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,50 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"dns-server/pkg/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+type levelRequest struct {
+	Module string `json:"module"`
+	Level  string `json:"level"`
+}
+
+func (s *Server) RegisterLogging(logs *logger.Manager) {
+	s.HandleFunc("GET /logging", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, logs.Levels())
+	})
+
+	s.HandleFunc("PUT /logging/level", func(w http.ResponseWriter, r *http.Request) {
+		var req levelRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := logs.SetLevel(req.Module, req.Level); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		s.logger.WithFields(logrus.Fields{
+			"target_module": req.Module,
+			"new_level":     req.Level,
+			"remote":        r.RemoteAddr,
+		}).Info("log level changed via admin API")
+
+		writeJSON(w, http.StatusOK, logs.Levels())
+	})
+
+	s.HandleFunc("POST /logging/reopen", func(w http.ResponseWriter, r *http.Request) {
+		if err := logs.Reopen(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "reopened"})
+	})
+}
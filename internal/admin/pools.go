@@ -0,0 +1,14 @@
+package admin
+
+import (
+	"net/http"
+
+	"dns-server/internal/health"
+)
+
+// RegisterPools serves the health of every pool address, as GET /pools.
+func (s *Server) RegisterPools(checker *health.Checker) {
+	s.HandleFunc("GET /pools", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, checker.Status())
+	})
+}
@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dns-server/internal/cache"
+	"dns-server/internal/config"
+	"dns-server/internal/trace"
+	"dns-server/pkg/logger"
+)
+
+// TestAdminLogsSkipExcludedNames checks that the names the admin API logs
+// go through the exclude_domains filter of the logger.
+func TestAdminLogsSkipExcludedNames(t *testing.T) {
+	var out bytes.Buffer
+	log := logger.NewLogger(&config.LoggingConfig{Level: "info", Format: "json", ExcludeDomains: []string{"private.example"}})
+	log.SetOutput(&out)
+
+	s := NewServer(&config.AdminConfig{}, log)
+	s.RegisterCache(cache.NewLRUCache(100, time.Minute, time.Minute))
+	s.RegisterTrace(trace.NewTracer())
+
+	tests := []struct {
+		method, target, body string
+		logged               bool
+	}{
+		{http.MethodPost, "/trace", `{"name": "www.private.example", "count": 1}`, false},
+		{http.MethodPost, "/trace", `{"name": "www.example.com", "count": 1}`, true},
+		{http.MethodDelete, "/cache?name=www.private.example", "", false},
+		{http.MethodDelete, "/cache?suffix=private.example", "", false},
+		{http.MethodDelete, "/cache?name=www.example.com", "", true},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status %d: %s", tt.method, tt.target, w.Code, w.Body)
+		}
+		if logged := out.Len() > 0; logged != tt.logged {
+			t.Errorf("%s %s %s: logged = %v, want %v: %s", tt.method, tt.target, tt.body, logged, tt.logged, out.String())
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"dns-server/internal/cache"
+	"dns-server/internal/resolver"
+
+	"github.com/sirupsen/logrus"
+)
+
+func (s *Server) RegisterProfiles(local *resolver.LocalResolver, dnsCache cache.Cache) {
+	s.HandleFunc("GET /profiles", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, local.Profiles())
+	})
+
+	toggle := func(enabled bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			name := r.PathValue("name")
+			if err := local.SetProfileEnabled(name, enabled); err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, resolver.ErrUnknownProfile) {
+					status = http.StatusNotFound
+				}
+				writeError(w, status, err)
+				return
+			}
+
+			localChanged(dnsCache)
+
+			s.logger.WithFields(logrus.Fields{
+				"profile": name,
+				"enabled": enabled,
+				"remote":  r.RemoteAddr,
+			}).Info("profile updated via admin API")
+
+			writeJSON(w, http.StatusOK, local.Profiles())
+		}
+	}
+
+	s.HandleFunc("POST /profiles/{name}/enable", toggle(true))
+	s.HandleFunc("POST /profiles/{name}/disable", toggle(false))
+}
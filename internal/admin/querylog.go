@@ -0,0 +1,36 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"dns-server/internal/querylog"
+
+	"github.com/sirupsen/logrus"
+)
+
+type queryLogRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (s *Server) RegisterQueryLog(log *querylog.Log) {
+	s.HandleFunc("GET /querylog", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, log.Status())
+	})
+
+	s.HandleFunc("PUT /querylog", func(w http.ResponseWriter, r *http.Request) {
+		var req queryLogRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		log.SetEnabled(req.Enabled)
+		s.logger.WithFields(logrus.Fields{
+			"enabled": req.Enabled,
+			"remote":  r.RemoteAddr,
+		}).Info("query log toggled via admin API")
+
+		writeJSON(w, http.StatusOK, log.Status())
+	})
+}
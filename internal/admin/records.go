@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"dns-server/internal/cache"
+	"dns-server/internal/config"
+	"dns-server/internal/resolver"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+type Record struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+	Data string `json:"data"`
+}
+
+// RegisterRecords manages the dynamic records: GET lists them, POST adds
+// and DELETE removes the records in the request body. GET /records/conflicts
+// lists the names and types the record sources disagree on.
+func (s *Server) RegisterRecords(local *resolver.LocalResolver, dnsCache cache.Cache) {
+	list := func(w http.ResponseWriter) {
+		records := []Record{}
+		for _, rr := range local.DynamicRecords() {
+			hdr := rr.Header()
+			records = append(records, Record{
+				Name: strings.TrimSuffix(hdr.Name, "."),
+				Type: dns.TypeToString[hdr.Rrtype],
+				TTL:  hdr.Ttl,
+				Data: strings.TrimPrefix(rr.String(), hdr.String()),
+			})
+		}
+		writeJSON(w, http.StatusOK, records)
+	}
+
+	s.HandleFunc("GET /records", func(w http.ResponseWriter, r *http.Request) {
+		list(w)
+	})
+
+	s.HandleFunc("GET /records/conflicts", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, local.Conflicts())
+	})
+
+	change := func(add bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var records []Record
+			if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			rrs := make([]dns.RR, 0, len(records))
+			for _, rec := range records {
+				rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", config.CanonicalName(rec.Name), rec.TTL, rec.Type, rec.Data))
+				if err != nil || rr == nil {
+					writeError(w, http.StatusBadRequest, fmt.Errorf("invalid record %s %s %q: %v", rec.Name, rec.Type, rec.Data, err))
+					return
+				}
+				rrs = append(rrs, rr)
+			}
+
+			var err error
+			if add {
+				err = local.UpdateDynamic("admin", nil, rrs)
+			} else {
+				err = local.UpdateDynamic("admin", rrs, nil)
+			}
+			if errors.Is(err, resolver.ErrConflict) {
+				writeError(w, http.StatusConflict, err)
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+
+			localChanged(dnsCache)
+
+			s.logger.WithFields(logrus.Fields{
+				"records": len(rrs),
+				"added":   add,
+				"remote":  r.RemoteAddr,
+			}).Info("records updated via admin API")
+
+			list(w)
+		}
+	}
+
+	s.HandleFunc("POST /records", change(true))
+	s.HandleFunc("DELETE /records", change(false))
+}
@@ -0,0 +1,13 @@
+package admin
+
+import "net/http"
+
+func (s *Server) RegisterReload(reload func() error) {
+	s.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		if err := reload(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+	})
+}
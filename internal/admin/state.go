@@ -0,0 +1,18 @@
+package admin
+
+import "net/http"
+
+func (s *Server) RegisterState(state func() any, diff func() (any, error)) {
+	s.HandleFunc("GET /state", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, state())
+	})
+
+	s.HandleFunc("GET /state/diff", func(w http.ResponseWriter, r *http.Request) {
+		result, err := diff()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+}
@@ -0,0 +1,35 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"dns-server/internal/metrics"
+)
+
+func (s *Server) RegisterStats(stats func() any) {
+	s.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, stats())
+	})
+}
+
+// RegisterDomainStats serves the most queried names and zones. limit caps
+// both lists; without it every tracked entry is returned.
+func (s *Server) RegisterDomainStats(stats func(limit int) any) {
+	s.HandleFunc("GET /stats/domains", func(w http.ResponseWriter, r *http.Request) {
+		limit := 0
+		if value := r.URL.Query().Get("limit"); value != "" {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", value))
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, stats(limit))
+	})
+}
+
+func (s *Server) RegisterMetrics(registry *metrics.Registry) {
+	s.Handle("GET /metrics", registry.Handler())
+}
@@ -0,0 +1,13 @@
+package admin
+
+import "net/http"
+
+// RegisterStatus serves a read-only status document at path. Router and
+// homelab dashboards embed it from other origins, so CORS is left open.
+func (s *Server) RegisterStatus(path string, status func() any) {
+	s.HandleFunc("GET "+path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, status())
+	})
+}
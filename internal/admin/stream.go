@@ -0,0 +1,72 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"dns-server/internal/querylog"
+)
+
+// streamKeepalive is how often an idle query stream sends a comment, so
+// proxies between it and the client do not close the connection.
+const streamKeepalive = 15 * time.Second
+
+// RegisterQueryStream pushes every answered query as a server-sent event to
+// clients of GET /querylog/stream, optionally only those from client or for
+// name. When a client falls behind, a "dropped" event says how many entries
+// it has missed so far.
+func (s *Server) RegisterQueryStream(stream *querylog.Stream) {
+	stop := make(chan struct{})
+	s.server.RegisterOnShutdown(func() { close(stop) })
+
+	s.HandleFunc("GET /querylog/stream", func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+			return
+		}
+		client := r.URL.Query().Get("client")
+		name := strings.TrimSuffix(r.URL.Query().Get("name"), ".")
+
+		sub := stream.Subscribe()
+		defer stream.Unsubscribe(sub)
+		s.logger.WithField("remote", r.RemoteAddr).Debug("query stream opened")
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepalive := time.NewTicker(streamKeepalive)
+		defer keepalive.Stop()
+
+		var dropped uint64
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-stop:
+				return
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			case entry := <-sub.C:
+				if client != "" && entry.Client != client || name != "" && !strings.EqualFold(entry.Name, name) {
+					continue
+				}
+				if missed := sub.Dropped(); missed != dropped {
+					dropped = missed
+					fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", dropped)
+				}
+				data, err := json.Marshal(entry)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "data: %s\n\n", data)
+			}
+			flusher.Flush()
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"dns-server/internal/trace"
+
+	"github.com/sirupsen/logrus"
+)
+
+type traceRequest struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// RegisterTrace captures per-stage timings of the next queries for a name:
+// POST /trace with {"name": "example.com", "count": 10}, then GET /trace
+// for what was captured.
+func (s *Server) RegisterTrace(tracer *trace.Tracer) {
+	s.HandleFunc("GET /trace", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, tracer.Status())
+	})
+
+	s.HandleFunc("POST /trace", func(w http.ResponseWriter, r *http.Request) {
+		req := traceRequest{Count: 1}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Count <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("count must be positive"))
+			return
+		}
+
+		tracer.Arm(req.Name, req.Count)
+		s.logger.WithFields(logrus.Fields{
+			"name":   req.Name,
+			"count":  req.Count,
+			"remote": r.RemoteAddr,
+		}).Info("query trace armed via admin API")
+
+		writeJSON(w, http.StatusOK, tracer.Status())
+	})
+
+	s.HandleFunc("DELETE /trace", func(w http.ResponseWriter, r *http.Request) {
+		tracer.Clear()
+		writeJSON(w, http.StatusOK, tracer.Status())
+	})
+}
@@ -0,0 +1,212 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/client"
+
+	"github.com/miekg/dns"
+)
+
+// tick is how often the pacer releases the queries that have come due, so
+// high rates are not paced by one sleep per query.
+const tick = time.Millisecond
+
+// Options describes the load to generate.
+//
+// Queries are drawn from Questions, uniformly or, with Zipf above 1, with
+// the first ones far more often than the rest, as real clients ask for
+// popular names. The rate ramps linearly from StartQPS to EndQPS over
+// Duration; with both 0 queries are sent as fast as Concurrency clients get
+// answers.
+type Options struct {
+	Server      client.Server
+	Questions   []dns.Question
+	Zipf        float64
+	StartQPS    float64
+	EndQPS      float64
+	Duration    time.Duration
+	Concurrency int
+	Timeout     time.Duration
+}
+
+type Report struct {
+	Duration  time.Duration  `json:"duration"`
+	Sent      int            `json:"sent"`
+	Skipped   int            `json:"skipped"`
+	Errors    int            `json:"errors"`
+	Rcodes    map[string]int `json:"rcodes"`
+	QPS       float64        `json:"qps"`
+	ErrorRate float64        `json:"error_rate"`
+	P50       time.Duration  `json:"p50"`
+	P90       time.Duration  `json:"p90"`
+	P99       time.Duration  `json:"p99"`
+	P999      time.Duration  `json:"p999"`
+	Max       time.Duration  `json:"max"`
+}
+
+type result struct {
+	rcode   int
+	err     error
+	latency time.Duration
+}
+
+// Run sends queries to opts.Server until opts.Duration is over or ctx is
+// done and reports how it answered. Queries without an answer within
+// opts.Timeout, and failed exchanges, count as errors; queries the clients
+// were too busy to send at the configured rate count as skipped.
+func Run(ctx context.Context, opts *Options) (*Report, error) {
+	if len(opts.Questions) == 0 {
+		return nil, fmt.Errorf("no questions to send")
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	var skipped atomic.Int64
+	jobs := make(chan struct{}, opts.Concurrency)
+	if opts.StartQPS > 0 || opts.EndQPS > 0 {
+		go func() {
+			defer close(jobs)
+			pace(ctx, opts, jobs, &skipped)
+		}()
+	} else {
+		go func() {
+			defer close(jobs)
+			for {
+				select {
+				case jobs <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	var mu sync.Mutex
+	var results []result
+
+	c := client.New(opts.Timeout)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pick := picker(len(opts.Questions), opts.Zipf)
+			var local []result
+			for range jobs {
+				question := opts.Questions[pick()]
+				query := new(dns.Msg)
+				query.SetQuestion(question.Name, question.Qtype)
+
+				// the exchange may outlive the run by up to its timeout
+				exchangeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), opts.Timeout)
+				sent := time.Now()
+				response, _, err := c.Exchange(exchangeCtx, query, opts.Server)
+				r := result{err: err, latency: time.Since(sent)}
+				cancel()
+				if err == nil {
+					r.rcode = response.Rcode
+				}
+				local = append(local, r)
+			}
+			mu.Lock()
+			results = append(results, local...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	report := summarize(results, time.Since(start))
+	report.Skipped = int(skipped.Load())
+	return report, nil
+}
+
+// pace releases queries into jobs at the ramping rate. Queries that come
+// due while every client is busy are skipped rather than queued, so a slow
+// server shows up as skipped queries instead of as a growing backlog.
+func pace(ctx context.Context, opts *Options, jobs chan<- struct{}, skipped *atomic.Int64) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	start := time.Now()
+	released := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			due := int(queriesBy(opts, now.Sub(start))) - released
+			for range due {
+				select {
+				case jobs <- struct{}{}:
+				default:
+					skipped.Add(1)
+				}
+			}
+			released += max(due, 0)
+		}
+	}
+}
+
+// queriesBy is how many queries are due by elapsed into the run: the
+// integral of the rate, which ramps linearly from StartQPS to EndQPS.
+func queriesBy(opts *Options, elapsed time.Duration) float64 {
+	t := min(elapsed, opts.Duration).Seconds()
+	slope := (opts.EndQPS - opts.StartQPS) / opts.Duration.Seconds()
+	return opts.StartQPS*t + slope*t*t/2
+}
+
+// picker returns a function drawing question indexes below n, following a
+// Zipf distribution with exponent s when s is above 1 and uniformly
+// otherwise.
+func picker(n int, s float64) func() int {
+	r := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	if s <= 1 || n == 1 {
+		return func() int { return r.IntN(n) }
+	}
+	zipf := rand.NewZipf(r, s, 1, uint64(n-1))
+	return func() int { return int(zipf.Uint64()) }
+}
+
+func summarize(results []result, duration time.Duration) *Report {
+	report := &Report{Duration: duration, Sent: len(results), Rcodes: map[string]int{}}
+	latencies := make([]time.Duration, 0, len(results))
+	for _, r := range results {
+		if r.err != nil {
+			report.Errors++
+			continue
+		}
+		latencies = append(latencies, r.latency)
+		report.Rcodes[dns.RcodeToString[r.rcode]]++
+	}
+	if report.Sent > 0 {
+		report.ErrorRate = float64(report.Errors) / float64(report.Sent)
+	}
+	if duration > 0 {
+		report.QPS = float64(report.Sent) / duration.Seconds()
+	}
+
+	slices.Sort(latencies)
+	report.P50 = percentile(latencies, 0.50)
+	report.P90 = percentile(latencies, 0.90)
+	report.P99 = percentile(latencies, 0.99)
+	report.P999 = percentile(latencies, 0.999)
+	if len(latencies) > 0 {
+		report.Max = latencies[len(latencies)-1]
+	}
+	return report
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[min(len(sorted)-1, int(p*float64(len(sorted))))]
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"dns-server/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	shrinkFactor = 0.75
+	growFactor   = 1.25
+	// capacity only grows back once usage is well below the limit, so the
+	// cache does not oscillate around it
+	regrowBelow = 0.6
+)
+
+func (c *LRUCache) Capacity() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capacity
+}
+
+// SetCapacity changes the maximum number of entries, evicting the least
+// recently used ones of each shard if it is over its new share.
+func (c *LRUCache) SetCapacity(capacity int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capacity = capacity
+	c.redistribute()
+}
+
+// SetMaxMemory limits the approximate memory of the cached entries to
+// maxBytes, evicting the least recently used ones of each shard that is over
+// its share. Zero removes the limit.
+func (c *LRUCache) SetMaxMemory(maxBytes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxBytes = maxBytes
+	c.redistribute()
+}
+
+func (c *LRUCache) MaxMemory() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.maxBytes
+}
+
+func (c *LRUCache) redistribute() {
+	for _, s := range c.shards {
+		s.mu.Lock()
+	}
+	distribute(c.shards, c.capacity, c.maxBytes)
+	for _, s := range c.shards {
+		for s.evictList.Len() > s.capacity || (s.maxBytes > 0 && s.bytes > s.maxBytes) {
+			s.removeOldest()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// RunAdaptiveSizing watches process memory and shrinks the cache while it is
+// over the configured limit, then grows it back towards maxEntries once the
+// pressure is gone.
+func (c *LRUCache) RunAdaptiveSizing(ctx context.Context, cfg *config.AdaptiveConfig, maxEntries int, logger *logrus.Logger) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		used := memoryInUse()
+		capacity := c.Capacity()
+		target := capacity
+
+		switch {
+		case used > int64(cfg.MemoryLimit):
+			target = max(int(float64(capacity)*shrinkFactor), cfg.MinEntries)
+		case used < int64(float64(cfg.MemoryLimit)*regrowBelow):
+			target = min(int(float64(capacity)*growFactor)+1, maxEntries)
+		}
+
+		if target == capacity {
+			continue
+		}
+
+		c.SetCapacity(target)
+		if target < capacity {
+			// hand the evicted entries back to the OS promptly
+			runtime.GC()
+		}
+
+		logger.WithFields(logrus.Fields{
+			"memory":       config.ByteSize(used).String(),
+			"limit":        cfg.MemoryLimit.String(),
+			"old_capacity": capacity,
+			"new_capacity": target,
+		}).Info("cache capacity adjusted")
+	}
+}
+
+// memoryInUse approximates the resident size of the Go heap and runtime.
+func memoryInUse() int64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.Sys - stats.HeapReleased)
+}
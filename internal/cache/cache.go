@@ -2,30 +2,44 @@ package cache
 
 import (
 	"container/list"
-	"encoding/gob"
-	"os"
+	"context"
+	"hash/maphash"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
+// CacheEntry holds a response packed in wire format, which takes a
+// fraction of the memory of the unpacked message, along with where its
+// record TTLs are so they can be aged in place. Source is the server the
+// response came from, when it came from one, and Rank how credible it is.
 type CacheEntry struct {
 	Key       string
-	Response  *dns.Msg
+	Wire      []byte
+	StoredAt  time.Time
 	ExpiresAt time.Time
+	Source    string
+	Rank      Rank
+	question  dns.Question
+	ttls      []uint16
+	opt       bool
+	hits      int
+	size      int
 	element   *list.Element
 }
 
-type SerializableCacheEntry struct {
-	Key       string
-	Response  *dns.Msg
-	ExpiresAt time.Time
-}
-
 type Cache interface {
 	Get(key string) (*dns.Msg, bool)
+	AppendWire(dst []byte, key string) (wire []byte, opt bool, found bool)
+	GetStale(key string) (*dns.Msg, bool)
+	PrefetchCandidates(minHits int, threshold float64) []dns.Question
 	Set(key string, response *dns.Msg, ttl time.Duration)
+	SetFrom(key string, response *dns.Msg, ttl time.Duration, source string)
+	BelowNXDomain(question dns.Question) (*dns.Msg, bool)
 	Delete(key string)
 	Clear()
 	Size() int
@@ -33,216 +47,395 @@ type Cache interface {
 	LoadFromFile(filename string) error
 }
 
+// LRUCache splits its entries over shards by key hash, each with its own
+// lock and LRU list, so lookups on different names do not contend. Capacity
+// and the memory limit are divided evenly, which makes eviction least
+// recently used per shard rather than across the whole cache.
 type LRUCache struct {
-	mu          sync.RWMutex
-	capacity    int
-	items       map[string]*CacheEntry
-	evictList   *list.List
-	defaultTTL  time.Duration
-	stopCleanup chan struct{}
+	shards          []*shard
+	seed            maphash.Seed
+	mu              sync.Mutex
+	capacity        int
+	maxBytes        int
+	defaultTTL      time.Duration
+	maxStale        atomic.Int64
+	cleanupInterval time.Duration
+	writer          *writer
+	nxdomains       *nxdomains
 }
 
+type shard struct {
+	mu        sync.RWMutex
+	capacity  int
+	maxBytes  int
+	bytes     int
+	items     map[string]*CacheEntry
+	evictList *list.List
+	kept      uint64
+}
+
+// entryOverhead approximates the memory an entry takes beyond its key, its
+// packed response and TTL offsets: the entry, list element and map slot.
+const entryOverhead = 192
+
+// minShardEntries keeps shards large enough for per-shard LRU to stay a
+// reasonable approximation of a global one.
+const minShardEntries = 64
+
 func NewLRUCache(capacity int, defaultTTL, cleanupInterval time.Duration) *LRUCache {
-	cache := &LRUCache{
-		capacity:    capacity,
-		items:       make(map[string]*CacheEntry),
-		evictList:   list.New(),
-		defaultTTL:  defaultTTL,
-		stopCleanup: make(chan struct{}),
+	c := &LRUCache{
+		seed:            maphash.MakeSeed(),
+		capacity:        capacity,
+		defaultTTL:      defaultTTL,
+		cleanupInterval: cleanupInterval,
 	}
-
-	go cache.cleanupExpired(cleanupInterval)
-	return cache
+	c.shards = newShards(defaultShards(capacity), capacity, 0)
+	return c
 }
 
-func (c *LRUCache) Get(key string) (*dns.Msg, bool) {
-	c.mu.RLock()
-	entry, exists := c.items[key]
-	c.mu.RUnlock()
+// defaultShards scales the shard count with the CPUs available, as a power
+// of two, without making shards smaller than minShardEntries.
+func defaultShards(capacity int) int {
+	shards := 1
+	for shards < runtime.GOMAXPROCS(0)*4 && (shards*2)*minShardEntries <= capacity {
+		shards *= 2
+	}
+	return shards
+}
 
-	if !exists {
-		return nil, false
+func newShards(count, capacity, maxBytes int) []*shard {
+	shards := make([]*shard, count)
+	for i := range shards {
+		shards[i] = &shard{
+			items:     make(map[string]*CacheEntry),
+			evictList: list.New(),
+		}
 	}
+	distribute(shards, capacity, maxBytes)
+	return shards
+}
 
-	if time.Now().After(entry.ExpiresAt) {
-		c.Delete(key)
-		return nil, false
+// distribute splits capacity and maxBytes over the shards so the shares add
+// up to them. Zero maxBytes leaves memory unlimited.
+func distribute(shards []*shard, capacity, maxBytes int) {
+	for i, s := range shards {
+		s.capacity = capacity / len(shards)
+		if i < capacity%len(shards) {
+			s.capacity++
+		}
+		s.maxBytes = maxBytes / len(shards)
 	}
+}
 
+// SetShards redistributes the cache over count shards; zero picks a count
+// from the number of CPUs. It must be called before the cache is shared.
+func (c *LRUCache) SetShards(count int) {
 	c.mu.Lock()
-	c.evictList.MoveToFront(entry.element)
-	c.mu.Unlock()
+	defer c.mu.Unlock()
+
+	if count <= 0 {
+		count = defaultShards(c.capacity)
+	}
+	if count == len(c.shards) {
+		return
+	}
+
+	old := c.shards
+	c.shards = newShards(count, c.capacity, c.maxBytes)
+	for _, s := range old {
+		s.mu.Lock()
+		for element := s.evictList.Back(); element != nil; element = element.Prev() {
+			entry := element.Value.(*CacheEntry)
+			c.shardFor(entry.Key).insert(entry)
+		}
+		s.mu.Unlock()
+	}
+}
 
-	return entry.Response.Copy(), true
+func (c *LRUCache) Shards() int {
+	return len(c.shards)
 }
 
-func (c *LRUCache) Set(key string, response *dns.Msg, ttl time.Duration) {
-	if ttl == 0 {
-		ttl = c.defaultTTL
+func (c *LRUCache) shardFor(key string) *shard {
+	return c.shards[maphash.String(c.seed, key)%uint64(len(c.shards))]
+}
+
+func (c *LRUCache) Get(key string) (*dns.Msg, bool) {
+	entry, found := c.lookup(key)
+	if !found {
+		return nil, false
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	// the wire bytes of an entry never change once stored, so it is
+	// unpacked outside the lock
+	response, err := entry.response(time.Since(entry.StoredAt))
+	if err != nil {
+		return nil, false
+	}
+	return response, true
+}
 
-	if entry, exists := c.items[key]; exists {
-		entry.Response = response.Copy()
-		entry.ExpiresAt = time.Now().Add(ttl)
-		c.evictList.MoveToFront(entry.element)
-		return
+// AppendWire appends the packed response under key to dst with its TTLs
+// aged like Get's, so a hit can be sent without unpacking and packing it
+// again. opt reports whether the response has an OPT record.
+func (c *LRUCache) AppendWire(dst []byte, key string) (wire []byte, opt bool, found bool) {
+	entry, found := c.lookup(key)
+	if !found {
+		return dst, false, false
 	}
+	return entry.appendWire(dst, time.Since(entry.StoredAt)), entry.opt, true
+}
 
-	if c.evictList.Len() >= c.capacity {
-		c.removeOldest()
+// lookup returns the live entry under key and counts the hit, dropping the
+// entry once it is past its stale grace period.
+func (c *LRUCache) lookup(key string) (*CacheEntry, bool) {
+	s := c.shardFor(key)
+	s.mu.RLock()
+	entry, exists := s.items[key]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, false
 	}
 
-	entry := &CacheEntry{
-		Key:       key,
-		Response:  response.Copy(),
-		ExpiresAt: time.Now().Add(ttl),
+	if now := time.Now(); now.After(entry.ExpiresAt) {
+		if now.After(entry.ExpiresAt.Add(c.getMaxStale())) {
+			c.Delete(key)
+		}
+		return nil, false
 	}
 
-	entry.element = c.evictList.PushFront(entry)
-	c.items[key] = entry
+	s.mu.Lock()
+	s.evictList.MoveToFront(entry.element)
+	entry.hits++
+	s.mu.Unlock()
+	return entry, true
 }
 
-func (c *LRUCache) Delete(key string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// GetStale returns an entry even if it has expired, as long as it is still
+// within the max-stale grace period (RFC 8767).
+func (c *LRUCache) GetStale(key string) (*dns.Msg, bool) {
+	s := c.shardFor(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, exists := s.items[key]
+	if !exists || time.Now().After(entry.ExpiresAt.Add(c.getMaxStale())) {
+		return nil, false
+	}
 
-	if entry, exists := c.items[key]; exists {
-		c.evictList.Remove(entry.element)
-		delete(c.items, key)
+	response, err := entry.response(0)
+	if err != nil {
+		return nil, false
 	}
+	return response, true
 }
 
-func (c *LRUCache) Clear() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// PrefetchCandidates returns the questions of live entries that were hit at
+// least minHits times since they were stored and have used up the given
+// fraction of their TTL.
+func (c *LRUCache) PrefetchCandidates(minHits int, threshold float64) []dns.Question {
+	now := time.Now()
+	var questions []dns.Question
+
+	for _, s := range c.shards {
+		s.mu.RLock()
+		for _, entry := range s.items {
+			if entry.hits < minHits || now.After(entry.ExpiresAt) || entry.question.Name == "" {
+				continue
+			}
 
-	c.items = make(map[string]*CacheEntry)
-	c.evictList.Init()
+			lifetime := entry.ExpiresAt.Sub(entry.StoredAt)
+			if now.Sub(entry.StoredAt) >= time.Duration(float64(lifetime)*threshold) {
+				questions = append(questions, entry.question)
+			}
+		}
+		s.mu.RUnlock()
+	}
+
+	return questions
 }
 
-func (c *LRUCache) Size() int {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return len(c.items)
+func (c *LRUCache) SetMaxStale(maxStale time.Duration) {
+	c.maxStale.Store(int64(maxStale))
 }
 
-func (c *LRUCache) Close() {
-	close(c.stopCleanup)
+func (c *LRUCache) getMaxStale() time.Duration {
+	return time.Duration(c.maxStale.Load())
 }
 
-func (c *LRUCache) removeOldest() {
-	element := c.evictList.Back()
-	if element != nil {
-		entry := element.Value.(*CacheEntry)
-		c.evictList.Remove(element)
-		delete(c.items, entry.Key)
-	}
+func (c *LRUCache) Set(key string, response *dns.Msg, ttl time.Duration) {
+	c.SetFrom(key, response, ttl, "")
 }
 
-func (c *LRUCache) cleanupExpired(interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// SetFrom stores response like Set, recording source as the server it came
+// from.
+func (c *LRUCache) SetFrom(key string, response *dns.Msg, ttl time.Duration, source string) {
+	if ttl == 0 {
+		ttl = c.defaultTTL
+	}
 
-	for {
-		select {
-		case <-ticker.C:
-			c.removeExpired()
-		case <-c.stopCleanup:
-			return
-		}
+	now := time.Now()
+	entry, err := packEntry(key, response, now, now.Add(ttl))
+	if err != nil {
+		// a response that does not pack cannot be sent either
+		return
+	}
+	entry.Source = source
+	entry.Rank = RankOf(response)
+	c.nxdomains.note(entry)
+	if c.writer.queue(entry) {
+		return
 	}
+	c.shardFor(key).insert(entry)
 }
 
-func (c *LRUCache) removeExpired() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// insert adds entry as the most recently used one, replacing an entry with
+// the same key and evicting the least recently used ones until the shard is
+// within its entry and byte limits. An entry larger than the whole byte
+// share of the shard is not cached, nor one ranked below the live entry it
+// would replace.
+func (s *shard) insert(entry *CacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insertLocked(entry)
+}
 
-	now := time.Now()
-	var toRemove []*list.Element
+func (s *shard) insertLocked(entry *CacheEntry) {
+	entry.size = len(entry.Key) + len(entry.Wire) + 2*len(entry.ttls) + entryOverhead
 
-	for element := c.evictList.Back(); element != nil; element = element.Prev() {
-		entry := element.Value.(*CacheEntry)
-		if now.After(entry.ExpiresAt) {
-			toRemove = append(toRemove, element)
+	if existing, exists := s.items[entry.Key]; exists {
+		if existing.Rank > entry.Rank && entry.StoredAt.Before(existing.ExpiresAt) {
+			s.kept++
+			return
 		}
+		s.remove(existing)
+	}
+	if s.maxBytes > 0 && entry.size > s.maxBytes {
+		return
 	}
 
-	for _, element := range toRemove {
-		entry := element.Value.(*CacheEntry)
-		c.evictList.Remove(element)
-		delete(c.items, entry.Key)
+	for s.evictList.Len() > 0 && s.full(entry.size) {
+		s.removeOldest()
 	}
-}
 
-func (c *LRUCache) DumpToFile(filename string) error {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	entry.element = s.evictList.PushFront(entry)
+	s.items[entry.Key] = entry
+	s.bytes += entry.size
+}
 
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+// full reports whether the shard has no room for another entry of size
+// bytes.
+func (s *shard) full(size int) bool {
+	return s.evictList.Len() >= s.capacity || (s.maxBytes > 0 && s.bytes+size > s.maxBytes)
+}
 
-	encoder := gob.NewEncoder(file)
+func (s *shard) remove(entry *CacheEntry) {
+	s.evictList.Remove(entry.element)
+	delete(s.items, entry.Key)
+	s.bytes -= entry.size
+}
 
-	var entries []SerializableCacheEntry
-	now := time.Now()
+func (c *LRUCache) Delete(key string) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	for _, entry := range c.items {
-		if now.Before(entry.ExpiresAt) {
-			entries = append(entries, SerializableCacheEntry{
-				Key:       entry.Key,
-				Response:  entry.Response,
-				ExpiresAt: entry.ExpiresAt,
-			})
-		}
+	if entry, exists := s.items[key]; exists {
+		s.remove(entry)
 	}
+}
 
-	return encoder.Encode(entries)
+func (c *LRUCache) Clear() {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.items = make(map[string]*CacheEntry)
+		s.evictList.Init()
+		s.bytes = 0
+		s.mu.Unlock()
+	}
+	if c.nxdomains != nil {
+		c.nxdomains.mu.Lock()
+		c.nxdomains.keys = make(map[string]string)
+		c.nxdomains.mu.Unlock()
+	}
 }
 
-func (c *LRUCache) LoadFromFile(filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return err
+// Bytes returns the approximate memory held by the cached entries.
+func (c *LRUCache) Bytes() int {
+	bytes := 0
+	for _, s := range c.shards {
+		s.mu.RLock()
+		bytes += s.bytes
+		s.mu.RUnlock()
 	}
-	defer file.Close()
+	return bytes
+}
 
-	decoder := gob.NewDecoder(file)
-	var entries []SerializableCacheEntry
+func (c *LRUCache) Size() int {
+	size := 0
+	for _, s := range c.shards {
+		s.mu.RLock()
+		size += len(s.items)
+		s.mu.RUnlock()
+	}
+	return size
+}
 
-	if err := decoder.Decode(&entries); err != nil {
-		return err
+func (s *shard) removeOldest() {
+	if element := s.evictList.Back(); element != nil {
+		s.remove(element.Value.(*CacheEntry))
 	}
+}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// RunCleanup periodically drops expired entries until ctx is canceled.
+func (c *LRUCache) RunCleanup(ctx context.Context) {
+	ticker := time.NewTicker(c.cleanupInterval)
+	defer ticker.Stop()
 
-	now := time.Now()
-	for _, entry := range entries {
-		if now.Before(entry.ExpiresAt) {
-			if c.evictList.Len() >= c.capacity {
-				c.removeOldest()
-			}
+	for {
+		select {
+		case <-ticker.C:
+			c.removeExpired()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-			cacheEntry := &CacheEntry{
-				Key:       entry.Key,
-				Response:  entry.Response,
-				ExpiresAt: entry.ExpiresAt,
+func (c *LRUCache) removeExpired() {
+	now := time.Now()
+	maxStale := c.getMaxStale()
+
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for element := s.evictList.Back(); element != nil; {
+			prev := element.Prev()
+			entry := element.Value.(*CacheEntry)
+			if now.After(entry.ExpiresAt.Add(maxStale)) {
+				s.remove(entry)
 			}
-
-			cacheEntry.element = c.evictList.PushFront(cacheEntry)
-			c.items[entry.Key] = cacheEntry
+			element = prev
 		}
+		s.mu.Unlock()
 	}
+	c.nxdomains.prune(c)
+}
 
-	return nil
+// Kept returns how many entries were not stored because the live entry
+// they would have replaced was ranked higher.
+func (c *LRUCache) Kept() uint64 {
+	var kept uint64
+	for _, s := range c.shards {
+		s.mu.RLock()
+		kept += s.kept
+		s.mu.RUnlock()
+	}
+	return kept
 }
 
 func GenerateCacheKey(question dns.Question) string {
-	return question.Name + ":" + dns.TypeToString[question.Qtype] + ":" + dns.ClassToString[question.Qclass]
+	// names are case-insensitive, and clients randomizing the case of their
+	// queries must still hit the same entry
+	return strings.ToLower(question.Name) + ":" + dns.TypeToString[question.Qtype] + ":" + dns.ClassToString[question.Qclass]
 }
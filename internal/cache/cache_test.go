@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const benchNames = 4096
+
+// BenchmarkLRUCacheParallel measures Get and Set from all CPUs at once on a
+// single shard, which is the cache before sharding, and on the default
+// shard count.
+func BenchmarkLRUCacheParallel(b *testing.B) {
+	keys := make([]string, benchNames)
+	responses := make([]*dns.Msg, benchNames)
+	for i := range keys {
+		question := dns.Question{Name: fmt.Sprintf("host%d.example.com.", i), Qtype: dns.TypeA, Qclass: dns.ClassINET}
+		keys[i] = GenerateCacheKey(question)
+		responses[i] = benchResponse(question)
+	}
+
+	for _, shards := range []int{1, 0} {
+		c := NewLRUCache(benchNames*2, time.Hour, time.Minute)
+		c.SetShards(shards)
+		for i, key := range keys {
+			c.Set(key, responses[i], time.Hour)
+		}
+
+		b.Run(fmt.Sprintf("Get/shards=%d", c.Shards()), func(b *testing.B) {
+			var next atomic.Uint64
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := next.Add(1) * 7919
+				for pb.Next() {
+					i++
+					if _, found := c.Get(keys[i%benchNames]); !found {
+						b.Error("cached entry missing")
+						return
+					}
+				}
+			})
+		})
+		b.Run(fmt.Sprintf("Set/shards=%d", c.Shards()), func(b *testing.B) {
+			var next atomic.Uint64
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := next.Add(1) * 7919
+				for pb.Next() {
+					i++
+					c.Set(keys[i%benchNames], responses[i%benchNames], time.Hour)
+				}
+			})
+		})
+	}
+}
+
+func benchResponse(question dns.Question) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(question.Name, question.Qtype)
+	msg.Response = true
+	msg.RecursionAvailable = true
+	msg.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600},
+		A:   net.IPv4(192, 0, 2, 1),
+	}}
+	return msg
+}
@@ -0,0 +1,341 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Cache files start with fileMagic and a version byte, followed by one frame
+// per entry: the payload length and its CRC-32C, followed by the key, the
+// store and expiry times, the rank and source of the entry and the response
+// in wire format. Version 1 files, without rank and source, are still read.
+// A damaged frame only loses the entries from that point on.
+const (
+	fileMagic    = "DNSCACHE"
+	fileVersion  = 2
+	maxFrameSize = 1 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrLegacyFormat is returned by LoadFromFile for cache files written in the
+// gob format of earlier versions, which LoadLegacy reads.
+var ErrLegacyFormat = errors.New("legacy cache file format")
+
+// UnsupportedVersionError is returned by LoadFromFile for a file with a
+// format version this build does not read. The file is left in place.
+type UnsupportedVersionError struct {
+	Path    string
+	Version byte
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("cache data has unsupported version %d", e.Version)
+	}
+	return fmt.Sprintf("cache file %s has unsupported version %d", e.Path, e.Version)
+}
+
+// CorruptFileError is returned by Load and LoadFromFile when the data is
+// damaged. The entries before the damage are loaded; LoadFromFile also moves
+// the file aside to Quarantine so it is not overwritten by the next dump.
+type CorruptFileError struct {
+	Path       string
+	Quarantine string
+	Loaded     int
+	Offset     int64
+	Skipped    int64
+	Err        error
+}
+
+func (e *CorruptFileError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("cache data is corrupt at offset %d: %v", e.Offset, e.Err)
+	}
+	return fmt.Sprintf("cache file %s is corrupt at offset %d: %v", e.Path, e.Offset, e.Err)
+}
+
+func (e *CorruptFileError) Unwrap() error {
+	return e.Err
+}
+
+// DumpToFile writes the live entries to a temporary file next to filename
+// and renames it into place, so a crash never leaves a partial file behind.
+func (c *LRUCache) DumpToFile(filename string) error {
+	dir := filepath.Dir(filename)
+	file, err := os.CreateTemp(dir, filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err := c.Dump(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), filename); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// Dump writes the live entries in the cache file format.
+func (c *LRUCache) Dump(w io.Writer) error {
+	if _, err := io.WriteString(w, fileMagic); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{fileVersion}); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, s := range c.shards {
+		if err := s.dump(w, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s *shard) dump(w io.Writer, now time.Time) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, entry := range s.items {
+		if !now.Before(entry.ExpiresAt) {
+			continue
+		}
+		payload, err := encodeEntry(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", entry.Key, err)
+		}
+
+		var header [8]byte
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(payload, crcTable))
+		if _, err := w.Write(header[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *LRUCache) LoadFromFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Load(file)
+	var corrupt *CorruptFileError
+	var unsupported *UnsupportedVersionError
+	switch {
+	case errors.As(err, &corrupt):
+		file.Close()
+		corrupt.Path = filename
+		corrupt.Skipped = info.Size() - corrupt.Offset
+		quarantine := fmt.Sprintf("%s.corrupt-%s", filename, time.Now().Format("20060102T150405"))
+		if renameErr := os.Rename(filename, quarantine); renameErr == nil {
+			corrupt.Quarantine = quarantine
+		}
+	case errors.As(err, &unsupported):
+		unsupported.Path = filename
+	}
+	return err
+}
+
+// Load adds the unexpired entries of data written by Dump and returns how
+// many were added. Damaged data is reported as a *CorruptFileError after the
+// entries before the damage were added.
+func (c *LRUCache) Load(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	var offset int64
+	var loaded int
+	corrupt := func(err error) (int, error) {
+		return loaded, &CorruptFileError{Loaded: loaded, Offset: offset, Err: err}
+	}
+
+	header := make([]byte, len(fileMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(fileMagic)]) != fileMagic {
+		if isGob(header) {
+			return 0, ErrLegacyFormat
+		}
+		return corrupt(errors.New("unknown file format"))
+	}
+	version := header[len(fileMagic)]
+	if version < 1 || version > fileVersion {
+		return 0, &UnsupportedVersionError{Version: version}
+	}
+	offset = int64(len(header))
+
+	now := time.Now()
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(br, header[:]); err == io.EOF {
+			return loaded, nil
+		} else if err != nil {
+			return corrupt(fmt.Errorf("truncated frame header: %w", err))
+		}
+
+		size := binary.BigEndian.Uint32(header[0:4])
+		if size > maxFrameSize {
+			return corrupt(fmt.Errorf("frame of %d bytes exceeds limit", size))
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return corrupt(fmt.Errorf("truncated frame: %w", err))
+		}
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+			return corrupt(errors.New("checksum mismatch"))
+		}
+		entry, err := decodeEntry(payload, version)
+		if err != nil {
+			return corrupt(err)
+		}
+		offset += int64(len(header)) + int64(size)
+
+		if !now.Before(entry.ExpiresAt) {
+			continue
+		}
+		c.nxdomains.note(entry)
+		c.shardFor(entry.Key).insert(entry)
+		loaded++
+	}
+}
+
+// legacyEntry is an entry of the gob cache files of earlier versions.
+type legacyEntry struct {
+	Key       string
+	Response  *dns.Msg
+	ExpiresAt time.Time
+}
+
+// LoadLegacy adds the unexpired entries of a cache file in the gob format of
+// earlier versions and returns how many were added. Those versions never
+// registered the record types with gob, so a dump failed as soon as a
+// response held a record, after the type definitions were written: such
+// files end before their entries and are reported as corrupt, and only
+// responses without records were ever saved. The entries are stored as if
+// fetched now, keyed by their question.
+func (c *LRUCache) LoadLegacy(r io.Reader) (int, error) {
+	var entries []legacyEntry
+	if err := gob.NewDecoder(r).Decode(&entries); err != nil {
+		return 0, &CorruptFileError{Err: err}
+	}
+
+	now := time.Now()
+	var loaded int
+	for _, legacy := range entries {
+		if legacy.Response == nil || len(legacy.Response.Question) == 0 || !now.Before(legacy.ExpiresAt) {
+			continue
+		}
+		key := GenerateCacheKey(legacy.Response.Question[0])
+		entry, err := packEntry(key, legacy.Response, now, legacy.ExpiresAt)
+		if err != nil {
+			continue
+		}
+		c.nxdomains.note(entry)
+		c.shardFor(key).insert(entry)
+		loaded++
+	}
+	return loaded, nil
+}
+
+// isGob reports whether header starts like the gob stream earlier versions
+// wrote: a length-prefixed type definition message.
+func isGob(header []byte) bool {
+	return len(header) > 1 && header[0] > 0 && header[0] < 0x80 && header[1] == 0xff
+}
+
+func encodeEntry(entry *CacheEntry) ([]byte, error) {
+	wire := entry.Wire
+	source := entry.Source[:min(len(entry.Source), 255)]
+	payload := make([]byte, 0, 2+len(entry.Key)+18+len(source)+len(wire))
+	payload = binary.BigEndian.AppendUint16(payload, uint16(len(entry.Key)))
+	payload = append(payload, entry.Key...)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(entry.StoredAt.UnixNano()))
+	payload = binary.BigEndian.AppendUint64(payload, uint64(entry.ExpiresAt.UnixNano()))
+	payload = append(payload, byte(entry.Rank), byte(len(source)))
+	payload = append(payload, source...)
+	return append(payload, wire...), nil
+}
+
+func decodeEntry(payload []byte, version byte) (*CacheEntry, error) {
+	if len(payload) < 2 {
+		return nil, errors.New("short frame")
+	}
+	keyLen := int(binary.BigEndian.Uint16(payload))
+	if len(payload) < 2+keyLen+16 {
+		return nil, errors.New("short frame")
+	}
+	key := string(payload[2 : 2+keyLen])
+	times := payload[2+keyLen:]
+	rest := times[16:]
+
+	var rank Rank
+	var source string
+	if version >= 2 {
+		if len(rest) < 2 || len(rest) < 2+int(rest[1]) {
+			return nil, errors.New("short frame")
+		}
+		rank = Rank(rest[0])
+		source = string(rest[2 : 2+int(rest[1])])
+		rest = rest[2+int(rest[1]):]
+	}
+
+	// the payload is dropped after loading, so the entry keeps a copy of
+	// just the response
+	wire := append([]byte(nil), rest...)
+	if err := new(dns.Msg).Unpack(wire); err != nil {
+		return nil, fmt.Errorf("invalid response for %s: %w", key, err)
+	}
+
+	storedAt := time.Unix(0, int64(binary.BigEndian.Uint64(times[0:8])))
+	expiresAt := time.Unix(0, int64(binary.BigEndian.Uint64(times[8:16])))
+	entry, err := newEntry(key, wire, storedAt, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response for %s: %w", key, err)
+	}
+	entry.Rank = rank
+	entry.Source = source
+	return entry, nil
+}
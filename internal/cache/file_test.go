@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestLoadLegacy(t *testing.T) {
+	nodata := new(dns.Msg)
+	nodata.SetQuestion("NoData.Example.com.", dns.TypeAAAA)
+	nodata.Response = true
+
+	var file bytes.Buffer
+	entries := []legacyEntry{
+		{Key: "NoData.Example.com.:AAAA:IN", Response: nodata, ExpiresAt: time.Now().Add(time.Hour)},
+		{Key: "gone.example.com.:AAAA:IN", Response: nodata.Copy(), ExpiresAt: time.Now().Add(-time.Hour)},
+	}
+	if err := gob.NewEncoder(&file).Encode(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewLRUCache(100, time.Minute, time.Minute)
+	loaded, err := c.LoadLegacy(&file)
+	if err != nil || loaded != 1 {
+		t.Fatalf("LoadLegacy = %d, %v, want 1 entry", loaded, err)
+	}
+	key := GenerateCacheKey(dns.Question{Name: "nodata.example.com.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+	if _, found := c.Get(key); !found {
+		t.Errorf("converted entry not found under %s", key)
+	}
+}
+
+// TestLoadLegacyTruncated reads a file as earlier versions left it when a
+// response held a record: the type definitions and nothing else.
+func TestLoadLegacyTruncated(t *testing.T) {
+	answer := new(dns.Msg)
+	answer.SetQuestion("www.example.com.", dns.TypeA)
+	answer.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.IPv4(192, 0, 2, 1),
+	}}
+
+	var file bytes.Buffer
+	entries := []legacyEntry{{Key: "www.example.com.:A:IN", Response: answer, ExpiresAt: time.Now().Add(time.Hour)}}
+	if err := gob.NewEncoder(&file).Encode(entries); err == nil {
+		t.Fatal("gob encoded a record without its type registered")
+	}
+
+	c := NewLRUCache(100, time.Minute, time.Minute)
+	loaded, err := c.LoadLegacy(&file)
+	var corrupt *CorruptFileError
+	if !errors.As(err, &corrupt) || loaded != 0 {
+		t.Errorf("LoadLegacy = %d, %v, want a CorruptFileError", loaded, err)
+	}
+}
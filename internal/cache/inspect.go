@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// EntryInfo is a copy of a cache entry for inspection.
+type EntryInfo struct {
+	Key       string
+	Name      string
+	Type      string
+	Response  *dns.Msg
+	StoredAt  time.Time
+	ExpiresAt time.Time
+	Source    string
+	Rank      Rank
+	Hits      int
+	Size      int
+}
+
+// Remaining is how long the entry stays fresh at now; it is negative once the
+// entry has expired and may only be served stale.
+func (e *EntryInfo) Remaining(now time.Time) time.Duration {
+	return e.ExpiresAt.Sub(now)
+}
+
+// splitKey returns the lowercased name, without the trailing dot, and the
+// type of a key made by GenerateCacheKey.
+func splitKey(key string) (name, qtype string) {
+	rest, _, _ := cutLast(key, ":")
+	name, qtype, _ = cutLast(rest, ":")
+	return strings.TrimSuffix(strings.ToLower(name), "."), qtype
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// Entries returns copies of the entries match accepts by name and type,
+// including expired ones still held for serve-stale, sorted by key.
+func (c *LRUCache) Entries(match func(name, qtype string) bool) []EntryInfo {
+	var entries []EntryInfo
+	for _, s := range c.shards {
+		s.mu.RLock()
+		for key, entry := range s.items {
+			name, qtype := splitKey(key)
+			if !match(name, qtype) {
+				continue
+			}
+			response, err := entry.response(0)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, EntryInfo{
+				Key:       key,
+				Name:      name,
+				Type:      qtype,
+				Response:  response,
+				StoredAt:  entry.StoredAt,
+				ExpiresAt: entry.ExpiresAt,
+				Source:    entry.Source,
+				Rank:      entry.Rank,
+				Hits:      entry.hits,
+				Size:      entry.size,
+			})
+		}
+		s.mu.RUnlock()
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// DeleteMatching removes the entries match accepts by name and type and
+// returns how many were removed.
+func (c *LRUCache) DeleteMatching(match func(name, qtype string) bool) int {
+	deleted := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for key, entry := range s.items {
+			if name, qtype := splitKey(key); match(name, qtype) {
+				s.remove(entry)
+				deleted++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return deleted
+}
@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Rank is how credible the data of an entry is, after the ranking of RFC
+// 2181 section 5.4.1. A live entry is only replaced by one of the same or a
+// higher rank, so a stray answer cannot push out better data before it
+// expires.
+type Rank uint8
+
+const (
+	// RankUnknown is the rank of entries read from cache files that did
+	// not record one; any entry may replace them.
+	RankUnknown Rank = iota
+	// RankAdditional is data from the authority or additional section of
+	// a non-authoritative answer, such as the negative answers and
+	// referrals of recursive servers.
+	RankAdditional
+	// RankAnswer is the answer section of a non-authoritative answer.
+	RankAnswer
+	// RankAuthority is the authority section of an authoritative answer,
+	// such as the SOA of its negative answers.
+	RankAuthority
+	// RankAuthAnswer is the answer section of an authoritative answer.
+	RankAuthAnswer
+)
+
+var rankNames = [...]string{"unknown", "additional", "answer", "authority", "authoritative"}
+
+func (r Rank) String() string {
+	if int(r) < len(rankNames) {
+		return rankNames[r]
+	}
+	return "unknown"
+}
+
+// RankOf returns the rank of the data response answers with.
+func RankOf(response *dns.Msg) Rank {
+	switch {
+	case response.Authoritative && len(response.Answer) > 0:
+		return RankAuthAnswer
+	case response.Authoritative:
+		return RankAuthority
+	case len(response.Answer) > 0:
+		return RankAnswer
+	default:
+		return RankAdditional
+	}
+}
+
+// nxdomains indexes the cached NXDOMAIN answers for names that do not exist
+// themselves, rather than at the end of a CNAME chain, by that name, so
+// names below them can be answered from them (RFC 8020).
+type nxdomains struct {
+	mu   sync.RWMutex
+	keys map[string]string
+}
+
+// SetHardenBelowNXDomain has BelowNXDomain answer names below a name the
+// cache holds an NXDOMAIN for. It must be called before the cache is used.
+func (c *LRUCache) SetHardenBelowNXDomain(enabled bool) {
+	if enabled {
+		c.nxdomains = &nxdomains{keys: make(map[string]string)}
+	}
+}
+
+// note indexes entry when it is an NXDOMAIN without answer records.
+func (n *nxdomains) note(entry *CacheEntry) {
+	if n == nil || len(entry.Wire) < 12 {
+		return
+	}
+	rcode := int(entry.Wire[3] & 0x0f)
+	answers := binary.BigEndian.Uint16(entry.Wire[6:])
+	if rcode != dns.RcodeNameError || answers > 0 {
+		return
+	}
+
+	n.mu.Lock()
+	n.keys[strings.ToLower(entry.question.Name)] = entry.Key
+	n.mu.Unlock()
+}
+
+func (n *nxdomains) forget(name string) {
+	n.mu.Lock()
+	delete(n.keys, name)
+	n.mu.Unlock()
+}
+
+// BelowNXDomain answers question with NXDOMAIN when the cache holds a live
+// NXDOMAIN for a name above it: a name that does not exist has nothing
+// below it either.
+func (c *LRUCache) BelowNXDomain(question dns.Question) (*dns.Msg, bool) {
+	if c.nxdomains == nil {
+		return nil, false
+	}
+
+	name := strings.ToLower(dns.Fqdn(question.Name))
+	for offset, end := dns.NextLabel(name, 0); !end; offset, end = dns.NextLabel(name, offset) {
+		ancestor := name[offset:]
+		c.nxdomains.mu.RLock()
+		key, exists := c.nxdomains.keys[ancestor]
+		c.nxdomains.mu.RUnlock()
+		if !exists {
+			continue
+		}
+
+		response, found := c.Get(key)
+		if !found || response.Rcode != dns.RcodeNameError || len(response.Answer) > 0 {
+			c.nxdomains.forget(ancestor)
+			continue
+		}
+		response.Question = []dns.Question{question}
+		return response, true
+	}
+	return nil, false
+}
+
+// prune drops the names whose entries are gone.
+func (n *nxdomains) prune(c *LRUCache) {
+	if n == nil {
+		return
+	}
+	now := time.Now()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for name, key := range n.keys {
+		s := c.shardFor(key)
+		s.mu.RLock()
+		entry, exists := s.items[key]
+		s.mu.RUnlock()
+		if !exists || now.After(entry.ExpiresAt) {
+			delete(n.keys, name)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var errMalformed = errors.New("malformed message")
+
+// packEntry packs a canonical copy of response with name compression for an
+// entry stored at now. The message itself is left as it was.
+func packEntry(key string, response *dns.Msg, now, expires time.Time) (*CacheEntry, error) {
+	canonical := response.Copy()
+	canonicalize(canonical)
+	canonical.Compress = true
+	wire, err := canonical.Pack()
+	if err != nil {
+		return nil, err
+	}
+	return newEntry(key, wire, now, expires)
+}
+
+// canonicalize lowercases the question name, the owner names and the CNAME
+// targets of msg. Clients share entries whatever case they asked in, so the
+// entry must not keep the spelling of the one that filled it; and with one
+// spelling, names equal to the question compress to a pointer at it, so
+// answering a hit in the client's spelling only takes rewriting the
+// question. Lowercase names are the canonical form DNSSEC signs (RFC 4034
+// section 6.2), so signatures still validate.
+func canonicalize(msg *dns.Msg) {
+	for i := range msg.Question {
+		msg.Question[i].Name = strings.ToLower(msg.Question[i].Name)
+	}
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			hdr.Name = strings.ToLower(hdr.Name)
+			if cname, ok := rr.(*dns.CNAME); ok {
+				cname.Target = strings.ToLower(cname.Target)
+			}
+		}
+	}
+}
+
+// newEntry holds wire, a packed response, with the offsets of its record
+// TTLs so hits can age them without unpacking it first.
+func newEntry(key string, wire []byte, storedAt, expiresAt time.Time) (*CacheEntry, error) {
+	question, ttls, opt, err := scanWire(wire)
+	if err != nil {
+		return nil, err
+	}
+	return &CacheEntry{
+		Key:       key,
+		Wire:      wire,
+		StoredAt:  storedAt,
+		ExpiresAt: expiresAt,
+		question:  question,
+		ttls:      ttls,
+		opt:       opt,
+	}, nil
+}
+
+// appendWire appends the packed entry to dst with every TTL lowered by
+// elapsed, the time it spent in the cache, so clients never hold records
+// longer than the origin intended.
+func (e *CacheEntry) appendWire(dst []byte, elapsed time.Duration) []byte {
+	start := len(dst)
+	dst = append(dst, e.Wire...)
+	if seconds := uint32(elapsed / time.Second); seconds > 0 {
+		wire := dst[start:]
+		for _, offset := range e.ttls {
+			ttl := binary.BigEndian.Uint32(wire[offset:])
+			binary.BigEndian.PutUint32(wire[offset:], ttl-min(ttl, seconds))
+		}
+	}
+	return dst
+}
+
+// response unpacks a copy of the entry with its TTLs aged by elapsed.
+func (e *CacheEntry) response(elapsed time.Duration) (*dns.Msg, error) {
+	wire := e.Wire
+	if elapsed >= time.Second {
+		wire = e.appendWire(nil, elapsed)
+	}
+
+	response := new(dns.Msg)
+	if err := response.Unpack(wire); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// scanWire walks a packed message and returns its first question, the
+// offsets of the TTL of every record but OPT, whose TTL field holds flags,
+// and whether it has an OPT record.
+func scanWire(wire []byte) (dns.Question, []uint16, bool, error) {
+	var question dns.Question
+	if len(wire) < 12 || len(wire) > dns.MaxMsgSize {
+		return question, nil, false, errMalformed
+	}
+	qdcount := int(binary.BigEndian.Uint16(wire[4:]))
+	rrcount := int(binary.BigEndian.Uint16(wire[6:])) + int(binary.BigEndian.Uint16(wire[8:])) + int(binary.BigEndian.Uint16(wire[10:]))
+
+	off := 12
+	for i := range qdcount {
+		name, end, err := dns.UnpackDomainName(wire, off)
+		if err != nil || end+4 > len(wire) {
+			return question, nil, false, errMalformed
+		}
+		if i == 0 {
+			question = dns.Question{
+				Name:   name,
+				Qtype:  binary.BigEndian.Uint16(wire[end:]),
+				Qclass: binary.BigEndian.Uint16(wire[end+2:]),
+			}
+		}
+		off = end + 4
+	}
+
+	ttls := make([]uint16, 0, rrcount)
+	opt := false
+	for range rrcount {
+		end, err := skipName(wire, off)
+		if err != nil || end+10 > len(wire) {
+			return question, nil, false, errMalformed
+		}
+		rrtype := binary.BigEndian.Uint16(wire[end:])
+		rdlength := int(binary.BigEndian.Uint16(wire[end+8:]))
+		if rrtype == dns.TypeOPT {
+			opt = true
+		} else {
+			ttls = append(ttls, uint16(end+4))
+		}
+		off = end + 10 + rdlength
+		if off > len(wire) {
+			return question, nil, false, errMalformed
+		}
+	}
+	return question, ttls, opt, nil
+}
+
+// skipName returns the offset just past the domain name at off.
+func skipName(wire []byte, off int) (int, error) {
+	for {
+		if off >= len(wire) {
+			return 0, errMalformed
+		}
+		switch length := int(wire[off]); {
+		case length == 0:
+			return off + 1, nil
+		case length&0xC0 == 0xC0:
+			// a compression pointer ends the name
+			return off + 2, nil
+		case length&0xC0 != 0:
+			return 0, errMalformed
+		default:
+			off += 1 + length
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+
+	"dns-server/internal/config"
+	"dns-server/internal/metrics"
+)
+
+// writer stores entries from a background goroutine, so the queries whose
+// answers they are never wait for a shard lock. Entries are packed before
+// they are queued, since the caller goes on to send and change the message.
+// A write still queued lands after a Delete or Clear of its key.
+type writer struct {
+	entries chan *CacheEntry
+	batch   int
+	sync    bool
+
+	written, batches, dropped, overflowed atomic.Uint64
+}
+
+// SetAsyncWrites has Set queue entries for RunWrites to store, as cfg
+// describes. It must be called before the cache is used.
+func (c *LRUCache) SetAsyncWrites(cfg *config.CacheWritesConfig) {
+	c.writer = &writer{
+		entries: make(chan *CacheEntry, cfg.Buffer),
+		batch:   cfg.Batch,
+		sync:    cfg.Overflow == config.CacheOverflowSync,
+	}
+}
+
+// queue reports whether Set is done with entry: queued, or dropped because
+// the buffer is full. Without asynchronous writes, and when the overflow
+// action is to write it anyway, the caller stores it.
+func (w *writer) queue(entry *CacheEntry) bool {
+	if w == nil {
+		return false
+	}
+	select {
+	case w.entries <- entry:
+		return true
+	default:
+	}
+	if w.sync {
+		w.overflowed.Add(1)
+		return false
+	}
+	w.dropped.Add(1)
+	return true
+}
+
+// RunWrites stores queued entries until ctx is done, taking every entry
+// already waiting, up to the batch size, under one lock per shard. The
+// entries still queued then are stored before it returns, so a dump of the
+// cache at shutdown has them.
+func (c *LRUCache) RunWrites(ctx context.Context) {
+	w := c.writer
+	batch := make([]*CacheEntry, 0, w.batch)
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				batch = w.fill(batch[:0])
+				if len(batch) == 0 {
+					return
+				}
+				c.insertBatch(batch)
+			}
+		case entry := <-w.entries:
+			batch = w.fill(append(batch[:0], entry))
+			c.insertBatch(batch)
+		}
+	}
+}
+
+// fill adds the entries waiting in the queue to batch until it is full.
+func (w *writer) fill(batch []*CacheEntry) []*CacheEntry {
+	for len(batch) < w.batch {
+		select {
+		case entry := <-w.entries:
+			batch = append(batch, entry)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (c *LRUCache) insertBatch(entries []*CacheEntry) {
+	byShard := make(map[*shard][]*CacheEntry)
+	for _, entry := range entries {
+		s := c.shardFor(entry.Key)
+		byShard[s] = append(byShard[s], entry)
+	}
+	for s, entries := range byShard {
+		s.mu.Lock()
+		for _, entry := range entries {
+			s.insertLocked(entry)
+		}
+		s.mu.Unlock()
+	}
+	c.writer.written.Add(uint64(len(entries)))
+	c.writer.batches.Add(1)
+}
+
+// CollectWrites reports the asynchronous writes, if they are enabled.
+func (c *LRUCache) CollectWrites(w *metrics.Writer) {
+	if c.writer == nil {
+		return
+	}
+	w.Gauge("dns_cache_writes_queued", "Cache writes waiting for the background writer.", float64(len(c.writer.entries)))
+	w.Counter("dns_cache_writes_total", "Cache entries stored by the background writer.", float64(c.writer.written.Load()))
+	w.Counter("dns_cache_write_batches_total", "Batches of entries stored by the background writer.", float64(c.writer.batches.Load()))
+	w.Counter("dns_cache_writes_dropped_total", "Answers left uncached because the write buffer was full.", float64(c.writer.dropped.Load()))
+	w.Counter("dns_cache_writes_overflowed_total", "Answers stored by the query that got them because the write buffer was full.", float64(c.writer.overflowed.Load()))
+}
@@ -0,0 +1,217 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	ProtoUDP   = "udp"
+	ProtoTCP   = "tcp"
+	ProtoTLS   = "tls"
+	ProtoHTTPS = "https"
+)
+
+type Server struct {
+	Proto   string
+	Address string
+	URL     string
+}
+
+func (s Server) String() string {
+	if s.Proto == ProtoHTTPS {
+		return s.URL
+	}
+	return s.Proto + "://" + s.Address
+}
+
+// ParseServer accepts "host", "host:port" or "proto://host[:port]" for udp,
+// tcp and tls, and a full URL for https.
+func ParseServer(spec string) (Server, error) {
+	proto := ProtoUDP
+	if i := strings.Index(spec, "://"); i >= 0 {
+		proto = strings.ToLower(spec[:i])
+		if proto != ProtoHTTPS {
+			spec = spec[i+3:]
+		}
+	}
+
+	switch proto {
+	case ProtoUDP, ProtoTCP:
+		return Server{Proto: proto, Address: withDefaultPort(spec, "53")}, nil
+	case ProtoTLS:
+		return Server{Proto: proto, Address: withDefaultPort(spec, "853")}, nil
+	case ProtoHTTPS:
+		u, err := url.Parse(spec)
+		if err != nil || u.Host == "" {
+			return Server{}, fmt.Errorf("invalid DoH URL: %s", spec)
+		}
+		if u.Path == "" {
+			u.Path = "/dns-query"
+		}
+		return Server{Proto: proto, Address: withDefaultPort(u.Host, "443"), URL: u.String()}, nil
+	default:
+		return Server{}, fmt.Errorf("unsupported protocol: %s", proto)
+	}
+}
+
+func withDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// Client exchanges queries over every supported transport. Bootstrap, when
+// set, is the IP address dialed in place of a server's host name; the name
+// is still used to verify its certificate and as the DoH host. Otherwise
+// Lookup, when set, resolves host names instead of the system resolver; a
+// nil IP leaves the name to the system resolver after all.
+type Client struct {
+	Timeout   time.Duration
+	TLSConfig *tls.Config
+	Bootstrap string
+	Lookup    func(ctx context.Context, host string) (net.IP, error)
+	http      *http.Client
+	once      sync.Once
+}
+
+func New(timeout time.Duration) *Client {
+	return &Client{Timeout: timeout}
+}
+
+func (c *Client) Exchange(ctx context.Context, msg *dns.Msg, server Server) (*dns.Msg, time.Duration, error) {
+	switch server.Proto {
+	case ProtoUDP, ProtoTCP, ProtoTLS:
+		network := server.Proto
+		if network == ProtoTLS {
+			network = "tcp-tls"
+		}
+
+		dc := &dns.Client{
+			Net:       network,
+			Timeout:   c.Timeout,
+			TLSConfig: c.tlsConfig(server),
+		}
+		address, err := c.DialAddress(ctx, server.Address)
+		if err != nil {
+			return nil, 0, err
+		}
+		return dc.ExchangeContext(ctx, msg, address)
+	case ProtoHTTPS:
+		return c.exchangeHTTPS(ctx, msg, server)
+	default:
+		return nil, 0, fmt.Errorf("unsupported protocol: %s", server.Proto)
+	}
+}
+
+// DialAddress returns the address connected to for address, the host:port
+// of a server.
+func (c *Client) DialAddress(ctx context.Context, address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address, nil
+	}
+	if c.Bootstrap != "" {
+		return net.JoinHostPort(c.Bootstrap, port), nil
+	}
+	if c.Lookup == nil || net.ParseIP(host) != nil {
+		return address, nil
+	}
+
+	ip, err := c.Lookup(ctx, host)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	if ip == nil {
+		return address, nil
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// TLSConfigFor returns the TLS settings used for server.
+func (c *Client) TLSConfigFor(server Server) *tls.Config {
+	return c.tlsConfig(server)
+}
+
+func (c *Client) tlsConfig(server Server) *tls.Config {
+	if c.TLSConfig != nil {
+		return c.TLSConfig
+	}
+
+	host, _, _ := net.SplitHostPort(server.Address)
+	return &tls.Config{ServerName: host}
+}
+
+// exchangeHTTPS implements RFC 8484 DNS-over-HTTPS using POST.
+func (c *Client) exchangeHTTPS(ctx context.Context, msg *dns.Msg, server Server) (*dns.Msg, time.Duration, error) {
+	c.once.Do(func() {
+		dialer := &net.Dialer{}
+		c.http = &http.Client{
+			Timeout: c.Timeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					addr, err := c.DialAddress(ctx, addr)
+					if err != nil {
+						return nil, err
+					}
+					return dialer.DialContext(ctx, network, addr)
+				},
+				TLSClientConfig:   c.TLSConfig,
+				ForceAttemptHTTP2: true,
+			},
+		}
+	})
+
+	// the ID should be zero for cache friendliness
+	query := msg.Copy()
+	query.Id = 0
+
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to pack query: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, bytes.NewReader(packed))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	start := time.Now()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("DoH request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("DoH server returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read DoH response: %w", err)
+	}
+	rtt := time.Since(start)
+
+	response := &dns.Msg{}
+	if err := response.Unpack(body); err != nil {
+		return nil, 0, fmt.Errorf("failed to unpack DoH response: %w", err)
+	}
+	response.Id = msg.Id
+
+	return response, rtt, nil
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ChaosConfig is the profile the soak subcommand runs the server under. It
+// replaces the configured upstreams with simulated ones that drop, fail,
+// delay or garble a fraction of the queries they receive, and takes the first
+// one down for Outage every OutageEvery, while Concurrency clients replay
+// Corpus for Duration. The server itself ignores this section.
+type ChaosConfig struct {
+	Corpus        string        `toml:"corpus"`
+	Duration      time.Duration `toml:"duration"`
+	Concurrency   int           `toml:"concurrency"`
+	Upstreams     int           `toml:"upstreams"`
+	AnswerTTL     time.Duration `toml:"answer_ttl"`
+	DropRate      float64       `toml:"drop_rate"`
+	ServfailRate  float64       `toml:"servfail_rate"`
+	MalformedRate float64       `toml:"malformed_rate"`
+	LatencyRate   float64       `toml:"latency_rate"`
+	Latency       time.Duration `toml:"latency"`
+	OutageEvery   time.Duration `toml:"outage_every"`
+	Outage        time.Duration `toml:"outage"`
+	SLO           SLOConfig     `toml:"slo"`
+}
+
+// SLOConfig is what a soak run must meet to pass. Zero values are not
+// checked.
+type SLOConfig struct {
+	MinSuccessRate  float64       `toml:"min_success_rate"`
+	MaxServfailRate float64       `toml:"max_servfail_rate"`
+	MaxP99          time.Duration `toml:"max_p99"`
+}
+
+func validateChaos(chaos *ChaosConfig) error {
+	rates := map[string]float64{
+		"drop_rate":             chaos.DropRate,
+		"servfail_rate":         chaos.ServfailRate,
+		"malformed_rate":        chaos.MalformedRate,
+		"latency_rate":          chaos.LatencyRate,
+		"slo.min_success_rate":  chaos.SLO.MinSuccessRate,
+		"slo.max_servfail_rate": chaos.SLO.MaxServfailRate,
+	}
+	for _, name := range sortedKeys(rates) {
+		if rate := rates[name]; rate < 0 || rate > 1 {
+			return fmt.Errorf("chaos %s must be between 0 and 1: %g", name, rate)
+		}
+	}
+	if chaos.DropRate+chaos.ServfailRate+chaos.MalformedRate > 1 {
+		return fmt.Errorf("chaos drop_rate, servfail_rate and malformed_rate add up to more than 1")
+	}
+	if chaos.Duration < 0 || chaos.AnswerTTL < 0 || chaos.Latency < 0 || chaos.OutageEvery < 0 || chaos.Outage < 0 || chaos.SLO.MaxP99 < 0 {
+		return fmt.Errorf("chaos durations must be non-negative")
+	}
+	if chaos.Concurrency < 0 || chaos.Upstreams < 0 {
+		return fmt.Errorf("chaos concurrency and upstreams must be non-negative")
+	}
+	if chaos.Outage > 0 && chaos.Outage >= chaos.OutageEvery {
+		return fmt.Errorf("chaos outage must be shorter than outage_every")
+	}
+	return nil
+}
+
+func setChaosDefaults(chaos *ChaosConfig) {
+	if chaos.Duration == 0 {
+		chaos.Duration = 30 * time.Second
+	}
+	if chaos.Concurrency == 0 {
+		chaos.Concurrency = 8
+	}
+	if chaos.Upstreams == 0 {
+		chaos.Upstreams = 2
+	}
+	if chaos.AnswerTTL == 0 {
+		chaos.AnswerTTL = 5 * time.Second
+	}
+	if chaos.Latency == 0 {
+		chaos.Latency = 500 * time.Millisecond
+	}
+}
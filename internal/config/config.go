@@ -3,51 +3,335 @@ package config
 import (
 	"fmt"
 	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/BurntSushi/toml"
+	"github.com/miekg/dns"
 )
 
 type Config struct {
-	Server   ServerConfig   `toml:"server"`
-	Cache    CacheConfig    `toml:"cache"`
-	Upstream UpstreamConfig `toml:"upstream"`
-	Logging  LoggingConfig  `toml:"logging"`
-	Records  RecordsConfig  `toml:"records"`
+	Server      ServerConfig               `toml:"server"`
+	Cache       CacheConfig                `toml:"cache"`
+	Upstream    UpstreamConfig             `toml:"upstream"`
+	Logging     LoggingConfig              `toml:"logging"`
+	Admin       AdminConfig                `toml:"admin"`
+	Status      StatusConfig               `toml:"status"`
+	Records     RecordsConfig              `toml:"records"`
+	Profiles    map[string]ProfileConfig   `toml:"profiles"`
+	Views       []ViewConfig               `toml:"views"`
+	Pools       map[string]PoolConfig      `toml:"pools"`
+	Discovery   DiscoveryConfig            `toml:"discovery"`
+	MDNS        MDNSConfig                 `toml:"mdns"`
+	Leases      LeasesConfig               `toml:"leases"`
+	Webhooks    []WebhookConfig            `toml:"webhooks"`
+	Fallback    FallbackConfig             `toml:"fallback"`
+	Transfer    TransferConfig             `toml:"transfer"`
+	Secondary   map[string]SecondaryConfig `toml:"secondary"`
+	Stub        map[string]StubConfig      `toml:"stub"`
+	Special     SpecialZonesConfig         `toml:"special_zones"`
+	LocalZones  map[string]string          `toml:"local_zones"`
+	Sites       map[string]SiteConfig      `toml:"sites"`
+	QueryLog    QueryLogConfig             `toml:"query_log"`
+	History     HistoryConfig              `toml:"history"`
+	Stats       StatsConfig                `toml:"stats"`
+	Rewrite     RewriteConfig              `toml:"rewrite"`
+	Filter      FilterConfig               `toml:"filter"`
+	ExternalDNS ExternalDNSConfig          `toml:"external_dns"`
+	Hooks       []HookConfig               `toml:"hooks"`
+	RecordStore RecordStoreConfig          `toml:"record_store"`
+	Precedence  PrecedenceConfig           `toml:"precedence"`
+	Chaos       ChaosConfig                `toml:"chaos"`
+	Tracing     TracingConfig              `toml:"tracing"`
 }
 
+type FallbackConfig struct {
+	TTL     time.Duration `toml:"ttl"`
+	Records RecordsConfig `toml:"records"`
+}
+
+// What the server does with a query that arrives while MaxQueries are
+// already being answered.
+const (
+	OverloadRefuse = "refuse"
+	OverloadDrop   = "drop"
+)
+
 type ServerConfig struct {
-	Port         int           `toml:"port"`
-	BindAddress  string        `toml:"bind_address"`
-	ReadTimeout  time.Duration `toml:"read_timeout"`
-	WriteTimeout time.Duration `toml:"write_timeout"`
+	Port         int              `toml:"port"`
+	BindAddress  string           `toml:"bind_address"`
+	ReadTimeout  time.Duration    `toml:"read_timeout"`
+	WriteTimeout time.Duration    `toml:"write_timeout"`
+	DrainTimeout time.Duration    `toml:"drain_timeout"`
+	NSID         string           `toml:"nsid"`
+	Version      string           `toml:"version"`
+	Hostname     string           `toml:"hostname"`
+	ServerID     string           `toml:"server_id"`
+	HideIdentity bool             `toml:"hide_identity"`
+	PIDFile      string           `toml:"pid_file"`
+	MaxQueries   int              `toml:"max_concurrent_queries"`
+	ReusePort    bool             `toml:"reuse_port"`
+	Overload     string           `toml:"overload"`
+	AllowFrom    []string         `toml:"allow_from"`
+	DenyFrom     []string         `toml:"deny_from"`
+	Listen       []ListenerConfig `toml:"listen"`
+	DNSCrypt     DNSCryptConfig   `toml:"dnscrypt"`
 }
 
 type CacheConfig struct {
-	MaxEntries      int           `toml:"max_entries"`
-	DefaultTTL      time.Duration `toml:"default_ttl"`
-	CleanupInterval time.Duration `toml:"cleanup_interval"`
+	MaxEntries      int                  `toml:"max_entries"`
+	Shards          int                  `toml:"shards"`
+	MaxMemoryMB     int                  `toml:"max_memory_mb"`
+	DefaultTTL      time.Duration        `toml:"default_ttl"`
+	CleanupInterval time.Duration        `toml:"cleanup_interval"`
+	NoPersist       bool                 `toml:"no_persist"`
+	PersistPath     string               `toml:"persist_path"`
+	PersistInterval time.Duration        `toml:"persist_interval"`
+	ServeStale      bool                 `toml:"serve_stale"`
+	HardenNXDomain  bool                 `toml:"harden_below_nxdomain"`
+	MaxStale        time.Duration        `toml:"max_stale"`
+	StaleTTL        time.Duration        `toml:"stale_ttl"`
+	TTLMode         string               `toml:"ttl_mode"`
+	TTLPolicy       map[string]TTLPolicy `toml:"ttl_policy"`
+	AllowFrom       []string             `toml:"allow_from"`
+	Prefetch        PrefetchConfig       `toml:"prefetch"`
+	Adaptive        AdaptiveConfig       `toml:"adaptive"`
+	Writes          CacheWritesConfig    `toml:"writes"`
+}
+
+// TTLPolicy clamps the TTL of upstream records of one type before they are
+// cached. NoCache keeps answers to queries of that type out of the cache.
+type TTLPolicy struct {
+	Min     time.Duration `toml:"min"`
+	Max     time.Duration `toml:"max"`
+	NoCache bool          `toml:"no_cache"`
+}
+
+type AdaptiveConfig struct {
+	Enabled     bool          `toml:"enabled"`
+	MemoryLimit ByteSize      `toml:"memory_limit"`
+	MinEntries  int           `toml:"min_entries"`
+	Interval    time.Duration `toml:"interval"`
+}
+
+// Cache write overflow actions.
+const (
+	CacheOverflowDrop = "drop"
+	CacheOverflowSync = "sync"
+)
+
+// CacheWritesConfig moves cache writes off the response path when Async is
+// set: answers are packed by whoever answered them and stored by a
+// background writer, Batch at a time per shard lock, through a buffer of
+// Buffer entries. When the buffer is full, answers go uncached ("drop") or
+// are stored by the caller as without Async ("sync").
+type CacheWritesConfig struct {
+	Async    bool   `toml:"async"`
+	Buffer   int    `toml:"buffer"`
+	Batch    int    `toml:"batch"`
+	Overflow string `toml:"overflow"`
+}
+
+type PrefetchConfig struct {
+	Enabled   bool          `toml:"enabled"`
+	MinHits   int           `toml:"min_hits"`
+	Threshold float64       `toml:"threshold"`
+	Interval  time.Duration `toml:"interval"`
+	Workers   int           `toml:"workers"`
 }
 
 type UpstreamConfig struct {
-	Servers []string      `toml:"servers"`
+	Mode              string                 `toml:"mode"`
+	RootHints         []string               `toml:"root_hints"`
+	QNameMinimization string                 `toml:"qname_minimization"`
+	Servers           []string               `toml:"servers"`
+	ServerBlocks      []UpstreamServerConfig `toml:"server"`
+	Bootstrap         []string               `toml:"bootstrap"`
+	Timeout           time.Duration          `toml:"timeout"`
+	Retries           int                    `toml:"retries"`
+	Strategy          string                 `toml:"strategy"`
+	RequestNSID       bool                   `toml:"request_nsid"`
+	RandomizeCase     bool                   `toml:"randomize_case"`
+	HardenReferrals   bool                   `toml:"harden_referral_path"`
+	Pipeline          bool                   `toml:"pipeline"`
+	PipelineConns     int                    `toml:"pipeline_connections"`
+	Encryption        string                 `toml:"encryption"`
+	EncryptionRecheck time.Duration          `toml:"encryption_recheck"`
+	RaceCount         int                    `toml:"race_count"`
+	PassRcodes        []string               `toml:"pass_rcodes"`
+	HealthCheck       HealthCheckConfig      `toml:"health_check"`
+	Audit             UpstreamAuditConfig    `toml:"audit"`
+}
+
+// UpstreamAuditConfig controls the periodic self-audit of the upstream pool.
+type UpstreamAuditConfig struct {
+	Enabled    bool          `toml:"enabled"`
+	Interval   time.Duration `toml:"interval"`
+	StuckAfter time.Duration `toml:"stuck_after"`
+}
+
+type HealthCheckConfig struct {
+	Enabled           bool          `toml:"enabled"`
+	Interval          time.Duration `toml:"interval"`
+	Timeout           time.Duration `toml:"timeout"`
+	FailureThreshold  int           `toml:"failure_threshold"`
+	RecoveryThreshold int           `toml:"recovery_threshold"`
+	ProbeName         string        `toml:"probe_name"`
+}
+
+// What an async log does with entries logged while its buffer is full.
+const (
+	LogOverflowDrop  = "drop"
+	LogOverflowBlock = "block"
+)
+
+// LoggingConfig is the [logging] section. With Async set, entries are
+// formatted by the goroutine that logs them but written to Output by a
+// background one, through a buffer of Buffer entries. Sample keeps only
+// that fraction of the entries of a level, e.g. { debug = 0.01 }.
+type LoggingConfig struct {
+	Level          string             `toml:"level"`
+	Format         string             `toml:"format"`
+	Output         string             `toml:"output"`
+	Modules        map[string]string  `toml:"modules"`
+	ExcludeDomains []string           `toml:"exclude_domains"`
+	ClientNames    ClientNamesConfig  `toml:"client_names"`
+	Async          bool               `toml:"async"`
+	Buffer         int                `toml:"buffer"`
+	Overflow       string             `toml:"overflow"`
+	Sample         map[string]float64 `toml:"sample"`
+}
+
+type QueryLogConfig struct {
+	Enabled     bool          `toml:"enabled"`
+	Path        string        `toml:"path"`
+	Format      string        `toml:"format"`
+	MaxSize     ByteSize      `toml:"max_size"`
+	RotateEvery time.Duration `toml:"rotate_every"`
+	MaxBackups  int           `toml:"max_backups"`
+}
+
+// HistoryConfig selects where answered queries are kept for dashboards:
+// "memory" (a ring of size entries), "sqlite" or "clickhouse". An empty
+// backend disables history.
+type HistoryConfig struct {
+	Backend       string        `toml:"backend"`
+	Size          int           `toml:"size"`
+	Path          string        `toml:"path"`
+	URL           string        `toml:"url"`
+	Table         string        `toml:"table"`
+	Retention     time.Duration `toml:"retention"`
+	FlushInterval time.Duration `toml:"flush_interval"`
+	BatchSize     int           `toml:"batch_size"`
+}
+
+// StatsConfig sizes the per-domain query counters. TopDomains bounds how
+// many names and zones are tracked; ZoneLabels is how many trailing labels
+// of a name make up its zone.
+type StatsConfig struct {
+	TopDomains int `toml:"top_domains"`
+	ZoneLabels int `toml:"zone_labels"`
+}
+
+// ClientNamesConfig controls reverse DNS lookups of client addresses for
+// query logs.
+type ClientNamesConfig struct {
+	Enabled bool          `toml:"enabled"`
+	TTL     time.Duration `toml:"ttl"`
+	Rate    int           `toml:"rate"`
+	Workers int           `toml:"workers"`
+}
+
+// AdminConfig is the [admin] section. Dashboard serves a web page of live
+// statistics at /dashboard on the admin API.
+type AdminConfig struct {
+	Enabled     bool   `toml:"enabled"`
+	BindAddress string `toml:"bind_address"`
+	Port        int    `toml:"port"`
+	Dashboard   bool   `toml:"dashboard"`
+}
+
+type StatusConfig struct {
+	Enabled     bool   `toml:"enabled"`
+	BindAddress string `toml:"bind_address"`
+	Port        int    `toml:"port"`
+	Path        string `toml:"path"`
+}
+
+// RecordStoreConfig keeps records managed at runtime (admin API,
+// external-dns) in a database, so they survive restarts. The only backend is
+// "sqlite"; an empty backend keeps them in memory.
+type RecordStoreConfig struct {
+	Backend string `toml:"backend"`
+	Path    string `toml:"path"`
+}
+
+// ExternalDNSConfig serves the external-dns webhook provider API on its own
+// listener. Domains limits which names external-dns may manage; TTL applies
+// to endpoints without one.
+type ExternalDNSConfig struct {
+	Enabled     bool          `toml:"enabled"`
+	BindAddress string        `toml:"bind_address"`
+	Port        int           `toml:"port"`
+	Domains     []string      `toml:"domains"`
+	TTL         time.Duration `toml:"ttl"`
+}
+
+type WebhookConfig struct {
+	URL     string        `toml:"url"`
+	Secret  string        `toml:"secret"`
 	Timeout time.Duration `toml:"timeout"`
 	Retries int           `toml:"retries"`
 }
 
-type LoggingConfig struct {
-	Level  string `toml:"level"`
-	Format string `toml:"format"`
+type TransferConfig struct {
+	Enabled     bool               `toml:"enabled"`
+	Zones       []string           `toml:"zones"`
+	AllowFrom   []string           `toml:"allow_from"`
+	TSIG        map[string]TSIGKey `toml:"tsig"`
+	JournalSize int                `toml:"journal_size"`
+	Notify      []string           `toml:"notify"`
+	NotifyKey   string             `toml:"notify_tsig_key"`
 }
 
+type SecondaryConfig struct {
+	Primaries []string `toml:"primaries"`
+	TSIGKey   string   `toml:"tsig_key"`
+}
+
+// StubConfig sends questions in a zone to its authoritative servers with
+// recursion off instead of the upstreams.
+type StubConfig struct {
+	Servers []string `toml:"servers"`
+}
+
+type TSIGKey struct {
+	Algorithm string `toml:"algorithm"`
+	Secret    string `toml:"secret"`
+}
+
+type ProfileConfig struct {
+	Enabled bool          `toml:"enabled"`
+	Records RecordsConfig `toml:"records"`
+}
+
+// RecordsConfig holds records by type and owner name. HostsFiles are read
+// in /etc/hosts format for A and AAAA records of names the maps don't hold.
+// Zones are owned locally like zones with an SOA among the records: names
+// in them are never sent upstream. Those without one are given an SOA.
 type RecordsConfig struct {
+	HostsFiles []string `toml:"hosts_files"`
+	Zones      []string `toml:"zones"`
+
 	A      map[string]string       `toml:"A"`
 	AAAA   map[string]string       `toml:"AAAA"`
 	CNAME  map[string]string       `toml:"CNAME"`
+	NS     map[string][]string     `toml:"NS"`
+	SOA    map[string]SOARecord    `toml:"SOA"`
 	MX     map[string]MXRecord     `toml:"MX"`
-	TXT    map[string]string       `toml:"TXT"`
+	TXT    map[string]TXTRecord    `toml:"TXT"`
 	HTTPS  map[string]HTTPSRecord  `toml:"HTTPS"`
 	CAA    map[string]CAARecord    `toml:"CAA"`
 	SRV    map[string]SRVRecord    `toml:"SRV"`
@@ -67,6 +351,48 @@ type MXRecord struct {
 	Target   string `toml:"target"`
 }
 
+// TXTRecord holds the strings of a TXT record, written as one string or
+// an array of them. Strings longer than the 255 bytes a TXT string can
+// hold, as DKIM keys often are, are split when the record is built.
+type TXTRecord []string
+
+func (t *TXTRecord) UnmarshalTOML(value any) error {
+	switch v := value.(type) {
+	case string:
+		*t = TXTRecord{v}
+	case []any:
+		strs := make(TXTRecord, 0, len(v))
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("TXT record strings must be strings, got %T", item)
+			}
+			strs = append(strs, str)
+		}
+		*t = strs
+	default:
+		return fmt.Errorf("TXT record must be a string or an array of strings, got %T", value)
+	}
+	return nil
+}
+
+// UnmarshalText takes the value of a -set override or environment variable
+// as a single string, commas and all.
+func (t *TXTRecord) UnmarshalText(text []byte) error {
+	*t = TXTRecord{string(text)}
+	return nil
+}
+
+type SOARecord struct {
+	MName   string `toml:"mname"`
+	RName   string `toml:"rname"`
+	Serial  uint32 `toml:"serial"`
+	Refresh uint32 `toml:"refresh"`
+	Retry   uint32 `toml:"retry"`
+	Expire  uint32 `toml:"expire"`
+	Minimum uint32 `toml:"minimum"`
+}
+
 type HTTPSRecord struct {
 	Priority int    `toml:"priority"`
 	Target   string `toml:"target"`
@@ -152,21 +478,67 @@ type ConfigLoader interface {
 	Load(path string) (*Config, error)
 }
 
-type TOMLConfigLoader struct{}
+// FileConfigLoader reads the config file and applies overrides on top of
+// it. Values are taken, lowest precedence first, from the built-in defaults,
+// the file, DNS_ environment variables and -set overrides.
+type FileConfigLoader struct {
+	format    string
+	environ   []string
+	overrides []string
+}
+
+// NewConfigLoader returns a loader that picks the format by file extension:
+// .yaml and .yml are YAML, .json is JSON and anything else TOML.
+func NewConfigLoader() *FileConfigLoader {
+	return &FileConfigLoader{}
+}
+
+func NewTOMLConfigLoader() *FileConfigLoader {
+	return &FileConfigLoader{format: FormatTOML}
+}
+
+func NewYAMLConfigLoader() *FileConfigLoader {
+	return &FileConfigLoader{format: FormatYAML}
+}
+
+func NewJSONConfigLoader() *FileConfigLoader {
+	return &FileConfigLoader{format: FormatJSON}
+}
+
+// SetEnvironment sets the variables, as KEY=value like os.Environ, whose
+// DNS_ entries override values of the file.
+func (l *FileConfigLoader) SetEnvironment(environ []string) {
+	l.environ = environ
+}
 
-func NewTOMLConfigLoader() *TOMLConfigLoader {
-	return &TOMLConfigLoader{}
+// SetOverrides sets key=value pairs with dotted TOML keys that override the
+// file and the environment.
+func (l *FileConfigLoader) SetOverrides(overrides []string) {
+	l.overrides = overrides
 }
 
-func (l *TOMLConfigLoader) Load(path string) (*Config, error) {
+func (l *FileConfigLoader) Load(path string) (*Config, error) {
 	config := &Config{}
 
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return l.defaultConfig(), nil
+		config = l.defaultConfig()
+		if len(l.environ) == 0 && len(l.overrides) == 0 {
+			resolvePaths(config, filepath.Dir(path))
+			return config, nil
+		}
+	} else if err := decodeFile(path, l.format, config); err != nil {
+		return nil, fmt.Errorf("failed to decode config file %s: %w", path, err)
 	}
 
-	if _, err := toml.DecodeFile(path, config); err != nil {
-		return nil, fmt.Errorf("failed to decode config file %s: %w", path, err)
+	if err := applyEnvironment(config, l.environ); err != nil {
+		return nil, fmt.Errorf("invalid environment override: %w", err)
+	}
+	if err := applyOverrides(config, l.overrides); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if err := expandSites(config); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
 	if err := l.validate(config); err != nil {
@@ -174,111 +546,501 @@ func (l *TOMLConfigLoader) Load(path string) (*Config, error) {
 	}
 
 	l.setDefaults(config)
+	resolvePaths(config, filepath.Dir(path))
 	return config, nil
 }
 
-func (l *TOMLConfigLoader) defaultConfig() *Config {
+// resolvePaths makes the relative paths of the files the server reads and
+// writes relative to dir, the directory of the config file, rather than to
+// whichever directory the server was started from. Hook commands are run as
+// given.
+func resolvePaths(config *Config, dir string) {
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	resolveAll := func(paths []string) {
+		for i := range paths {
+			resolve(&paths[i])
+		}
+	}
+
+	resolve(&config.Server.PIDFile)
+	for i := range config.Server.Listen {
+		resolve(&config.Server.Listen[i].CertFile)
+		resolve(&config.Server.Listen[i].KeyFile)
+	}
+	resolve(&config.Server.DNSCrypt.KeyFile)
+	resolve(&config.Cache.PersistPath)
+	for i := range config.Upstream.ServerBlocks {
+		resolve(&config.Upstream.ServerBlocks[i].TLSCAFile)
+	}
+
+	resolveAll(config.Records.HostsFiles)
+	resolveAll(config.Fallback.Records.HostsFiles)
+	for _, profile := range config.Profiles {
+		resolveAll(profile.Records.HostsFiles)
+	}
+	for i := range config.Views {
+		resolveAll(config.Views[i].Records.HostsFiles)
+	}
+
+	resolve(&config.Discovery.TokenFile)
+	resolve(&config.Discovery.CAFile)
+	resolve(&config.Leases.Path)
+	resolve(&config.QueryLog.Path)
+	resolve(&config.History.Path)
+	resolve(&config.RecordStore.Path)
+}
+
+func (l *FileConfigLoader) defaultConfig() *Config {
 	config := &Config{
 		Server: ServerConfig{
 			Port:         53,
 			BindAddress:  "0.0.0.0",
 			ReadTimeout:  5 * time.Second,
 			WriteTimeout: 5 * time.Second,
+			DrainTimeout: 5 * time.Second,
 		},
 		Cache: CacheConfig{
 			MaxEntries:      10000,
 			DefaultTTL:      300 * time.Second,
 			CleanupInterval: 60 * time.Second,
+			PersistPath:     "dns-cache.bin",
+			PersistInterval: 5 * time.Minute,
+			MaxStale:        24 * time.Hour,
+			StaleTTL:        30 * time.Second,
+			TTLMode:         "minimum",
+			Prefetch: PrefetchConfig{
+				MinHits:   3,
+				Threshold: 0.8,
+				Interval:  time.Second,
+				Workers:   4,
+			},
+			Adaptive: AdaptiveConfig{
+				MinEntries: 1000,
+				Interval:   10 * time.Second,
+			},
+			Writes: CacheWritesConfig{
+				Buffer:   4096,
+				Batch:    128,
+				Overflow: CacheOverflowDrop,
+			},
 		},
 		Upstream: UpstreamConfig{
-			Servers: []string{"8.8.8.8:53", "1.1.1.1:53"},
-			Timeout: 2 * time.Second,
-			Retries: 3,
+			Mode:              "forward",
+			QNameMinimization: "relaxed",
+			Servers:           []string{"8.8.8.8:53", "1.1.1.1:53"},
+			Timeout:           2 * time.Second,
+			Retries:           3,
+			Strategy:          "serial",
+			PassRcodes:        []string{"REFUSED", "NOTIMP", "FORMERR"},
+			HealthCheck: HealthCheckConfig{
+				Interval:          10 * time.Second,
+				Timeout:           2 * time.Second,
+				FailureThreshold:  3,
+				RecoveryThreshold: 2,
+				ProbeName:         ".",
+			},
+			Audit: UpstreamAuditConfig{
+				Enabled:    true,
+				Interval:   time.Minute,
+				StuckAfter: 30 * time.Second,
+			},
 		},
 		Logging: LoggingConfig{
 			Level:  "info",
 			Format: "json",
+			ClientNames: ClientNamesConfig{
+				TTL:     time.Hour,
+				Rate:    10,
+				Workers: 4,
+			},
+		},
+		Admin: AdminConfig{
+			Enabled:     false,
+			BindAddress: "127.0.0.1",
+			Port:        8053,
+		},
+		Status: StatusConfig{
+			Enabled:     false,
+			BindAddress: "0.0.0.0",
+			Port:        8054,
+			Path:        "/status",
 		},
 		Records: RecordsConfig{
 			A:     make(map[string]string),
 			AAAA:  make(map[string]string),
 			CNAME: make(map[string]string),
+			NS:    make(map[string][]string),
+			SOA:   make(map[string]SOARecord),
 			MX:    make(map[string]MXRecord),
-			TXT:   make(map[string]string),
+			TXT:   make(map[string]TXTRecord),
+		},
+		Profiles: make(map[string]ProfileConfig),
+		Fallback: FallbackConfig{
+			TTL: 30 * time.Second,
+		},
+		Transfer: TransferConfig{
+			JournalSize: 100,
+		},
+		QueryLog: QueryLogConfig{
+			Format:     "json",
+			MaxSize:    100 << 20,
+			MaxBackups: 5,
+		},
+		History: HistoryConfig{
+			Size:          10000,
+			Path:          "history.db",
+			Table:         "dns_queries",
+			FlushInterval: time.Second,
+			BatchSize:     500,
+		},
+		Stats: StatsConfig{
+			TopDomains: 100,
+			ZoneLabels: 2,
+		},
+		RecordStore: RecordStoreConfig{
+			Path: "records.db",
+		},
+		Precedence: PrecedenceConfig{
+			Order:    DefaultSourceOrder,
+			Conflict: ConflictFirstWins,
+		},
+		ExternalDNS: ExternalDNSConfig{
+			BindAddress: "127.0.0.1",
+			Port:        8888,
+			TTL:         300 * time.Second,
+		},
+		Filter: FilterConfig{
+			Response:   FilterResponseNXDomain,
+			SafeSearch: SafeSearchConfig{YouTube: YouTubeStrict},
 		},
 	}
 	return config
 }
 
-func (l *TOMLConfigLoader) validate(config *Config) error {
-	if config.Server.Port < 1 || config.Server.Port > 65535 {
+func (l *FileConfigLoader) validate(config *Config) error {
+	if len(config.Server.Listen) == 0 && (config.Server.Port < 1 || config.Server.Port > 65535) {
 		return fmt.Errorf("invalid server port: %d", config.Server.Port)
 	}
+	if err := validateListeners(config.Server.Listen); err != nil {
+		return err
+	}
+	if err := validateDNSCrypt(&config.Server.DNSCrypt, config.Server.Listen); err != nil {
+		return err
+	}
+	if config.Server.DrainTimeout < 0 {
+		return fmt.Errorf("server drain_timeout must not be negative: %s", config.Server.DrainTimeout)
+	}
+	if config.Server.MaxQueries < 0 {
+		return fmt.Errorf("server max_concurrent_queries must not be negative: %d", config.Server.MaxQueries)
+	}
+	switch config.Server.Overload {
+	case "", OverloadRefuse, OverloadDrop:
+	default:
+		return fmt.Errorf("invalid server overload action: %s", config.Server.Overload)
+	}
 
 	if config.Cache.MaxEntries < 1 {
 		return fmt.Errorf("cache max_entries must be positive: %d", config.Cache.MaxEntries)
 	}
 
-	if len(config.Upstream.Servers) == 0 {
-		return fmt.Errorf("at least one upstream server must be configured")
+	if config.Cache.PersistInterval < 0 {
+		return fmt.Errorf("cache persist_interval must be non-negative: %s", config.Cache.PersistInterval)
 	}
 
-	if config.Upstream.Retries < 0 {
-		return fmt.Errorf("upstream retries must be non-negative: %d", config.Upstream.Retries)
+	if config.Cache.Shards < 0 {
+		return fmt.Errorf("cache shards must be non-negative: %d", config.Cache.Shards)
 	}
 
-	if err := l.validateRecords(config); err != nil {
-		return fmt.Errorf("invalid records configuration: %w", err)
+	if config.Cache.MaxMemoryMB < 0 {
+		return fmt.Errorf("cache max_memory_mb must be non-negative: %d", config.Cache.MaxMemoryMB)
 	}
 
-	return nil
-}
+	if config.Cache.MaxStale < 0 || config.Cache.StaleTTL < 0 {
+		return fmt.Errorf("cache max_stale and stale_ttl must be non-negative")
+	}
 
-func (l *TOMLConfigLoader) validateRecords(config *Config) error {
-	for domain, ip := range config.Records.A {
-		if !l.isValidDomain(domain) {
-			return fmt.Errorf("invalid A record domain: %s", domain)
+	switch config.Cache.TTLMode {
+	case "", "minimum", "rrset":
+	default:
+		return fmt.Errorf("invalid cache ttl_mode: %s", config.Cache.TTLMode)
+	}
+
+	for _, network := range append(config.Server.AllowFrom, config.Server.DenyFrom...) {
+		if _, err := ParseNetwork(network); err != nil {
+			return fmt.Errorf("server allow_from/deny_from: %w", err)
 		}
-		if net.ParseIP(ip) == nil {
-			return fmt.Errorf("invalid A record IP for %s: %s", domain, ip)
+	}
+
+	for _, allowed := range config.Cache.AllowFrom {
+		if _, err := ParseNetwork(allowed); err != nil {
+			return fmt.Errorf("cache allow_from: %w", err)
 		}
 	}
 
-	for domain, ip := range config.Records.AAAA {
-		if !l.isValidDomain(domain) {
-			return fmt.Errorf("invalid AAAA record domain: %s", domain)
+	for qtype, policy := range config.Cache.TTLPolicy {
+		if _, known := dns.StringToType[strings.ToUpper(qtype)]; !known {
+			return fmt.Errorf("cache ttl_policy: unknown record type %q", qtype)
 		}
-		if net.ParseIP(ip) == nil {
-			return fmt.Errorf("invalid AAAA record IP for %s: %s", domain, ip)
+		if policy.Min < 0 || policy.Max < 0 || (policy.Max > 0 && policy.Min > policy.Max) {
+			return fmt.Errorf("cache ttl_policy %s: min and max must be non-negative with min <= max", qtype)
 		}
 	}
 
-	for domain, target := range config.Records.CNAME {
-		if !l.isValidDomain(domain) {
-			return fmt.Errorf("invalid CNAME record domain: %s", domain)
+	if config.Cache.Prefetch.Threshold < 0 || config.Cache.Prefetch.Threshold >= 1 {
+		return fmt.Errorf("cache prefetch threshold must be between 0 and 1: %g", config.Cache.Prefetch.Threshold)
+	}
+	if config.Cache.Prefetch.Workers < 0 {
+		return fmt.Errorf("cache prefetch workers must be non-negative: %d", config.Cache.Prefetch.Workers)
+	}
+
+	if config.Cache.Adaptive.Enabled && config.Cache.Adaptive.MemoryLimit <= 0 {
+		return fmt.Errorf("cache adaptive sizing needs a memory_limit")
+	}
+	if config.Cache.Adaptive.Enabled && (config.Cache.Adaptive.MinEntries < 0 || config.Cache.Adaptive.MinEntries > config.Cache.MaxEntries) {
+		return fmt.Errorf("cache adaptive min_entries must be between 0 and max_entries: %d", config.Cache.Adaptive.MinEntries)
+	}
+
+	if config.Cache.Writes.Buffer < 0 || config.Cache.Writes.Batch < 0 {
+		return fmt.Errorf("cache writes buffer and batch must be non-negative")
+	}
+	switch config.Cache.Writes.Overflow {
+	case "", CacheOverflowDrop, CacheOverflowSync:
+	default:
+		return fmt.Errorf("invalid cache writes overflow action: %s", config.Cache.Writes.Overflow)
+	}
+
+	switch config.Upstream.Mode {
+	case "", "forward":
+		if len(config.Upstream.UpstreamServers()) == 0 {
+			return fmt.Errorf("at least one upstream server must be configured")
 		}
-		if !l.isValidDomain(target) {
-			return fmt.Errorf("invalid CNAME record target for %s: %s", domain, target)
+		for i := range config.Upstream.ServerBlocks {
+			if err := validateUpstreamServer(&config.Upstream.ServerBlocks[i]); err != nil {
+				return err
+			}
 		}
+		for _, server := range config.Upstream.Bootstrap {
+			if err := validateBootstrapServer(server); err != nil {
+				return err
+			}
+		}
+	case "recursive":
+		for _, hint := range config.Upstream.RootHints {
+			if _, _, err := net.SplitHostPort(hint); err != nil {
+				return fmt.Errorf("invalid root hint %s: %w", hint, err)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid upstream mode: %s", config.Upstream.Mode)
+	}
+
+	switch config.Upstream.QNameMinimization {
+	case "", "off", "relaxed", "strict":
+	default:
+		return fmt.Errorf("invalid upstream qname_minimization: %s", config.Upstream.QNameMinimization)
 	}
 
-	for domain, mx := range config.Records.MX {
-		if !l.isValidDomain(domain) {
-			return fmt.Errorf("invalid MX record domain: %s", domain)
+	if config.Upstream.Retries < 0 {
+		return fmt.Errorf("upstream retries must be non-negative: %d", config.Upstream.Retries)
+	}
+	for _, name := range config.Upstream.PassRcodes {
+		rcode, known := dns.StringToRcode[strings.ToUpper(name)]
+		if !known || rcode == dns.RcodeSuccess || rcode == dns.RcodeNameError {
+			return fmt.Errorf("upstream pass_rcodes: %q is not an error RCODE", name)
 		}
-		if !l.isValidDomain(mx.Target) {
-			return fmt.Errorf("invalid MX record target for %s: %s", domain, mx.Target)
+	}
+
+	if config.Admin.Port < 0 || config.Admin.Port > 65535 {
+		return fmt.Errorf("invalid admin port: %d", config.Admin.Port)
+	}
+
+	if config.Status.Port < 0 || config.Status.Port > 65535 {
+		return fmt.Errorf("invalid status port: %d", config.Status.Port)
+	}
+	if config.Status.Path != "" && !strings.HasPrefix(config.Status.Path, "/") {
+		return fmt.Errorf("status path must start with /: %s", config.Status.Path)
+	}
+
+	switch config.Upstream.Strategy {
+	case "", "serial", "race":
+	default:
+		return fmt.Errorf("invalid upstream strategy: %s", config.Upstream.Strategy)
+	}
+
+	switch config.Upstream.Encryption {
+	case "", "off", "opportunistic":
+	default:
+		return fmt.Errorf("invalid upstream encryption: %s", config.Upstream.Encryption)
+	}
+	if config.Upstream.EncryptionRecheck < 0 {
+		return fmt.Errorf("upstream encryption_recheck must be non-negative: %s", config.Upstream.EncryptionRecheck)
+	}
+
+	if config.Upstream.PipelineConns < 0 {
+		return fmt.Errorf("upstream pipeline_connections must be non-negative: %d", config.Upstream.PipelineConns)
+	}
+	if config.Upstream.RaceCount < 0 {
+		return fmt.Errorf("upstream race_count must be non-negative: %d", config.Upstream.RaceCount)
+	}
+
+	if config.Upstream.Audit.Interval < 0 || config.Upstream.Audit.StuckAfter < 0 {
+		return fmt.Errorf("upstream audit interval and stuck_after must be non-negative")
+	}
+
+	if config.Upstream.HealthCheck.FailureThreshold < 0 || config.Upstream.HealthCheck.RecoveryThreshold < 0 {
+		return fmt.Errorf("upstream health_check thresholds must be non-negative")
+	}
+
+	if config.QueryLog.Enabled && config.QueryLog.Path == "" {
+		return fmt.Errorf("query_log needs a path")
+	}
+	switch config.QueryLog.Format {
+	case "", "json", "text":
+	default:
+		return fmt.Errorf("invalid query_log format: %s", config.QueryLog.Format)
+	}
+	if config.QueryLog.MaxSize < 0 || config.QueryLog.RotateEvery < 0 || config.QueryLog.MaxBackups < 0 {
+		return fmt.Errorf("query_log max_size, rotate_every and max_backups must be non-negative")
+	}
+
+	if err := validateHistory(&config.History); err != nil {
+		return err
+	}
+	if err := validateTracing(&config.Tracing); err != nil {
+		return err
+	}
+	if err := validateRewrite(&config.Rewrite); err != nil {
+		return err
+	}
+	if err := validateFilter(&config.Filter); err != nil {
+		return err
+	}
+	if err := validateHooks(config.Hooks); err != nil {
+		return err
+	}
+
+	if err := validatePrecedence(&config.Precedence); err != nil {
+		return err
+	}
+	if err := validateChaos(&config.Chaos); err != nil {
+		return err
+	}
+
+	switch config.RecordStore.Backend {
+	case "", "sqlite":
+	default:
+		return fmt.Errorf("invalid record_store backend: %s", config.RecordStore.Backend)
+	}
+
+	if config.ExternalDNS.Port < 0 || config.ExternalDNS.Port > 65535 {
+		return fmt.Errorf("invalid external_dns port: %d", config.ExternalDNS.Port)
+	}
+	if config.ExternalDNS.TTL < 0 {
+		return fmt.Errorf("external_dns ttl must be non-negative")
+	}
+
+	if config.Stats.TopDomains < 0 || config.Stats.ZoneLabels < 0 {
+		return fmt.Errorf("stats top_domains and zone_labels must be non-negative")
+	}
+
+	if names := config.Logging.ClientNames; names.TTL < 0 || names.Rate < 0 || names.Workers < 0 {
+		return fmt.Errorf("logging client_names ttl, rate and workers must be non-negative")
+	}
+	if config.Logging.Buffer < 0 {
+		return fmt.Errorf("logging buffer must be non-negative")
+	}
+	switch config.Logging.Overflow {
+	case "", LogOverflowDrop, LogOverflowBlock:
+	default:
+		return fmt.Errorf("invalid logging overflow action: %s", config.Logging.Overflow)
+	}
+	for level, rate := range config.Logging.Sample {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("logging sample rate for %s must be between 0 and 1: %g", level, rate)
+		}
+	}
+
+	for _, hook := range config.Webhooks {
+		u, err := url.Parse(hook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook url: %q", hook.URL)
+		}
+		if hook.Retries < 0 {
+			return fmt.Errorf("webhook retries must be non-negative: %d", hook.Retries)
+		}
+	}
+
+	if err := l.validateRecords("records", &config.Records); err != nil {
+		return err
+	}
+
+	if err := validateTransfer(&config.Transfer, &config.Records); err != nil {
+		return fmt.Errorf("invalid transfer configuration: %w", err)
+	}
+
+	for zone, secondary := range config.Secondary {
+		if err := validateSecondary(secondary, config.Transfer.TSIG); err != nil {
+			return fmt.Errorf("invalid secondary zone %s: %w", zone, err)
+		}
+	}
+
+	for zone, stub := range config.Stub {
+		if len(stub.Servers) == 0 {
+			return fmt.Errorf("stub zone %s needs at least one server", zone)
+		}
+		for _, server := range stub.Servers {
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				return fmt.Errorf("stub zone %s server must be host:port: %s", zone, server)
+			}
 		}
-		if mx.Priority < 0 || mx.Priority > 65535 {
-			return fmt.Errorf("invalid MX record priority for %s: %d", domain, mx.Priority)
+	}
+
+	if err := l.validateRecords("fallback.records", &config.Fallback.Records); err != nil {
+		return err
+	}
+
+	for name, profile := range config.Profiles {
+		if name == "" {
+			return fmt.Errorf("profile name must not be empty")
 		}
+		if err := l.validateRecords("profiles."+tomlKey(name)+".records", &profile.Records); err != nil {
+			return err
+		}
+	}
+	if err := l.validateViews(config.Views); err != nil {
+		return err
+	}
+	if err := l.validatePools(config.Pools); err != nil {
+		return err
+	}
+	if err := validateDiscovery(&config.Discovery); err != nil {
+		return err
+	}
+	if err := validateMDNS(&config.MDNS); err != nil {
+		return err
+	}
+	if err := validateLeases(&config.Leases); err != nil {
+		return err
+	}
+	if err := l.validateSpecialZones(&config.Special); err != nil {
+		return err
+	}
+	if err := l.validateLocalZones(config.LocalZones); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-func (l *TOMLConfigLoader) isValidDomain(domain string) bool {
-	if len(domain) == 0 || len(domain) > 253 {
+// isValidDomain checks domain as it will be stored, with internationalized
+// labels in punycode.
+func (l *FileConfigLoader) isValidDomain(domain string) bool {
+	domain, err := ToASCII(domain)
+	if err != nil || len(domain) == 0 || len(domain) > 253 {
 		return false
 	}
 
@@ -295,7 +1057,7 @@ func (l *TOMLConfigLoader) isValidDomain(domain string) bool {
 	return true
 }
 
-func (l *TOMLConfigLoader) setDefaults(config *Config) {
+func (l *FileConfigLoader) setDefaults(config *Config) {
 	if config.Server.Port == 0 {
 		config.Server.Port = 53
 	}
@@ -308,6 +1070,12 @@ func (l *TOMLConfigLoader) setDefaults(config *Config) {
 	if config.Server.WriteTimeout == 0 {
 		config.Server.WriteTimeout = 5 * time.Second
 	}
+	if config.Server.DrainTimeout == 0 {
+		config.Server.DrainTimeout = 5 * time.Second
+	}
+	if config.Server.Overload == "" {
+		config.Server.Overload = OverloadRefuse
+	}
 	if config.Cache.MaxEntries == 0 {
 		config.Cache.MaxEntries = 10000
 	}
@@ -317,21 +1085,203 @@ func (l *TOMLConfigLoader) setDefaults(config *Config) {
 	if config.Cache.CleanupInterval == 0 {
 		config.Cache.CleanupInterval = 60 * time.Second
 	}
-	if len(config.Upstream.Servers) == 0 {
+	if config.Cache.PersistPath == "" {
+		config.Cache.PersistPath = "dns-cache.bin"
+	}
+	if config.Cache.PersistInterval == 0 {
+		config.Cache.PersistInterval = 5 * time.Minute
+	}
+	if config.Cache.MaxStale == 0 {
+		config.Cache.MaxStale = 24 * time.Hour
+	}
+	if config.Cache.StaleTTL == 0 {
+		config.Cache.StaleTTL = 30 * time.Second
+	}
+	if config.Cache.TTLMode == "" {
+		config.Cache.TTLMode = "minimum"
+	}
+	if config.Cache.Prefetch.MinHits == 0 {
+		config.Cache.Prefetch.MinHits = 3
+	}
+	if config.Cache.Prefetch.Threshold == 0 {
+		config.Cache.Prefetch.Threshold = 0.8
+	}
+	if config.Cache.Prefetch.Interval == 0 {
+		config.Cache.Prefetch.Interval = time.Second
+	}
+	if config.Cache.Prefetch.Workers == 0 {
+		config.Cache.Prefetch.Workers = 4
+	}
+	if config.Cache.Adaptive.MinEntries == 0 {
+		config.Cache.Adaptive.MinEntries = min(1000, config.Cache.MaxEntries)
+	}
+	if config.Cache.Adaptive.Interval == 0 {
+		config.Cache.Adaptive.Interval = 10 * time.Second
+	}
+	if config.Cache.Writes.Buffer == 0 {
+		config.Cache.Writes.Buffer = 4096
+	}
+	if config.Cache.Writes.Batch == 0 {
+		config.Cache.Writes.Batch = 128
+	}
+	if config.Cache.Writes.Overflow == "" {
+		config.Cache.Writes.Overflow = CacheOverflowDrop
+	}
+	if config.Upstream.Mode == "" {
+		config.Upstream.Mode = "forward"
+	}
+	if config.Upstream.QNameMinimization == "" {
+		config.Upstream.QNameMinimization = "relaxed"
+	}
+	if len(config.Upstream.Servers) == 0 && len(config.Upstream.ServerBlocks) == 0 {
 		config.Upstream.Servers = []string{"8.8.8.8:53", "1.1.1.1:53"}
 	}
+	for i := range config.Upstream.ServerBlocks {
+		setUpstreamServerDefaults(&config.Upstream.ServerBlocks[i])
+	}
+	for i, server := range config.Upstream.Bootstrap {
+		if net.ParseIP(server) != nil {
+			config.Upstream.Bootstrap[i] = net.JoinHostPort(server, "53")
+		}
+	}
 	if config.Upstream.Timeout == 0 {
 		config.Upstream.Timeout = 2 * time.Second
 	}
 	if config.Upstream.Retries == 0 {
 		config.Upstream.Retries = 3
 	}
+	if config.Upstream.PassRcodes == nil {
+		config.Upstream.PassRcodes = []string{"REFUSED", "NOTIMP", "FORMERR"}
+	}
+	if config.Upstream.Strategy == "" {
+		config.Upstream.Strategy = "serial"
+	}
+	if config.Upstream.PipelineConns == 0 {
+		config.Upstream.PipelineConns = 1
+	}
+	if config.Upstream.Encryption == "" {
+		config.Upstream.Encryption = "off"
+	}
+	if config.Upstream.EncryptionRecheck == 0 {
+		config.Upstream.EncryptionRecheck = time.Hour
+	}
+	if config.Upstream.HealthCheck.Interval == 0 {
+		config.Upstream.HealthCheck.Interval = 10 * time.Second
+	}
+	if config.Upstream.HealthCheck.Timeout == 0 {
+		config.Upstream.HealthCheck.Timeout = config.Upstream.Timeout
+	}
+	if config.Upstream.HealthCheck.FailureThreshold == 0 {
+		config.Upstream.HealthCheck.FailureThreshold = 3
+	}
+	if config.Upstream.HealthCheck.RecoveryThreshold == 0 {
+		config.Upstream.HealthCheck.RecoveryThreshold = 2
+	}
+	if config.Upstream.HealthCheck.ProbeName == "" {
+		config.Upstream.HealthCheck.ProbeName = "."
+	}
+	if config.Upstream.Audit.Interval == 0 {
+		config.Upstream.Audit.Interval = time.Minute
+	}
+	if config.Upstream.Audit.StuckAfter == 0 {
+		config.Upstream.Audit.StuckAfter = 30 * time.Second
+	}
 	if config.Logging.Level == "" {
 		config.Logging.Level = "info"
 	}
 	if config.Logging.Format == "" {
 		config.Logging.Format = "json"
 	}
+	if config.Logging.Buffer == 0 {
+		config.Logging.Buffer = 8192
+	}
+	if config.Logging.Overflow == "" {
+		config.Logging.Overflow = LogOverflowDrop
+	}
+	if config.QueryLog.Format == "" {
+		config.QueryLog.Format = "json"
+	}
+	if config.QueryLog.MaxSize == 0 {
+		config.QueryLog.MaxSize = 100 << 20
+	}
+	if config.QueryLog.MaxBackups == 0 {
+		config.QueryLog.MaxBackups = 5
+	}
+	if config.History.Size == 0 {
+		config.History.Size = 10000
+	}
+	if config.History.Path == "" {
+		config.History.Path = "history.db"
+	}
+	if config.History.Table == "" {
+		config.History.Table = "dns_queries"
+	}
+	if config.History.FlushInterval == 0 {
+		config.History.FlushInterval = time.Second
+	}
+	if config.History.BatchSize == 0 {
+		config.History.BatchSize = 500
+	}
+	for i := range config.Hooks {
+		if config.Hooks[i].Name == "" {
+			config.Hooks[i].Name = fmt.Sprintf("hook%d", i+1)
+		}
+		if config.Hooks[i].Timeout == 0 {
+			config.Hooks[i].Timeout = 2 * time.Second
+		}
+	}
+	if config.RecordStore.Path == "" {
+		config.RecordStore.Path = "records.db"
+	}
+	setPrecedenceDefaults(&config.Precedence)
+	setChaosDefaults(&config.Chaos)
+	setTracingDefaults(&config.Tracing)
+	setListenerDefaults(config.Server.Listen)
+	setDNSCryptDefaults(&config.Server.DNSCrypt)
+	setPoolDefaults(config.Pools)
+	setDiscoveryDefaults(&config.Discovery)
+	setMDNSDefaults(&config.MDNS)
+	setLeasesDefaults(&config.Leases)
+	setFilterDefaults(&config.Filter)
+	if config.ExternalDNS.BindAddress == "" {
+		config.ExternalDNS.BindAddress = "127.0.0.1"
+	}
+	if config.ExternalDNS.Port == 0 {
+		config.ExternalDNS.Port = 8888
+	}
+	if config.ExternalDNS.TTL == 0 {
+		config.ExternalDNS.TTL = 300 * time.Second
+	}
+	if config.Stats.TopDomains == 0 {
+		config.Stats.TopDomains = 100
+	}
+	if config.Stats.ZoneLabels == 0 {
+		config.Stats.ZoneLabels = 2
+	}
+	if config.Logging.ClientNames.TTL == 0 {
+		config.Logging.ClientNames.TTL = time.Hour
+	}
+	if config.Logging.ClientNames.Rate == 0 {
+		config.Logging.ClientNames.Rate = 10
+	}
+	if config.Logging.ClientNames.Workers == 0 {
+		config.Logging.ClientNames.Workers = 4
+	}
+	if config.Admin.BindAddress == "" {
+		config.Admin.BindAddress = "127.0.0.1"
+	}
+	if config.Admin.Port == 0 {
+		config.Admin.Port = 8053
+	}
+	if config.Status.BindAddress == "" {
+		config.Status.BindAddress = "0.0.0.0"
+	}
+	if config.Status.Port == 0 {
+		config.Status.Port = 8054
+	}
+	if config.Status.Path == "" {
+		config.Status.Path = "/status"
+	}
 	if config.Records.A == nil {
 		config.Records.A = make(map[string]string)
 	}
@@ -344,7 +1294,27 @@ func (l *TOMLConfigLoader) setDefaults(config *Config) {
 	if config.Records.MX == nil {
 		config.Records.MX = make(map[string]MXRecord)
 	}
+	if config.Records.NS == nil {
+		config.Records.NS = make(map[string][]string)
+	}
+	if config.Records.SOA == nil {
+		config.Records.SOA = make(map[string]SOARecord)
+	}
 	if config.Records.TXT == nil {
-		config.Records.TXT = make(map[string]string)
+		config.Records.TXT = make(map[string]TXTRecord)
+	}
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]ProfileConfig)
+	}
+	if config.Fallback.TTL == 0 {
+		config.Fallback.TTL = 30 * time.Second
+	}
+	for i := range config.Webhooks {
+		if config.Webhooks[i].Timeout == 0 {
+			config.Webhooks[i].Timeout = 5 * time.Second
+		}
+	}
+	if config.Transfer.JournalSize == 0 {
+		config.Transfer.JournalSize = 100
 	}
 }
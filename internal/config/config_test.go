@@ -0,0 +1,52 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathsRelativeToConfig(t *testing.T) {
+	dir := t.TempDir()
+	abs := filepath.Join(t.TempDir(), "cache.bin")
+
+	tests := []struct {
+		name    string
+		content string
+		path    func(*Config) string
+		want    string
+	}{
+		{"default persist_path", "", func(c *Config) string { return c.Cache.PersistPath }, filepath.Join(dir, "dns-cache.bin")},
+		{"relative persist_path", "cache.persist_path = \"state/cache.bin\"\n", func(c *Config) string { return c.Cache.PersistPath }, filepath.Join(dir, "state", "cache.bin")},
+		{"absolute persist_path", "cache.persist_path = \"" + abs + "\"\n", func(c *Config) string { return c.Cache.PersistPath }, abs},
+		{"pid_file", "server.pid_file = \"run/dns.pid\"\n", func(c *Config) string { return c.Server.PIDFile }, filepath.Join(dir, "run", "dns.pid")},
+		{"hosts_files", "records.hosts_files = [\"hosts\"]\n", func(c *Config) string { return c.Records.HostsFiles[0] }, filepath.Join(dir, "hosts")},
+		{"query log", "query_log.path = \"queries.log\"\n", func(c *Config) string { return c.QueryLog.Path }, filepath.Join(dir, "queries.log")},
+		{"history", "", func(c *Config) string { return c.History.Path }, filepath.Join(dir, "history.db")},
+		{"unset stays unset", "", func(c *Config) string { return c.Server.PIDFile }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "config.toml")
+			content := "server.port = 53\ncache.max_entries = 100\nupstream.servers = [\"127.0.0.1:1\"]\n" + tt.content
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := NewConfigLoader().Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.path(cfg); got != tt.want {
+				t.Errorf("path = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	cfg, err := NewConfigLoader().Load(filepath.Join(dir, "missing.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "dns-cache.bin"); cfg.Cache.PersistPath != want {
+		t.Errorf("persist_path without a config file = %s, want %s", cfg.Cache.PersistPath, want)
+	}
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Discovery backends.
+const (
+	DiscoveryConsul     = "consul"
+	DiscoveryEtcd       = "etcd"
+	DiscoveryKubernetes = "kubernetes"
+)
+
+// DiscoveryConfig synthesizes records for the services of a Consul catalog
+// or the SkyDNS-style entries under an etcd prefix. Consul services are
+// answered as <service>.<domain>, with A/AAAA records for every passing
+// instance and SRV records for their ports. An etcd key like
+// /skydns/local/cluster/web/1 holding {"host": "10.0.0.5", "port": 8080}
+// is answered as 1.web.cluster.local and, together with its siblings, as
+// web.cluster.local. Kubernetes EndpointSlices are answered the way cluster
+// DNS answers headless services under Domain.
+type DiscoveryConfig struct {
+	Backend   string        `toml:"backend"`
+	Address   string        `toml:"address"`
+	Token     string        `toml:"token"`
+	TokenFile string        `toml:"token_file"`
+	CAFile    string        `toml:"ca_file"`
+	Domain    string        `toml:"domain"`
+	Prefix    string        `toml:"prefix"`
+	Interval  time.Duration `toml:"interval"`
+	TTL       time.Duration `toml:"ttl"`
+}
+
+func validateDiscovery(discovery *DiscoveryConfig) error {
+	switch discovery.Backend {
+	case "":
+		return nil
+	case DiscoveryConsul, DiscoveryEtcd, DiscoveryKubernetes:
+	default:
+		return fmt.Errorf("unknown discovery backend: %s", discovery.Backend)
+	}
+
+	if discovery.Address != "" {
+		u, err := url.Parse(discovery.Address)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("discovery address must be an http or https URL: %s", discovery.Address)
+		}
+	}
+	if discovery.Interval < 0 || discovery.TTL < 0 {
+		return fmt.Errorf("discovery interval and ttl must not be negative")
+	}
+	return nil
+}
+
+func setDiscoveryDefaults(discovery *DiscoveryConfig) {
+	switch discovery.Backend {
+	case DiscoveryConsul:
+		if discovery.Address == "" {
+			discovery.Address = "http://127.0.0.1:8500"
+		}
+		if discovery.Domain == "" {
+			discovery.Domain = "service.consul"
+		}
+	case DiscoveryEtcd:
+		if discovery.Address == "" {
+			discovery.Address = "http://127.0.0.1:2379"
+		}
+		if discovery.Prefix == "" {
+			discovery.Prefix = "/skydns/"
+		}
+	case DiscoveryKubernetes:
+		// the address comes from the in-cluster environment when unset
+		if discovery.Domain == "" {
+			discovery.Domain = "cluster.local"
+		}
+		if discovery.Token == "" && discovery.TokenFile == "" {
+			discovery.TokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+		}
+		if discovery.CAFile == "" && discovery.Address == "" {
+			discovery.CAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+		}
+	}
+	if discovery.Interval == 0 {
+		discovery.Interval = 30 * time.Second
+	}
+	if discovery.TTL == 0 {
+		discovery.TTL = 30 * time.Second
+	}
+}
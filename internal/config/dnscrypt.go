@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNSCryptCertPrefix starts every DNSCrypt v2 provider name; clients fetch
+// the resolver certificates as TXT records at the provider name.
+const DNSCryptCertPrefix = "2.dnscrypt-cert."
+
+// DNSCryptConfig is the provider identity of "dnscrypt" listeners. KeyFile
+// holds the provider's long-term Ed25519 secret key in hex and is created
+// when missing; clients pin its public key, which the server logs with a
+// DNS stamp on startup. Short-term resolver certificates are signed with
+// it and replaced every CertTTL, with the previous one accepted until it
+// expires.
+type DNSCryptConfig struct {
+	ProviderName string        `toml:"provider_name"`
+	KeyFile      string        `toml:"key_file"`
+	CertTTL      time.Duration `toml:"cert_ttl"`
+}
+
+func validateDNSCrypt(dnscrypt *DNSCryptConfig, listeners []ListenerConfig) error {
+	if !servesDNSCrypt(listeners) {
+		return nil
+	}
+	if _, ok := dns.IsDomainName(dnscrypt.ProviderName); dnscrypt.ProviderName != "" && !ok {
+		return fmt.Errorf("dnscrypt provider_name is not a domain name: %s", dnscrypt.ProviderName)
+	}
+	if dnscrypt.CertTTL < 0 {
+		return fmt.Errorf("dnscrypt cert_ttl must not be negative")
+	}
+	return nil
+}
+
+func setDNSCryptDefaults(dnscrypt *DNSCryptConfig) {
+	if dnscrypt.ProviderName == "" {
+		dnscrypt.ProviderName = "dns-server"
+	}
+	if !strings.HasPrefix(dnscrypt.ProviderName, DNSCryptCertPrefix) {
+		dnscrypt.ProviderName = DNSCryptCertPrefix + dnscrypt.ProviderName
+	}
+	dnscrypt.ProviderName = strings.TrimSuffix(dnscrypt.ProviderName, ".")
+	if dnscrypt.KeyFile == "" {
+		dnscrypt.KeyFile = "dnscrypt.key"
+	}
+	if dnscrypt.CertTTL == 0 {
+		dnscrypt.CertTTL = 24 * time.Hour
+	}
+}
+
+func servesDNSCrypt(listeners []ListenerConfig) bool {
+	for _, listener := range listeners {
+		if slices.Contains(listener.Protocols, ProtocolDNSCrypt) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Filter rule actions.
+const (
+	FilterBlock = "block"
+	FilterAllow = "allow"
+)
+
+// How blocked queries are answered.
+const (
+	FilterResponseNXDomain = "nxdomain"
+	FilterResponseRefused  = "refused"
+	FilterResponseNull     = "null"
+)
+
+// Search engines safe search can be enforced on.
+const (
+	SafeSearchGoogle     = "google"
+	SafeSearchBing       = "bing"
+	SafeSearchDuckDuckGo = "duckduckgo"
+	SafeSearchYouTube    = "youtube"
+)
+
+// YouTube restriction levels.
+const (
+	YouTubeStrict   = "strict"
+	YouTubeModerate = "moderate"
+)
+
+// FilterConfig blocks queries by name before they are answered from
+// anywhere. Rules are tried in order and the first that matches decides:
+// block answers with Response, allow lets the query through, so allow rules
+// listed first carve exceptions out of broader block rules below them.
+// Response "null" answers A and AAAA queries with the unspecified address
+// and others with no records. Lists are named sets of names, such as a
+// category of sites, and Groups named sets of client networks, that rules
+// refer to. Schedules are read in Timezone, the local one when empty.
+type FilterConfig struct {
+	Response   string              `toml:"response"`
+	Timezone   string              `toml:"timezone"`
+	Lists      map[string][]string `toml:"lists"`
+	Groups     map[string][]string `toml:"groups"`
+	Rules      []FilterRule        `toml:"rules"`
+	SafeSearch SafeSearchConfig    `toml:"safe_search"`
+}
+
+// SafeSearchConfig answers the names of search engines with a CNAME to the
+// names the engines serve restricted results from, such as
+// forcesafesearch.google.com, so clients get them whatever their settings.
+// Engines limits it to some engines and Clients to some client groups;
+// YouTube picks its strict or moderate restricted mode.
+type SafeSearchConfig struct {
+	Enabled bool     `toml:"enabled"`
+	Engines []string `toml:"engines"`
+	Clients []string `toml:"clients"`
+	YouTube string   `toml:"youtube"`
+}
+
+// FilterRule matches queries like a rewrite rule: by name, where
+// "*.example.com" covers every name below example.com, by a regular
+// expression on the lowercased fully-qualified name, or by any name of a
+// list. Only queries from the client groups in Clients match, when it is
+// set, and only on Days between From and Until, when those are: "09:00" to
+// "17:00", or "21:00" to "07:00" for a window past midnight, which belongs
+// to the day it starts on. With Log set every query it decides is logged.
+type FilterRule struct {
+	Name    string   `toml:"name"`
+	Regex   string   `toml:"regex"`
+	List    string   `toml:"list"`
+	Action  string   `toml:"action"`
+	Clients []string `toml:"clients"`
+	Days    []string `toml:"days"`
+	From    string   `toml:"from"`
+	Until   string   `toml:"until"`
+	Log     bool     `toml:"log"`
+}
+
+var weekdays = map[string][]time.Weekday{
+	"sun":      {time.Sunday},
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+// ParseDays returns the days of the week names stand for: mon to sun,
+// weekdays and weekends. No names stands for every day.
+func ParseDays(names []string) ([7]bool, error) {
+	var days [7]bool
+	if len(names) == 0 {
+		return [7]bool{true, true, true, true, true, true, true}, nil
+	}
+	for _, name := range names {
+		listed, known := weekdays[strings.ToLower(name)]
+		if !known {
+			return days, fmt.Errorf("unknown day %q; use mon to sun, weekdays or weekends", name)
+		}
+		for _, day := range listed {
+			days[day] = true
+		}
+	}
+	return days, nil
+}
+
+// ParseClock returns the time of day of a clock time such as "17:30" as
+// the time since midnight.
+func ParseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q; use HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func validateFilter(filter *FilterConfig) error {
+	switch filter.Response {
+	case "", FilterResponseNXDomain, FilterResponseRefused, FilterResponseNull:
+	default:
+		return fmt.Errorf("invalid filter response: %s", filter.Response)
+	}
+	if _, err := time.LoadLocation(filter.Timezone); err != nil {
+		return fmt.Errorf("invalid filter timezone: %w", err)
+	}
+	for _, name := range sortedKeys(filter.Lists) {
+		if len(filter.Lists[name]) == 0 {
+			return fmt.Errorf("filter list %s is empty", name)
+		}
+	}
+	for _, name := range sortedKeys(filter.Groups) {
+		if len(filter.Groups[name]) == 0 {
+			return fmt.Errorf("filter group %s is empty", name)
+		}
+		for _, client := range filter.Groups[name] {
+			if _, err := ParseNetwork(client); err != nil {
+				return fmt.Errorf("filter group %s: %w", name, err)
+			}
+		}
+	}
+
+	for i, rule := range filter.Rules {
+		matchers := 0
+		for _, matcher := range []string{rule.Name, rule.Regex, rule.List} {
+			if matcher != "" {
+				matchers++
+			}
+		}
+		if matchers != 1 {
+			return fmt.Errorf("filter rule %d needs exactly one of name, regex or list", i+1)
+		}
+		if rule.Regex != "" {
+			if _, err := regexp.Compile(rule.Regex); err != nil {
+				return fmt.Errorf("filter rule %d has an invalid regex: %w", i+1, err)
+			}
+		}
+		if _, exists := filter.Lists[rule.List]; rule.List != "" && !exists {
+			return fmt.Errorf("filter rule %d refers to unknown list %s", i+1, rule.List)
+		}
+		switch rule.Action {
+		case "", FilterBlock, FilterAllow:
+		default:
+			return fmt.Errorf("filter rule %d has an invalid action %q; use block or allow", i+1, rule.Action)
+		}
+		for _, group := range rule.Clients {
+			if _, exists := filter.Groups[group]; !exists {
+				return fmt.Errorf("filter rule %d refers to unknown client group %s", i+1, group)
+			}
+		}
+		if _, err := ParseDays(rule.Days); err != nil {
+			return fmt.Errorf("filter rule %d: %w", i+1, err)
+		}
+		if (rule.From == "") != (rule.Until == "") {
+			return fmt.Errorf("filter rule %d needs both from and until, or neither", i+1)
+		}
+		for _, clock := range []string{rule.From, rule.Until} {
+			if _, err := ParseClock(clock); clock != "" && err != nil {
+				return fmt.Errorf("filter rule %d: %w", i+1, err)
+			}
+		}
+	}
+	return validateSafeSearch(filter)
+}
+
+func validateSafeSearch(filter *FilterConfig) error {
+	safe := &filter.SafeSearch
+	for _, engine := range safe.Engines {
+		switch engine {
+		case SafeSearchGoogle, SafeSearchBing, SafeSearchDuckDuckGo, SafeSearchYouTube:
+		default:
+			return fmt.Errorf("filter safe_search has an unknown engine %q; use google, bing, duckduckgo or youtube", engine)
+		}
+	}
+	for _, group := range safe.Clients {
+		if _, exists := filter.Groups[group]; !exists {
+			return fmt.Errorf("filter safe_search refers to unknown client group %s", group)
+		}
+	}
+	switch safe.YouTube {
+	case "", YouTubeStrict, YouTubeModerate:
+	default:
+		return fmt.Errorf("invalid filter safe_search youtube mode %q; use strict or moderate", safe.YouTube)
+	}
+	return nil
+}
+
+func setFilterDefaults(filter *FilterConfig) {
+	if filter.Response == "" {
+		filter.Response = FilterResponseNXDomain
+	}
+	if filter.SafeSearch.YouTube == "" {
+		filter.SafeSearch.YouTube = YouTubeStrict
+	}
+	for i := range filter.Rules {
+		if filter.Rules[i].Action == "" {
+			filter.Rules[i].Action = FilterBlock
+		}
+	}
+}
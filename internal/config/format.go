@@ -0,0 +1,122 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file formats.
+const (
+	FormatTOML = "toml"
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+)
+
+// tomlKeyError matches how the TOML decoder names the key a value could not
+// be decoded into, with a line number that means nothing for YAML and JSON.
+var tomlKeyError = regexp.MustCompile(`^toml: (?:line \d+ )?\(last key "([^"]*)"\): `)
+
+// FormatOf picks the format of a config file by its extension.
+func FormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	default:
+		return FormatTOML
+	}
+}
+
+// decodeFile decodes the file at path into config. YAML and JSON use the
+// same keys as TOML: they are decoded generically and passed through the
+// TOML decoder, so durations, sizes and every table behave the same in all
+// three formats.
+func decodeFile(path, format string, config *Config) error {
+	if format == "" {
+		format = FormatOf(path)
+	}
+	if format == FormatTOML {
+		_, err := toml.DecodeFile(path, config)
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var doc map[string]any
+	switch format {
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown config format %s", format)
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(normalizeDocument(doc)); err != nil {
+		return err
+	}
+	if _, err := toml.Decode(buf.String(), config); err != nil {
+		return errors.New(tomlKeyError.ReplaceAllString(err.Error(), "$1: "))
+	}
+	return nil
+}
+
+// normalizeDocument turns a generically decoded document into values TOML
+// can encode: nulls are dropped, keys become strings and JSON numbers
+// integers where they are whole.
+func normalizeDocument(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, item := range v {
+			if item != nil {
+				out[key] = normalizeDocument(item)
+			}
+		}
+		return out
+	case map[any]any:
+		out := make(map[string]any, len(v))
+		for key, item := range v {
+			if item != nil {
+				out[fmt.Sprint(key)] = normalizeDocument(item)
+			}
+		}
+		return out
+	case []any:
+		out := make([]any, 0, len(v))
+		for _, item := range v {
+			if item != nil {
+				out = append(out, normalizeDocument(item))
+			}
+		}
+		return out
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
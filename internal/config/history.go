@@ -0,0 +1,28 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+func validateHistory(history *HistoryConfig) error {
+	switch history.Backend {
+	case "", "memory", "sqlite":
+	case "clickhouse":
+		if history.URL == "" {
+			return fmt.Errorf("history clickhouse backend needs a url")
+		}
+	default:
+		return fmt.Errorf("invalid history backend: %s", history.Backend)
+	}
+
+	if history.Table != "" && !tableName.MatchString(history.Table) {
+		return fmt.Errorf("invalid history table name: %s", history.Table)
+	}
+	if history.Size < 0 || history.BatchSize < 0 || history.Retention < 0 || history.FlushInterval < 0 {
+		return fmt.Errorf("history size, batch_size, retention and flush_interval must be non-negative")
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// HookConfig calls out to an external resolver with the query in DNS wire
+// format. Command is run once per query with the query on stdin and must
+// print the response on stdout; URL receives it as an HTTP POST of
+// application/dns-message. A hook answers for Zones, or when Fallback is set,
+// for queries upstream failed to resolve.
+type HookConfig struct {
+	Name     string        `toml:"name"`
+	Zones    []string      `toml:"zones"`
+	Fallback bool          `toml:"fallback"`
+	Command  []string      `toml:"command"`
+	URL      string        `toml:"url"`
+	Timeout  time.Duration `toml:"timeout"`
+}
+
+func validateHooks(hooks []HookConfig) error {
+	for i, hook := range hooks {
+		name := hook.Name
+		if name == "" {
+			name = fmt.Sprint(i + 1)
+		}
+		if (len(hook.Command) == 0) == (hook.URL == "") {
+			return fmt.Errorf("hook %s needs exactly one of command or url", name)
+		}
+		if hook.URL != "" {
+			u, err := url.Parse(hook.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("hook %s has an invalid url: %q", name, hook.URL)
+			}
+		}
+		if len(hook.Zones) == 0 && !hook.Fallback {
+			return fmt.Errorf("hook %s needs zones or fallback", name)
+		}
+		if hook.Timeout < 0 {
+			return fmt.Errorf("hook %s timeout must be non-negative", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// DHCP lease file formats.
+const (
+	LeaseFormatDnsmasq = "dnsmasq"
+	LeaseFormatISC     = "isc"
+	LeaseFormatKea     = "kea"
+)
+
+// LeasesConfig answers the hostnames DHCP clients sent as <hostname>.<domain>,
+// with A/AAAA records for their leased addresses and PTR records back, for
+// as long as their leases are active. Path is the lease file of dnsmasq
+// (dnsmasq.leases), ISC dhcpd (dhcpd.leases) or Kea (kea-leases4.csv).
+type LeasesConfig struct {
+	Path   string        `toml:"path"`
+	Format string        `toml:"format"`
+	Domain string        `toml:"domain"`
+	TTL    time.Duration `toml:"ttl"`
+}
+
+func validateLeases(leases *LeasesConfig) error {
+	if leases.Path == "" {
+		return nil
+	}
+	switch leases.Format {
+	case LeaseFormatDnsmasq, LeaseFormatISC, LeaseFormatKea:
+	default:
+		return fmt.Errorf("unknown lease file format: %s", leases.Format)
+	}
+	if leases.Domain == "" {
+		return fmt.Errorf("leases domain must not be empty")
+	}
+	if leases.TTL < 0 {
+		return fmt.Errorf("leases ttl must not be negative")
+	}
+	return nil
+}
+
+func setLeasesDefaults(leases *LeasesConfig) {
+	if leases.Path == "" {
+		return
+	}
+	if leases.Format == "" {
+		leases.Format = LeaseFormatDnsmasq
+	}
+	if leases.Domain == "" {
+		leases.Domain = "lan"
+	}
+	if leases.TTL == 0 {
+		leases.TTL = time.Minute
+	}
+}
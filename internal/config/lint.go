@@ -0,0 +1,191 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+type Severity string
+
+const (
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+type LintIssue struct {
+	Severity Severity `json:"severity"`
+	Scope    string   `json:"scope"`
+	Message  string   `json:"message"`
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%-7s %s: %s", i.Severity, i.Scope, i.Message)
+}
+
+// Lint reports semantic mistakes that pass validation but are almost
+// certainly not what the operator intended.
+func Lint(config *Config) []LintIssue {
+	issues := lintRecords("records", &config.Records)
+	issues = append(issues, lintRecords("fallback", &config.Fallback.Records)...)
+
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		profile := config.Profiles[name]
+		issues = append(issues, lintRecords("profiles."+name, &profile.Records)...)
+	}
+	for _, view := range config.Views {
+		issues = append(issues, lintRecords("views."+view.Name, &view.Records)...)
+	}
+	issues = append(issues, lintPrecedence(config)...)
+	issues = append(issues, lintListeners(config)...)
+
+	return issues
+}
+
+func HasErrors(issues []LintIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func lintRecords(scope string, records *RecordsConfig) []LintIssue {
+	var issues []LintIssue
+	add := func(severity Severity, format string, args ...any) {
+		issues = append(issues, LintIssue{
+			Severity: severity,
+			Scope:    scope,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	types := namesByType(records)
+	hasAddress := func(name string) bool {
+		name = normalizeName(name)
+		return types[name]["A"] || types[name]["AAAA"]
+	}
+
+	for _, domain := range sortedKeys(records.CNAME) {
+		target := records.CNAME[domain]
+		if normalizeName(domain) == normalizeName(target) {
+			add(SeverityError, "CNAME %s points at itself", domain)
+		}
+
+		for qtype := range types[normalizeName(domain)] {
+			if qtype != "CNAME" {
+				add(SeverityError, "CNAME %s coexists with other data (%s) at the same name", domain, qtype)
+			}
+		}
+	}
+
+	for _, domain := range sortedKeys(records.MX) {
+		target := records.MX[domain].Target
+		switch {
+		case net.ParseIP(strings.TrimSuffix(target, ".")) != nil:
+			add(SeverityError, "MX %s points at IP literal %s; MX targets must be host names", domain, target)
+		case types[normalizeName(target)]["CNAME"]:
+			add(SeverityWarning, "MX %s target %s is a CNAME (RFC 2181 section 10.3)", domain, target)
+		}
+	}
+
+	for _, domain := range sortedKeys(records.SRV) {
+		target := records.SRV[domain].Target
+		switch {
+		case target == "." || target == "":
+			// "." explicitly means the service is not available
+		case types[normalizeName(target)]["CNAME"]:
+			add(SeverityWarning, "SRV %s target %s is a CNAME (RFC 2782)", domain, target)
+		case !hasAddress(target):
+			add(SeverityWarning, "SRV %s target %s has no local A or AAAA record", domain, target)
+		}
+	}
+
+	for _, domain := range sortedKeys(records.CAA) {
+		switch tag := strings.ToLower(records.CAA[domain].Tag); tag {
+		case "issue", "issuewild":
+		case "iodef":
+			add(SeverityWarning, "CAA %s only has an iodef tag; without issue or issuewild it restricts no CA", domain)
+		default:
+			add(SeverityWarning, "CAA %s has tag %q instead of issue or issuewild", domain, tag)
+		}
+	}
+
+	for _, name := range sortedKeys(types) {
+		if !strings.HasPrefix(name, "*.") {
+			continue
+		}
+		parent := strings.TrimPrefix(name, "*.")
+
+		for _, explicit := range sortedKeys(types) {
+			if explicit == name || !strings.HasSuffix(explicit, "."+parent) {
+				continue
+			}
+
+			for _, qtype := range sortedKeys(types[name]) {
+				if !types[explicit][qtype] {
+					add(SeverityWarning, "wildcard %s answers %s queries for %s, which has explicit records; RFC 4592 wildcards do not match existing names", name, qtype, explicit)
+				}
+			}
+		}
+	}
+
+	return issues
+}
+
+func namesByType(records *RecordsConfig) map[string]map[string]bool {
+	types := make(map[string]map[string]bool)
+	add := func(qtype string, names []string) {
+		for _, name := range names {
+			name = normalizeName(name)
+			if types[name] == nil {
+				types[name] = make(map[string]bool)
+			}
+			types[name][qtype] = true
+		}
+	}
+
+	add("A", sortedKeys(records.A))
+	add("AAAA", sortedKeys(records.AAAA))
+	add("CNAME", sortedKeys(records.CNAME))
+	add("NS", sortedKeys(records.NS))
+	add("SOA", sortedKeys(records.SOA))
+	add("MX", sortedKeys(records.MX))
+	add("TXT", sortedKeys(records.TXT))
+	add("HTTPS", sortedKeys(records.HTTPS))
+	add("CAA", sortedKeys(records.CAA))
+	add("SRV", sortedKeys(records.SRV))
+	add("SVCB", sortedKeys(records.SVCB))
+	add("DS", sortedKeys(records.DS))
+	add("DNSKEY", sortedKeys(records.DNSKEY))
+	add("URI", sortedKeys(records.URI))
+	add("NAPTR", sortedKeys(records.NAPTR))
+	add("SSHFP", sortedKeys(records.SSHFP))
+	add("TLSA", sortedKeys(records.TLSA))
+	add("SMIMEA", sortedKeys(records.SMIMEA))
+	add("CERT", sortedKeys(records.CERT))
+
+	return types
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
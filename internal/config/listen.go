@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"slices"
+)
+
+// Listener protocols.
+const (
+	ProtocolUDP      = "udp"
+	ProtocolTCP      = "tcp"
+	ProtocolTLS      = "tls"
+	ProtocolDNSCrypt = "dnscrypt"
+)
+
+// ListenerConfig is one address the server answers on, with the protocols it
+// serves there: "udp", "tcp", "tls" for DNS over TLS (RFC 7858), which
+// needs CertFile and KeyFile, and "dnscrypt" for DNSCrypt v2 over both UDP
+// and TCP. Port defaults to 853 for a TLS-only listener, 443 for a
+// DNSCrypt-only one and 53 otherwise; protocols default to udp and tcp.
+type ListenerConfig struct {
+	Address   string   `toml:"address"`
+	Port      int      `toml:"port"`
+	Protocols []string `toml:"protocols"`
+	CertFile  string   `toml:"cert_file"`
+	KeyFile   string   `toml:"key_file"`
+}
+
+// Listeners returns the addresses the server answers on. Without
+// [[server.listen]] entries that is bind_address and port over UDP, and
+// over TCP too when zone transfers or secondary zones need it.
+func Listeners(config *Config) []ListenerConfig {
+	if len(config.Server.Listen) > 0 {
+		return config.Server.Listen
+	}
+
+	protocols := []string{ProtocolUDP}
+	if config.Transfer.Enabled || len(config.Secondary) > 0 {
+		protocols = append(protocols, ProtocolTCP)
+	}
+	return []ListenerConfig{{
+		Address:   config.Server.BindAddress,
+		Port:      config.Server.Port,
+		Protocols: protocols,
+	}}
+}
+
+func validateListeners(listeners []ListenerConfig) error {
+	seen := make(map[string]bool)
+	for i, listener := range listeners {
+		name := fmt.Sprintf("server listener %d", i+1)
+		if listener.Address != "" && net.ParseIP(listener.Address) == nil {
+			return fmt.Errorf("%s has an invalid address: %s", name, listener.Address)
+		}
+		if listener.Port < 0 || listener.Port > 65535 {
+			return fmt.Errorf("%s has an invalid port: %d", name, listener.Port)
+		}
+		for _, protocol := range listener.Protocols {
+			switch protocol {
+			case ProtocolUDP, ProtocolTCP, ProtocolTLS, ProtocolDNSCrypt:
+			default:
+				return fmt.Errorf("%s has an unknown protocol: %s", name, protocol)
+			}
+		}
+		if slices.Contains(listener.Protocols, ProtocolTLS) && (listener.CertFile == "" || listener.KeyFile == "") {
+			return fmt.Errorf("%s serves tls and needs cert_file and key_file", name)
+		}
+
+		key := fmt.Sprintf("%s|%d|%v", listener.Address, listener.Port, listener.Protocols)
+		if seen[key] {
+			return fmt.Errorf("%s is listed twice", name)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+func setListenerDefaults(listeners []ListenerConfig) {
+	for i := range listeners {
+		listener := &listeners[i]
+		if listener.Address == "" {
+			listener.Address = "0.0.0.0"
+		}
+		if len(listener.Protocols) == 0 {
+			listener.Protocols = []string{ProtocolUDP, ProtocolTCP}
+		}
+		if listener.Port == 0 {
+			listener.Port = 53
+			if len(listener.Protocols) == 1 {
+				switch listener.Protocols[0] {
+				case ProtocolTLS:
+					listener.Port = 853
+				case ProtocolDNSCrypt:
+					listener.Port = 443
+				}
+			}
+		}
+	}
+}
+
+// lintListeners warns when zone transfers are enabled but no listener serves
+// TCP, which AXFR and IXFR need.
+func lintListeners(config *Config) []LintIssue {
+	if len(config.Server.Listen) == 0 || !config.Transfer.Enabled {
+		return nil
+	}
+	for _, listener := range config.Server.Listen {
+		if slices.Contains(listener.Protocols, ProtocolTCP) {
+			return nil
+		}
+	}
+	return []LintIssue{{
+		Severity: SeverityWarning,
+		Scope:    "server.listen",
+		Message:  "transfer is enabled but no listener serves tcp, so secondaries cannot transfer zones",
+	}}
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// MDNSConfig answers .local names, and single-label names when Unqualified
+// is set, by asking the LAN over multicast DNS (RFC 6762) instead of the
+// upstreams. Interface picks the network queries go out on; the system
+// default is used when empty.
+type MDNSConfig struct {
+	Enabled     bool          `toml:"enabled"`
+	Unqualified bool          `toml:"unqualified"`
+	Interface   string        `toml:"interface"`
+	Timeout     time.Duration `toml:"timeout"`
+}
+
+func validateMDNS(mdns *MDNSConfig) error {
+	if !mdns.Enabled {
+		return nil
+	}
+	if mdns.Timeout < 0 {
+		return fmt.Errorf("mdns timeout must not be negative")
+	}
+	if mdns.Interface != "" {
+		if _, err := net.InterfaceByName(mdns.Interface); err != nil {
+			return fmt.Errorf("mdns interface %s: %w", mdns.Interface, err)
+		}
+	}
+	return nil
+}
+
+func setMDNSDefaults(mdns *MDNSConfig) {
+	if mdns.Timeout == 0 {
+		mdns.Timeout = time.Second
+	}
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// idnaProfile converts internationalized labels as a resolver looks them
+// up: mapped to lowercase and normalized, then punycoded. Labels such as
+// _dmarc and *, which are ASCII, never reach it.
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.Transitional(false),
+	idna.BidiRule(),
+)
+
+// CanonicalName returns name fully qualified, lowercased and with every
+// label that is not ASCII converted to its punycode (xn--) form, the form
+// names are stored and compared in. A name that cannot be converted is
+// returned lowercased as given; config validation rejects those.
+func CanonicalName(name string) string {
+	ascii, err := ToASCII(name)
+	if err != nil {
+		ascii = name
+	}
+	ascii = strings.ToLower(ascii)
+	if !strings.HasSuffix(ascii, ".") {
+		ascii += "."
+	}
+	return ascii
+}
+
+// ToASCII converts the labels of name that are not ASCII to punycode and
+// leaves the others, and their case, alone.
+func ToASCII(name string) (string, error) {
+	if isASCII(name) {
+		return name, nil
+	}
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		ascii, err := idnaProfile.ToASCII(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = ascii
+	}
+	return strings.Join(labels, "."), nil
+}
+
+func isASCII(s string) bool {
+	for i := range len(s) {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix starts the environment variables that override config values:
+// server.port is DNS_SERVER_PORT and upstream.servers DNS_UPSTREAM_SERVERS.
+const EnvPrefix = "DNS_"
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// applyEnvironment sets the values named by DNS_ variables in environ, given
+// as KEY=value like os.Environ. Variables that name no config value are
+// ignored, so unrelated DNS_ variables do no harm.
+func applyEnvironment(config *Config, environ []string) error {
+	fields := make(map[string]string)
+	walkFields(reflect.TypeOf(config).Elem(), nil, func(path []string) {
+		fields[EnvPrefix+strings.ToUpper(strings.Join(path, "_"))] = strings.Join(path, ".")
+	})
+
+	for _, variable := range environ {
+		name, value, ok := strings.Cut(variable, "=")
+		if !ok || !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		key, known := fields[name]
+		if !known {
+			continue
+		}
+		if err := setValue(config, key, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// applyOverrides sets the key=value pairs given with -set, where key is the
+// dotted TOML path. Below a table of plain values, like logging.modules or
+// records.A, the rest of the path is the entry's name.
+func applyOverrides(config *Config, overrides []string) error {
+	for _, override := range overrides {
+		key, value, ok := strings.Cut(override, "=")
+		if !ok {
+			return fmt.Errorf("override %q is not key=value", override)
+		}
+		if err := setValue(config, strings.TrimSpace(key), value); err != nil {
+			return fmt.Errorf("override %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// walkFields calls fn with the TOML path of every value an environment
+// variable can set: the scalar and list fields of nested tables.
+func walkFields(t reflect.Type, path []string, fn func(path []string)) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name := field.Tag.Get("toml")
+		if name == "" || name == "-" {
+			continue
+		}
+		fieldPath := append(append([]string(nil), path...), name)
+
+		switch {
+		case settable(field.Type):
+			fn(fieldPath)
+		case field.Type.Kind() == reflect.Struct:
+			walkFields(field.Type, fieldPath, fn)
+		}
+	}
+}
+
+func settable(t reflect.Type) bool {
+	if t == durationType || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Uint16, reflect.Uint32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+func setValue(config *Config, key string, value string) error {
+	v := reflect.ValueOf(config).Elem()
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		field, found := fieldByTag(v, part)
+		if !found {
+			return fmt.Errorf("unknown config key %s", key)
+		}
+
+		switch {
+		case settable(field.Type()) && i == len(parts)-1:
+			return parseInto(field, value)
+		case field.Kind() == reflect.Struct && i < len(parts)-1:
+			v = field
+		case field.Kind() == reflect.Map && field.Type().Key().Kind() == reflect.String && settable(field.Type().Elem()) && i < len(parts)-1:
+			entry := reflect.New(field.Type().Elem()).Elem()
+			if err := parseInto(entry, value); err != nil {
+				return err
+			}
+			if field.IsNil() {
+				field.Set(reflect.MakeMap(field.Type()))
+			}
+			field.SetMapIndex(reflect.ValueOf(strings.Join(parts[i+1:], ".")), entry)
+			return nil
+		default:
+			return fmt.Errorf("config key %s cannot be overridden", key)
+		}
+	}
+	return fmt.Errorf("config key %s cannot be overridden", key)
+}
+
+func fieldByTag(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := range t.NumField() {
+		if t.Field(i).Tag.Get("toml") == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// parseInto sets v from its text form: durations like "5s", lists as comma
+// separated values, and everything else as it would be written in TOML
+// without quotes.
+func parseInto(v reflect.Value, text string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(text))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint16, reflect.Uint32:
+		n, err := strconv.ParseUint(text, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	}
+	return nil
+}
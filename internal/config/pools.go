@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Target check protocols.
+const (
+	CheckTCP   = "tcp"
+	CheckHTTP  = "http"
+	CheckHTTPS = "https"
+	CheckICMP  = "icmp"
+)
+
+// PoolConfig answers a name with those of its addresses that pass their
+// health check. Failover addresses are answered only while every address
+// is down, and when those are down too every address is answered, since
+// a possibly dead address beats no answer. A and AAAA queries get the
+// addresses of their family.
+type PoolConfig struct {
+	Addresses []string          `toml:"addresses"`
+	Failover  []string          `toml:"failover"`
+	TTL       time.Duration     `toml:"ttl"`
+	Check     TargetCheckConfig `toml:"check"`
+}
+
+// TargetCheckConfig probes a pool address: a TCP connect to Port, an HTTP
+// GET of Path expecting a 2xx or 3xx status, or an ICMP echo, which needs
+// raw socket privileges. An address turns unhealthy after
+// FailureThreshold failed probes in a row and healthy again after
+// RecoveryThreshold successful ones.
+type TargetCheckConfig struct {
+	Protocol          string        `toml:"protocol"`
+	Port              int           `toml:"port"`
+	Path              string        `toml:"path"`
+	Interval          time.Duration `toml:"interval"`
+	Timeout           time.Duration `toml:"timeout"`
+	FailureThreshold  int           `toml:"failure_threshold"`
+	RecoveryThreshold int           `toml:"recovery_threshold"`
+}
+
+func (l *FileConfigLoader) validatePools(pools map[string]PoolConfig) error {
+	for name, pool := range pools {
+		if !l.isValidDomain(name) {
+			return fmt.Errorf("invalid pool name: %s", name)
+		}
+		if len(pool.Addresses) == 0 {
+			return fmt.Errorf("pool %s has no addresses", name)
+		}
+		for _, address := range append(append([]string(nil), pool.Addresses...), pool.Failover...) {
+			if net.ParseIP(address) == nil {
+				return fmt.Errorf("pool %s has an invalid address: %s", name, address)
+			}
+		}
+		if pool.TTL < 0 {
+			return fmt.Errorf("pool %s ttl must not be negative", name)
+		}
+
+		check := pool.Check
+		switch check.Protocol {
+		case "", CheckTCP, CheckHTTP, CheckHTTPS, CheckICMP:
+		default:
+			return fmt.Errorf("pool %s has an unknown check protocol: %s", name, check.Protocol)
+		}
+		if check.Port < 0 || check.Port > 65535 {
+			return fmt.Errorf("pool %s has an invalid check port: %d", name, check.Port)
+		}
+		if (check.Protocol == "" || check.Protocol == CheckTCP) && check.Port == 0 {
+			return fmt.Errorf("pool %s needs a check port for tcp checks", name)
+		}
+		if check.Interval < 0 || check.Timeout < 0 || check.FailureThreshold < 0 || check.RecoveryThreshold < 0 {
+			return fmt.Errorf("pool %s check interval, timeout and thresholds must not be negative", name)
+		}
+	}
+	return nil
+}
+
+func setPoolDefaults(pools map[string]PoolConfig) {
+	for name, pool := range pools {
+		if pool.TTL == 0 {
+			pool.TTL = 30 * time.Second
+		}
+		check := &pool.Check
+		if check.Protocol == "" {
+			check.Protocol = CheckTCP
+		}
+		if check.Port == 0 {
+			switch check.Protocol {
+			case CheckHTTP:
+				check.Port = 80
+			case CheckHTTPS:
+				check.Port = 443
+			}
+		}
+		if check.Path == "" {
+			check.Path = "/"
+		}
+		if check.Interval == 0 {
+			check.Interval = 10 * time.Second
+		}
+		if check.Timeout == 0 {
+			check.Timeout = 2 * time.Second
+		}
+		if check.FailureThreshold == 0 {
+			check.FailureThreshold = 3
+		}
+		if check.RecoveryThreshold == 0 {
+			check.RecoveryThreshold = 2
+		}
+		pools[name] = pool
+	}
+}
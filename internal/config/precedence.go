@@ -0,0 +1,186 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Record sources, in their default precedence.
+const (
+	SourceProfiles = "profiles"
+	SourceDynamic  = "dynamic"
+	SourceConfig   = "config"
+)
+
+var DefaultSourceOrder = []string{SourceProfiles, SourceDynamic, SourceConfig}
+
+// Conflict policies for names and types held by more than one source.
+const (
+	ConflictFirstWins = "first-wins"
+	ConflictMerge     = "merge"
+	ConflictError     = "error"
+)
+
+// PrecedenceConfig orders the local record sources: enabled profiles, the
+// dynamic records of the admin API, external-dns and the record store, and
+// the [records] of this file. Conflict says what happens when more than one
+// source holds records for the same name and type: the first source in
+// Order answers, the RRsets are merged, or the conflict is rejected. Zones
+// override both for names at or below them.
+type PrecedenceConfig struct {
+	Order    []string                        `toml:"order"`
+	Conflict string                          `toml:"conflict"`
+	Zones    map[string]ZonePrecedenceConfig `toml:"zones"`
+}
+
+type ZonePrecedenceConfig struct {
+	Order    []string `toml:"order"`
+	Conflict string   `toml:"conflict"`
+}
+
+func validatePrecedence(precedence *PrecedenceConfig) error {
+	check := func(scope string, order []string, conflict string) error {
+		seen := make(map[string]bool, len(order))
+		for _, source := range order {
+			if !slices.Contains(DefaultSourceOrder, source) {
+				return fmt.Errorf("%s: unknown record source %q", scope, source)
+			}
+			if seen[source] {
+				return fmt.Errorf("%s: record source %q listed twice", scope, source)
+			}
+			seen[source] = true
+		}
+		switch conflict {
+		case "", ConflictFirstWins, ConflictMerge, ConflictError:
+		default:
+			return fmt.Errorf("%s: invalid conflict policy: %s", scope, conflict)
+		}
+		return nil
+	}
+
+	if err := check("precedence", precedence.Order, precedence.Conflict); err != nil {
+		return err
+	}
+	for _, zone := range sortedKeys(precedence.Zones) {
+		cfg := precedence.Zones[zone]
+		if err := check("precedence zone "+zone, cfg.Order, cfg.Conflict); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// completeOrder appends the sources missing from order in their default
+// precedence.
+func completeOrder(order []string) []string {
+	complete := slices.Clone(order)
+	for _, source := range DefaultSourceOrder {
+		if !slices.Contains(complete, source) {
+			complete = append(complete, source)
+		}
+	}
+	return complete
+}
+
+// Policy returns the source order and conflict policy for name, taken from
+// the most specific zone that contains it.
+func (p *PrecedenceConfig) Policy(name string) (order []string, conflict string) {
+	order, conflict = p.Order, p.Conflict
+
+	name = normalizeName(name)
+	best := -1
+	for zone, cfg := range p.Zones {
+		zone = normalizeName(zone)
+		labels := 0
+		if zone != "" {
+			if name != zone && !strings.HasSuffix(name, "."+zone) {
+				continue
+			}
+			labels = strings.Count(zone, ".") + 1
+		}
+		if labels > best {
+			best = labels
+			order, conflict = cfg.Order, cfg.Conflict
+		}
+	}
+	return order, conflict
+}
+
+// setPrecedenceDefaults completes every order and lets zones inherit what
+// they leave unset.
+func setPrecedenceDefaults(precedence *PrecedenceConfig) {
+	precedence.Order = completeOrder(precedence.Order)
+	if precedence.Conflict == "" {
+		precedence.Conflict = ConflictFirstWins
+	}
+	for zone, cfg := range precedence.Zones {
+		if len(cfg.Order) == 0 {
+			cfg.Order = precedence.Order
+		}
+		cfg.Order = completeOrder(cfg.Order)
+		if cfg.Conflict == "" {
+			cfg.Conflict = precedence.Conflict
+		}
+		precedence.Zones[zone] = cfg
+	}
+}
+
+// lintPrecedence reports records of a profile that conflict with [records].
+// Dynamic records are only known at runtime; the admin API lists their
+// conflicts.
+func lintPrecedence(config *Config) []LintIssue {
+	var issues []LintIssue
+
+	base := recordValues(&config.Records)
+	for _, profile := range sortedKeys(config.Profiles) {
+		records := config.Profiles[profile].Records
+		values := recordValues(&records)
+		for _, key := range sortedKeys(values) {
+			value, exists := base[key]
+			if !exists || reflect.DeepEqual(value, values[key]) {
+				continue
+			}
+
+			qtype, name, _ := strings.Cut(key, " ")
+			order, conflict := config.Precedence.Policy(name)
+			issue := LintIssue{Severity: SeverityInfo, Scope: "profiles." + profile}
+			switch conflict {
+			case ConflictError:
+				issue.Severity = SeverityError
+				issue.Message = fmt.Sprintf("%s %s conflicts with [records] under the error policy", qtype, name)
+			case ConflictMerge:
+				issue.Message = fmt.Sprintf("%s %s is merged with [records] while the profile is enabled", qtype, name)
+			default:
+				winner := "[records]"
+				if slices.Index(order, SourceProfiles) < slices.Index(order, SourceConfig) {
+					winner = "the profile"
+				}
+				issue.Message = fmt.Sprintf("%s %s differs from [records]; %s wins while the profile is enabled", qtype, name, winner)
+			}
+			issues = append(issues, issue)
+		}
+	}
+
+	return issues
+}
+
+// recordValues keys the values of a records config by "TYPE name".
+func recordValues(records *RecordsConfig) map[string]any {
+	values := make(map[string]any)
+
+	v := reflect.ValueOf(records).Elem()
+	t := v.Type()
+	for i := range t.NumField() {
+		if v.Field(i).Kind() != reflect.Map {
+			continue
+		}
+		typeName := t.Field(i).Tag.Get("toml")
+		iter := v.Field(i).MapRange()
+		for iter.Next() {
+			values[typeName+" "+normalizeName(iter.Key().String())] = iter.Value().Interface()
+		}
+	}
+	return values
+}
@@ -0,0 +1,454 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/miekg/dns"
+)
+
+// digestSizes are the lengths in bytes of the digests DS, SSHFP, TLSA and
+// SMIMEA records hold, by their digest or matching type. Types missing here
+// are not checked for length.
+var (
+	dsDigestSizes    = map[int]int{1: 20, 2: 32, 3: 32, 4: 48}
+	sshfpDigestSizes = map[int]int{1: 20, 2: 32}
+	tlsaDigestSizes  = map[int]int{1: 32, 2: 64}
+)
+
+// recordValidator collects every problem of a records table, each naming
+// the TOML key at fault, such as records.MX."hello.world".priority.
+type recordValidator struct {
+	loader *FileConfigLoader
+	table  string
+	errs   []error
+}
+
+// validateRecords checks the records under table, the TOML key they were
+// read from, in the order of their names so errors are reported the same
+// way every time.
+func (l *FileConfigLoader) validateRecords(table string, records *RecordsConfig) error {
+	v := &recordValidator{loader: l, table: table}
+
+	for i, zone := range records.Zones {
+		key := fmt.Sprintf("%s.zones[%d]", table, i)
+		if strings.HasPrefix(zone, "*") {
+			v.fail(key, "%q is a wildcard, not a zone", zone)
+			continue
+		}
+		v.target(key, zone, false)
+	}
+	for _, name := range sortedKeys(records.A) {
+		key := v.key("A", name)
+		if v.name(key, name) {
+			v.ipv4(key, records.A[name])
+		}
+	}
+	for _, name := range sortedKeys(records.AAAA) {
+		key := v.key("AAAA", name)
+		if v.name(key, name) {
+			v.ipv6(key, records.AAAA[name])
+		}
+	}
+	for _, name := range sortedKeys(records.CNAME) {
+		key := v.key("CNAME", name)
+		if v.name(key, name) {
+			v.target(key, records.CNAME[name], false)
+		}
+	}
+	for _, name := range sortedKeys(records.NS) {
+		key := v.key("NS", name)
+		if !v.name(key, name) {
+			continue
+		}
+		if len(records.NS[name]) == 0 {
+			v.fail(key, "no name servers")
+		}
+		for i, ns := range records.NS[name] {
+			v.target(fmt.Sprintf("%s[%d]", key, i), ns, false)
+		}
+	}
+	for _, name := range sortedKeys(records.SOA) {
+		key := v.key("SOA", name)
+		if !v.name(key, name) {
+			continue
+		}
+		soa := records.SOA[name]
+		v.target(key+".mname", soa.MName, false)
+		v.target(key+".rname", soa.RName, false)
+	}
+	for _, name := range sortedKeys(records.MX) {
+		key := v.key("MX", name)
+		if !v.name(key, name) {
+			continue
+		}
+		mx := records.MX[name]
+		v.uint16(key+".priority", mx.Priority)
+		// a target of "." says the name accepts no mail (RFC 7505)
+		v.target(key+".target", mx.Target, true)
+	}
+	for _, name := range sortedKeys(records.TXT) {
+		key := v.key("TXT", name)
+		if v.name(key, name) && len(records.TXT[name]) == 0 {
+			v.fail(key, "no strings")
+		}
+	}
+	for _, name := range sortedKeys(records.HTTPS) {
+		key := v.key("HTTPS", name)
+		if v.name(key, name) {
+			https := records.HTTPS[name]
+			v.svcb(key, "HTTPS", https.Priority, https.Target, https.Params)
+		}
+	}
+	for _, name := range sortedKeys(records.SVCB) {
+		key := v.key("SVCB", name)
+		if v.name(key, name) {
+			svcb := records.SVCB[name]
+			v.svcb(key, "SVCB", svcb.Priority, svcb.Target, svcb.Params)
+		}
+	}
+	for _, name := range sortedKeys(records.CAA) {
+		key := v.key("CAA", name)
+		if !v.name(key, name) {
+			continue
+		}
+		caa := records.CAA[name]
+		v.uint8(key+".flag", caa.Flag)
+		if !isCAATag(caa.Tag) {
+			v.fail(key+".tag", "%q is not a tag; tags are 1 to 15 letters and digits, such as issue", caa.Tag)
+		}
+	}
+	for _, name := range sortedKeys(records.SRV) {
+		key := v.key("SRV", name)
+		if !v.name(key, name) {
+			continue
+		}
+		srv := records.SRV[name]
+		v.uint16(key+".priority", srv.Priority)
+		v.uint16(key+".weight", srv.Weight)
+		v.uint16(key+".port", srv.Port)
+		// a target of "." says the service is not available (RFC 2782)
+		v.target(key+".target", srv.Target, true)
+	}
+	for _, name := range sortedKeys(records.DS) {
+		key := v.key("DS", name)
+		if !v.name(key, name) {
+			continue
+		}
+		ds := records.DS[name]
+		v.uint16(key+".keytag", ds.KeyTag)
+		v.uint8(key+".algorithm", ds.Algorithm)
+		if v.uint8(key+".digesttype", ds.DigestType) {
+			v.hex(key+".digest", ds.Digest, dsDigestSizes[ds.DigestType], "digest type", ds.DigestType)
+		}
+	}
+	for _, name := range sortedKeys(records.DNSKEY) {
+		key := v.key("DNSKEY", name)
+		if !v.name(key, name) {
+			continue
+		}
+		dnskey := records.DNSKEY[name]
+		v.uint16(key+".flags", dnskey.Flags)
+		if dnskey.Protocol != 3 {
+			v.fail(key+".protocol", "must be 3, got %d (RFC 4034 section 2.1.2)", dnskey.Protocol)
+		}
+		v.uint8(key+".algorithm", dnskey.Algorithm)
+		v.base64(key+".publickey", dnskey.PublicKey)
+	}
+	for _, name := range sortedKeys(records.URI) {
+		key := v.key("URI", name)
+		if !v.name(key, name) {
+			continue
+		}
+		uri := records.URI[name]
+		v.uint16(key+".priority", uri.Priority)
+		v.uint16(key+".weight", uri.Weight)
+		if u, err := url.Parse(uri.Target); err != nil || u.Scheme == "" {
+			v.fail(key+".target", "%q is not an absolute URI", uri.Target)
+		}
+	}
+	for _, name := range sortedKeys(records.NAPTR) {
+		key := v.key("NAPTR", name)
+		if v.name(key, name) {
+			v.naptr(key, records.NAPTR[name])
+		}
+	}
+	for _, name := range sortedKeys(records.SSHFP) {
+		key := v.key("SSHFP", name)
+		if !v.name(key, name) {
+			continue
+		}
+		sshfp := records.SSHFP[name]
+		v.uint8(key+".algorithm", sshfp.Algorithm)
+		if v.uint8(key+".type", sshfp.Type) {
+			v.hex(key+".fingerprint", sshfp.Fingerprint, sshfpDigestSizes[sshfp.Type], "type", sshfp.Type)
+		}
+	}
+	for _, name := range sortedKeys(records.TLSA) {
+		key := v.key("TLSA", name)
+		if v.name(key, name) {
+			tlsa := records.TLSA[name]
+			v.tlsa(key, tlsa.Usage, tlsa.Selector, tlsa.MatchingType, tlsa.Certificate)
+		}
+	}
+	for _, name := range sortedKeys(records.SMIMEA) {
+		key := v.key("SMIMEA", name)
+		if v.name(key, name) {
+			smimea := records.SMIMEA[name]
+			v.tlsa(key, smimea.Usage, smimea.Selector, smimea.MatchingType, smimea.Certificate)
+		}
+	}
+	for _, name := range sortedKeys(records.CERT) {
+		key := v.key("CERT", name)
+		if !v.name(key, name) {
+			continue
+		}
+		cert := records.CERT[name]
+		v.uint16(key+".type", cert.Type)
+		v.uint16(key+".keytag", cert.KeyTag)
+		v.uint8(key+".algorithm", cert.Algorithm)
+		v.base64(key+".certificate", cert.Certificate)
+	}
+
+	return errors.Join(v.errs...)
+}
+
+// key returns the TOML key of the record of type rrtype for name.
+func (v *recordValidator) key(rrtype, name string) string {
+	return v.table + "." + rrtype + "." + tomlKey(name)
+}
+
+func (v *recordValidator) fail(key, format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+}
+
+// name checks the owner name of a record; the rest of a record with a bad
+// name is not checked.
+func (v *recordValidator) name(key, name string) bool {
+	if !v.loader.isValidDomain(name) {
+		v.fail(key, "%q is not a valid domain name", name)
+		return false
+	}
+	return true
+}
+
+// target checks a domain name a record points at. With root set, "." is
+// accepted as well.
+func (v *recordValidator) target(key, target string, root bool) {
+	if root && target == "." {
+		return
+	}
+	if !v.loader.isValidDomain(target) {
+		v.fail(key, "%q is not a valid domain name", target)
+	}
+}
+
+func (v *recordValidator) ipv4(key, value string) {
+	addr, err := netip.ParseAddr(value)
+	switch {
+	case err != nil:
+		v.fail(key, "%q is not an IPv4 address", value)
+	case addr.Is6():
+		v.fail(key, "%q is an IPv6 address; it belongs under %s.AAAA", value, v.table)
+	}
+}
+
+func (v *recordValidator) ipv6(key, value string) {
+	addr, err := netip.ParseAddr(value)
+	switch {
+	case err != nil:
+		v.fail(key, "%q is not an IPv6 address", value)
+	case addr.Is4():
+		v.fail(key, "%q is an IPv4 address; it belongs under %s.A", value, v.table)
+	case addr.Zone() != "":
+		v.fail(key, "%q has a zone, which records cannot carry", value)
+	}
+}
+
+func (v *recordValidator) uint8(key string, value int) bool {
+	return v.between(key, value, 0, 255)
+}
+
+func (v *recordValidator) uint16(key string, value int) bool {
+	return v.between(key, value, 0, 65535)
+}
+
+func (v *recordValidator) between(key string, value, low, high int) bool {
+	if value < low || value > high {
+		v.fail(key, "%d is out of range %d-%d", value, low, high)
+		return false
+	}
+	return true
+}
+
+// hex checks a digest in hexadecimal. When size is not 0 the digest must
+// be that many bytes, as the kind and number it was given for require.
+func (v *recordValidator) hex(key, value string, size int, kind string, number int) {
+	digest, err := hex.DecodeString(value)
+	switch {
+	case value == "":
+		v.fail(key, "must not be empty")
+	case err != nil:
+		v.fail(key, "not hexadecimal: %v", err)
+	case size != 0 && len(digest) != size:
+		v.fail(key, "%s %d needs %d bytes (%d hex digits), got %d", kind, number, size, size*2, len(digest))
+	}
+}
+
+// base64 checks a key or certificate in base64, which may be split by
+// whitespace as in zone files.
+func (v *recordValidator) base64(key, value string) {
+	value = strings.Join(strings.Fields(value), "")
+	if value == "" {
+		v.fail(key, "must not be empty")
+		return
+	}
+	if _, err := base64.StdEncoding.DecodeString(value); err != nil {
+		v.fail(key, "not valid base64: %v", err)
+	}
+}
+
+// svcb checks an HTTPS or SVCB record, parsing its params the way the
+// record store will.
+func (v *recordValidator) svcb(key, rrtype string, priority int, target, params string) {
+	if !v.uint16(key+".priority", priority) {
+		return
+	}
+	v.target(key+".target", target, true)
+	params = strings.TrimSpace(params)
+	if params == "" {
+		return
+	}
+	if priority == 0 {
+		v.fail(key+".params", "a record with priority 0 is an alias and takes no params (RFC 9460 section 2.4.2)")
+		return
+	}
+	if _, err := dns.NewRR(fmt.Sprintf(". 0 IN %s %d . %s", rrtype, priority, params)); err != nil {
+		v.fail(key+".params", "%v", err)
+	}
+}
+
+func (v *recordValidator) tlsa(key string, usage, selector, matchingType int, certificate string) {
+	v.between(key+".usage", usage, 0, 3)
+	v.between(key+".selector", selector, 0, 1)
+	if v.between(key+".matchingtype", matchingType, 0, 2) {
+		v.hex(key+".certificate", certificate, tlsaDigestSizes[matchingType], "matching type", matchingType)
+	}
+}
+
+func (v *recordValidator) naptr(key string, naptr NAPTRRecord) {
+	v.uint16(key+".order", naptr.Order)
+	v.uint16(key+".preference", naptr.Preference)
+	for _, flag := range naptr.Flags {
+		if flag > unicode.MaxASCII || !unicode.IsLetter(flag) && !unicode.IsDigit(flag) {
+			v.fail(key+".flags", "%q is not a flag; flags are letters and digits, such as U or S", flag)
+			break
+		}
+	}
+
+	replacement := naptr.Replacement != "" && naptr.Replacement != "."
+	if replacement {
+		v.target(key+".replacement", naptr.Replacement, false)
+	}
+	if naptr.Regexp == "" {
+		return
+	}
+	if replacement {
+		v.fail(key, "regexp and replacement are mutually exclusive (RFC 3403 section 4.1)")
+	}
+	if err := checkSubstitution(naptr.Regexp); err != nil {
+		v.fail(key+".regexp", "%v", err)
+	}
+}
+
+// checkSubstitution checks a NAPTR substitution expression (RFC 3402
+// section 3.2): a delimiter, a regular expression, the delimiter, the
+// replacement, the delimiter and an optional i flag, as in
+// !^.*$!sip:info@example.com!.
+func checkSubstitution(expression string) error {
+	delim, size := utf8.DecodeRuneInString(expression)
+	if delim == utf8.RuneError || delim == '\\' || delim == 'i' || unicode.IsDigit(delim) || unicode.IsSpace(delim) {
+		return fmt.Errorf("%q cannot delimit a substitution expression", delim)
+	}
+
+	var parts []string
+	var part strings.Builder
+	escaped := false
+	for _, r := range expression[size:] {
+		switch {
+		case escaped:
+			if r != delim {
+				part.WriteRune('\\')
+			}
+			part.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == delim:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(r)
+		}
+	}
+	if escaped {
+		part.WriteRune('\\')
+	}
+	parts = append(parts, part.String())
+
+	if len(parts) != 3 {
+		return fmt.Errorf("must be %[1]cregexp%[1]creplacement%[1]c followed by an optional i flag", delim)
+	}
+	if parts[2] != "" && parts[2] != "i" {
+		return fmt.Errorf("unknown flags %q after the replacement", parts[2])
+	}
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return err
+	}
+	for _, ref := range backReference.FindAllStringSubmatch(parts[1], -1) {
+		if n, _ := strconv.Atoi(ref[1]); n > re.NumSubexp() {
+			return fmt.Errorf("replacement refers to group \\%d, but the regexp has %d", n, re.NumSubexp())
+		}
+	}
+	return nil
+}
+
+var backReference = regexp.MustCompile(`\\([0-9])`)
+
+// isCAATag reports whether tag is a CAA property tag (RFC 8659 section
+// 4.1): 1 to 15 ASCII letters and digits.
+func isCAATag(tag string) bool {
+	if len(tag) == 0 || len(tag) > 15 {
+		return false
+	}
+	for _, c := range tag {
+		if c > unicode.MaxASCII || !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// tomlKey returns key as written in a dotted TOML key: bare when it can be
+// and quoted otherwise.
+func tomlKey(key string) string {
+	if key == "" {
+		return `""`
+	}
+	for _, c := range key {
+		if c > unicode.MaxASCII || !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '-' && c != '_' {
+			return strconv.Quote(key)
+		}
+	}
+	return key
+}
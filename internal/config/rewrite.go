@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type RewriteConfig struct {
+	Rules []RewriteRule `toml:"rules"`
+}
+
+// RewriteRule matches queries by name, where "*.example.com" covers every
+// name below example.com, or by a regular expression on the lowercased
+// fully-qualified name. QName sends the query upstream under another name:
+// a suffix replacing the matched one, or a regexp replacement template. The
+// answer records of Type are replaced with Answer, and answer TTLs are
+// clamped to MinTTL and MaxTTL.
+type RewriteRule struct {
+	Name   string        `toml:"name"`
+	Regex  string        `toml:"regex"`
+	Type   string        `toml:"type"`
+	QName  string        `toml:"qname"`
+	Answer string        `toml:"answer"`
+	MinTTL time.Duration `toml:"min_ttl"`
+	MaxTTL time.Duration `toml:"max_ttl"`
+}
+
+func validateRewrite(rewrite *RewriteConfig) error {
+	for i, rule := range rewrite.Rules {
+		if (rule.Name == "") == (rule.Regex == "") {
+			return fmt.Errorf("rewrite rule %d needs exactly one of name or regex", i+1)
+		}
+		if rule.Regex != "" {
+			if _, err := regexp.Compile(rule.Regex); err != nil {
+				return fmt.Errorf("rewrite rule %d has an invalid regex: %w", i+1, err)
+			}
+		}
+		if rule.Type != "" {
+			if _, known := dns.StringToType[strings.ToUpper(rule.Type)]; !known {
+				return fmt.Errorf("rewrite rule %d has an unknown type: %s", i+1, rule.Type)
+			}
+		}
+		if rule.Answer != "" {
+			if rule.Type == "" {
+				return fmt.Errorf("rewrite rule %d needs a type for its answer", i+1)
+			}
+			if _, err := dns.NewRR(fmt.Sprintf(". 0 IN %s %s", rule.Type, rule.Answer)); err != nil {
+				return fmt.Errorf("rewrite rule %d has an invalid answer: %w", i+1, err)
+			}
+		}
+		if rule.MinTTL < 0 || rule.MaxTTL < 0 || (rule.MaxTTL > 0 && rule.MinTTL > rule.MaxTTL) {
+			return fmt.Errorf("rewrite rule %d has an invalid ttl range", i+1)
+		}
+		if rule.QName == "" && rule.Answer == "" && rule.MinTTL == 0 && rule.MaxTTL == 0 {
+			return fmt.Errorf("rewrite rule %d does not rewrite anything", i+1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SiteConfig is shorthand for hosting a domain: it expands into the apex
+// A/AAAA, alias CNAMEs, MX, SPF and DMARC records such a domain usually needs.
+type SiteConfig struct {
+	IPv4  string   `toml:"ipv4"`
+	IPv6  string   `toml:"ipv6"`
+	Mail  string   `toml:"mail"`
+	Alias []string `toml:"alias"`
+}
+
+// expandSites adds the records implied by each site to the base records.
+// Records written out explicitly always win over generated ones.
+func expandSites(config *Config) error {
+	records := &config.Records
+
+	for name, site := range config.Sites {
+		apex := strings.ToLower(strings.TrimSuffix(name, "."))
+		if site.IPv4 == "" && site.IPv6 == "" {
+			return fmt.Errorf("site %s needs ipv4 or ipv6", name)
+		}
+
+		if site.IPv4 != "" {
+			setDefault(&records.A, apex, site.IPv4)
+		}
+		if site.IPv6 != "" {
+			setDefault(&records.AAAA, apex, site.IPv6)
+		}
+
+		aliases := site.Alias
+		if aliases == nil {
+			aliases = []string{"www"}
+		}
+		for _, alias := range aliases {
+			setDefault(&records.CNAME, strings.ToLower(alias)+"."+apex, apex)
+		}
+
+		// a domain without mail publishes policies that reject all of it,
+		// so it cannot be spoofed as a sender
+		spf, dmarc := "v=spf1 -all", "v=DMARC1; p=reject"
+		if site.Mail != "" {
+			setDefault(&records.MX, apex, MXRecord{Priority: 10, Target: strings.TrimSuffix(site.Mail, ".")})
+			spf, dmarc = "v=spf1 mx -all", "v=DMARC1; p=quarantine; rua=mailto:postmaster@"+apex
+		}
+		setDefault(&records.TXT, apex, TXTRecord{spf})
+		setDefault(&records.TXT, "_dmarc."+apex, TXTRecord{dmarc})
+	}
+
+	return nil
+}
+
+func setDefault[V any](m *map[string]V, name string, value V) {
+	if *m == nil {
+		*m = make(map[string]V)
+	}
+	if _, exists := (*m)[name]; !exists {
+		(*m)[name] = value
+	}
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a size in bytes written in the config as a plain number or
+// with a unit suffix such as "512MB" or "1GiB".
+type ByteSize int64
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+func (s *ByteSize) UnmarshalText(text []byte) error {
+	value := strings.TrimSpace(string(text))
+
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(value), strings.ToUpper(unit.suffix)) {
+			value = strings.TrimSpace(value[:len(value)-len(unit.suffix)])
+			factor = unit.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size: %s", text)
+	}
+
+	*s = ByteSize(n * float64(factor))
+	return nil
+}
+
+func (s ByteSize) String() string {
+	return fmt.Sprintf("%.1fMiB", float64(s)/(1<<20))
+}
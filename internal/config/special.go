@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Local zone policies say how names in a zone that no local record holds
+// are answered, after unbound's local-zone types: NXDOMAIN or NODATA
+// (static), from upstream (transparent), REFUSED (refuse) or not at all
+// (deny). Under nxdomain every name is NXDOMAIN, records or not.
+const (
+	LocalZoneStatic      = "static"
+	LocalZoneTransparent = "transparent"
+	LocalZoneRefuse      = "refuse"
+	LocalZoneDeny        = "deny"
+	LocalZoneNXDomain    = "nxdomain"
+)
+
+// SpecialZonesConfig answers the zones reserved for local or no use here,
+// the way RFC 6303 and RFC 6761 ask of resolvers, rather than asking
+// upstream servers that can only answer NXDOMAIN and learn the names
+// asked. Zones switches single zones off, with false, or adds more, with
+// true. Records under [records] for names in them are answered as usual.
+type SpecialZonesConfig struct {
+	Disabled bool            `toml:"disabled"`
+	Zones    map[string]bool `toml:"zones"`
+}
+
+// DefaultSpecialZones lists the zones answered locally unless switched off:
+// the reverse zones of private, loopback, link-local and documentation
+// addresses (RFC 6303, RFC 7793), and the special-use names localhost,
+// test and invalid (RFC 6761), onion (RFC 7686) and home.arpa (RFC 8375).
+var DefaultSpecialZones = defaultSpecialZones()
+
+func defaultSpecialZones() []string {
+	zones := []string{
+		"10.in-addr.arpa.",
+		"168.192.in-addr.arpa.",
+		"0.in-addr.arpa.",
+		"127.in-addr.arpa.",
+		"254.169.in-addr.arpa.",
+		"2.0.192.in-addr.arpa.",
+		"100.51.198.in-addr.arpa.",
+		"113.0.203.in-addr.arpa.",
+		"255.255.255.255.in-addr.arpa.",
+		strings.Repeat("0.", 32) + "ip6.arpa.",
+		"1." + strings.Repeat("0.", 31) + "ip6.arpa.",
+		"d.f.ip6.arpa.",
+		"8.e.f.ip6.arpa.",
+		"9.e.f.ip6.arpa.",
+		"a.e.f.ip6.arpa.",
+		"b.e.f.ip6.arpa.",
+		"8.b.d.0.1.0.0.2.ip6.arpa.",
+		"localhost.",
+		"test.",
+		"invalid.",
+		"onion.",
+		"home.arpa.",
+	}
+	for octet := 16; octet <= 31; octet++ {
+		zones = append(zones, fmt.Sprintf("%d.172.in-addr.arpa.", octet))
+	}
+	for octet := 64; octet <= 127; octet++ {
+		zones = append(zones, fmt.Sprintf("%d.100.in-addr.arpa.", octet))
+	}
+	return zones
+}
+
+func (l *FileConfigLoader) validateSpecialZones(special *SpecialZonesConfig) error {
+	for _, zone := range sortedKeys(special.Zones) {
+		if !l.isZone(zone) {
+			return fmt.Errorf("special_zones.zones.%s: %q is not a zone", tomlKey(zone), zone)
+		}
+	}
+	return nil
+}
+
+func (l *FileConfigLoader) validateLocalZones(zones map[string]string) error {
+	for _, zone := range sortedKeys(zones) {
+		if !l.isZone(zone) {
+			return fmt.Errorf("local_zones.%s: %q is not a zone", tomlKey(zone), zone)
+		}
+		switch zones[zone] {
+		case LocalZoneStatic, LocalZoneTransparent, LocalZoneRefuse, LocalZoneDeny, LocalZoneNXDomain:
+		default:
+			return fmt.Errorf("local_zones.%s: unknown policy %q; use static, transparent, refuse, deny or nxdomain", tomlKey(zone), zones[zone])
+		}
+	}
+	return nil
+}
+
+func (l *FileConfigLoader) isZone(zone string) bool {
+	return !strings.HasPrefix(zone, "*") && l.isValidDomain(zone)
+}
+
+// LocalZones returns the policy of every zone with one, by canonical name:
+// the special zones are static unless [local_zones] says otherwise.
+func LocalZones(cfg *Config) map[string]string {
+	zones := make(map[string]string)
+	for _, zone := range SpecialZones(cfg) {
+		zones[zone] = LocalZoneStatic
+	}
+	for zone, policy := range cfg.LocalZones {
+		zones[CanonicalName(zone)] = policy
+	}
+	return zones
+}
+
+// SpecialZones returns the zones cfg has answered locally, in canonical
+// form and sorted. Zones that stub zones, hooks or secondary zones are set
+// up for, or that hold one of those, are left out, since answering them
+// here would keep those from ever being asked.
+func SpecialZones(cfg *Config) []string {
+	if cfg.Special.Disabled {
+		return nil
+	}
+
+	enabled := make(map[string]bool)
+	for _, zone := range DefaultSpecialZones {
+		enabled[zone] = true
+	}
+	for zone, on := range cfg.Special.Zones {
+		enabled[CanonicalName(zone)] = on
+	}
+
+	var served []string
+	for zone := range cfg.Stub {
+		served = append(served, CanonicalName(zone))
+	}
+	for zone := range cfg.Secondary {
+		served = append(served, CanonicalName(zone))
+	}
+	for _, hook := range cfg.Hooks {
+		for _, zone := range hook.Zones {
+			served = append(served, CanonicalName(zone))
+		}
+	}
+
+	var zones []string
+	for zone, on := range enabled {
+		if on && !overlaps(zone, served) {
+			zones = append(zones, zone)
+		}
+	}
+	sort.Strings(zones)
+	return zones
+}
+
+func overlaps(zone string, zones []string) bool {
+	for _, other := range zones {
+		if dns.IsSubDomain(zone, other) || dns.IsSubDomain(other, zone) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// TracingConfig exports a span per query, with child spans for the cache,
+// local records, hooks and every upstream exchange, to an OpenTelemetry
+// collector over OTLP/HTTP. SampleRate is the fraction of queries
+// exported; spans are sent in batches of up to BatchSize every
+// FlushInterval.
+type TracingConfig struct {
+	Enabled       bool              `toml:"enabled"`
+	Endpoint      string            `toml:"endpoint"`
+	Headers       map[string]string `toml:"headers"`
+	ServiceName   string            `toml:"service_name"`
+	SampleRate    float64           `toml:"sample_rate"`
+	BatchSize     int               `toml:"batch_size"`
+	FlushInterval time.Duration     `toml:"flush_interval"`
+	Timeout       time.Duration     `toml:"timeout"`
+}
+
+func validateTracing(tracing *TracingConfig) error {
+	if tracing.Endpoint != "" {
+		u, err := url.Parse(tracing.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid tracing endpoint: %q", tracing.Endpoint)
+		}
+	}
+	if tracing.SampleRate < 0 || tracing.SampleRate > 1 {
+		return fmt.Errorf("tracing sample_rate must be between 0 and 1: %g", tracing.SampleRate)
+	}
+	if tracing.BatchSize < 0 || tracing.FlushInterval < 0 || tracing.Timeout < 0 {
+		return fmt.Errorf("tracing batch_size, flush_interval and timeout must be non-negative")
+	}
+	return nil
+}
+
+func setTracingDefaults(tracing *TracingConfig) {
+	if tracing.Endpoint == "" {
+		tracing.Endpoint = "http://localhost:4318/v1/traces"
+	}
+	if tracing.ServiceName == "" {
+		tracing.ServiceName = "dns-server"
+	}
+	if tracing.BatchSize == 0 {
+		tracing.BatchSize = 512
+	}
+	if tracing.FlushInterval == 0 {
+		tracing.FlushInterval = 5 * time.Second
+	}
+	if tracing.Timeout == 0 {
+		tracing.Timeout = 10 * time.Second
+	}
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var tsigAlgorithms = map[string]bool{
+	"hmac-sha1":   true,
+	"hmac-sha224": true,
+	"hmac-sha256": true,
+	"hmac-sha384": true,
+	"hmac-sha512": true,
+}
+
+func validateTransfer(transfer *TransferConfig, records *RecordsConfig) error {
+	for name, key := range transfer.TSIG {
+		if !tsigAlgorithms[strings.ToLower(key.Algorithm)] {
+			return fmt.Errorf("unsupported tsig algorithm for key %s: %s", name, key.Algorithm)
+		}
+		if _, err := base64.StdEncoding.DecodeString(key.Secret); err != nil || key.Secret == "" {
+			return fmt.Errorf("tsig secret for key %s must be base64", name)
+		}
+	}
+
+	if !transfer.Enabled {
+		return nil
+	}
+
+	if len(transfer.Zones) == 0 {
+		return fmt.Errorf("at least one zone is required")
+	}
+	for _, zone := range transfer.Zones {
+		if _, exists := records.SOA[strings.ToLower(strings.TrimSuffix(zone, "."))]; !exists {
+			return fmt.Errorf("zone %s has no SOA record", zone)
+		}
+	}
+
+	if len(transfer.AllowFrom) == 0 && len(transfer.TSIG) == 0 {
+		return fmt.Errorf("allow_from or tsig keys are required")
+	}
+	for _, allowed := range transfer.AllowFrom {
+		if _, err := ParseNetwork(allowed); err != nil {
+			return err
+		}
+	}
+
+	for _, target := range transfer.Notify {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return fmt.Errorf("notify target must be host:port: %s", target)
+		}
+	}
+	if _, exists := transfer.TSIG[transfer.NotifyKey]; transfer.NotifyKey != "" && !exists {
+		return fmt.Errorf("unknown notify_tsig_key %s", transfer.NotifyKey)
+	}
+
+	if transfer.JournalSize < 0 {
+		return fmt.Errorf("journal_size must be non-negative: %d", transfer.JournalSize)
+	}
+
+	return nil
+}
+
+// ParseNetwork accepts a CIDR or a bare IP address, which is treated as a
+// single-host network.
+func ParseNetwork(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network: %s", s)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid network: %s", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func validateSecondary(secondary SecondaryConfig, keys map[string]TSIGKey) error {
+	if len(secondary.Primaries) == 0 {
+		return fmt.Errorf("at least one primary is required")
+	}
+	for _, primary := range secondary.Primaries {
+		if _, _, err := net.SplitHostPort(primary); err != nil {
+			return fmt.Errorf("primary must be host:port: %s", primary)
+		}
+	}
+
+	if secondary.TSIGKey != "" {
+		if _, exists := keys[secondary.TSIGKey]; !exists {
+			return fmt.Errorf("unknown tsig key %s, define it under [transfer.tsig]", secondary.TSIGKey)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// UpstreamServerConfig is an [[upstream.server]] block. Address takes the
+// same forms as an entry of servers; Protocol names the transport when the
+// address has no scheme of its own. Timeout and the TLS settings override
+// the [upstream] ones for this server only, and Bootstrap is the IP address
+// connected to in place of a host name in Address, which is still used to
+// verify the certificate and as the DoH host.
+//
+// Servers with a Weight are tried in an order drawn in proportion to it, so
+// they share the queries; while all weights are equal, as by default, they
+// are tried in the order configured.
+type UpstreamServerConfig struct {
+	Address       string        `toml:"address"`
+	Protocol      string        `toml:"protocol"`
+	Timeout       time.Duration `toml:"timeout"`
+	Weight        int           `toml:"weight"`
+	Bootstrap     string        `toml:"bootstrap"`
+	TLSServerName string        `toml:"tls_server_name"`
+	TLSInsecure   bool          `toml:"tls_insecure_skip_verify"`
+	TLSCAFile     string        `toml:"tls_ca_file"`
+	Disabled      bool          `toml:"disabled"`
+}
+
+// Spec is the server address with the scheme of its protocol, as accepted
+// by the servers list.
+func (s *UpstreamServerConfig) Spec() string {
+	if s.Protocol == "" || strings.Contains(s.Address, "://") {
+		return s.Address
+	}
+	return s.Protocol + "://" + s.Address
+}
+
+// UpstreamServers returns the enabled upstream servers: the entries of the
+// servers list, with the [upstream] settings, followed by the
+// [[upstream.server]] blocks.
+func (u *UpstreamConfig) UpstreamServers() []UpstreamServerConfig {
+	servers := make([]UpstreamServerConfig, 0, len(u.Servers)+len(u.ServerBlocks))
+	for _, address := range u.Servers {
+		servers = append(servers, UpstreamServerConfig{Address: address, Weight: 1})
+	}
+	for _, server := range u.ServerBlocks {
+		if !server.Disabled {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// UpstreamAddresses returns the Spec of every enabled upstream server.
+func (u *UpstreamConfig) UpstreamAddresses() []string {
+	servers := u.UpstreamServers()
+	addresses := make([]string, len(servers))
+	for i := range servers {
+		addresses[i] = servers[i].Spec()
+	}
+	return addresses
+}
+
+func validateUpstreamServer(server *UpstreamServerConfig) error {
+	if server.Address == "" {
+		return fmt.Errorf("upstream server needs an address")
+	}
+	switch server.Protocol {
+	case "", "udp", "tcp", "tls", "https":
+	default:
+		return fmt.Errorf("upstream server %s has unknown protocol: %s", server.Address, server.Protocol)
+	}
+	if scheme, _, found := strings.Cut(server.Address, "://"); found && server.Protocol != "" && !strings.EqualFold(scheme, server.Protocol) {
+		return fmt.Errorf("upstream server %s conflicts with protocol %s", server.Address, server.Protocol)
+	}
+	if server.Timeout < 0 {
+		return fmt.Errorf("upstream server %s timeout must not be negative", server.Address)
+	}
+	if server.Weight < 0 {
+		return fmt.Errorf("upstream server %s weight must not be negative", server.Address)
+	}
+	if server.Bootstrap != "" && net.ParseIP(server.Bootstrap) == nil {
+		return fmt.Errorf("upstream server %s bootstrap must be an IP address: %s", server.Address, server.Bootstrap)
+	}
+	return nil
+}
+
+func setUpstreamServerDefaults(server *UpstreamServerConfig) {
+	if server.Weight == 0 {
+		server.Weight = 1
+	}
+}
+
+// validateBootstrapServer accepts an IP address, with or without a port:
+// the bootstrap servers are what host names are resolved with, so they
+// cannot have one themselves.
+func validateBootstrapServer(server string) error {
+	host := server
+	if h, _, err := net.SplitHostPort(server); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("upstream bootstrap server must be an IP address: %s", server)
+	}
+	return nil
+}
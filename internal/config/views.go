@@ -0,0 +1,39 @@
+package config
+
+import "fmt"
+
+// ViewConfig is a set of records only some clients see, like a BIND view:
+// a query from an address in MatchClients is answered from the first
+// matching view's records before [records], so internal clients can get
+// internal addresses for a name that resolves publicly for everyone else.
+type ViewConfig struct {
+	Name         string        `toml:"name"`
+	MatchClients []string      `toml:"match_clients"`
+	Records      RecordsConfig `toml:"records"`
+}
+
+func (l *FileConfigLoader) validateViews(views []ViewConfig) error {
+	seen := make(map[string]bool)
+	for i, view := range views {
+		if view.Name == "" {
+			return fmt.Errorf("view %d has no name", i+1)
+		}
+		if seen[view.Name] {
+			return fmt.Errorf("view %s is defined twice", view.Name)
+		}
+		seen[view.Name] = true
+
+		if len(view.MatchClients) == 0 {
+			return fmt.Errorf("view %s has no match_clients", view.Name)
+		}
+		for _, client := range view.MatchClients {
+			if _, err := ParseNetwork(client); err != nil {
+				return fmt.Errorf("view %s: %w", view.Name, err)
+			}
+		}
+		if err := l.validateRecords(fmt.Sprintf("views[%d].records", i), &view.Records); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// consul synthesizes records for every service in the Consul catalog from
+// its passing instances: A/AAAA and SRV records at <service>.<domain> and
+// <tag>.<service>.<domain>, and an address record per instance at the SRV
+// target <id>.<service>.<domain>.
+type consul struct {
+	address string
+	token   string
+	domain  string
+	wait    time.Duration
+	ttl     uint32
+	client  *http.Client
+}
+
+type consulEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string   `json:"ID"`
+		Service string   `json:"Service"`
+		Address string   `json:"Address"`
+		Port    int      `json:"Port"`
+		Tags    []string `json:"Tags"`
+	} `json:"Service"`
+}
+
+// watch long-polls the service catalog. Health changes do not move the
+// catalog index, so instances are re-read whenever a poll returns, at the
+// latest after the wait time.
+func (c *consul) watch(ctx context.Context, update func([]dns.RR)) error {
+	index := "0"
+	for {
+		var services map[string][]string
+		next, err := c.get(ctx, "/v1/catalog/services?index="+index+"&wait="+strconv.Itoa(int(c.wait.Seconds()))+"s", &services)
+		if err != nil {
+			return err
+		}
+		// a lower index means the catalog was reset
+		if n, _ := strconv.ParseUint(next, 10, 64); n > 0 {
+			if current, _ := strconv.ParseUint(index, 10, 64); n < current {
+				next = "0"
+			}
+			index = next
+		}
+
+		names := make([]string, 0, len(services))
+		for name := range services {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var rrs []dns.RR
+		for _, name := range names {
+			var entries []consulEntry
+			if _, err := c.get(ctx, "/v1/health/service/"+url.PathEscape(name)+"?passing=true", &entries); err != nil {
+				return err
+			}
+			rrs = append(rrs, c.records(name, entries)...)
+		}
+		update(rrs)
+	}
+}
+
+func (c *consul) records(service string, entries []consulEntry) []dns.RR {
+	serviceName := label(service)
+	if serviceName == "" {
+		return nil
+	}
+	base := serviceName + "." + c.domain
+
+	var rrs []dns.RR
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		instance := label(entry.Service.ID)
+		if instance == "" {
+			instance = serviceName
+		}
+		target := instance + "." + base
+
+		owners := []string{base}
+		for _, tag := range entry.Service.Tags {
+			if tag := label(tag); tag != "" {
+				owners = append(owners, tag+"."+base)
+			}
+		}
+
+		if rr := addressRecord(target, host, c.ttl); rr != nil {
+			rrs = append(rrs, rr)
+		}
+		for _, owner := range owners {
+			if rr := addressRecord(owner, host, c.ttl); rr != nil {
+				rrs = append(rrs, rr)
+			}
+			if entry.Service.Port > 0 {
+				rrs = append(rrs, srvRecord(owner, target, entry.Service.Port, 1, 1, c.ttl))
+			}
+		}
+	}
+	return rrs
+}
+
+// get decodes a Consul API response into v and returns its X-Consul-Index.
+func (c *consul) get(ctx context.Context, path string, v any) (string, error) {
+	// a blocking query returns after at most wait plus a sixteenth of jitter
+	ctx, cancel := context.WithTimeout(ctx, c.wait+c.wait/16+10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.address+path, nil)
+	if err != nil {
+		return "", err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("consul returned status %d for %s", resp.StatusCode, path)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", fmt.Errorf("failed to decode consul response: %w", err)
+	}
+	return resp.Header.Get("X-Consul-Index"), nil
+}
@@ -0,0 +1,246 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/metrics"
+	"dns-server/internal/resolver"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// backend watches a service registry and reports the full record set each
+// time it changes, until ctx is canceled or the registry fails.
+type backend interface {
+	watch(ctx context.Context, update func([]dns.RR)) error
+}
+
+// Catalog answers the records synthesized from a service registry. They
+// follow the registry closely, so they are answered before the cache and
+// never stored in it.
+type Catalog struct {
+	backend  backend
+	name     string
+	interval time.Duration
+	logger   *logrus.Logger
+
+	mu       sync.RWMutex
+	store    *resolver.Store
+	synced   time.Time
+	syncs    atomic.Uint64
+	failures atomic.Uint64
+}
+
+type Status struct {
+	Backend string    `json:"backend"`
+	Records int       `json:"records"`
+	Synced  time.Time `json:"synced,omitzero"`
+}
+
+func New(cfg *config.DiscoveryConfig, logger *logrus.Logger) (*Catalog, error) {
+	client := &http.Client{}
+	ttl := uint32(cfg.TTL.Seconds())
+
+	var b backend
+	switch cfg.Backend {
+	case config.DiscoveryConsul:
+		b = &consul{
+			address: strings.TrimSuffix(cfg.Address, "/"),
+			token:   cfg.Token,
+			domain:  dns.Fqdn(strings.ToLower(cfg.Domain)),
+			wait:    cfg.Interval,
+			ttl:     ttl,
+			client:  client,
+		}
+	case config.DiscoveryEtcd:
+		b = &etcd{
+			address: strings.TrimSuffix(cfg.Address, "/"),
+			token:   cfg.Token,
+			prefix:  cfg.Prefix,
+			resync:  cfg.Interval,
+			ttl:     ttl,
+			client:  client,
+		}
+	case config.DiscoveryKubernetes:
+		address := cfg.Address
+		if address == "" {
+			host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+			if host == "" || port == "" {
+				return nil, fmt.Errorf("discovery address is not set and the server is not running in a kubernetes cluster")
+			}
+			address = "https://" + net.JoinHostPort(host, port)
+		}
+		if cfg.CAFile != "" {
+			pem, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read kubernetes CA: %w", err)
+			}
+			roots := x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in %s", cfg.CAFile)
+			}
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+			client.Transport = transport
+		}
+		b = &kubernetes{
+			address:   strings.TrimSuffix(address, "/"),
+			token:     cfg.Token,
+			tokenFile: cfg.TokenFile,
+			domain:    dns.Fqdn(strings.ToLower(cfg.Domain)),
+			resync:    cfg.Interval,
+			ttl:       ttl,
+			client:    client,
+		}
+	default:
+		return nil, fmt.Errorf("unknown discovery backend: %s", cfg.Backend)
+	}
+
+	return &Catalog{
+		backend:  b,
+		name:     cfg.Backend,
+		interval: cfg.Interval,
+		logger:   logger,
+		store:    resolver.NewStore(),
+	}, nil
+}
+
+// Run keeps the records in sync with the registry, reconnecting every
+// interval after a failure, until ctx is canceled. The last records stay
+// served while the registry is unreachable.
+func (c *Catalog) Run(ctx context.Context) {
+	for {
+		err := c.backend.watch(ctx, c.update)
+		if ctx.Err() != nil {
+			return
+		}
+		c.failures.Add(1)
+		c.logger.WithFields(logrus.Fields{
+			"backend": c.name,
+			"error":   err,
+		}).Warn("service discovery sync failed")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.interval):
+		}
+	}
+}
+
+func (c *Catalog) update(rrs []dns.RR) {
+	rrs = dns.Dedup(rrs, nil)
+	store := resolver.NewStore()
+	for _, rr := range rrs {
+		store.Add(rr)
+	}
+
+	c.mu.Lock()
+	changed := store.Len() != c.store.Len()
+	c.store = store
+	c.synced = time.Now()
+	c.mu.Unlock()
+
+	c.syncs.Add(1)
+	fields := logrus.Fields{
+		"backend": c.name,
+		"records": len(rrs),
+	}
+	if changed {
+		c.logger.WithFields(fields).Info("service records updated")
+	} else {
+		c.logger.WithFields(fields).Debug("service records synced")
+	}
+}
+
+// Resolve answers names the registry holds. A name that only has a CNAME
+// is answered with it for every type.
+func (c *Catalog) Resolve(question dns.Question) (*dns.Msg, bool) {
+	c.mu.RLock()
+	store := c.store
+	c.mu.RUnlock()
+
+	rrset, _ := store.Lookup(question.Name, question.Qtype)
+	if len(rrset) == 0 && question.Qtype != dns.TypeCNAME {
+		rrset, _ = store.Lookup(question.Name, dns.TypeCNAME)
+	}
+	if len(rrset) == 0 {
+		return nil, false
+	}
+
+	response := &dns.Msg{}
+	response.SetReply(&dns.Msg{Question: []dns.Question{question}})
+	response.Authoritative = true
+	response.RecursionAvailable = false
+	response.Answer = rrset
+
+	c.logger.WithFields(logrus.Fields{
+		"domain":  strings.ToLower(strings.TrimSuffix(question.Name, ".")),
+		"qtype":   dns.TypeToString[question.Qtype],
+		"answers": len(rrset),
+	}).Debug("service record resolved")
+
+	return response, true
+}
+
+func (c *Catalog) Status() Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return Status{Backend: c.name, Records: c.store.Len(), Synced: c.synced}
+}
+
+func (c *Catalog) Collect(w *metrics.Writer) {
+	status := c.Status()
+	backend := metrics.L("backend", status.Backend)
+	w.Gauge("dns_discovery_records", "Service record sets synthesized from the registry.", float64(status.Records), backend)
+	w.Counter("dns_discovery_syncs_total", "Record updates received from the registry.", float64(c.syncs.Load()), backend)
+	w.Counter("dns_discovery_failures_total", "Failed registry watches.", float64(c.failures.Load()), backend)
+}
+
+// addressRecord returns an A or AAAA record for host at name, or nil when
+// host is not an IP address.
+func addressRecord(name, host string, ttl uint32) dns.RR {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: ip4}
+	}
+	return &dns.AAAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl}, AAAA: ip}
+}
+
+func srvRecord(name, target string, port, priority, weight int, ttl uint32) dns.RR {
+	return &dns.SRV{
+		Hdr:      dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: ttl},
+		Priority: uint16(priority),
+		Weight:   uint16(weight),
+		Port:     uint16(port),
+		Target:   dns.Fqdn(target),
+	}
+}
+
+// label turns a registry identifier into a single DNS label.
+func label(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
@@ -0,0 +1,263 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// etcd synthesizes records from SkyDNS-style entries under a key prefix,
+// read through the etcd v3 JSON gateway. The key /skydns/local/cluster/web/1
+// names 1.web.cluster.local; its value is answered there and at
+// web.cluster.local alongside its siblings.
+type etcd struct {
+	address string
+	token   string
+	prefix  string
+	resync  time.Duration
+	ttl     uint32
+	client  *http.Client
+}
+
+// skydnsEntry is the JSON value SkyDNS and CoreDNS keep under each key.
+type skydnsEntry struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Priority int    `json:"priority"`
+	Weight   int    `json:"weight"`
+	TTL      uint32 `json:"ttl"`
+}
+
+type etcdKV struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type etcdRangeResponse struct {
+	Header struct {
+		Revision string `json:"revision"`
+	} `json:"header"`
+	Kvs []etcdKV `json:"kvs"`
+}
+
+type etcdWatchResponse struct {
+	Result struct {
+		Events []json.RawMessage `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// watch reads the prefix and re-reads it whenever a watch from the read
+// revision reports a change, and at least every resync interval in case a
+// watch silently stalls.
+func (e *etcd) watch(ctx context.Context, update func([]dns.RR)) error {
+	for {
+		var kvs etcdRangeResponse
+		if err := e.post(ctx, "/v3/kv/range", e.keyRange(nil), &kvs); err != nil {
+			return err
+		}
+		update(e.records(kvs.Kvs))
+
+		// the gateway encodes 64-bit revisions as strings
+		revision, _ := strconv.ParseInt(kvs.Header.Revision, 10, 64)
+		request := map[string]any{"create_request": e.keyRange(map[string]any{"start_revision": strconv.FormatInt(revision+1, 10)})}
+		if err := e.waitForChange(ctx, request); err != nil {
+			return err
+		}
+	}
+}
+
+// waitForChange returns once a watch reports an event or the resync
+// interval passes.
+func (e *etcd) waitForChange(ctx context.Context, request map[string]any) error {
+	watchCtx, cancel := context.WithTimeout(ctx, e.resync)
+	defer cancel()
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	resp, err := e.do(watchCtx, "/v3/watch", body)
+	if err != nil {
+		if watchCtx.Err() != nil && ctx.Err() == nil {
+			return nil
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message etcdWatchResponse
+		if err := decoder.Decode(&message); err != nil {
+			if watchCtx.Err() != nil && ctx.Err() == nil {
+				return nil
+			}
+			return fmt.Errorf("etcd watch ended: %w", err)
+		}
+		if message.Error != nil {
+			return fmt.Errorf("etcd watch failed: %s", message.Error.Message)
+		}
+		if len(message.Result.Events) > 0 {
+			return nil
+		}
+	}
+}
+
+func (e *etcd) records(kvs []etcdKV) []dns.RR {
+	var rrs []dns.RR
+	for _, kv := range kvs {
+		key, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			continue
+		}
+		var entry skydnsEntry
+		if err := json.Unmarshal(value, &entry); err != nil || entry.Host == "" {
+			continue
+		}
+
+		name := e.name(string(key))
+		if name == "" {
+			continue
+		}
+		ttl := e.ttl
+		if entry.TTL > 0 {
+			ttl = entry.TTL
+		}
+		weight := entry.Weight
+		if weight == 0 {
+			weight = 100
+		}
+
+		owners := []string{name}
+		if _, parent, ok := strings.Cut(name, "."); ok && strings.Contains(strings.TrimSuffix(parent, "."), ".") {
+			owners = append(owners, parent)
+		}
+
+		address := addressRecord(name, entry.Host, ttl)
+		target := name
+		if address == nil {
+			// a host name rather than an address: alias the key's own name
+			// and point SRV records straight at it
+			target = dns.Fqdn(entry.Host)
+			rrs = append(rrs, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl},
+				Target: target,
+			})
+		}
+		for i, owner := range owners {
+			if address != nil {
+				rrs = append(rrs, addressRecord(owner, entry.Host, ttl))
+			}
+			if entry.Port > 0 && (address != nil || i > 0) {
+				rrs = append(rrs, srvRecord(owner, target, entry.Port, entry.Priority, weight, ttl))
+			}
+		}
+	}
+	return rrs
+}
+
+// name turns a key below the prefix into the domain name it holds, e.g.
+// /skydns/local/cluster/web/1 into 1.web.cluster.local.
+func (e *etcd) name(key string) string {
+	path, ok := strings.CutPrefix(key, e.prefix)
+	if !ok {
+		return ""
+	}
+
+	var labels []string
+	for _, part := range strings.Split(path, "/") {
+		if part != "" {
+			labels = append([]string{strings.ToLower(part)}, labels...)
+		}
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+
+	name := dns.Fqdn(strings.Join(labels, "."))
+	if _, ok := dns.IsDomainName(name); !ok {
+		return ""
+	}
+	return name
+}
+
+// keyRange is a request for every key under the prefix, plus extra fields.
+func (e *etcd) keyRange(extra map[string]any) map[string]any {
+	request := map[string]any{
+		"key":       base64.StdEncoding.EncodeToString([]byte(e.prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(prefixEnd(e.prefix)),
+	}
+	for k, v := range extra {
+		request[k] = v
+	}
+	return request
+}
+
+func (e *etcd) post(ctx context.Context, path string, request any, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	resp, err := e.do(ctx, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode etcd response: %w", err)
+	}
+	return nil
+}
+
+func (e *etcd) do(ctx context.Context, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.address+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", e.token)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("etcd returned status %d for %s", resp.StatusCode, path)
+	}
+	return resp, nil
+}
+
+// prefixEnd is the etcd range end covering every key that starts with
+// prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
@@ -0,0 +1,271 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// errExpired reports a watch whose resource version the API server no
+// longer has, which needs a fresh list.
+var errExpired = errors.New("resource version expired")
+
+// kubernetes watches EndpointSlices and answers them the way cluster DNS
+// answers headless services:
+//
+//	<service>.<namespace>.svc.<domain>                 A/AAAA of ready endpoints
+//	<host>.<service>.<namespace>.svc.<domain>          A/AAAA of one endpoint
+//	_<port>._<proto>.<service>.<namespace>.svc.<domain> SRV to the endpoints
+//	<a-b-c-d>.<namespace>.pod.<domain>                 A/AAAA of a pod
+//
+// where <host> is the endpoint's hostname or its dashed address.
+type kubernetes struct {
+	address   string
+	token     string
+	tokenFile string
+	domain    string
+	resync    time.Duration
+	ttl       uint32
+	client    *http.Client
+}
+
+type endpointSlice struct {
+	Metadata struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace"`
+		Labels          map[string]string `json:"labels"`
+		ResourceVersion string            `json:"resourceVersion"`
+	} `json:"metadata"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Hostname   string   `json:"hostname"`
+		Conditions struct {
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name     string `json:"name"`
+		Protocol string `json:"protocol"`
+		Port     int    `json:"port"`
+	} `json:"ports"`
+}
+
+type endpointSliceList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []endpointSlice `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+const endpointSlicesPath = "/apis/discovery.k8s.io/v1/endpointslices"
+
+// serviceNameLabel ties an EndpointSlice to its service.
+const serviceNameLabel = "kubernetes.io/service-name"
+
+// watch lists every EndpointSlice, then follows a watch from the listed
+// resource version, reporting the records after every change. Watches end
+// after the resync interval and resume where they stopped; an expired
+// version starts over with a list.
+func (k *kubernetes) watch(ctx context.Context, update func([]dns.RR)) error {
+	for {
+		var list endpointSliceList
+		if err := k.list(ctx, &list); err != nil {
+			return err
+		}
+		slices := make(map[string]endpointSlice, len(list.Items))
+		for _, slice := range list.Items {
+			slices[slice.Metadata.Namespace+"/"+slice.Metadata.Name] = slice
+		}
+		update(k.records(slices))
+
+		version := list.Metadata.ResourceVersion
+		for {
+			var err error
+			version, err = k.follow(ctx, version, slices, update)
+			if errors.Is(err, errExpired) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (k *kubernetes) list(ctx context.Context, list *endpointSliceList) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := k.get(ctx, endpointSlicesPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(list); err != nil {
+		return fmt.Errorf("failed to decode endpoint slices: %w", err)
+	}
+	return nil
+}
+
+// follow applies watch events from version to slices until the watch
+// times out, and returns the version to resume from.
+func (k *kubernetes) follow(ctx context.Context, version string, slices map[string]endpointSlice, update func([]dns.RR)) (string, error) {
+	query := url.Values{
+		"watch":               {"1"},
+		"allowWatchBookmarks": {"true"},
+		"resourceVersion":     {version},
+		"timeoutSeconds":      {strconv.Itoa(int(k.resync.Seconds()))},
+	}
+	// bounds a watch the API server fails to end at timeoutSeconds
+	watchCtx, cancel := context.WithTimeout(ctx, k.resync+30*time.Second)
+	defer cancel()
+
+	resp, err := k.get(watchCtx, endpointSlicesPath+"?"+query.Encode())
+	if err != nil {
+		return version, err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event watchEvent
+		if err := decoder.Decode(&event); err != nil {
+			if ctx.Err() != nil {
+				return version, ctx.Err()
+			}
+			// the API server ended the watch at timeoutSeconds
+			return version, nil
+		}
+
+		if event.Type == "ERROR" {
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			json.Unmarshal(event.Object, &status)
+			if status.Code == http.StatusGone {
+				return version, errExpired
+			}
+			return version, fmt.Errorf("endpoint slice watch failed: %s", status.Message)
+		}
+
+		var slice endpointSlice
+		if err := json.Unmarshal(event.Object, &slice); err != nil {
+			return version, fmt.Errorf("failed to decode endpoint slice: %w", err)
+		}
+		version = slice.Metadata.ResourceVersion
+
+		key := slice.Metadata.Namespace + "/" + slice.Metadata.Name
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			slices[key] = slice
+		case "DELETED":
+			delete(slices, key)
+		default:
+			continue
+		}
+		update(k.records(slices))
+	}
+}
+
+func (k *kubernetes) records(slices map[string]endpointSlice) []dns.RR {
+	keys := make([]string, 0, len(slices))
+	for key := range slices {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var rrs []dns.RR
+	for _, key := range keys {
+		slice := slices[key]
+		service := label(slice.Metadata.Labels[serviceNameLabel])
+		namespace := label(slice.Metadata.Namespace)
+		if service == "" || namespace == "" {
+			continue
+		}
+		base := service + "." + namespace + ".svc." + k.domain
+
+		for _, endpoint := range slice.Endpoints {
+			// endpoints without conditions count as ready
+			if ready := endpoint.Conditions.Ready; ready != nil && !*ready {
+				continue
+			}
+			for _, address := range endpoint.Addresses {
+				dashed := label(address)
+				host := label(endpoint.Hostname)
+				if host == "" {
+					host = dashed
+				}
+				target := host + "." + base
+
+				for _, owner := range []string{base, target, dashed + "." + namespace + ".pod." + k.domain} {
+					if rr := addressRecord(owner, address, k.ttl); rr != nil {
+						rrs = append(rrs, rr)
+					}
+				}
+				for _, port := range slice.Ports {
+					if port.Name == "" || port.Port == 0 {
+						continue
+					}
+					protocol := strings.ToLower(port.Protocol)
+					if protocol == "" {
+						protocol = "tcp"
+					}
+					owner := "_" + label(port.Name) + "._" + protocol + "." + base
+					rrs = append(rrs, srvRecord(owner, target, port.Port, 0, 100, k.ttl))
+				}
+			}
+		}
+	}
+	return rrs
+}
+
+func (k *kubernetes) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.address+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// service account tokens rotate, so the file is read for every request
+	token := k.token
+	if token == "" && k.tokenFile != "" {
+		data, err := os.ReadFile(k.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kubernetes token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusGone {
+			return nil, errExpired
+		}
+		return nil, fmt.Errorf("kubernetes API returned status %d for %s", resp.StatusCode, path)
+	}
+	return resp, nil
+}
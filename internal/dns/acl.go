@@ -0,0 +1,39 @@
+package dns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// SetCacheClients limits cached answers to clients in networks. Everyone
+// else always gets a fresh resolution and cannot probe the cache with RD=0
+// queries. An empty list lets every client use the cache.
+func (h *Handler) SetCacheClients(networks []*net.IPNet) {
+	h.cacheClients = networks
+}
+
+func (h *Handler) cacheAllowed(w dns.ResponseWriter) bool {
+	if len(h.cacheClients) == 0 {
+		return true
+	}
+
+	ip := remoteIP(w)
+	if ip == nil {
+		return false
+	}
+	for _, network := range h.cacheClients {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(w dns.ResponseWriter) net.IP {
+	host, _, err := net.SplitHostPort(w.RemoteAddr().String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// hidden is the identity value that refuses to answer, as in BIND.
+const hidden = "none"
+
+// identity is what CH class queries learn about the server: its version,
+// from version.bind and version.server, its host name, from hostname.bind,
+// and its server ID, from id.server (RFC 4892). A configured version of ""
+// stands for the build version. With hide set, every one is refused.
+type identity struct {
+	version  string
+	build    string
+	hostname string
+	id       string
+	hide     bool
+}
+
+// SetIdentity sets the answers to CH class queries. Values of "" or "none"
+// are refused, except for version, where "" answers the build version.
+func (h *Handler) SetIdentity(version, hostname, id string) {
+	h.identity.version = version
+	h.identity.hostname = hostname
+	h.identity.id = id
+}
+
+// HideIdentity refuses every CH class query, whatever SetIdentity set.
+func (h *Handler) HideIdentity(hide bool) {
+	h.identity.hide = hide
+}
+
+// SetBuildVersion sets the version answered when none is configured.
+func (h *Handler) SetBuildVersion(version string) {
+	h.identity.build = version
+}
+
+func (i *identity) lookup(name string) string {
+	if i.hide {
+		return ""
+	}
+	var value string
+	switch strings.ToLower(name) {
+	case "version.bind.", "version.server.":
+		value = i.version
+		if value == "" {
+			value = i.build
+		}
+	case "hostname.bind.":
+		value = i.hostname
+	case "id.server.":
+		value = i.id
+	}
+	if value == hidden {
+		return ""
+	}
+	return value
+}
+
+// chaosAnswer answers a CH class query like BIND: with a TXT record for the
+// names above and REFUSED for everything else.
+func (h *Handler) chaosAnswer(r *dns.Msg) *dns.Msg {
+	response := new(dns.Msg)
+	response.SetReply(r)
+
+	question := r.Question[0]
+	value := h.identity.lookup(question.Name)
+	if value == "" {
+		response.Rcode = dns.RcodeRefused
+		return response
+	}
+
+	response.Authoritative = true
+	if question.Qtype == dns.TypeTXT || question.Qtype == dns.TypeANY {
+		response.Answer = []dns.RR{&dns.TXT{
+			Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS},
+			Txt: []string{value},
+		}}
+	}
+	return response
+}
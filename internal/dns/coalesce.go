@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"dns-server/internal/trace"
+	"dns-server/internal/upstream"
+
+	"github.com/miekg/dns"
+)
+
+// flightTimeout bounds a coalesced upstream lookup. It is not tied to the
+// query that started it, which may give up before the others waiting on it.
+const flightTimeout = 5 * time.Second
+
+// flights coalesces concurrent upstream lookups of the same question: the
+// first query to miss the cache sends it and the rest wait for its answer
+// instead of sending their own.
+type flights struct {
+	mu      sync.Mutex
+	pending map[string]*flight
+}
+
+type flight struct {
+	done     chan struct{}
+	response *dns.Msg
+	origin   string
+	err      error
+}
+
+// resolveShared resolves question upstream, or waits for the lookup of it
+// already in flight. key is its cache key; the CD bit is part of the flight
+// key since it changes what upstream returns. Every caller gets its own
+// copy of the response, along with the server it came from.
+func (h *Handler) resolveShared(ctx context.Context, key string, question dns.Question, checkingDisabled bool) (*dns.Msg, string, error) {
+	if checkingDisabled {
+		key += "/cd"
+	}
+	start := time.Now()
+	t := trace.From(ctx)
+
+	h.flights.mu.Lock()
+	if h.flights.pending == nil {
+		h.flights.pending = make(map[string]*flight)
+	}
+	f, waiting := h.flights.pending[key]
+	if !waiting {
+		f = &flight{done: make(chan struct{})}
+		h.flights.pending[key] = f
+	}
+	h.flights.mu.Unlock()
+
+	if !waiting {
+		flightCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), flightTimeout)
+		flightCtx = upstream.WithCheckingDisabled(flightCtx, checkingDisabled)
+		flightCtx, origin := upstream.WithOrigin(flightCtx)
+		f.response, f.err = h.resolveUpstream(flightCtx, question)
+		f.origin = origin.Server()
+		cancel()
+		t.Stage("upstream", start, "")
+
+		h.flights.mu.Lock()
+		delete(h.flights.pending, key)
+		h.flights.mu.Unlock()
+		close(f.done)
+	} else {
+		h.stats.coalesced.Add(1)
+		select {
+		case <-f.done:
+			t.Stage("upstream", start, "coalesced")
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		}
+	}
+
+	if f.err != nil {
+		return nil, "", f.err
+	}
+	return f.response.Copy(), f.origin, nil
+}
@@ -0,0 +1,164 @@
+package dns
+
+import (
+	"container/heap"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// DomainCount is a queried name or zone with an estimate of how often it was
+// asked for. The true count lies between Count-Error and Count.
+type DomainCount struct {
+	Name  string `json:"name"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error,omitempty"`
+}
+
+type DomainStats struct {
+	Domains []DomainCount `json:"domains"`
+	Zones   []DomainCount `json:"zones"`
+	Blocked []DomainCount `json:"blocked"`
+}
+
+// topCounter keeps approximate counts for the most frequent keys using the
+// Space-Saving algorithm: when it is full, a new key replaces the least
+// counted one and inherits its count as the error bound.
+type topCounter struct {
+	capacity int
+	entries  map[string]*topEntry
+	heap     topHeap
+}
+
+type topEntry struct {
+	key   string
+	count uint64
+	error uint64
+	index int
+}
+
+func newTopCounter(capacity int) *topCounter {
+	return &topCounter{
+		capacity: capacity,
+		entries:  make(map[string]*topEntry, capacity),
+	}
+}
+
+func (c *topCounter) add(key string) {
+	if entry, exists := c.entries[key]; exists {
+		entry.count++
+		heap.Fix(&c.heap, entry.index)
+		return
+	}
+
+	if len(c.heap) < c.capacity {
+		entry := &topEntry{key: key, count: 1}
+		c.entries[key] = entry
+		heap.Push(&c.heap, entry)
+		return
+	}
+
+	entry := c.heap[0]
+	delete(c.entries, entry.key)
+	entry.key = key
+	entry.error = entry.count
+	entry.count++
+	c.entries[key] = entry
+	heap.Fix(&c.heap, 0)
+}
+
+func (c *topCounter) top(n int) []DomainCount {
+	counts := make([]DomainCount, 0, len(c.heap))
+	for _, entry := range c.heap {
+		counts = append(counts, DomainCount{Name: entry.key, Count: entry.count, Error: entry.error})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	if n > 0 && n < len(counts) {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+type topHeap []*topEntry
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h topHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topHeap) Push(x any) {
+	entry := x.(*topEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *topHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+type domainCounter struct {
+	mu         sync.Mutex
+	zoneLabels int
+	domains    *topCounter
+	zones      *topCounter
+	blocked    *topCounter
+}
+
+// SetDomainStats enables counting of the top queried names and zones, and
+// of the names most often blocked by the filter.
+func (h *Handler) SetDomainStats(topDomains, zoneLabels int) {
+	h.domains = &domainCounter{
+		zoneLabels: zoneLabels,
+		domains:    newTopCounter(topDomains),
+		zones:      newTopCounter(topDomains),
+		blocked:    newTopCounter(topDomains),
+	}
+}
+
+func (c *domainCounter) add(name string) {
+	name = strings.ToLower(dns.Fqdn(name))
+	zone := name
+	if labels := dns.Split(name); len(labels) > c.zoneLabels {
+		zone = name[labels[len(labels)-c.zoneLabels]:]
+	}
+
+	c.mu.Lock()
+	c.domains.add(name)
+	c.zones.add(zone)
+	c.mu.Unlock()
+}
+
+func (c *domainCounter) block(name string) {
+	c.mu.Lock()
+	c.blocked.add(strings.ToLower(dns.Fqdn(name)))
+	c.mu.Unlock()
+}
+
+// DomainStats returns the n most queried names and zones and the n most
+// blocked names, or all tracked ones when n is zero.
+func (h *Handler) DomainStats(n int) DomainStats {
+	if h.domains == nil {
+		return DomainStats{Domains: []DomainCount{}, Zones: []DomainCount{}, Blocked: []DomainCount{}}
+	}
+
+	h.domains.mu.Lock()
+	defer h.domains.mu.Unlock()
+	return DomainStats{
+		Domains: h.domains.domains.top(n),
+		Zones:   h.domains.zones.top(n),
+		Blocked: h.domains.blocked.top(n),
+	}
+}
@@ -0,0 +1,29 @@
+package dns
+
+import "github.com/miekg/dns"
+
+// applyHeaderFlags makes the response header follow the request: RD and CD
+// are copied back (RFC 1035, RFC 4035 3.2.2) and AD is only kept when the
+// upstream validated the data and the client asked for it via AD or DO
+// (RFC 6840 5.7-5.8). Answers that never passed through a validating
+// upstream must have AD cleared before they get here.
+func applyHeaderFlags(r, msg *dns.Msg) {
+	msg.RecursionDesired = r.RecursionDesired
+	msg.CheckingDisabled = r.CheckingDisabled
+
+	if !r.AuthenticatedData && !dnssecOK(r) {
+		msg.AuthenticatedData = false
+	}
+}
+
+func dnssecOK(r *dns.Msg) bool {
+	opt := r.IsEdns0()
+	return opt != nil && opt.Do()
+}
+
+// cacheable reports whether an upstream answer for r may be stored. With CD
+// set the upstream skipped validation, so the answer must not be handed to
+// clients that expect validated data.
+func cacheable(r *dns.Msg) bool {
+	return !r.CheckingDisabled
+}
@@ -0,0 +1,181 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"dns-server/internal/cache"
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+type headerFlags struct {
+	rd, cd, ad bool
+}
+
+func TestApplyHeaderFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		request headerFlags
+		do      bool
+		answer  headerFlags
+		want    headerFlags
+	}{
+		{"copies RD", headerFlags{rd: true}, false, headerFlags{}, headerFlags{rd: true}},
+		{"clears RD", headerFlags{}, false, headerFlags{rd: true}, headerFlags{}},
+		{"copies CD", headerFlags{cd: true}, false, headerFlags{}, headerFlags{cd: true}},
+		{"clears CD", headerFlags{}, false, headerFlags{cd: true}, headerFlags{}},
+		{"keeps AD asked for with AD", headerFlags{ad: true}, false, headerFlags{ad: true}, headerFlags{ad: true}},
+		{"keeps AD asked for with DO", headerFlags{}, true, headerFlags{ad: true}, headerFlags{ad: true}},
+		{"clears AD not asked for", headerFlags{rd: true}, false, headerFlags{ad: true}, headerFlags{rd: true}},
+		{"never sets AD", headerFlags{ad: true}, true, headerFlags{}, headerFlags{}},
+		{"all together", headerFlags{rd: true, cd: true, ad: true}, true, headerFlags{ad: true}, headerFlags{rd: true, cd: true, ad: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := new(dns.Msg)
+			r.SetQuestion("example.com.", dns.TypeA)
+			r.RecursionDesired = tt.request.rd
+			r.CheckingDisabled = tt.request.cd
+			r.AuthenticatedData = tt.request.ad
+			if tt.do {
+				r.SetEdns0(dns.DefaultMsgSize, true)
+			}
+
+			msg := new(dns.Msg)
+			msg.SetReply(r)
+			msg.RecursionDesired = tt.answer.rd
+			msg.CheckingDisabled = tt.answer.cd
+			msg.AuthenticatedData = tt.answer.ad
+
+			applyHeaderFlags(r, msg)
+			if got := flagsOf(msg); got != tt.want {
+				t.Errorf("flags = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	for _, cd := range []bool{false, true} {
+		r := new(dns.Msg)
+		r.CheckingDisabled = cd
+		if got := cacheable(r); got == cd {
+			t.Errorf("cacheable with CD=%v = %v", cd, got)
+		}
+	}
+}
+
+// TestHeaderFlagsEndToEnd sends queries through the handler and checks the
+// header bits of local answers, upstream answers and the cached copies of
+// those, in order, since each query may fill the cache for the next. Local
+// answers come from a zone the server owns, so they are authoritative and do
+// not offer recursion.
+func TestHeaderFlagsEndToEnd(t *testing.T) {
+	handler := newTestHandler(loadTestConfig(t, `
+[records.A]
+"local.test" = "192.0.2.1"
+`), validatingUpstream{})
+
+	tests := []struct {
+		name    string
+		qname   string
+		request headerFlags
+		do      bool
+		want    headerFlags
+		ra      bool
+	}{
+		{"local with RD", "local.test.", headerFlags{rd: true}, false, headerFlags{rd: true}, false},
+		{"local without RD", "local.test.", headerFlags{}, false, headerFlags{}, false},
+		{"local never AD", "local.test.", headerFlags{rd: true, ad: true}, true, headerFlags{rd: true}, false},
+		{"local copies CD", "local.test.", headerFlags{rd: true, cd: true}, false, headerFlags{rd: true, cd: true}, false},
+		{"upstream AD asked for", "signed.test.", headerFlags{rd: true, ad: true}, false, headerFlags{rd: true, ad: true}, true},
+		{"cached AD not asked for", "signed.test.", headerFlags{rd: true}, false, headerFlags{rd: true}, true},
+		{"cached AD asked for with DO", "signed.test.", headerFlags{rd: true}, true, headerFlags{rd: true, ad: true}, true},
+		{"cached without RD", "signed.test.", headerFlags{}, false, headerFlags{}, true},
+		{"upstream with CD", "unchecked.test.", headerFlags{rd: true, cd: true, ad: true}, false, headerFlags{rd: true, cd: true, ad: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := new(dns.Msg)
+			r.SetQuestion(tt.qname, dns.TypeA)
+			r.RecursionDesired = tt.request.rd
+			r.CheckingDisabled = tt.request.cd
+			r.AuthenticatedData = tt.request.ad
+			if tt.do {
+				r.SetEdns0(dns.DefaultMsgSize, true)
+			}
+
+			w := &recorder{}
+			handler.ServeDNS(w, r)
+			if w.msg == nil {
+				t.Fatal("no response")
+			}
+			if w.msg.Rcode != dns.RcodeSuccess || len(w.msg.Answer) == 0 {
+				t.Fatalf("rcode %s with %d answers", dns.RcodeToString[w.msg.Rcode], len(w.msg.Answer))
+			}
+			if w.msg.RecursionAvailable != tt.ra {
+				t.Errorf("RA = %v, want %v", w.msg.RecursionAvailable, tt.ra)
+			}
+			if got := flagsOf(w.msg); got != tt.want {
+				t.Errorf("flags = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, found := handler.cache.Get(cache.GenerateCacheKey(dns.Question{Name: "unchecked.test.", Qtype: dns.TypeA, Qclass: dns.ClassINET})); found {
+		t.Error("answer to a CD query was cached")
+	}
+}
+
+func flagsOf(msg *dns.Msg) headerFlags {
+	return headerFlags{rd: msg.RecursionDesired, cd: msg.CheckingDisabled, ad: msg.AuthenticatedData}
+}
+
+// loadTestConfig loads a config of the server, cache and upstream sections
+// tests need followed by extra.
+func loadTestConfig(t testing.TB, extra string) *config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	content := `
+[server]
+port = 53
+
+[cache]
+max_entries = 1000
+
+[upstream]
+servers = ["127.0.0.1:1"]
+` + extra
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.NewConfigLoader().Load(path)
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	return cfg
+}
+
+// validatingUpstream answers every A query with one address and AD set, as
+// a validating resolver would.
+type validatingUpstream struct{}
+
+func (validatingUpstream) Resolve(ctx context.Context, question dns.Question) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(question.Name, question.Qtype)
+	msg.Response = true
+	msg.RecursionAvailable = true
+	msg.AuthenticatedData = true
+	msg.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.IPv4(198, 51, 100, 1),
+	}}
+	return msg, nil
+}
@@ -0,0 +1,224 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"dns-server/internal/cache"
+	"dns-server/internal/config"
+	"dns-server/internal/resolver"
+	"dns-server/internal/upstream"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files from the current responses")
+
+const goldenDir = "../../testdata/golden"
+
+type goldenCase struct {
+	qtype string
+	name  string
+	file  string
+}
+
+// TestGolden answers one query per record in testdata/golden/records.toml
+// in-process and compares the wire-format response with its golden file.
+// Record types of RecordsConfig without a sample fail the test, so a new
+// type cannot be added without a golden case. Run with -update to rewrite
+// the golden files after an intended change.
+func TestGolden(t *testing.T) {
+	cfg, err := config.NewConfigLoader().Load(filepath.Join(goldenDir, "records.toml"))
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	handler := newTestHandler(cfg, noUpstream{})
+
+	cases, untested := goldenCases(&cfg.Records)
+	for _, typeName := range untested {
+		t.Errorf("%s has no sample record in records.toml", typeName)
+	}
+
+	for _, c := range cases {
+		t.Run(c.qtype+"_"+c.name, func(t *testing.T) {
+			query := new(dns.Msg)
+			query.SetQuestion(dns.Fqdn(c.name), dns.StringToType[c.qtype])
+			query.Id = 1
+
+			w := &recorder{}
+			handler.ServeDNS(w, query)
+			if w.msg == nil {
+				t.Fatal("no response")
+			}
+			got, err := w.msg.Pack()
+			if err != nil {
+				t.Fatalf("failed to pack response: %v", err)
+			}
+
+			path := filepath.Join(goldenDir, c.file)
+			if *update {
+				if err := writeGolden(path, w.msg, got); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := readGolden(path)
+			if errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("no golden file %s (run with -update)", c.file)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(want, got) {
+				t.Errorf("response differs from %s\n--- want\n%s--- got\n%s", c.file, describe(want), w.msg)
+			}
+		})
+	}
+}
+
+// goldenCases lists one query per name in each record type table, in type
+// and name order, and the types without a sample.
+func goldenCases(records *config.RecordsConfig) (cases []goldenCase, untested []string) {
+	v := reflect.ValueOf(records).Elem()
+	t := v.Type()
+	for i := range t.NumField() {
+		typeName := t.Field(i).Tag.Get("toml")
+		if _, ok := dns.StringToType[typeName]; !ok || v.Field(i).Kind() != reflect.Map {
+			continue
+		}
+
+		var names []string
+		for _, key := range v.Field(i).MapKeys() {
+			names = append(names, key.String())
+		}
+		if len(names) == 0 {
+			untested = append(untested, typeName)
+			continue
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			// wildcards are exercised through a name they cover
+			name = strings.TrimSuffix(strings.ToLower(name), ".")
+			if rest, ok := strings.CutPrefix(name, "*."); ok {
+				name = "wildcard." + rest
+			}
+			cases = append(cases, goldenCase{
+				qtype: typeName,
+				name:  name,
+				file:  fmt.Sprintf("%s_%s.golden", typeName, name),
+			})
+		}
+	}
+	return cases, untested
+}
+
+// Golden files hold the response in hex, preceded by its presentation form
+// as ; comments for review.
+func writeGolden(path string, msg *dns.Msg, wire []byte) error {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(msg.String(), "\n"), "\n") {
+		b.WriteString(strings.TrimSpace("; "+line) + "\n")
+	}
+	encoded := hex.EncodeToString(wire)
+	for len(encoded) > 64 {
+		b.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	b.WriteString(encoded + "\n")
+
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+func readGolden(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var encoded strings.Builder
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, ";") {
+			encoded.WriteString(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	wire, err := hex.DecodeString(encoded.String())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return wire, nil
+}
+
+func describe(wire []byte) string {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(wire); err != nil {
+		return fmt.Sprintf("unparsable golden response: %v", err)
+	}
+	return msg.String()
+}
+
+// newTestHandler builds a handler answering from the records of cfg, with
+// upstream behind them and logging discarded.
+func newTestHandler(cfg *config.Config, upstream upstream.DNSResolver) *Handler {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	dnsCache := cache.NewLRUCache(cfg.Cache.MaxEntries, cfg.Cache.DefaultTTL, cfg.Cache.CleanupInterval)
+	localResolver := resolver.NewLocalResolver(&cfg.Records, logger)
+	return NewHandler(dnsCache, localResolver, upstream, logger)
+}
+
+// noUpstream fails every upstream query, so only local records answer.
+type noUpstream struct{}
+
+func (noUpstream) Resolve(ctx context.Context, question dns.Question) (*dns.Msg, error) {
+	return nil, fmt.Errorf("no upstream in tests")
+}
+
+// recorder keeps the last response written to it.
+type recorder struct {
+	msg *dns.Msg
+}
+
+func (r *recorder) LocalAddr() net.Addr  { return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53} }
+func (r *recorder) RemoteAddr() net.Addr { return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353} }
+func (r *recorder) WriteMsg(msg *dns.Msg) error {
+	r.msg = msg
+	return nil
+}
+func (r *recorder) Write(b []byte) (int, error) {
+	msg := &dns.Msg{}
+	if err := msg.Unpack(b); err != nil {
+		return 0, err
+	}
+	r.msg = msg
+	return len(b), nil
+}
+func (r *recorder) Network() string     { return "udp" }
+func (r *recorder) Close() error        { return nil }
+func (r *recorder) TsigStatus() error   { return nil }
+func (r *recorder) TsigTimersOnly(bool) {}
+func (r *recorder) Hijack()             {}
+
+var _ dns.ResponseWriter = (*recorder)(nil)
@@ -2,11 +2,26 @@ package dns
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
+	"net"
 	"strings"
 	"time"
 
 	"dns-server/internal/cache"
+	"dns-server/internal/config"
+	"dns-server/internal/discovery"
+	"dns-server/internal/filter"
+	"dns-server/internal/history"
+	"dns-server/internal/hook"
+	"dns-server/internal/leases"
+	"dns-server/internal/ptr"
+	"dns-server/internal/querylog"
 	"dns-server/internal/resolver"
+	"dns-server/internal/rewrite"
+	"dns-server/internal/secondary"
+	"dns-server/internal/trace"
+	"dns-server/internal/transfer"
 	"dns-server/internal/upstream"
 
 	"github.com/miekg/dns"
@@ -16,8 +31,36 @@ import (
 type Handler struct {
 	cache         cache.Cache
 	localResolver *resolver.LocalResolver
+	views         *resolver.Views
+	pools         *resolver.Pools
+	services      *discovery.Catalog
+	leases        *leases.Table
 	resolver      upstream.DNSResolver
 	logger        *logrus.Logger
+	serveStale    bool
+	staleTTL      uint32
+	nsid          string
+	passRcodes    map[int]bool
+	identity      identity
+	fallback      *resolver.LocalResolver
+	fallbackTTL   uint32
+	ttlMode       string
+	ttlPolicy     map[uint16]config.TTLPolicy
+	cacheClients  []*net.IPNet
+	clientNames   *ptr.Enricher
+	queryLog      *querylog.Log
+	queryStream   *querylog.Stream
+	history       *history.Recorder
+	transfer      *transfer.Server
+	secondary     *secondary.Manager
+	domains       *domainCounter
+	rewriter      *rewrite.Engine
+	filter        *filter.Filter
+	hooks         *hook.Hooks
+	flights       flights
+	limiter       limiter
+	tracer        *trace.Tracer
+	stats         handlerStats
 }
 
 func NewHandler(cache cache.Cache, localResolver *resolver.LocalResolver, resolver upstream.DNSResolver, logger *logrus.Logger) *Handler {
@@ -26,10 +69,28 @@ func NewHandler(cache cache.Cache, localResolver *resolver.LocalResolver, resolv
 		localResolver: localResolver,
 		resolver:      resolver,
 		logger:        logger,
+		tracer:        trace.NewTracer(),
 	}
 }
 
 func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	if !h.limiter.acquire() {
+		h.overload(w, r)
+		return
+	}
+	defer h.limiter.release()
+
+	if !h.queryLog.Enabled() && h.history == nil && !h.queryStream.Active() {
+		h.serve(w, r)
+		return
+	}
+
+	qw := &queryWriter{ResponseWriter: w, start: time.Now()}
+	h.serve(qw, r)
+	h.logAnswer(qw, r)
+}
+
+func (h *Handler) serve(w dns.ResponseWriter, r *dns.Msg) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -38,81 +99,492 @@ func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	response.Authoritative = false
 	response.RecursionAvailable = true
 
-	if len(r.Question) == 0 {
+	// a message holds exactly one question in practice, and answering
+	// just the first of several would leave the rest unanswered without a
+	// word (RFC 9619)
+	if len(r.Question) != 1 {
 		response.Rcode = dns.RcodeFormatError
-		h.writeResponse(w, response)
+		h.writeResponse(w, r, response, SourceInvalid)
+		return
+	}
+
+	if r.Opcode == dns.OpcodeNotify {
+		if h.secondary != nil {
+			setSource(w, SourceNotify)
+			h.secondary.ServeDNS(w, r)
+			return
+		}
+		response.Rcode = dns.RcodeNotImplemented
+		h.writeResponse(w, r, response, SourceUnsupported)
 		return
 	}
 
 	question := r.Question[0]
+	h.stats.queries.Add(1)
+	if h.domains != nil {
+		h.domains.add(question.Name)
+	}
 
-	if !h.isSupportedType(question.Qtype) {
-		h.logger.WithFields(logrus.Fields{
+	t := h.tracer.Start(question.Name, dns.TypeToString[question.Qtype], w.RemoteAddr().String())
+	ctx = trace.With(ctx, t)
+	log := h.logger.WithField("trace_id", t.ID)
+	if t.Recorded() {
+		qw := traceWriter(w, t)
+		w = qw
+		defer h.finishTrace(t, qw)
+	}
+	h.logQuery(r, w.RemoteAddr().String(), t.ID)
+
+	switch question.Qclass {
+	case dns.ClassINET:
+	case dns.ClassCHAOS:
+		h.writeResponse(w, r, h.chaosAnswer(r), SourceLocal)
+		return
+	default:
+		log.WithFields(logrus.Fields{
 			"question": question.Name,
-			"qtype":    dns.TypeToString[question.Qtype],
-		}).Debug("unsupported query type")
+			"qclass":   dns.ClassToString[question.Qclass],
+		}).Debug("unsupported query class")
 
 		response.Rcode = dns.RcodeNotImplemented
-		h.writeResponse(w, response)
+		h.writeResponse(w, r, response, SourceUnsupported)
 		return
 	}
 
-	cacheKey := cache.GenerateCacheKey(question)
+	if (question.Qtype == dns.TypeAXFR || question.Qtype == dns.TypeIXFR) && h.transfer != nil {
+		setSource(w, SourceTransfer)
+		h.transfer.ServeDNS(w, r)
+		return
+	}
 
-	if cachedResponse, found := h.cache.Get(cacheKey); found {
-		h.logger.WithFields(logrus.Fields{
+	if !h.isSupportedType(question.Qtype) {
+		log.WithFields(logrus.Fields{
 			"question": question.Name,
 			"qtype":    dns.TypeToString[question.Qtype],
-		}).Debug("cache hit")
+		}).Debug("unsupported query type")
 
-		cachedResponse.Id = r.Id
-		h.writeResponse(w, cachedResponse)
+		response.Rcode = dns.RcodeNotImplemented
+		h.writeResponse(w, r, response, SourceUnsupported)
 		return
 	}
 
-	if localResponse, found := h.localResolver.Resolve(question); found {
-		h.logger.WithFields(logrus.Fields{
+	if h.filter != nil {
+		if blocked := h.filter.Check(r, remoteIP(w)); blocked != nil {
+			log.WithFields(logrus.Fields{
+				"question": question.Name,
+				"qtype":    dns.TypeToString[question.Qtype],
+			}).Debug("query blocked by filter")
+
+			h.stats.blocked.Add(1)
+			if h.domains != nil {
+				h.domains.block(question.Name)
+			}
+			h.writeResponse(w, r, blocked, SourceBlocked)
+			return
+		}
+		if cname := h.filter.SafeSearch(question, remoteIP(w)); cname != nil {
+			response.Answer = []dns.RR{cname}
+			h.follow(ctx, question, response)
+			h.writeResponse(w, r, response, SourceSafeSearch)
+			return
+		}
+	}
+
+	if h.transfer != nil {
+		if soaResponse, found := h.transfer.Resolve(question); found {
+			soaResponse.Id = r.Id
+			h.stats.localAnswers.Add(1)
+			h.writeResponse(w, r, soaResponse, SourceLocal)
+			return
+		}
+	}
+
+	if h.secondary != nil {
+		if zoneResponse, found := h.secondary.Resolve(question); found {
+			zoneResponse.Id = r.Id
+			h.stats.zoneAnswers.Add(1)
+			h.writeResponse(w, r, zoneResponse, SourceSecondary)
+			return
+		}
+	}
+
+	// view answers depend on the client, so they come before the shared
+	// cache and are never stored in it
+	if h.views != nil {
+		if viewResponse, found := h.views.Resolve(remoteIP(w), question); found {
+			viewResponse.Id = r.Id
+			h.stats.localAnswers.Add(1)
+			h.writeResponse(w, r, viewResponse, SourceLocal)
+			return
+		}
+	}
+
+	// pool answers follow target health, so they are not cached either
+	if h.pools != nil {
+		if poolResponse, found := h.pools.Resolve(question); found {
+			poolResponse.Id = r.Id
+			h.stats.localAnswers.Add(1)
+			h.writeResponse(w, r, poolResponse, SourceLocal)
+			return
+		}
+	}
+
+	if h.services != nil {
+		if serviceResponse, found := h.services.Resolve(question); found {
+			serviceResponse.Id = r.Id
+			h.stats.localAnswers.Add(1)
+			h.writeResponse(w, r, serviceResponse, SourceLocal)
+			return
+		}
+	}
+
+	if h.leases != nil {
+		if leaseResponse, found := h.leases.Resolve(question); found {
+			leaseResponse.Id = r.Id
+			h.stats.localAnswers.Add(1)
+			h.writeResponse(w, r, leaseResponse, SourceLocal)
+			return
+		}
+	}
+
+	cacheKey := cache.GenerateCacheKey(question)
+	useCache := h.cacheAllowed(w)
+
+	if useCache {
+		lookupStart := time.Now()
+		hit, found := h.cachedAnswer(w, r, cacheKey)
+		t.Stage("cache", lookupStart, hitOrMiss(found))
+		if found {
+			log.WithFields(logrus.Fields{
+				"question": question.Name,
+				"qtype":    dns.TypeToString[question.Qtype],
+			}).Debug("cache hit")
+
+			h.stats.cacheHits.Add(1)
+			h.writeHit(w, r, hit)
+			return
+		}
+	}
+
+	lookupStart := time.Now()
+	localResponse, found := h.resolveLocal(ctx, question)
+	t.Stage("local", lookupStart, hitOrMiss(found))
+	if found {
+		log.WithFields(logrus.Fields{
 			"question": question.Name,
 			"qtype":    dns.TypeToString[question.Qtype],
 		}).Debug("local record resolved")
 
 		localResponse.Id = r.Id
+		h.stats.localAnswers.Add(1)
 
 		ttl := h.extractTTL(localResponse)
 		if ttl > 0 {
-			h.cache.Set(cacheKey, localResponse, ttl)
+			h.cache.SetFrom(cacheKey, localResponse, ttl, SourceLocal)
 		}
 
-		h.writeResponse(w, localResponse)
+		h.writeResponse(w, r, localResponse, SourceLocal)
 		return
 	}
 
-	h.logger.WithFields(logrus.Fields{
+	if h.hooks != nil {
+		lookupStart := time.Now()
+		hookResponse, found, err := h.hooks.Resolve(ctx, r, w.RemoteAddr().String())
+		t.Stage("hook", lookupStart, hitOrMiss(found))
+		if found {
+			if err != nil {
+				h.stats.failures.Add(1)
+				response.Rcode = dns.RcodeServerFailure
+				h.writeResponse(w, r, response, SourceFailure)
+				return
+			}
+
+			h.stats.hookAnswers.Add(1)
+			if cacheable(r) {
+				h.cacheUpstream(cacheKey, hookResponse.Copy(), SourceHook)
+			}
+			h.writeResponse(w, r, hookResponse, SourceHook)
+			return
+		}
+	}
+
+	switch h.localResolver.Policy(question.Name) {
+	case config.LocalZoneRefuse:
+		log.WithField("question", question.Name).Debug("refused by local zone policy")
+		response.Rcode = dns.RcodeRefused
+		h.writeResponse(w, r, response, SourceRefused)
+		return
+	case config.LocalZoneDeny:
+		log.WithField("question", question.Name).Debug("dropped by local zone policy")
+		return
+	}
+
+	// a name below one upstream said does not exist does not exist either
+	// (RFC 8020), which spares asking for random names below it
+	if useCache {
+		if nxResponse, found := h.cache.BelowNXDomain(question); found {
+			log.WithField("question", question.Name).Debug("answered from cached NXDOMAIN above")
+
+			nxResponse.Id = r.Id
+			h.stats.cacheHits.Add(1)
+			h.writeResponse(w, r, nxResponse, SourceCache)
+			return
+		}
+	}
+
+	if !r.RecursionDesired {
+		log.WithFields(logrus.Fields{
+			"question": question.Name,
+			"qtype":    dns.TypeToString[question.Qtype],
+		}).Debug("recursion not desired and no cached or local answer")
+
+		response.Rcode = dns.RcodeRefused
+		addEDE(r, response, dns.ExtendedErrorCodeNotAuthoritative, "recursion not desired")
+		h.writeResponse(w, r, response, SourceRefused)
+		return
+	}
+
+	log.WithFields(logrus.Fields{
 		"question": question.Name,
 		"qtype":    dns.TypeToString[question.Qtype],
 	}).Debug("cache miss and no local record, forwarding to upstream")
 
-	upstreamResponse, err := h.resolver.Resolve(ctx, question)
+	upstreamResponse, origin, err := h.resolveShared(ctx, cacheKey, question, r.CheckingDisabled)
 	if err != nil {
-		h.logger.WithFields(logrus.Fields{
+		log.WithFields(logrus.Fields{
 			"question": question.Name,
 			"qtype":    dns.TypeToString[question.Qtype],
 			"error":    err,
 		}).Error("upstream resolution failed")
 
+		if staleResponse, found := h.staleAnswer(cacheKey, useCache); found {
+			log.WithFields(logrus.Fields{
+				"question": question.Name,
+				"qtype":    dns.TypeToString[question.Qtype],
+				"error":    err,
+			}).Warn("serving stale answer after upstream failure")
+
+			staleResponse.Id = r.Id
+			staleResponse.AuthenticatedData = false
+			addEDE(r, staleResponse, dns.ExtendedErrorCodeStaleAnswer, "upstream unavailable")
+			h.stats.staleAnswers.Add(1)
+			h.writeResponse(w, r, staleResponse, SourceStale)
+			return
+		}
+
+		if h.hooks != nil {
+			if hookResponse, found := h.hooks.Fallback(ctx, r, w.RemoteAddr().String()); found {
+				log.WithFields(logrus.Fields{
+					"question": question.Name,
+					"qtype":    dns.TypeToString[question.Qtype],
+					"error":    err,
+				}).Warn("serving hook answer after upstream failure")
+
+				h.stats.hookAnswers.Add(1)
+				h.writeResponse(w, r, hookResponse, SourceHook)
+				return
+			}
+		}
+
+		if fallbackResponse, found := h.fallbackAnswer(question); found {
+			log.WithFields(logrus.Fields{
+				"question": question.Name,
+				"qtype":    dns.TypeToString[question.Qtype],
+				"error":    err,
+			}).Warn("serving static fallback answer after upstream failure")
+
+			fallbackResponse.Id = r.Id
+			addEDE(r, fallbackResponse, dns.ExtendedErrorCodeOther, "static fallback answer, upstream unavailable")
+			h.stats.fallbackAnswers.Add(1)
+			h.writeResponse(w, r, fallbackResponse, SourceFallback)
+			return
+		}
+
+		h.stats.failures.Add(1)
 		response.Rcode = dns.RcodeServerFailure
-		h.writeResponse(w, response)
+		var rcodeErr *upstream.RcodeError
+		if errors.As(err, &rcodeErr) && h.passRcodes[rcodeErr.Rcode] {
+			response.Rcode = rcodeErr.Rcode
+		}
+		h.writeResponse(w, r, response, SourceFailure)
 		return
 	}
 
 	upstreamResponse.Id = r.Id
+	h.stats.upstreamAnswers.Add(1)
+
+	if cacheable(r) {
+		h.cacheUpstream(cacheKey, upstreamResponse, origin)
+	}
+
+	h.writeResponse(w, r, upstreamResponse, SourceUpstream)
+}
+
+// SetPassRcodes has clients get the RCODE the upstream servers answered
+// with, rather than SERVFAIL, when it is one of rcodes.
+func (h *Handler) SetPassRcodes(rcodes []string) {
+	h.passRcodes = make(map[int]bool, len(rcodes))
+	for _, name := range rcodes {
+		h.passRcodes[dns.StringToRcode[strings.ToUpper(name)]] = true
+	}
+}
+
+func (h *Handler) SetServeStale(enabled bool, staleTTL time.Duration) {
+	h.serveStale = enabled
+	h.staleTTL = uint32(staleTTL.Seconds())
+}
+
+// SetFallback configures static records served only when upstream
+// resolution fails and no stale cache entry exists.
+func (h *Handler) SetFallback(fallback *resolver.LocalResolver, ttl time.Duration) {
+	h.fallback = fallback
+	h.fallbackTTL = uint32(ttl.Seconds())
+}
+
+func (h *Handler) fallbackAnswer(question dns.Question) (*dns.Msg, bool) {
+	if h.fallback == nil {
+		return nil, false
+	}
+
+	msg, found := h.fallback.Resolve(question)
+	if !found {
+		return nil, false
+	}
+
+	msg.Authoritative = false
+	msg.AuthenticatedData = false
+	msg.RecursionAvailable = true
+	for _, rr := range msg.Answer {
+		rr.Header().Ttl = h.fallbackTTL
+	}
+
+	return msg, true
+}
+
+// SetViews answers clients in a view from its records before the cache and
+// the shared records.
+func (h *Handler) SetViews(views *resolver.Views) {
+	h.views = views
+}
+
+// SetPools answers pool names with their healthy addresses.
+func (h *Handler) SetPools(pools *resolver.Pools) {
+	h.pools = pools
+}
+
+func (h *Handler) SetServices(services *discovery.Catalog) {
+	h.services = services
+}
+
+// SetLeases answers the hostnames of active DHCP leases and their
+// addresses in reverse.
+func (h *Handler) SetLeases(leases *leases.Table) {
+	h.leases = leases
+}
+
+// SetTransfer routes AXFR and IXFR requests to transfer instead of
+// answering them as unsupported, and lets it answer apex SOA queries.
+func (h *Handler) SetTransfer(transfer *transfer.Server) {
+	h.transfer = transfer
+}
+
+// SetSecondary answers questions for transferred secondary zones before the
+// cache and accepts NOTIFY for them.
+func (h *Handler) SetSecondary(zones *secondary.Manager) {
+	h.secondary = zones
+}
+
+// SetClientNames adds the reverse DNS name of the client to query log
+// entries once names has resolved it.
+func (h *Handler) SetClientNames(names *ptr.Enricher) {
+	h.clientNames = names
+}
 
-	ttl := h.extractTTL(upstreamResponse)
-	if ttl > 0 {
-		h.cache.Set(cacheKey, upstreamResponse, ttl)
+// SetHooks passes queries for hook zones to external resolvers, and lets
+// fallback hooks answer when upstream resolution fails.
+func (h *Handler) SetHooks(hooks *hook.Hooks) {
+	h.hooks = hooks
+}
+
+// SetFilter blocks the queries filter's rules block before they are
+// answered from anywhere, and sends search queries to restricted names when
+// it enforces safe search.
+func (h *Handler) SetFilter(filter *filter.Filter) {
+	h.filter = filter
+}
+
+func (h *Handler) SetNSID(nsid string) {
+	h.nsid = nsid
+}
+
+// applyEDNS echoes an OPT record when the client sent one (RFC 6891) and
+// answers NSID requests (RFC 5001).
+func (h *Handler) applyEDNS(r, msg *dns.Msg) {
+	reqOpt := r.IsEdns0()
+	if reqOpt == nil {
+		return
 	}
 
-	h.writeResponse(w, upstreamResponse)
+	opt := msg.IsEdns0()
+	if opt == nil {
+		msg.SetEdns0(dns.DefaultMsgSize, reqOpt.Do())
+		opt = msg.IsEdns0()
+	}
+
+	if h.nsid == "" {
+		return
+	}
+
+	for _, option := range reqOpt.Option {
+		if option.Option() == dns.EDNS0NSID {
+			opt.Option = append(opt.Option, &dns.EDNS0_NSID{
+				Code: dns.EDNS0NSID,
+				Nsid: hex.EncodeToString([]byte(h.nsid)),
+			})
+			return
+		}
+	}
+}
+
+func (h *Handler) staleAnswer(cacheKey string, useCache bool) (*dns.Msg, bool) {
+	if !h.serveStale || !useCache {
+		return nil, false
+	}
+
+	msg, found := h.cache.GetStale(cacheKey)
+	if !found {
+		return nil, false
+	}
+
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				rr.Header().Ttl = h.staleTTL
+			}
+		}
+	}
+
+	return msg, true
+}
+
+// addEDE attaches an Extended DNS Error (RFC 8914) to response if the client
+// signalled EDNS support.
+func addEDE(request, response *dns.Msg, code uint16, text string) {
+	reqOpt := request.IsEdns0()
+	if reqOpt == nil {
+		return
+	}
+
+	opt := response.IsEdns0()
+	if opt == nil {
+		response.SetEdns0(reqOpt.UDPSize(), reqOpt.Do())
+		opt = response.IsEdns0()
+	}
+
+	opt.Option = append(opt.Option, &dns.EDNS0_EDE{
+		InfoCode:  code,
+		ExtraText: text,
+	})
 }
 
 func (h *Handler) isSupportedType(qtype uint16) bool {
@@ -125,12 +597,23 @@ func (h *Handler) isSupportedType(qtype uint16) bool {
 }
 
 func (h *Handler) extractTTL(msg *dns.Msg) time.Duration {
-	if len(msg.Answer) == 0 {
+	records := msg.Answer
+	if len(records) == 0 {
+		// negative answers last as long as the SOA that comes with them
+		// (RFC 2308 section 5)
+		for _, rr := range msg.Ns {
+			if rr.Header().Rrtype == dns.TypeSOA {
+				records = []dns.RR{rr}
+				break
+			}
+		}
+	}
+	if len(records) == 0 {
 		return 300 * time.Second
 	}
 
 	minTTL := uint32(3600)
-	for _, rr := range msg.Answer {
+	for _, rr := range records {
 		if rr.Header().Ttl < minTTL {
 			minTTL = rr.Header().Ttl
 		}
@@ -143,28 +626,42 @@ func (h *Handler) extractTTL(msg *dns.Msg) time.Duration {
 	return time.Duration(minTTL) * time.Second
 }
 
-func (h *Handler) writeResponse(w dns.ResponseWriter, msg *dns.Msg) {
-	if err := w.WriteMsg(msg); err != nil {
+func (h *Handler) writeResponse(w dns.ResponseWriter, r, msg *dns.Msg, source string) {
+	setSource(w, source)
+	// answers from the cache or shared with other queries may spell the
+	// name in another case; clients check for the one they sent
+	msg.Question = r.Question
+	applyHeaderFlags(r, msg)
+	h.applyEDNS(r, msg)
+
+	if err := writeMsg(w, msg); err != nil {
 		h.logger.WithError(err).Error("failed to write DNS response")
 	}
 }
 
-func (h *Handler) logQuery(r *dns.Msg, clientAddr string) {
+func (h *Handler) logQuery(r *dns.Msg, clientAddr, traceID string) {
 	if len(r.Question) == 0 {
 		return
 	}
 
 	question := r.Question[0]
-	h.logger.WithFields(logrus.Fields{
+	fields := logrus.Fields{
 		"client":   clientAddr,
 		"question": strings.TrimSuffix(question.Name, "."),
 		"qtype":    dns.TypeToString[question.Qtype],
 		"qclass":   dns.ClassToString[question.Qclass],
-	}).Info("DNS query received")
+		"trace_id": traceID,
+	}
+	if h.clientNames != nil {
+		if host, _, err := net.SplitHostPort(clientAddr); err == nil {
+			if name := h.clientNames.Name(host); name != "" {
+				fields["client_name"] = name
+			}
+		}
+	}
+	h.logger.WithFields(fields).Debug("DNS query received")
 }
 
 func (h *Handler) HandleQuery(w dns.ResponseWriter, r *dns.Msg) {
-	clientAddr := w.RemoteAddr().String()
-	h.logQuery(r, clientAddr)
 	h.ServeDNS(w, r)
 }
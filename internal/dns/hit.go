@@ -0,0 +1,147 @@
+package dns
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Header bits writeResponse sets from the request, in the third and fourth
+// bytes of a packed message.
+const (
+	bitRD = 0x01
+	bitAD = 0x20
+	bitCD = 0x10
+)
+
+// cacheHit is a cached answer ready to be written: packed in a pooled
+// buffer when it can be sent as it is, or else unpacked.
+type cacheHit struct {
+	wire []byte
+	buf  *[]byte
+	msg  *dns.Msg
+}
+
+// wireHits reports whether a cache hit for r can be sent as the packed
+// entry, patched in place, instead of being unpacked and packed again. That
+// takes a listener writer, and nothing to add to the answer but the header
+// bits, the question and a bare OPT record.
+func (h *Handler) wireHits(w dns.ResponseWriter, r *dns.Msg) bool {
+	return h.ttlMode != TTLModeRRset && (h.nsid == "" || r.IsEdns0() == nil) && wireCapable(w)
+}
+
+// cachedAnswer looks up the answer to r cached under key.
+func (h *Handler) cachedAnswer(w dns.ResponseWriter, r *dns.Msg, key string) (cacheHit, bool) {
+	if !h.wireHits(w, r) {
+		msg, found := h.cache.Get(key)
+		return cacheHit{msg: msg}, found
+	}
+
+	buf := packBuffers.Get().(*[]byte)
+	wire, opt, found := h.cache.AppendWire((*buf)[:0], key)
+	if !found {
+		packBuffers.Put(buf)
+		return cacheHit{}, false
+	}
+	if patched, ok := patchHit(r, wire, opt); ok {
+		return cacheHit{wire: patched, buf: buf}, true
+	}
+
+	msg := new(dns.Msg)
+	err := msg.Unpack(wire)
+	packBuffers.Put(buf)
+	return cacheHit{msg: msg}, err == nil
+}
+
+// writeHit sends hit as the answer to r.
+func (h *Handler) writeHit(w dns.ResponseWriter, r *dns.Msg, hit cacheHit) {
+	if hit.wire == nil {
+		hit.msg.Id = r.Id
+		h.pruneExpired(hit.msg)
+		respell(hit.msg, r.Question[0].Name)
+		h.writeResponse(w, r, hit.msg, SourceCache)
+		return
+	}
+
+	defer packBuffers.Put(hit.buf)
+	setSource(w, SourceCache)
+	if err := writeWire(w, hit.wire); err != nil {
+		h.logger.WithError(err).Error("failed to write DNS response")
+	}
+}
+
+// patchHit turns wire, a cached response, into the answer to r the way
+// writeResponse does for messages: the ID and the RD, CD and AD bits follow
+// the request, the question takes the client's spelling, which names
+// compressed to it follow, and a client using EDNS gets an OPT record. It
+// reports false when the question cannot be patched in place.
+func patchHit(r *dns.Msg, wire []byte, opt bool) ([]byte, bool) {
+	// a packed name is at most 255 octets (RFC 1035 section 3.1)
+	var name [255]byte
+	length, err := dns.PackDomainName(r.Question[0].Name, name[:], 0, nil, false)
+	if err != nil || 12+length > len(wire) || !equalFold(name[:length], wire[12:12+length]) {
+		return wire, false
+	}
+	copy(wire[12:], name[:length])
+
+	binary.BigEndian.PutUint16(wire, r.Id)
+	wire[2] = setBit(wire[2], bitRD, r.RecursionDesired)
+	wire[3] = setBit(wire[3], bitCD, r.CheckingDisabled)
+	reqOpt := r.IsEdns0()
+	if !r.AuthenticatedData && (reqOpt == nil || !reqOpt.Do()) {
+		wire[3] &^= bitAD
+	}
+
+	if reqOpt != nil && !opt {
+		var flags byte
+		if reqOpt.Do() {
+			flags = 0x80
+		}
+		// root owner, type OPT, the UDP size as class, then extended
+		// RCODE, version, flags and an empty RDATA
+		wire = append(wire, 0, 0, byte(dns.TypeOPT), byte(dns.DefaultMsgSize>>8), byte(dns.DefaultMsgSize&0xff), 0, 0, flags, 0, 0, 0)
+		binary.BigEndian.PutUint16(wire[10:], binary.BigEndian.Uint16(wire[10:])+1)
+	}
+	return wire, true
+}
+
+func setBit(b, bit byte, set bool) byte {
+	if set {
+		return b | bit
+	}
+	return b &^ bit
+}
+
+// equalFold reports whether a and b are the same packed name but for the
+// case of ASCII letters.
+func equalFold(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		if 'A' <= x && x <= 'Z' {
+			x += 'a' - 'A'
+		}
+		if 'A' <= y && y <= 'Z' {
+			y += 'a' - 'A'
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+// respell gives the records of msg owned by name, which cached entries keep
+// in lowercase, the client's spelling of it.
+func respell(msg *dns.Msg, name string) {
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if hdr := rr.Header(); strings.EqualFold(hdr.Name, name) {
+				hdr.Name = name
+			}
+		}
+	}
+}
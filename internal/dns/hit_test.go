@@ -0,0 +1,240 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPatchHit(t *testing.T) {
+	stored := new(dns.Msg)
+	stored.SetQuestion("www.example.com.", dns.TypeA)
+	stored.Response = true
+	stored.RecursionDesired = true
+	stored.RecursionAvailable = true
+	stored.AuthenticatedData = true
+	stored.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.IPv4(192, 0, 2, 1),
+	}}
+	stored.Compress = true
+	packed, err := stored.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		qname   string
+		request headerFlags
+		edns    bool
+		do      bool
+		want    headerFlags
+	}{
+		{"plain", "www.example.com.", headerFlags{rd: true}, false, false, headerFlags{rd: true}},
+		{"no RD", "www.example.com.", headerFlags{}, false, false, headerFlags{}},
+		{"CD and AD", "www.example.com.", headerFlags{rd: true, cd: true, ad: true}, false, false, headerFlags{rd: true, cd: true, ad: true}},
+		{"EDNS", "www.example.com.", headerFlags{rd: true}, true, false, headerFlags{rd: true}},
+		{"EDNS with DO", "www.example.com.", headerFlags{rd: true}, true, true, headerFlags{rd: true, ad: true}},
+		{"mixed case", "WwW.ExAmple.COM.", headerFlags{rd: true}, false, false, headerFlags{rd: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := new(dns.Msg)
+			r.SetQuestion(tt.qname, dns.TypeA)
+			r.Id = 0xbeef
+			r.RecursionDesired = tt.request.rd
+			r.CheckingDisabled = tt.request.cd
+			r.AuthenticatedData = tt.request.ad
+			if tt.edns {
+				r.SetEdns0(1232, tt.do)
+			}
+
+			wire, ok := patchHit(r, append([]byte(nil), packed...), false)
+			if !ok {
+				t.Fatal("not patched")
+			}
+			got := new(dns.Msg)
+			if err := got.Unpack(wire); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Id != r.Id {
+				t.Errorf("ID = %#x, want %#x", got.Id, r.Id)
+			}
+			if flags := flagsOf(got); flags != tt.want {
+				t.Errorf("flags = %+v, want %+v", flags, tt.want)
+			}
+			if got.Question[0].Name != tt.qname || got.Answer[0].Header().Name != tt.qname {
+				t.Errorf("names %s, %s, want %s", got.Question[0].Name, got.Answer[0].Header().Name, tt.qname)
+			}
+			opt := got.IsEdns0()
+			if (opt != nil) != tt.edns {
+				t.Fatalf("OPT present = %v, want %v", opt != nil, tt.edns)
+			}
+			if opt != nil && opt.Do() != tt.do {
+				t.Errorf("DO = %v, want %v", opt.Do(), tt.do)
+			}
+		})
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("other.example.com.", dns.TypeA)
+	if _, ok := patchHit(r, append([]byte(nil), packed...), false); ok {
+		t.Error("patched a question for another name")
+	}
+}
+
+// TestCacheHitWire checks that hits sent as patched wire bytes to listener
+// writers match the hits other writers get as messages.
+func TestCacheHitWire(t *testing.T) {
+	handler := newTestHandler(loadTestConfig(t, ""), validatingUpstream{})
+
+	fill := new(dns.Msg)
+	fill.SetQuestion("hit.test.", dns.TypeA)
+	handler.ServeDNS(&recorder{}, fill)
+
+	for _, edns := range []bool{false, true} {
+		r := new(dns.Msg)
+		r.SetQuestion("Hit.TEST.", dns.TypeA)
+		r.Id = 42
+		if edns {
+			r.SetEdns0(1232, true)
+		}
+
+		if !handler.wireHits(&wireWriter{ResponseWriter: &recorder{}}, r) {
+			t.Fatal("listener writer not served wire hits")
+		}
+		viaWire := &recorder{}
+		handler.ServeDNS(&wireWriter{ResponseWriter: viaWire}, r)
+		viaMsg := &recorder{}
+		handler.ServeDNS(viaMsg, r)
+		if viaWire.msg == nil || viaMsg.msg == nil {
+			t.Fatal("no response")
+		}
+
+		if got, want := viaWire.msg.String(), viaMsg.msg.String(); got != want {
+			t.Errorf("EDNS %v: wire hit\n%s\nwant\n%s", edns, got, want)
+		}
+	}
+	if hits := handler.GetStats().CacheHits; hits != 4 {
+		t.Errorf("cache hits = %d, want 4", hits)
+	}
+}
+
+func BenchmarkCacheHit(b *testing.B) {
+	handler := newTestHandler(loadTestConfig(b, ""), validatingUpstream{})
+	r := new(dns.Msg)
+	r.SetQuestion("hit.test.", dns.TypeA)
+	r.SetEdns0(1232, false)
+	handler.ServeDNS(&recorder{}, r)
+
+	b.Run("wire", func(b *testing.B) {
+		w := &wireWriter{ResponseWriter: &discard{}}
+		b.ReportAllocs()
+		for b.Loop() {
+			handler.ServeDNS(w, r)
+		}
+	})
+	b.Run("message", func(b *testing.B) {
+		w := &discard{}
+		b.ReportAllocs()
+		for b.Loop() {
+			handler.ServeDNS(w, r)
+		}
+	})
+}
+
+// discard drops what is written to it, packing messages first as the
+// writers of the listeners do, so benchmarks measure the handler alone.
+type discard struct {
+	recorder
+}
+
+func (*discard) WriteMsg(msg *dns.Msg) error {
+	_, err := msg.Pack()
+	return err
+}
+func (*discard) Write(b []byte) (int, error) { return len(b), nil }
+
+// TestCacheHitCase fills the cache from a client randomizing the case of
+// its queries and checks that later clients get the question name spelled
+// their way and no trace of the first client's spelling, whichever writer
+// they are answered through. Names compressed to the question may take the
+// client's spelling too, as they do from authoritative servers.
+func TestCacheHitCase(t *testing.T) {
+	handler := newTestHandler(loadTestConfig(t, ""), chainUpstream{})
+
+	fill := new(dns.Msg)
+	fill.SetQuestion("Long.exAMple.CoM.", dns.TypeA)
+	handler.ServeDNS(&recorder{}, fill)
+
+	for _, qname := range []string{"long.example.com.", "LONG.EXAMPLE.COM."} {
+		for _, wire := range []bool{false, true} {
+			r := new(dns.Msg)
+			r.SetQuestion(qname, dns.TypeA)
+
+			rec := &recorder{}
+			var w dns.ResponseWriter = rec
+			if wire {
+				w = &wireWriter{ResponseWriter: rec}
+			}
+			handler.ServeDNS(w, r)
+			if rec.msg == nil || len(rec.msg.Answer) != 2 {
+				t.Fatalf("%s (wire %v): unexpected response %v", qname, wire, rec.msg)
+			}
+			cname, ok := rec.msg.Answer[0].(*dns.CNAME)
+			if !ok {
+				t.Fatalf("%s (wire %v): first answer %v, want a CNAME", qname, wire, rec.msg.Answer[0])
+			}
+
+			if cname.Hdr.Name != qname {
+				t.Errorf("%s (wire %v): CNAME owned by %s", qname, wire, cname.Hdr.Name)
+			}
+			for _, name := range []string{cname.Target, rec.msg.Answer[1].Header().Name} {
+				if !strings.EqualFold(name, "up.example.com.") || mixedCase(name) {
+					t.Errorf("%s (wire %v): got %s, want up.example.com. in one case per label", qname, wire, name)
+				}
+			}
+		}
+	}
+	if hits := handler.GetStats().CacheHits; hits != 4 {
+		t.Errorf("cache hits = %d, want 4", hits)
+	}
+}
+
+// mixedCase reports whether a label of name mixes upper and lower case.
+func mixedCase(name string) bool {
+	for _, label := range dns.SplitDomainName(name) {
+		if label != strings.ToLower(label) && label != strings.ToUpper(label) {
+			return true
+		}
+	}
+	return false
+}
+
+// chainUpstream answers with a CNAME to a name in mixed case and its
+// address, echoing the case of the question like servers do.
+type chainUpstream struct{}
+
+func (chainUpstream) Resolve(ctx context.Context, question dns.Question) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(question.Name, question.Qtype)
+	msg.Response = true
+	msg.RecursionAvailable = true
+	msg.Answer = []dns.RR{
+		&dns.CNAME{
+			Hdr:    dns.RR_Header{Name: question.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 300},
+			Target: "uP.EXAmPLE.com.",
+		},
+		&dns.A{
+			Hdr: dns.RR_Header{Name: "uP.EXAmPLE.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.IPv4(192, 0, 2, 7),
+		},
+	}
+	return msg, nil
+}
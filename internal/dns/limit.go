@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"sync/atomic"
+
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// limiter bounds the queries answered at once. A burst beyond the bound is
+// refused or dropped straight away rather than piling up goroutines waiting
+// on upstreams until memory runs out.
+type limiter struct {
+	slots      chan struct{}
+	drop       bool
+	inFlight   atomic.Int64
+	overloaded atomic.Uint64
+}
+
+// SetConcurrencyLimit answers at most max queries at once, zero meaning no
+// limit. Queries over it are answered REFUSED, or not at all when overload
+// is drop, which costs less but leaves clients to time out and retry.
+func (h *Handler) SetConcurrencyLimit(max int, overload string) {
+	if max <= 0 {
+		h.limiter.slots = nil
+		return
+	}
+	h.limiter.slots = make(chan struct{}, max)
+	h.limiter.drop = overload == config.OverloadDrop
+}
+
+// acquire takes a slot for a query, reporting false when none is free.
+func (l *limiter) acquire() bool {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			l.overloaded.Add(1)
+			return false
+		}
+	}
+	l.inFlight.Add(1)
+	return true
+}
+
+func (l *limiter) release() {
+	l.inFlight.Add(-1)
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// overload answers a query turned away by the limiter.
+func (h *Handler) overload(w dns.ResponseWriter, r *dns.Msg) {
+	if h.limiter.drop {
+		return
+	}
+	response := &dns.Msg{}
+	response.SetRcode(r, dns.RcodeRefused)
+	response.RecursionAvailable = true
+	w.WriteMsg(response)
+}
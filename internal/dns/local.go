@@ -0,0 +1,61 @@
+package dns
+
+import (
+	"context"
+	"strings"
+
+	"dns-server/internal/cache"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// resolveLocal answers question from the local records. A CNAME of a local
+// zone pointing outside the local records is followed upstream, as a
+// recursive resolver would, so clients get the records they asked for.
+func (h *Handler) resolveLocal(ctx context.Context, question dns.Question) (*dns.Msg, bool) {
+	response, found := h.localResolver.Resolve(question)
+	if found {
+		h.follow(ctx, question, response)
+	}
+	return response, found
+}
+
+// follow resolves upstream the target of the CNAME ending the answer of
+// response, when nothing local has answered for it, and adds its records.
+func (h *Handler) follow(ctx context.Context, question dns.Question, response *dns.Msg) {
+	if question.Qtype == dns.TypeCNAME || len(response.Answer) == 0 || len(response.Ns) > 0 {
+		return
+	}
+	cname, ok := response.Answer[len(response.Answer)-1].(*dns.CNAME)
+	if !ok || answers(response, cname.Target) {
+		return
+	}
+
+	target := dns.Question{Name: cname.Target, Qtype: question.Qtype, Qclass: question.Qclass}
+	resolved, _, err := h.resolveShared(ctx, cache.GenerateCacheKey(target), target, false)
+	if err != nil {
+		h.logger.WithFields(logrus.Fields{
+			"question": question.Name,
+			"target":   cname.Target,
+			"error":    err,
+		}).Debug("failed to follow alias")
+		return
+	}
+
+	response.Answer = append(response.Answer, resolved.Answer...)
+	if resolved.Rcode == dns.RcodeNameError {
+		response.Rcode = dns.RcodeNameError
+	}
+}
+
+// answers reports whether msg already answers for name, as the CNAMEs of a
+// loop do.
+func answers(msg *dns.Msg, name string) bool {
+	for _, rr := range msg.Answer {
+		if strings.EqualFold(rr.Header().Name, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package dns
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"dns-server/internal/cache"
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// RunPrefetch periodically refreshes popular cache entries from upstream
+// before they expire, so clients asking for hot names never wait on an
+// upstream round trip. At most cfg.Workers entries are refreshed at once, so
+// a large set of hot names is spread over time rather than sent upstream in
+// one burst every tick.
+func (h *Handler) RunPrefetch(ctx context.Context, cfg *config.PrefetchConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	var (
+		mu       sync.Mutex
+		inflight = make(map[string]bool)
+		wg       sync.WaitGroup
+		workers  = make(chan struct{}, max(cfg.Workers, 1))
+	)
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, question := range h.cache.PrefetchCandidates(cfg.MinHits, cfg.Threshold) {
+			key := cache.GenerateCacheKey(question)
+
+			mu.Lock()
+			busy := inflight[key]
+			mu.Unlock()
+			if busy {
+				continue
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case workers <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			mu.Lock()
+			inflight[key] = true
+			mu.Unlock()
+
+			wg.Add(1)
+			go func(question dns.Question, key string) {
+				defer func() {
+					mu.Lock()
+					delete(inflight, key)
+					mu.Unlock()
+					<-workers
+					wg.Done()
+				}()
+
+				h.prefetch(ctx, question, key)
+			}(question, key)
+		}
+	}
+}
+
+func (h *Handler) prefetch(ctx context.Context, question dns.Question, key string) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// local answers are cached too and must not be replaced by upstream data
+	if response, found := h.resolveLocal(ctx, question); found {
+		if ttl := h.extractTTL(response); ttl > 0 {
+			h.cache.SetFrom(key, response, ttl, SourceLocal)
+		}
+		return
+	}
+
+	response, origin, err := h.resolveShared(ctx, key, question, false)
+	if err != nil {
+		h.logger.WithFields(logrus.Fields{
+			"question": question.Name,
+			"qtype":    dns.TypeToString[question.Qtype],
+			"error":    err,
+		}).Debug("prefetch failed")
+		return
+	}
+
+	h.cacheUpstream(key, response, origin)
+	h.stats.prefetches.Add(1)
+
+	h.logger.WithFields(logrus.Fields{
+		"question": question.Name,
+		"qtype":    dns.TypeToString[question.Qtype],
+	}).Debug("prefetched cache entry")
+}
@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dns-server/internal/cache"
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// TestRunPrefetchWorkers refreshes many hot entries through a slow upstream
+// and checks no more than the configured workers query it at once.
+func TestRunPrefetchWorkers(t *testing.T) {
+	upstream := &slowUpstream{delay: 20 * time.Millisecond}
+	handler := newTestHandler(loadTestConfig(t, ""), upstream)
+
+	for i := range 20 {
+		question := dns.Question{Name: fmt.Sprintf("hot%d.test.", i), Qtype: dns.TypeA, Qclass: dns.ClassINET}
+		msg, err := validatingUpstream{}.Resolve(context.Background(), question)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.cache.Set(cache.GenerateCacheKey(question), msg, time.Minute)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	handler.RunPrefetch(ctx, &config.PrefetchConfig{Interval: 5 * time.Millisecond, Workers: 2})
+
+	if calls := upstream.calls.Load(); calls < 4 {
+		t.Fatalf("only %d prefetches", calls)
+	}
+	if peak := upstream.peak.Load(); peak > 2 {
+		t.Errorf("%d prefetches at once, want at most 2", peak)
+	}
+}
+
+// slowUpstream answers like validatingUpstream after a delay, counting the
+// queries it is answering at once.
+type slowUpstream struct {
+	delay               time.Duration
+	calls, active, peak atomic.Int64
+}
+
+func (u *slowUpstream) Resolve(ctx context.Context, question dns.Question) (*dns.Msg, error) {
+	u.calls.Add(1)
+	active := u.active.Add(1)
+	defer u.active.Add(-1)
+	for peak := u.peak.Load(); active > peak && !u.peak.CompareAndSwap(peak, active); peak = u.peak.Load() {
+	}
+
+	select {
+	case <-time.After(u.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return validatingUpstream{}.Resolve(ctx, question)
+}
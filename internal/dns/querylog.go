@@ -0,0 +1,109 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"dns-server/internal/history"
+	"dns-server/internal/querylog"
+
+	"github.com/miekg/dns"
+)
+
+// Answer sources recorded in the query log.
+const (
+	SourceCache       = "cache"
+	SourceLocal       = "local"
+	SourceSecondary   = "secondary"
+	SourceUpstream    = "upstream"
+	SourceStale       = "stale"
+	SourceFallback    = "fallback"
+	SourceHook        = "hook"
+	SourceRefused     = "refused"
+	SourceBlocked     = "blocked"
+	SourceSafeSearch  = "safesearch"
+	SourceFailure     = "failure"
+	SourceInvalid     = "invalid"
+	SourceUnsupported = "unsupported"
+	SourceTransfer    = "transfer"
+	SourceNotify      = "notify"
+)
+
+// queryWriter remembers the RCODE of the response and where it came from so
+// the query log can record it once the handler is done.
+type queryWriter struct {
+	dns.ResponseWriter
+	start    time.Time
+	source   string
+	answered bool
+	rcode    int
+}
+
+func (w *queryWriter) WriteMsg(msg *dns.Msg) error {
+	if !w.answered {
+		w.answered = true
+		w.rcode = msg.Rcode
+	}
+	return writeMsg(w.ResponseWriter, msg)
+}
+
+// writeWire sends wire, a packed response, like writeMsg does.
+func (w *queryWriter) writeWire(wire []byte) error {
+	if !w.answered {
+		w.answered = true
+		w.rcode = int(wire[3] & 0x0f)
+	}
+	return writeWire(w.ResponseWriter, wire)
+}
+
+func setSource(w dns.ResponseWriter, source string) {
+	if qw, ok := w.(*queryWriter); ok {
+		qw.source = source
+	}
+}
+
+// SetQueryLog records every answered query in log while it is enabled.
+func (h *Handler) SetQueryLog(log *querylog.Log) {
+	h.queryLog = log
+}
+
+// SetQueryStream hands every answered query to the subscribers of stream.
+func (h *Handler) SetQueryStream(stream *querylog.Stream) {
+	h.queryStream = stream
+}
+
+// SetHistory also hands every answered query to recorder for dashboards.
+func (h *Handler) SetHistory(recorder *history.Recorder) {
+	h.history = recorder
+}
+
+func (h *Handler) logAnswer(w *queryWriter, r *dns.Msg) {
+	if !w.answered || len(r.Question) == 0 {
+		return
+	}
+
+	client := w.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+
+	entry := querylog.Entry{
+		Time:      w.start,
+		Client:    client,
+		Name:      strings.TrimSuffix(r.Question[0].Name, "."),
+		Type:      dns.TypeToString[r.Question[0].Qtype],
+		Rcode:     dns.RcodeToString[w.rcode],
+		LatencyMS: float64(time.Since(w.start).Microseconds()) / 1000,
+		Source:    w.source,
+	}
+	if h.clientNames != nil {
+		entry.ClientName = h.clientNames.Name(client)
+	}
+
+	h.queryLog.Write(entry)
+	h.queryStream.Write(entry)
+	if h.history != nil {
+		h.history.Add(entry)
+	}
+}
@@ -0,0 +1,29 @@
+package dns
+
+import (
+	"context"
+
+	"dns-server/internal/rewrite"
+
+	"github.com/miekg/dns"
+)
+
+func (h *Handler) SetRewriter(rewriter *rewrite.Engine) {
+	h.rewriter = rewriter
+}
+
+// resolveUpstream resolves question upstream with the rewrite rules applied,
+// so everything cached and returned is already rewritten.
+func (h *Handler) resolveUpstream(ctx context.Context, question dns.Question) (*dns.Msg, error) {
+	if h.rewriter == nil {
+		return h.resolver.Resolve(ctx, question)
+	}
+
+	resolved := h.rewriter.Query(question)
+	response, err := h.resolver.Resolve(ctx, resolved)
+	if err != nil {
+		return nil, err
+	}
+	h.rewriter.Response(question, resolved, response)
+	return response, nil
+}
@@ -0,0 +1,77 @@
+package dns
+
+import (
+	"sync/atomic"
+
+	"dns-server/internal/metrics"
+)
+
+type handlerStats struct {
+	queries         atomic.Uint64
+	cacheHits       atomic.Uint64
+	localAnswers    atomic.Uint64
+	zoneAnswers     atomic.Uint64
+	upstreamAnswers atomic.Uint64
+	staleAnswers    atomic.Uint64
+	fallbackAnswers atomic.Uint64
+	hookAnswers     atomic.Uint64
+	blocked         atomic.Uint64
+	failures        atomic.Uint64
+	prefetches      atomic.Uint64
+	coalesced       atomic.Uint64
+}
+
+type Stats struct {
+	Queries         uint64 `json:"queries"`
+	CacheHits       uint64 `json:"cache_hits"`
+	LocalAnswers    uint64 `json:"local_answers"`
+	ZoneAnswers     uint64 `json:"secondary_zone_answers"`
+	UpstreamAnswers uint64 `json:"upstream_answers"`
+	StaleAnswers    uint64 `json:"stale_answers"`
+	FallbackAnswers uint64 `json:"fallback_answers"`
+	HookAnswers     uint64 `json:"hook_answers"`
+	Blocked         uint64 `json:"blocked"`
+	Failures        uint64 `json:"failures"`
+	Prefetches      uint64 `json:"prefetches"`
+	Coalesced       uint64 `json:"coalesced"`
+	InFlight        int64  `json:"in_flight"`
+	Overloaded      uint64 `json:"overloaded"`
+}
+
+func (h *Handler) GetStats() Stats {
+	return Stats{
+		Queries:         h.stats.queries.Load(),
+		CacheHits:       h.stats.cacheHits.Load(),
+		LocalAnswers:    h.stats.localAnswers.Load(),
+		ZoneAnswers:     h.stats.zoneAnswers.Load(),
+		UpstreamAnswers: h.stats.upstreamAnswers.Load(),
+		StaleAnswers:    h.stats.staleAnswers.Load(),
+		FallbackAnswers: h.stats.fallbackAnswers.Load(),
+		HookAnswers:     h.stats.hookAnswers.Load(),
+		Blocked:         h.stats.blocked.Load(),
+		Failures:        h.stats.failures.Load(),
+		Prefetches:      h.stats.prefetches.Load(),
+		Coalesced:       h.stats.coalesced.Load(),
+		InFlight:        h.limiter.inFlight.Load(),
+		Overloaded:      h.limiter.overloaded.Load(),
+	}
+}
+
+func (h *Handler) Collect(w *metrics.Writer) {
+	stats := h.GetStats()
+
+	w.Counter("dns_queries_total", "Queries received.", float64(stats.Queries))
+	w.Counter("dns_responses_total", "Responses by answer source.", float64(stats.CacheHits), metrics.L("source", "cache"))
+	w.Counter("dns_responses_total", "Responses by answer source.", float64(stats.LocalAnswers), metrics.L("source", "local"))
+	w.Counter("dns_responses_total", "Responses by answer source.", float64(stats.ZoneAnswers), metrics.L("source", "secondary"))
+	w.Counter("dns_responses_total", "Responses by answer source.", float64(stats.UpstreamAnswers), metrics.L("source", "upstream"))
+	w.Counter("dns_responses_total", "Responses by answer source.", float64(stats.StaleAnswers), metrics.L("source", "stale"))
+	w.Counter("dns_responses_total", "Responses by answer source.", float64(stats.FallbackAnswers), metrics.L("source", "fallback"))
+	w.Counter("dns_responses_total", "Responses by answer source.", float64(stats.HookAnswers), metrics.L("source", "hook"))
+	w.Counter("dns_responses_total", "Responses by answer source.", float64(stats.Blocked), metrics.L("source", "blocked"))
+	w.Counter("dns_resolution_failures_total", "Queries answered with SERVFAIL after upstream failure.", float64(stats.Failures))
+	w.Counter("dns_prefetches_total", "Cache entries refreshed ahead of expiry.", float64(stats.Prefetches))
+	w.Gauge("dns_queries_in_flight", "Queries being answered.", float64(stats.InFlight))
+	w.Counter("dns_queries_overloaded_total", "Queries refused or dropped over max_concurrent_queries.", float64(stats.Overloaded))
+	w.Counter("dns_upstream_coalesced_total", "Cache misses answered by another query's upstream lookup in flight.", float64(stats.Coalesced))
+}
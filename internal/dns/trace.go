@@ -0,0 +1,36 @@
+package dns
+
+import (
+	"dns-server/internal/trace"
+
+	"github.com/miekg/dns"
+)
+
+// Tracer captures the stages of queries picked through the admin API.
+func (h *Handler) Tracer() *trace.Tracer {
+	return h.tracer
+}
+
+// traceWriter returns w as a queryWriter, which remembers the response and
+// its source for the trace, unless it already is one for the query log.
+func traceWriter(w dns.ResponseWriter, t *trace.Trace) *queryWriter {
+	if qw, ok := w.(*queryWriter); ok {
+		return qw
+	}
+	return &queryWriter{ResponseWriter: w, start: t.Start}
+}
+
+func (h *Handler) finishTrace(t *trace.Trace, w *queryWriter) {
+	rcode := ""
+	if w.answered {
+		rcode = dns.RcodeToString[w.rcode]
+	}
+	h.tracer.Finish(t, rcode, w.source)
+}
+
+func hitOrMiss(found bool) string {
+	if found {
+		return "hit"
+	}
+	return "miss"
+}
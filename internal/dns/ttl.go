@@ -0,0 +1,128 @@
+package dns
+
+import (
+	"strings"
+	"time"
+
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	TTLModeMinimum = "minimum"
+	TTLModeRRset   = "rrset"
+)
+
+// SetTTLMode selects how upstream answers with divergent RRset TTLs are
+// cached. In minimum mode every record is rewritten to the lowest TTL of the
+// response, so clients see one consistent expiry and the whole answer is
+// refreshed together. In rrset mode the upstream TTLs are kept and decay
+// independently: the entry still expires with its shortest answer RRset, but
+// longer-lived records are not understated and authority/additional records
+// are dropped as soon as their own TTL runs out.
+func (h *Handler) SetTTLMode(mode string) {
+	h.ttlMode = mode
+}
+
+// SetTTLPolicy installs per-type TTL clamps for upstream answers, keyed by
+// record type name.
+func (h *Handler) SetTTLPolicy(policies map[string]config.TTLPolicy) {
+	h.ttlPolicy = make(map[uint16]config.TTLPolicy, len(policies))
+	for name, policy := range policies {
+		h.ttlPolicy[dns.StringToType[strings.ToUpper(name)]] = policy
+	}
+}
+
+// cacheUpstream caches msg, an answer source gave, under key.
+func (h *Handler) cacheUpstream(key string, msg *dns.Msg, source string) {
+	var qtype uint16
+	if len(msg.Question) > 0 {
+		qtype = msg.Question[0].Qtype
+	}
+	if h.ttlPolicy[qtype].NoCache {
+		return
+	}
+
+	h.clampTTLs(msg)
+	if h.ttlMode != TTLModeRRset {
+		harmonizeTTLs(msg)
+	}
+
+	ttl := h.extractTTL(msg)
+	if limit := h.ttlPolicy[qtype].Max; limit > 0 {
+		ttl = min(ttl, limit)
+	}
+	if ttl > 0 {
+		h.cache.SetFrom(key, msg, ttl, source)
+	}
+}
+
+// clampTTLs applies the per-type policy to every record, so the TTLs clients
+// see match how long the answer is kept.
+func (h *Handler) clampTTLs(msg *dns.Msg) {
+	if len(h.ttlPolicy) == 0 {
+		return
+	}
+
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			policy, exists := h.ttlPolicy[hdr.Rrtype]
+			if !exists {
+				continue
+			}
+			ttl := time.Duration(hdr.Ttl) * time.Second
+			if policy.Max > 0 {
+				ttl = min(ttl, policy.Max)
+			}
+			ttl = max(ttl, policy.Min)
+			hdr.Ttl = uint32(ttl.Seconds())
+		}
+	}
+}
+
+func harmonizeTTLs(msg *dns.Msg) {
+	sections := [][]dns.RR{msg.Answer, msg.Ns, msg.Extra}
+
+	var minTTL uint32
+	found := false
+	for _, section := range sections {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype != dns.TypeOPT && (!found || hdr.Ttl < minTTL) {
+				minTTL = hdr.Ttl
+				found = true
+			}
+		}
+	}
+
+	for _, section := range sections {
+		for _, rr := range section {
+			if hdr := rr.Header(); hdr.Rrtype != dns.TypeOPT {
+				hdr.Ttl = minTTL
+			}
+		}
+	}
+}
+
+// pruneExpired drops authority and additional records whose decayed TTL
+// reached zero while the answer itself is still live.
+func (h *Handler) pruneExpired(msg *dns.Msg) {
+	if h.ttlMode != TTLModeRRset {
+		return
+	}
+
+	msg.Ns = liveRecords(msg.Ns)
+	msg.Extra = liveRecords(msg.Extra)
+}
+
+func liveRecords(rrs []dns.RR) []dns.RR {
+	live := rrs[:0]
+	for _, rr := range rrs {
+		if hdr := rr.Header(); hdr.Rrtype == dns.TypeOPT || hdr.Ttl > 0 {
+			live = append(live, rr)
+		}
+	}
+	return live
+}
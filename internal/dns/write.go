@@ -0,0 +1,73 @@
+package dns
+
+import (
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// packBufferSize fits nearly every response; larger ones are packed into
+// a buffer of their own.
+const packBufferSize = 4096
+
+var packBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, packBufferSize)
+		return &buf
+	},
+}
+
+// wireWriter marks the writers of the DNS listeners, which send the bytes
+// given to Write as they are. Responses to them are packed into a pooled
+// buffer instead of the one WriteMsg allocates for every response. Other
+// writers, such as DNSCrypt's, which encrypts and truncates what it sends,
+// are always given the message.
+type wireWriter struct {
+	dns.ResponseWriter
+}
+
+// WireHandler serves the queries of the DNS listeners.
+func (h *Handler) WireHandler() dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		h.ServeDNS(&wireWriter{ResponseWriter: w}, r)
+	})
+}
+
+// writeMsg sends msg through w. Signed responses still go through
+// WriteMsg, which holds the TSIG state of the exchange.
+func writeMsg(w dns.ResponseWriter, msg *dns.Msg) error {
+	ww, ok := w.(*wireWriter)
+	if !ok || msg.IsTsig() != nil {
+		return w.WriteMsg(msg)
+	}
+
+	buf := packBuffers.Get().(*[]byte)
+	defer packBuffers.Put(buf)
+
+	packed, err := msg.PackBuffer(*buf)
+	if err != nil {
+		return err
+	}
+	_, err = ww.Write(packed)
+	return err
+}
+
+// wireCapable reports whether w sends packed responses as they are, so
+// writeWire can be used.
+func wireCapable(w dns.ResponseWriter) bool {
+	if qw, ok := w.(*queryWriter); ok {
+		w = qw.ResponseWriter
+	}
+	_, ok := w.(*wireWriter)
+	return ok
+}
+
+// writeWire sends wire, a packed response, through w, which must be
+// wireCapable.
+func writeWire(w dns.ResponseWriter, wire []byte) error {
+	if qw, ok := w.(*queryWriter); ok {
+		return qw.writeWire(wire)
+	}
+	_, err := w.(*wireWriter).Write(wire)
+	return err
+}
@@ -0,0 +1,84 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestWriteMsg(t *testing.T) {
+	msg := writeBenchResponse()
+
+	viaWire := &recorder{}
+	if err := writeMsg(&wireWriter{ResponseWriter: viaWire}, msg); err != nil {
+		t.Fatal(err)
+	}
+	viaMsg := &recorder{}
+	if err := writeMsg(viaMsg, msg); err != nil {
+		t.Fatal(err)
+	}
+	if viaMsg.msg != msg {
+		t.Error("writer without Write support not given the message")
+	}
+	if viaWire.msg == nil || viaWire.msg.String() != msg.String() {
+		t.Errorf("listener writer got\n%v\nwant\n%v", viaWire.msg, msg)
+	}
+
+	signed := msg.Copy()
+	signed.SetTsig("key.", dns.HmacSHA256, 300, 0)
+	tsig := &recorder{}
+	if err := writeMsg(&wireWriter{ResponseWriter: tsig}, signed); err != nil {
+		t.Fatal(err)
+	}
+	if tsig.msg != signed {
+		t.Error("signed response not sent through WriteMsg")
+	}
+}
+
+// BenchmarkWriteMsg compares packing responses into pooled buffers for the
+// listener writers with WriteMsg, which allocates a buffer every time.
+func BenchmarkWriteMsg(b *testing.B) {
+	msg := writeBenchResponse()
+
+	b.Run("pooled", func(b *testing.B) {
+		w := &wireWriter{ResponseWriter: &discard{}}
+		b.ReportAllocs()
+		for b.Loop() {
+			if err := writeMsg(w, msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("WriteMsg", func(b *testing.B) {
+		w := &discard{}
+		b.ReportAllocs()
+		for b.Loop() {
+			if err := writeMsg(w, msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// writeBenchResponse is a typical answer: a CNAME, a few addresses and an
+// OPT record.
+func writeBenchResponse() *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.example.com.", dns.TypeA)
+	msg.Response = true
+	msg.RecursionAvailable = true
+	msg.Compress = true
+	msg.Answer = append(msg.Answer, &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 300},
+		Target: "edge.example.net.",
+	})
+	for i := range 4 {
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "edge.example.net.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, byte(i+1)),
+		})
+	}
+	msg.SetEdns0(1232, false)
+	return msg
+}
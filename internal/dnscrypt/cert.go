@@ -0,0 +1,125 @@
+package dnscrypt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	certMagic       = "DNSC"
+	certSize        = 124
+	clientMagicSize = 8
+	// esVersion is X25519-XSalsa20Poly1305, the construction every
+	// DNSCrypt client supports.
+	esVersion = 1
+)
+
+// cert is a short-term resolver key pair and the certificate that binds
+// its public key to the provider key. Clients select it by its client
+// magic, the first bytes of every query they encrypt for it.
+type cert struct {
+	serial      uint32
+	publicKey   [32]byte
+	secretKey   [32]byte
+	clientMagic [clientMagicSize]byte
+	notBefore   time.Time
+	notAfter    time.Time
+	signed      []byte
+}
+
+// newCert generates a resolver key pair valid from now for ttl and signs
+// its certificate with the provider key.
+func newCert(provider ed25519.PrivateKey, serial uint32, now time.Time, ttl time.Duration) (*cert, error) {
+	c := &cert{serial: serial, notBefore: now, notAfter: now.Add(ttl)}
+	if _, err := rand.Read(c.secretKey[:]); err != nil {
+		return nil, err
+	}
+	public, err := curve25519.X25519(c.secretKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	copy(c.publicKey[:], public)
+	copy(c.clientMagic[:], public)
+
+	// signed part: resolver-pk client-magic serial ts-start ts-end
+	inner := make([]byte, 0, 52)
+	inner = append(inner, c.publicKey[:]...)
+	inner = append(inner, c.clientMagic[:]...)
+	inner = binary.BigEndian.AppendUint32(inner, serial)
+	inner = binary.BigEndian.AppendUint32(inner, uint32(c.notBefore.Unix()))
+	inner = binary.BigEndian.AppendUint32(inner, uint32(c.notAfter.Unix()))
+
+	c.signed = make([]byte, 0, certSize)
+	c.signed = append(c.signed, certMagic...)
+	c.signed = binary.BigEndian.AppendUint16(c.signed, esVersion)
+	c.signed = binary.BigEndian.AppendUint16(c.signed, 0)
+	c.signed = append(c.signed, ed25519.Sign(provider, inner)...)
+	c.signed = append(c.signed, inner...)
+	return c, nil
+}
+
+// txt renders the certificate as a TXT string in presentation format, with
+// every byte outside printable ASCII escaped as \DDD.
+func (c *cert) txt() string {
+	var b strings.Builder
+	for _, ch := range c.signed {
+		switch {
+		case ch == '"' || ch == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case ch < ' ' || ch > '~':
+			fmt.Fprintf(&b, "\\%03d", ch)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+// loadProviderKey reads the provider's Ed25519 key, stored as the hex of
+// its seed, or generates one and writes it to path when it does not exist.
+func loadProviderKey(path string) (ed25519.PrivateKey, bool, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, false, fmt.Errorf("dnscrypt key file %s does not hold a hex Ed25519 seed", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), false, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, false, fmt.Errorf("failed to read dnscrypt key: %w", err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key.Seed())+"\n"), 0o600); err != nil {
+		return nil, false, fmt.Errorf("failed to write dnscrypt key: %w", err)
+	}
+	return key, true, nil
+}
+
+// Stamp is the sdns:// DNS stamp clients such as dnscrypt-proxy are
+// configured with: the address, provider public key and provider name.
+func Stamp(addr string, provider ed25519.PublicKey, providerName string) string {
+	stamp := []byte{0x01}
+	stamp = binary.LittleEndian.AppendUint64(stamp, 0)
+	for _, field := range [][]byte{[]byte(addr), provider, []byte(providerName)} {
+		stamp = append(stamp, byte(len(field)))
+		stamp = append(stamp, field...)
+	}
+	return "sdns://" + base64.RawURLEncoding.EncodeToString(stamp)
+}
@@ -0,0 +1,450 @@
+package dnscrypt
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/handoff"
+	"dns-server/internal/metrics"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	nonceSize      = 24
+	halfNonceSize  = nonceSize / 2
+	publicKeySize  = 32
+	queryHeader    = clientMagicSize + publicKeySize + halfNonceSize
+	resolverMagic  = "r6fnvWj8"
+	responseHeader = len(resolverMagic) + nonceSize
+	// queries and responses are padded to a multiple of this, hiding
+	// their exact length
+	blockSize = 64
+)
+
+// Server answers DNSCrypt v2 queries over UDP and TCP. It decrypts each
+// query with the resolver certificate the client selected, passes it to
+// the DNS handler like any other query, and encrypts the answer for the
+// client. Unencrypted queries are only answered for the certificates
+// themselves, published as TXT records at the provider name.
+type Server struct {
+	providerName string
+	provider     ed25519.PrivateKey
+	ttl          time.Duration
+	handler      dns.Handler
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	allowed      func(net.Addr) bool
+	logger       *logrus.Logger
+
+	mu        sync.RWMutex
+	certs     []*cert
+	serial    uint32
+	conns     []io.Closer
+	closing   bool
+	wg        sync.WaitGroup
+	queries   atomic.Uint64
+	failures  atomic.Uint64
+	rotations atomic.Uint64
+}
+
+// New loads or creates the provider key and issues the first resolver
+// certificate.
+func New(cfg *config.ServerConfig, handler dns.Handler, logger *logrus.Logger) (*Server, error) {
+	provider, created, err := loadProviderKey(cfg.DNSCrypt.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		logger.WithField("key_file", cfg.DNSCrypt.KeyFile).Info("generated dnscrypt provider key")
+	}
+
+	s := &Server{
+		providerName: dns.Fqdn(strings.ToLower(cfg.DNSCrypt.ProviderName)),
+		provider:     provider,
+		ttl:          cfg.DNSCrypt.CertTTL,
+		handler:      handler,
+		readTimeout:  cfg.ReadTimeout,
+		writeTimeout: cfg.WriteTimeout,
+		logger:       logger,
+	}
+	if err := s.rotate(time.Now()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetClients drops queries from addresses allowed rejects before they are
+// decrypted.
+func (s *Server) SetClients(allowed func(net.Addr) bool) {
+	s.allowed = allowed
+}
+
+// Stamp is the sdns:// stamp for reaching this server at addr.
+func (s *Server) Stamp(addr string) string {
+	return Stamp(addr, s.provider.Public().(ed25519.PublicKey), strings.TrimSuffix(s.providerName, "."))
+}
+
+// Run issues a new resolver certificate every certificate lifetime until
+// ctx is canceled. Each one is valid for two lifetimes, so clients holding
+// the previous certificate keep working until they fetch the new one.
+func (s *Server) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.rotate(now); err != nil {
+				s.logger.WithError(err).Warn("failed to rotate dnscrypt certificate")
+			}
+		}
+	}
+}
+
+func (s *Server) rotate(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// serials only need to grow; clients pick the highest one they can use
+	serial := max(uint32(now.Unix()), s.serial+1)
+	c, err := newCert(s.provider, serial, now, 2*s.ttl)
+	if err != nil {
+		return err
+	}
+
+	certs := []*cert{c}
+	for _, old := range s.certs {
+		if old.notAfter.After(now) {
+			certs = append(certs, old)
+		}
+	}
+	s.certs = certs
+	s.serial = serial
+	s.rotations.Add(1)
+
+	s.logger.WithFields(logrus.Fields{
+		"serial":  serial,
+		"expires": c.notAfter.Format(time.RFC3339),
+	}).Info("issued dnscrypt certificate")
+	return nil
+}
+
+// certFor returns the unexpired certificate packet was encrypted for.
+func (s *Server) certFor(packet []byte) *cert {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	for _, c := range s.certs {
+		if string(packet[:clientMagicSize]) == string(c.clientMagic[:]) && now.Before(c.notAfter) {
+			return c
+		}
+	}
+	return nil
+}
+
+// Listen binds addr over network, "udp" or "tcp" with an optional 4 or 6,
+// and serves it until Shutdown.
+func (s *Server) Listen(network, addr string) error {
+	var conn io.Closer
+	if strings.HasPrefix(network, "udp") {
+		pc, err := handoff.ListenPacket(network, addr)
+		if err != nil {
+			return err
+		}
+		conn = pc
+		s.wg.Add(1)
+		go s.serveUDP(pc)
+	} else {
+		listener, err := handoff.Listen(network, addr)
+		if err != nil {
+			return err
+		}
+		conn = listener
+		s.wg.Add(1)
+		go s.serveTCP(listener)
+	}
+
+	s.mu.Lock()
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+
+	s.logger.WithFields(logrus.Fields{
+		"address": addr,
+		"network": network,
+	}).Info("starting DNSCrypt server")
+	return nil
+}
+
+// Shutdown stops accepting queries and waits until ctx is done for those
+// in flight to be answered.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) closed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.closing
+}
+
+func (s *Server) serveUDP(conn net.PacketConn) {
+	defer s.wg.Done()
+
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.closed() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if s.allowed != nil && !s.allowed(addr) {
+			continue
+		}
+
+		packet := append([]byte(nil), buf[:n]...)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			w := &responseWriter{local: conn.LocalAddr(), remote: addr, maxSize: len(packet)}
+			w.send = func(b []byte) error {
+				_, err := conn.WriteTo(b, addr)
+				return err
+			}
+			s.serve(w, packet)
+		}()
+	}
+}
+
+func (s *Server) serveTCP(listener net.Listener) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.closed() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if s.allowed != nil && !s.allowed(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn answers the length-prefixed queries of one connection in turn.
+func (s *Server) serveConn(conn net.Conn) {
+	send := func(b []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		_, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...))
+		return err
+	}
+
+	for !s.closed() {
+		conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		packet := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, packet); err != nil {
+			return
+		}
+		s.serve(&responseWriter{local: conn.LocalAddr(), remote: conn.RemoteAddr(), send: send}, packet)
+	}
+}
+
+// serve answers one packet: a certificate request in the clear, or a query
+// encrypted for one of the certificates.
+func (s *Server) serve(w *responseWriter, packet []byte) {
+	if len(packet) < queryHeader+box.Overhead {
+		s.serveCertificates(w, packet)
+		return
+	}
+	c := s.certFor(packet)
+	if c == nil {
+		s.serveCertificates(w, packet)
+		return
+	}
+
+	var clientKey [publicKeySize]byte
+	copy(clientKey[:], packet[clientMagicSize:])
+	var nonce [nonceSize]byte
+	copy(nonce[:], packet[clientMagicSize+publicKeySize:queryHeader])
+
+	var shared [32]byte
+	box.Precompute(&shared, &clientKey, &c.secretKey)
+	padded, ok := box.OpenAfterPrecomputation(nil, packet[queryHeader:], &nonce, &shared)
+	query, unpadded := unpad(padded)
+	msg := new(dns.Msg)
+	if !ok || !unpadded || msg.Unpack(query) != nil {
+		s.failures.Add(1)
+		return
+	}
+
+	s.queries.Add(1)
+	w.shared = &shared
+	w.nonce = nonce
+	w.encrypted = true
+	s.handler.ServeDNS(w, msg)
+}
+
+// serveCertificates answers a plain TXT query for the provider name with
+// every current certificate. Anything else is not DNSCrypt and is dropped.
+func (s *Server) serveCertificates(w *responseWriter, packet []byte) {
+	query := new(dns.Msg)
+	if query.Unpack(packet) != nil || len(query.Question) != 1 || query.Response {
+		return
+	}
+
+	response := new(dns.Msg)
+	response.SetReply(query)
+	question := query.Question[0]
+	if !strings.EqualFold(question.Name, s.providerName) {
+		response.Rcode = dns.RcodeRefused
+		w.WriteMsg(response)
+		return
+	}
+	response.Authoritative = true
+	if question.Qtype == dns.TypeTXT {
+		s.mu.RLock()
+		for _, c := range s.certs {
+			response.Answer = append(response.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(s.ttl.Seconds())},
+				Txt: []string{c.txt()},
+			})
+		}
+		s.mu.RUnlock()
+	}
+	w.WriteMsg(response)
+}
+
+func (s *Server) Collect(w *metrics.Writer) {
+	w.Counter("dns_dnscrypt_queries_total", "DNSCrypt queries decrypted and answered.", float64(s.queries.Load()))
+	w.Counter("dns_dnscrypt_failures_total", "DNSCrypt queries that failed to decrypt.", float64(s.failures.Load()))
+	w.Counter("dns_dnscrypt_certificates_total", "Resolver certificates issued.", float64(s.rotations.Load()))
+}
+
+// responseWriter sends the handler's answer back the way the query came,
+// encrypted when it was. UDP answers must not be larger than the query, so
+// they are truncated to fit and the client retries over TCP.
+type responseWriter struct {
+	local     net.Addr
+	remote    net.Addr
+	maxSize   int
+	send      func([]byte) error
+	encrypted bool
+	shared    *[32]byte
+	nonce     [nonceSize]byte
+}
+
+func (w *responseWriter) LocalAddr() net.Addr  { return w.local }
+func (w *responseWriter) RemoteAddr() net.Addr { return w.remote }
+
+func (w *responseWriter) WriteMsg(msg *dns.Msg) error {
+	packed, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	if limit := (w.maxSize-responseHeader-box.Overhead)/blockSize*blockSize - 1; w.encrypted && w.maxSize > 0 && len(packed) > limit {
+		// Truncate never cuts below 512 bytes, which can still be more
+		// than a small query leaves room for
+		msg.Truncate(limit)
+		if packed, err = msg.Pack(); err == nil && len(packed) > limit {
+			msg.Answer, msg.Ns, msg.Extra = nil, nil, nil
+			msg.Truncated = true
+			packed, err = msg.Pack()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(packed)
+	return err
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.encrypted {
+		return len(b), w.send(b)
+	}
+
+	nonce := w.nonce
+	if _, err := rand.Read(nonce[halfNonceSize:]); err != nil {
+		return 0, err
+	}
+	packet := make([]byte, 0, responseHeader+len(b)+blockSize+box.Overhead)
+	packet = append(packet, resolverMagic...)
+	packet = append(packet, nonce[:]...)
+	packet = box.SealAfterPrecomputation(packet, pad(b), &nonce, w.shared)
+	return len(b), w.send(packet)
+}
+
+func (w *responseWriter) Network() string     { return w.remote.Network() }
+func (w *responseWriter) Close() error        { return nil }
+func (w *responseWriter) TsigStatus() error   { return nil }
+func (w *responseWriter) TsigTimersOnly(bool) {}
+func (w *responseWriter) Hijack()             {}
+
+// pad applies ISO/IEC 7816-4 padding up to the next multiple of blockSize.
+func pad(b []byte) []byte {
+	padded := make([]byte, (len(b)/blockSize+1)*blockSize)
+	copy(padded, b)
+	padded[len(b)] = 0x80
+	return padded
+}
+
+func unpad(b []byte) ([]byte, bool) {
+	for i := len(b) - 1; i >= 0; i-- {
+		switch b[i] {
+		case 0:
+			continue
+		case 0x80:
+			return b[:i], true
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,208 @@
+package externaldns
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/resolver"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// MediaType is the content type external-dns negotiates with providers.
+const MediaType = "application/external.dns.webhook+json;version=1"
+
+type Endpoint struct {
+	DNSName          string             `json:"dnsName"`
+	Targets          []string           `json:"targets"`
+	RecordType       string             `json:"recordType"`
+	SetIdentifier    string             `json:"setIdentifier,omitempty"`
+	RecordTTL        int64              `json:"recordTTL,omitempty"`
+	Labels           map[string]string  `json:"labels,omitempty"`
+	ProviderSpecific []ProviderProperty `json:"providerSpecific,omitempty"`
+}
+
+type ProviderProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Changes struct {
+	Create    []*Endpoint `json:"Create"`
+	UpdateOld []*Endpoint `json:"UpdateOld"`
+	UpdateNew []*Endpoint `json:"UpdateNew"`
+	Delete    []*Endpoint `json:"Delete"`
+}
+
+type DomainFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// supportedTypes are the record types external-dns manages.
+var supportedTypes = map[string]bool{
+	"A": true, "AAAA": true, "CNAME": true, "TXT": true,
+	"MX": true, "SRV": true, "NS": true, "NAPTR": true,
+}
+
+// Provider implements the provider side of the external-dns webhook API, so
+// records of Kubernetes services and ingresses are managed in the local
+// record set.
+type Provider struct {
+	local   *resolver.LocalResolver
+	domains []string
+	ttl     time.Duration
+	logger  *logrus.Logger
+}
+
+func NewProvider(cfg *config.ExternalDNSConfig, local *resolver.LocalResolver, logger *logrus.Logger) *Provider {
+	domains := make([]string, 0, len(cfg.Domains))
+	for _, domain := range cfg.Domains {
+		domains = append(domains, strings.ToLower(strings.TrimSuffix(domain, ".")))
+	}
+
+	return &Provider{
+		local:   local,
+		domains: domains,
+		ttl:     cfg.TTL,
+		logger:  logger,
+	}
+}
+
+func (p *Provider) DomainFilter() DomainFilter {
+	return DomainFilter{Include: p.domains}
+}
+
+func (p *Provider) managed(name string) bool {
+	if len(p.domains) == 0 {
+		return true
+	}
+	for _, domain := range p.domains {
+		if dns.IsSubDomain(dns.Fqdn(domain), dns.Fqdn(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Records lists the effective local records in the managed domains, one
+// endpoint per name and type.
+func (p *Provider) Records() []*Endpoint {
+	byKey := make(map[string]*Endpoint)
+	var keys []string
+
+	zones := p.domains
+	if len(zones) == 0 {
+		zones = []string{"."}
+	}
+	for _, zone := range zones {
+		for _, rr := range p.local.ZoneRecords(zone) {
+			hdr := rr.Header()
+			recordType := dns.TypeToString[hdr.Rrtype]
+			if !supportedTypes[recordType] {
+				continue
+			}
+
+			name := strings.TrimSuffix(hdr.Name, ".")
+			key := recordType + " " + name
+			endpoint, exists := byKey[key]
+			if !exists {
+				endpoint = &Endpoint{
+					DNSName:    name,
+					RecordType: recordType,
+					RecordTTL:  int64(hdr.Ttl),
+				}
+				byKey[key] = endpoint
+				keys = append(keys, key)
+			}
+			endpoint.Targets = append(endpoint.Targets, target(rr))
+		}
+	}
+
+	sort.Strings(keys)
+	endpoints := make([]*Endpoint, 0, len(keys))
+	for _, key := range keys {
+		endpoints = append(endpoints, byKey[key])
+	}
+	return endpoints
+}
+
+// AdjustEndpoints drops endpoints this provider cannot serve before
+// external-dns plans its changes.
+func (p *Provider) AdjustEndpoints(endpoints []*Endpoint) []*Endpoint {
+	adjusted := make([]*Endpoint, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if !supportedTypes[endpoint.RecordType] || !p.managed(endpoint.DNSName) {
+			continue
+		}
+		adjusted = append(adjusted, endpoint)
+	}
+	return adjusted
+}
+
+// ApplyChanges validates every change first, so a bad endpoint rejects the
+// whole batch and leaves the records untouched.
+func (p *Provider) ApplyChanges(changes *Changes) error {
+	remove, err := p.records(changes.Delete, changes.UpdateOld)
+	if err != nil {
+		return err
+	}
+	add, err := p.records(changes.Create, changes.UpdateNew)
+	if err != nil {
+		return err
+	}
+
+	if err := p.local.UpdateDynamic("external-dns", remove, add); err != nil {
+		return err
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"create": len(changes.Create),
+		"update": len(changes.UpdateNew),
+		"delete": len(changes.Delete),
+	}).Info("applied external-dns changes")
+	return nil
+}
+
+func (p *Provider) records(lists ...[]*Endpoint) ([]dns.RR, error) {
+	var records []dns.RR
+	for _, endpoints := range lists {
+		for _, endpoint := range endpoints {
+			if !supportedTypes[endpoint.RecordType] {
+				return nil, fmt.Errorf("unsupported record type %s for %s", endpoint.RecordType, endpoint.DNSName)
+			}
+			if !p.managed(endpoint.DNSName) {
+				return nil, fmt.Errorf("%s is outside the managed domains", endpoint.DNSName)
+			}
+
+			ttl := uint32(p.ttl.Seconds())
+			if endpoint.RecordTTL > 0 {
+				ttl = uint32(endpoint.RecordTTL)
+			}
+			for _, value := range endpoint.Targets {
+				if endpoint.RecordType == "TXT" {
+					value = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+				}
+				rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", config.CanonicalName(endpoint.DNSName), ttl, endpoint.RecordType, value))
+				if err != nil || rr == nil {
+					return nil, fmt.Errorf("invalid %s target %q for %s: %w", endpoint.RecordType, value, endpoint.DNSName, err)
+				}
+				records = append(records, rr)
+			}
+		}
+	}
+	return records, nil
+}
+
+// target renders rdata the way external-dns writes it: hostnames without the
+// trailing dot and TXT content unquoted.
+func target(rr dns.RR) string {
+	if txt, ok := rr.(*dns.TXT); ok {
+		return strings.Join(txt.Txt, "")
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(rr.String(), rr.Header().String()), ".")
+}
@@ -0,0 +1,141 @@
+package features
+
+import (
+	"slices"
+	"strings"
+
+	"dns-server/internal/config"
+	"dns-server/internal/handoff"
+	"dns-server/internal/resolver"
+)
+
+// Feature is a capability built into the binary and whether the given
+// configuration turns it on.
+type Feature struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+type capability struct {
+	name    string
+	enabled func(cfg *config.Config) bool
+}
+
+var registry = []capability{
+	{"cache", always},
+	{"serve-stale", func(cfg *config.Config) bool { return cfg.Cache.ServeStale }},
+	{"prefetch", func(cfg *config.Config) bool { return cfg.Cache.Prefetch.Enabled }},
+	{"adaptive-cache", func(cfg *config.Config) bool { return cfg.Cache.Adaptive.Enabled }},
+	{"async-cache-writes", func(cfg *config.Config) bool { return cfg.Cache.Writes.Async }},
+	{"ttl-policy", func(cfg *config.Config) bool { return len(cfg.Cache.TTLPolicy) > 0 }},
+	{"multi-listener", func(cfg *config.Config) bool { return len(cfg.Server.Listen) > 1 }},
+	{"dot-server", func(cfg *config.Config) bool {
+		for _, listener := range cfg.Server.Listen {
+			if slices.Contains(listener.Protocols, config.ProtocolTLS) {
+				return true
+			}
+		}
+		return false
+	}},
+	{"dnscrypt", func(cfg *config.Config) bool {
+		for _, listener := range cfg.Server.Listen {
+			if slices.Contains(listener.Protocols, config.ProtocolDNSCrypt) {
+				return true
+			}
+		}
+		return false
+	}},
+	{"listener-acl", func(cfg *config.Config) bool { return len(cfg.Server.AllowFrom)+len(cfg.Server.DenyFrom) > 0 }},
+	{"query-limit", func(cfg *config.Config) bool { return cfg.Server.MaxQueries > 0 }},
+	{"reuse-port", func(cfg *config.Config) bool { return cfg.Server.ReusePort && handoff.ReusePortSupported }},
+	{"cache-acl", func(cfg *config.Config) bool { return len(cfg.Cache.AllowFrom) > 0 }},
+	{"forwarding", func(cfg *config.Config) bool { return cfg.Upstream.Mode != "recursive" }},
+	{"recursion", func(cfg *config.Config) bool { return cfg.Upstream.Mode == "recursive" }},
+	{"qname-minimization", func(cfg *config.Config) bool {
+		return cfg.Upstream.Mode == "recursive" && cfg.Upstream.QNameMinimization != "off"
+	}},
+	{"pipelining", func(cfg *config.Config) bool { return cfg.Upstream.Pipeline }},
+	{"upstream-bootstrap", func(cfg *config.Config) bool {
+		return cfg.Upstream.Mode != "recursive" && len(cfg.Upstream.Bootstrap) > 0
+	}},
+	{"opportunistic-encryption", func(cfg *config.Config) bool {
+		return cfg.Upstream.Mode != "recursive" && cfg.Upstream.Encryption == "opportunistic"
+	}},
+	{"0x20", func(cfg *config.Config) bool { return cfg.Upstream.RandomizeCase }},
+	{"harden-below-nxdomain", func(cfg *config.Config) bool { return cfg.Cache.HardenNXDomain }},
+	{"harden-referral-path", func(cfg *config.Config) bool { return cfg.Upstream.HardenReferrals }},
+	{"health-checks", func(cfg *config.Config) bool { return cfg.Upstream.HealthCheck.Enabled }},
+	{"hooks", func(cfg *config.Config) bool { return len(cfg.Hooks) > 0 }},
+	{"rewrite", func(cfg *config.Config) bool { return len(cfg.Rewrite.Rules) > 0 }},
+	{"query-filter", func(cfg *config.Config) bool { return len(cfg.Filter.Rules) > 0 }},
+	{"safe-search", func(cfg *config.Config) bool { return cfg.Filter.SafeSearch.Enabled }},
+	{"local-records", always},
+	{"record-store", func(cfg *config.Config) bool { return cfg.RecordStore.Backend != "" }},
+	{"hosts-files", func(cfg *config.Config) bool { return len(cfg.Records.HostsFiles) > 0 }},
+	{"profiles", func(cfg *config.Config) bool { return len(cfg.Profiles) > 0 }},
+	{"pools", func(cfg *config.Config) bool { return len(cfg.Pools) > 0 }},
+	{"discovery", func(cfg *config.Config) bool { return cfg.Discovery.Backend != "" }},
+	{"dhcp-leases", func(cfg *config.Config) bool { return cfg.Leases.Path != "" }},
+	{"views", func(cfg *config.Config) bool { return len(cfg.Views) > 0 }},
+	{"sites", func(cfg *config.Config) bool { return len(cfg.Sites) > 0 }},
+	{"fallback", func(cfg *config.Config) bool { return resolver.CountRecords(&cfg.Fallback.Records) > 0 }},
+	{"zone-transfer", func(cfg *config.Config) bool { return cfg.Transfer.Enabled }},
+	{"notify", func(cfg *config.Config) bool { return cfg.Transfer.Enabled && len(cfg.Transfer.Notify) > 0 }},
+	{"secondary", func(cfg *config.Config) bool { return len(cfg.Secondary) > 0 }},
+	{"stub-zones", func(cfg *config.Config) bool { return len(cfg.Stub) > 0 }},
+	{"special-zones", func(cfg *config.Config) bool { return len(config.SpecialZones(cfg)) > 0 }},
+	{"local-zones", func(cfg *config.Config) bool { return len(cfg.LocalZones) > 0 }},
+	{"mdns", func(cfg *config.Config) bool { return cfg.MDNS.Enabled }},
+	{"tsig", func(cfg *config.Config) bool { return len(cfg.Transfer.TSIG) > 0 }},
+	{"nsid", func(cfg *config.Config) bool { return cfg.Server.NSID != "" }},
+	{"chaos-identity", func(cfg *config.Config) bool {
+		if cfg.Server.HideIdentity {
+			return false
+		}
+		return cfg.Server.Version != "none" || cfg.Server.Hostname != "none" || cfg.Server.ServerID != "" || cfg.Server.NSID != ""
+	}},
+	{"admin-api", func(cfg *config.Config) bool { return cfg.Admin.Enabled }},
+	{"metrics", func(cfg *config.Config) bool { return cfg.Admin.Enabled }},
+	{"dashboard", func(cfg *config.Config) bool { return cfg.Admin.Enabled && cfg.Admin.Dashboard }},
+	{"status-page", func(cfg *config.Config) bool { return cfg.Status.Enabled }},
+	{"query-log", func(cfg *config.Config) bool { return cfg.QueryLog.Enabled }},
+	{"query-history", func(cfg *config.Config) bool { return cfg.History.Backend != "" }},
+	{"client-names", func(cfg *config.Config) bool { return cfg.Logging.ClientNames.Enabled }},
+	{"async-logging", func(cfg *config.Config) bool { return cfg.Logging.Async }},
+	{"log-sampling", func(cfg *config.Config) bool { return len(cfg.Logging.Sample) > 0 }},
+	{"otlp-tracing", func(cfg *config.Config) bool { return cfg.Tracing.Enabled }},
+	{"external-dns", func(cfg *config.Config) bool { return cfg.ExternalDNS.Enabled }},
+	{"webhooks", func(cfg *config.Config) bool { return len(cfg.Webhooks) > 0 }},
+	{"pidfile", func(cfg *config.Config) bool { return cfg.Server.PIDFile != "" }},
+	{"doh-client", always},
+	{"dot-client", always},
+	{"pcap-replay", always},
+}
+
+func always(*config.Config) bool { return true }
+
+// Register adds a capability to the registry. It is meant to be called from
+// init functions of optional packages.
+func Register(name string, enabled func(cfg *config.Config) bool) {
+	registry = append(registry, capability{name: name, enabled: enabled})
+}
+
+// Names lists every capability compiled into the binary.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for _, c := range registry {
+		names = append(names, c.name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// List reports every capability and whether cfg enables it.
+func List(cfg *config.Config) []Feature {
+	list := make([]Feature, 0, len(registry))
+	for _, c := range registry {
+		list = append(list, Feature{Name: c.name, Enabled: c.enabled(cfg)})
+	}
+	slices.SortFunc(list, func(a, b Feature) int { return strings.Compare(a.Name, b.Name) })
+	return list
+}
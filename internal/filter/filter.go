@@ -0,0 +1,245 @@
+package filter
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/metrics"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// blockTTL is how long clients may keep the answer to a blocked query.
+const blockTTL = 60
+
+type rule struct {
+	pattern string
+	names   []name
+	regex   *regexp.Regexp
+	block   bool
+	log     bool
+
+	clients     []*net.IPNet
+	days        [7]bool
+	scheduled   bool
+	from, until time.Duration
+}
+
+type name struct {
+	name     string
+	wildcard bool
+}
+
+// Filter blocks and allows queries by name according to the configured
+// rules, the first matching one deciding.
+type Filter struct {
+	rules    []rule
+	response string
+	location *time.Location
+	logger   *logrus.Logger
+
+	safeSearch *safeSearch
+
+	blocked, allowed, restricted atomic.Uint64
+}
+
+func New(cfg *config.FilterConfig, logger *logrus.Logger) (*Filter, error) {
+	location, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("filter timezone: %w", err)
+	}
+	f := &Filter{response: cfg.Response, location: location, logger: logger}
+	for i, r := range cfg.Rules {
+		compiled := rule{
+			pattern: r.Name,
+			block:   r.Action == config.FilterBlock,
+			log:     r.Log,
+		}
+		switch {
+		case r.Regex != "":
+			regex, err := regexp.Compile(r.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("filter rule %d: %w", i+1, err)
+			}
+			compiled.pattern = r.Regex
+			compiled.regex = regex
+		case r.List != "":
+			compiled.pattern = r.List
+			for _, listed := range cfg.Lists[r.List] {
+				compiled.names = append(compiled.names, parseName(listed))
+			}
+		default:
+			compiled.names = []name{parseName(r.Name)}
+		}
+
+		if compiled.clients, err = networks(cfg, r.Clients); err != nil {
+			return nil, err
+		}
+		if compiled.days, err = config.ParseDays(r.Days); err != nil {
+			return nil, fmt.Errorf("filter rule %d: %w", i+1, err)
+		}
+		if r.From != "" {
+			compiled.scheduled = true
+			if compiled.from, err = config.ParseClock(r.From); err != nil {
+				return nil, fmt.Errorf("filter rule %d: %w", i+1, err)
+			}
+			if compiled.until, err = config.ParseClock(r.Until); err != nil {
+				return nil, fmt.Errorf("filter rule %d: %w", i+1, err)
+			}
+		}
+		f.rules = append(f.rules, compiled)
+	}
+
+	if cfg.SafeSearch.Enabled {
+		if f.safeSearch, err = newSafeSearch(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// networks returns the client networks of groups.
+func networks(cfg *config.FilterConfig, groups []string) ([]*net.IPNet, error) {
+	var clients []*net.IPNet
+	for _, group := range groups {
+		for _, client := range cfg.Groups[group] {
+			network, err := config.ParseNetwork(client)
+			if err != nil {
+				return nil, fmt.Errorf("filter group %s: %w", group, err)
+			}
+			clients = append(clients, network)
+		}
+	}
+	return clients, nil
+}
+
+func parseName(s string) name {
+	canonical := config.CanonicalName(s)
+	return name{
+		name:     strings.TrimPrefix(canonical, "*."),
+		wildcard: strings.HasPrefix(canonical, "*."),
+	}
+}
+
+func (r *rule) matches(qname string) bool {
+	if r.regex != nil {
+		return r.regex.MatchString(qname)
+	}
+	for _, n := range r.names {
+		if n.wildcard && strings.HasSuffix(qname, "."+n.name) || !n.wildcard && qname == n.name {
+			return true
+		}
+	}
+	return false
+}
+
+// applies reports whether r is in force for a query from client at now:
+// client is in one of its groups and now falls on one of its days and
+// inside its window. A window past midnight runs into the next day.
+func (r *rule) applies(client net.IP, now time.Time) bool {
+	if len(r.clients) > 0 && !contains(r.clients, client) {
+		return false
+	}
+
+	clock := now.Sub(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+	day := now.Weekday()
+	switch {
+	case !r.scheduled:
+	case r.from < r.until:
+		if clock < r.from || clock >= r.until {
+			return false
+		}
+	case r.from > r.until:
+		if clock < r.until {
+			day = (day + 6) % 7
+		} else if clock < r.from {
+			return false
+		}
+	}
+	return r.days[day]
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns the answer to r from client when a rule in force blocks
+// it, or nil when the query goes on to be answered.
+func (f *Filter) Check(r *dns.Msg, client net.IP) *dns.Msg {
+	question := r.Question[0]
+	qname := config.CanonicalName(question.Name)
+	now := time.Now().In(f.location)
+	for i := range f.rules {
+		rule := &f.rules[i]
+		if !rule.matches(qname) || !rule.applies(client, now) {
+			continue
+		}
+
+		if rule.log {
+			action := config.FilterAllow
+			if rule.block {
+				action = config.FilterBlock
+			}
+			f.logger.WithFields(logrus.Fields{
+				"domain": strings.TrimSuffix(qname, "."),
+				"qtype":  dns.TypeToString[question.Qtype],
+				"client": client.String(),
+				"rule":   rule.pattern,
+				"action": action,
+			}).Info("query filtered")
+		}
+
+		if !rule.block {
+			f.allowed.Add(1)
+			return nil
+		}
+		f.blocked.Add(1)
+		return f.answer(r)
+	}
+	return nil
+}
+
+func (f *Filter) answer(r *dns.Msg) *dns.Msg {
+	response := new(dns.Msg)
+	response.SetReply(r)
+	response.RecursionAvailable = true
+
+	question := r.Question[0]
+	switch f.response {
+	case config.FilterResponseRefused:
+		response.Rcode = dns.RcodeRefused
+	case config.FilterResponseNull:
+		hdr := dns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: dns.ClassINET, Ttl: blockTTL}
+		switch question.Qtype {
+		case dns.TypeA:
+			response.Answer = []dns.RR{&dns.A{Hdr: hdr, A: net.IPv4zero}}
+		case dns.TypeAAAA:
+			response.Answer = []dns.RR{&dns.AAAA{Hdr: hdr, AAAA: net.IPv6zero}}
+		}
+	default:
+		response.Rcode = dns.RcodeNameError
+	}
+	return response
+}
+
+func (f *Filter) Collect(w *metrics.Writer) {
+	w.Counter("dns_filter_queries_total", "Queries decided by a filter rule.", float64(f.blocked.Load()), metrics.L("action", config.FilterBlock))
+	w.Counter("dns_filter_queries_total", "Queries decided by a filter rule.", float64(f.allowed.Load()), metrics.L("action", config.FilterAllow))
+	if f.safeSearch != nil {
+		w.Counter("dns_safe_search_total", "Queries sent to a search engine's restricted name.", float64(f.restricted.Load()))
+	}
+}
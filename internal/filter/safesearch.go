@@ -0,0 +1,109 @@
+package filter
+
+import (
+	"net"
+	"regexp"
+
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// safeSearchTTL is how long clients may keep the CNAME to a restricted name.
+const safeSearchTTL = 300
+
+// googleSearch matches Google's search domains in every country, such as
+// google.com, www.google.de and google.co.uk.
+var googleSearch = regexp.MustCompile(`^(www\.)?google\.(com?\.)?[a-z]{2,3}\.$`)
+
+// safeSearchNames maps the names each engine serves search from to the
+// name that serves its restricted results.
+var safeSearchNames = map[string]map[string]string{
+	config.SafeSearchBing: {
+		"bing.com.":     "strict.bing.com.",
+		"www.bing.com.": "strict.bing.com.",
+	},
+	config.SafeSearchDuckDuckGo: {
+		"duckduckgo.com.":       "safe.duckduckgo.com.",
+		"www.duckduckgo.com.":   "safe.duckduckgo.com.",
+		"start.duckduckgo.com.": "safe.duckduckgo.com.",
+	},
+}
+
+var youTubeNames = []string{
+	"www.youtube.com.",
+	"m.youtube.com.",
+	"youtubei.googleapis.com.",
+	"youtube.googleapis.com.",
+	"www.youtube-nocookie.com.",
+}
+
+type safeSearch struct {
+	names   map[string]string
+	google  bool
+	clients []*net.IPNet
+}
+
+func newSafeSearch(cfg *config.FilterConfig) (*safeSearch, error) {
+	engines := cfg.SafeSearch.Engines
+	if len(engines) == 0 {
+		engines = []string{config.SafeSearchGoogle, config.SafeSearchBing, config.SafeSearchDuckDuckGo, config.SafeSearchYouTube}
+	}
+
+	s := &safeSearch{names: make(map[string]string)}
+	for _, engine := range engines {
+		switch engine {
+		case config.SafeSearchGoogle:
+			s.google = true
+		case config.SafeSearchYouTube:
+			target := "restrict.youtube.com."
+			if cfg.SafeSearch.YouTube == config.YouTubeModerate {
+				target = "restrictmoderate.youtube.com."
+			}
+			for _, name := range youTubeNames {
+				s.names[name] = target
+			}
+		default:
+			for name, target := range safeSearchNames[engine] {
+				s.names[name] = target
+			}
+		}
+	}
+
+	var err error
+	if s.clients, err = networks(cfg, cfg.SafeSearch.Clients); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *safeSearch) target(name string) string {
+	if target, found := s.names[name]; found {
+		return target
+	}
+	if s.google && googleSearch.MatchString(name) {
+		return "forcesafesearch.google.com."
+	}
+	return ""
+}
+
+// SafeSearch returns the CNAME that sends question from client to a search
+// engine's restricted name, or nil when safe search does not apply to it.
+func (f *Filter) SafeSearch(question dns.Question, client net.IP) dns.RR {
+	if f.safeSearch == nil {
+		return nil
+	}
+	if len(f.safeSearch.clients) > 0 && !contains(f.safeSearch.clients, client) {
+		return nil
+	}
+	target := f.safeSearch.target(config.CanonicalName(question.Name))
+	if target == "" {
+		return nil
+	}
+
+	f.restricted.Add(1)
+	return &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: question.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: safeSearchTTL},
+		Target: target,
+	}
+}
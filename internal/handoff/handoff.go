@@ -0,0 +1,190 @@
+package handoff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// socketsEnv names the sockets a previous process handed down, comma
+// separated in the order of their file descriptors from 3 on.
+const socketsEnv = "HANDOFF_SOCKETS"
+
+// readyName is the pipe a handed-down process writes to once it serves.
+const readyName = "ready"
+
+type socket interface {
+	File() (*os.File, error)
+}
+
+var (
+	mu        sync.Mutex
+	inherited map[string]*os.File
+	sockets   = make(map[string]socket)
+	loadOnce  sync.Once
+)
+
+func load() {
+	loadOnce.Do(func() {
+		inherited = make(map[string]*os.File)
+		value := os.Getenv(socketsEnv)
+		if value == "" {
+			return
+		}
+		os.Unsetenv(socketsEnv)
+		for i, name := range strings.Split(value, ",") {
+			inherited[name] = os.NewFile(uintptr(3+i), name)
+		}
+	})
+}
+
+// Inherited reports whether this process was started by Upgrade.
+func Inherited() bool {
+	load()
+	mu.Lock()
+	defer mu.Unlock()
+	return len(inherited) > 0
+}
+
+// Listen is net.Listen that takes over the listener a previous process
+// handed down for the same address, and remembers the listener so Upgrade
+// can pass it on.
+func Listen(network, addr string) (net.Listener, error) {
+	name := network + "://" + addr
+	var (
+		listener net.Listener
+		err      error
+	)
+	if file, found := take(name); found {
+		listener, err = net.FileListener(file)
+		file.Close()
+	} else {
+		listener, err = net.Listen(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	register(name, listener)
+	return listener, nil
+}
+
+// ListenPacket is net.ListenPacket with the same handoff as Listen.
+func ListenPacket(network, addr string) (net.PacketConn, error) {
+	name := network + "://" + addr
+	var (
+		conn net.PacketConn
+		err  error
+	)
+	if file, found := take(name); found {
+		conn, err = net.FilePacketConn(file)
+		file.Close()
+	} else {
+		conn, err = net.ListenPacket(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	register(name, conn)
+	return conn, nil
+}
+
+// ListenPacketReusePort opens one of several sockets bound to addr with
+// SO_REUSEPORT, so the kernel spreads packets over them. Every call adds
+// the next socket; they are handed down and taken over in that order.
+func ListenPacketReusePort(network, addr string) (net.PacketConn, error) {
+	mu.Lock()
+	index := 0
+	for {
+		if _, exists := sockets[fmt.Sprintf("%s://%s#%d", network, addr, index)]; !exists {
+			break
+		}
+		index++
+	}
+	mu.Unlock()
+	name := fmt.Sprintf("%s://%s#%d", network, addr, index)
+
+	var (
+		conn net.PacketConn
+		err  error
+	)
+	if file, found := take(name); found {
+		conn, err = net.FilePacketConn(file)
+		file.Close()
+	} else {
+		lc := net.ListenConfig{Control: reusePort}
+		conn, err = lc.ListenPacket(context.Background(), network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	register(name, conn)
+	return conn, nil
+}
+
+// Ready tells the process that handed its sockets down that this one
+// serves, so it can shut down, and closes handed-down sockets nothing took
+// over. It does nothing in a process that was not started by Upgrade.
+func Ready() error {
+	load()
+	mu.Lock()
+	defer mu.Unlock()
+
+	var errs []error
+	for name, file := range inherited {
+		if name == readyName {
+			_, err := file.Write([]byte{1})
+			errs = append(errs, err)
+		}
+		errs = append(errs, file.Close())
+		delete(inherited, name)
+	}
+	return errors.Join(errs...)
+}
+
+func register(name string, conn any) {
+	if s, ok := conn.(socket); ok {
+		mu.Lock()
+		sockets[name] = s
+		mu.Unlock()
+	}
+}
+
+func take(name string) (*os.File, bool) {
+	load()
+	mu.Lock()
+	defer mu.Unlock()
+
+	file, found := inherited[name]
+	delete(inherited, name)
+	return file, found
+}
+
+// files duplicates every open socket for a new process, sorted by name.
+func files() ([]string, []*os.File, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	names := make([]string, 0, len(sockets))
+	for name := range sockets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]*os.File, 0, len(names))
+	for _, name := range names {
+		file, err := sockets[name].File()
+		if err != nil {
+			for _, f := range out {
+				f.Close()
+			}
+			return nil, nil, fmt.Errorf("failed to hand down %s: %w", name, err)
+		}
+		out = append(out, file)
+	}
+	return names, out, nil
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package handoff
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ReusePortSupported reports whether ListenPacketReusePort spreads packets
+// over its sockets, which Linux does by hashing the client address.
+const ReusePortSupported = true
+
+func reusePort(network, address string, conn syscall.RawConn) error {
+	var sockErr error
+	err := conn.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
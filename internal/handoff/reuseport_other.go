@@ -0,0 +1,17 @@
+//go:build !linux
+
+package handoff
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ReusePortSupported reports whether ListenPacketReusePort spreads packets
+// over its sockets. Elsewhere SO_REUSEPORT either does not exist or hands
+// every packet to the last socket bound.
+const ReusePortSupported = false
+
+func reusePort(network, address string, conn syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT load balancing is not supported on this platform")
+}
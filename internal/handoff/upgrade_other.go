@@ -0,0 +1,13 @@
+//go:build !unix
+
+package handoff
+
+import (
+	"errors"
+	"time"
+)
+
+// Without inheritable file descriptors there is nothing to hand down.
+func Upgrade(timeout time.Duration) (int, error) {
+	return 0, errors.New("upgrades are not supported on this platform")
+}
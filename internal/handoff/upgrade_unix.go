@@ -0,0 +1,86 @@
+//go:build unix
+
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Upgrade starts the binary this process runs from again, with the same
+// arguments, hands it every socket opened through Listen and ListenPacket
+// and waits up to timeout for it to call Ready. Both processes then serve
+// on the same sockets until the caller shuts this one down. On error the
+// new process is gone and this one keeps serving.
+func Upgrade(timeout time.Duration) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find executable: %w", err)
+	}
+
+	names, extra, err := files()
+	if err != nil {
+		return 0, err
+	}
+	ready, notify, err := os.Pipe()
+	if err != nil {
+		closeAll(extra)
+		return 0, err
+	}
+	defer ready.Close()
+	extra = append(extra, notify)
+	names = append(names, readyName)
+
+	env := []string{socketsEnv + "=" + strings.Join(names, ",")}
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, socketsEnv+"=") {
+			env = append(env, variable)
+		}
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = env
+	cmd.ExtraFiles = extra
+	err = cmd.Start()
+	// passing a socket puts it in blocking mode, which is shared with the
+	// copies this process still serves on: reads would then block past
+	// their deadlines and hang shutdown
+	for _, file := range extra[:len(extra)-1] {
+		syscall.SetNonblock(int(file.Fd()), true)
+	}
+	closeAll(extra)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start %s: %w", executable, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+		if err == nil {
+			return cmd.Process.Pid, nil
+		}
+		err = errors.New("new process exited before it was ready")
+	case <-time.After(timeout):
+		err = fmt.Errorf("new process was not ready within %s", timeout)
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+	return 0, err
+}
+
+func closeAll(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
@@ -0,0 +1,238 @@
+package health
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Checker probes answer targets and tracks whether each one is healthy.
+// Targets start healthy, so a pool answers normally before its first
+// probes complete.
+type Checker struct {
+	mu      sync.RWMutex
+	targets map[string]*target
+	logger  *logrus.Logger
+}
+
+type target struct {
+	key     string
+	pool    string
+	address string
+	check   config.TargetCheckConfig
+
+	healthy              bool
+	probing              bool
+	consecutiveFailures  int
+	consecutiveSuccesses int
+	nextCheck            time.Time
+	lastCheck            time.Time
+	lastError            string
+	checks               uint64
+	failures             uint64
+}
+
+type TargetStatus struct {
+	Pool                string    `json:"pool"`
+	Address             string    `json:"address"`
+	Check               string    `json:"check"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastCheck           time.Time `json:"last_check,omitzero"`
+	LastError           string    `json:"last_error,omitempty"`
+	Checks              uint64    `json:"checks"`
+	Failures            uint64    `json:"failures"`
+}
+
+func NewChecker(logger *logrus.Logger) *Checker {
+	return &Checker{
+		targets: make(map[string]*target),
+		logger:  logger,
+	}
+}
+
+// Key names the probe of address for pool.
+func Key(pool, address string) string {
+	return pool + "|" + address
+}
+
+// SetPools replaces the probed targets with the addresses of pools. Targets
+// that stay keep their health state.
+func (c *Checker) SetPools(pools map[string]config.PoolConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	targets := make(map[string]*target)
+	for name, pool := range pools {
+		for _, address := range append(append([]string(nil), pool.Addresses...), pool.Failover...) {
+			key := Key(name, address)
+			if existing, ok := c.targets[key]; ok && existing.check == pool.Check {
+				targets[key] = existing
+				continue
+			}
+			targets[key] = &target{
+				key:     key,
+				pool:    name,
+				address: address,
+				check:   pool.Check,
+				healthy: true,
+			}
+		}
+	}
+	c.targets = targets
+}
+
+// Healthy reports whether the target under key passes its checks. Unknown
+// targets count as healthy.
+func (c *Checker) Healthy(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	t, ok := c.targets[key]
+	return !ok || t.healthy
+}
+
+// Run probes every target once per its check interval until ctx is
+// canceled.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		now := time.Now()
+		for _, t := range c.due(now) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.probe(ctx, t)
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// due marks the targets whose next check has come as being probed.
+func (c *Checker) due(now time.Time) []*target {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var due []*target
+	for _, t := range c.targets {
+		if t.probing || now.Before(t.nextCheck) {
+			continue
+		}
+		t.probing = true
+		t.nextCheck = now.Add(t.check.Interval)
+		due = append(due, t)
+	}
+	return due
+}
+
+func (c *Checker) probe(ctx context.Context, t *target) {
+	probeCtx, cancel := context.WithTimeout(ctx, t.check.Timeout)
+	err := probe(probeCtx, t.address, &t.check)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t.probing = false
+	t.checks++
+	t.lastCheck = time.Now()
+	if err != nil {
+		t.failures++
+		t.lastError = err.Error()
+		t.consecutiveSuccesses = 0
+		t.consecutiveFailures++
+		if t.healthy && t.consecutiveFailures >= t.check.FailureThreshold {
+			t.healthy = false
+			c.logger.WithFields(logrus.Fields{
+				"pool":    t.pool,
+				"address": t.address,
+				"error":   err,
+			}).Warn("answer target marked unhealthy")
+		}
+		return
+	}
+
+	t.lastError = ""
+	t.consecutiveFailures = 0
+	t.consecutiveSuccesses++
+	if !t.healthy && t.consecutiveSuccesses >= t.check.RecoveryThreshold {
+		t.healthy = true
+		c.logger.WithFields(logrus.Fields{
+			"pool":    t.pool,
+			"address": t.address,
+		}).Info("answer target recovered")
+	}
+}
+
+func (c *Checker) Status() []TargetStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	statuses := make([]TargetStatus, 0, len(c.targets))
+	for _, t := range c.targets {
+		statuses = append(statuses, TargetStatus{
+			Pool:                t.pool,
+			Address:             t.address,
+			Check:               describe(t.address, &t.check),
+			Healthy:             t.healthy,
+			ConsecutiveFailures: t.consecutiveFailures,
+			LastCheck:           t.lastCheck,
+			LastError:           t.lastError,
+			Checks:              t.checks,
+			Failures:            t.failures,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Pool != statuses[j].Pool {
+			return statuses[i].Pool < statuses[j].Pool
+		}
+		return statuses[i].Address < statuses[j].Address
+	})
+	return statuses
+}
+
+func (c *Checker) Collect(w *metrics.Writer) {
+	for _, status := range c.Status() {
+		labels := []metrics.Label{metrics.L("pool", status.Pool), metrics.L("address", status.Address)}
+		up := 0.0
+		if status.Healthy {
+			up = 1
+		}
+		w.Gauge("dns_target_up", "Whether an answer target passes its health check.", up, labels...)
+		w.Counter("dns_target_checks_total", "Health checks of answer targets.", float64(status.Checks), labels...)
+		w.Counter("dns_target_check_failures_total", "Failed health checks of answer targets.", float64(status.Failures), labels...)
+	}
+}
+
+func describe(address string, check *config.TargetCheckConfig) string {
+	switch check.Protocol {
+	case config.CheckICMP:
+		return "icmp://" + address
+	case config.CheckHTTP, config.CheckHTTPS:
+		return check.Protocol + "://" + net.JoinHostPort(address, strconv.Itoa(check.Port)) + check.Path
+	default:
+		return "tcp://" + net.JoinHostPort(address, strconv.Itoa(check.Port))
+	}
+}
@@ -0,0 +1,131 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"dns-server/internal/config"
+)
+
+// probeClient does not follow redirects: a 3xx already shows the target
+// serves.
+var probeClient = &http.Client{
+	Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func probe(ctx context.Context, address string, check *config.TargetCheckConfig) error {
+	switch check.Protocol {
+	case config.CheckICMP:
+		return probeICMP(ctx, address)
+	case config.CheckHTTP, config.CheckHTTPS:
+		return probeHTTP(ctx, address, check)
+	default:
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, strconv.Itoa(check.Port)))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// probeHTTP checks the target itself rather than whatever the name resolves
+// to; the certificate is not verified since it names the service, not the
+// address.
+func probeHTTP(ctx context.Context, address string, check *config.TargetCheckConfig) error {
+	url := check.Protocol + "://" + net.JoinHostPort(address, strconv.Itoa(check.Port)) + check.Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "dns-server health check")
+
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// probeICMP sends one echo request over a raw socket and waits for the
+// matching reply.
+func probeICMP(ctx context.Context, address string) error {
+	ip := net.ParseIP(address)
+	network, request, reply := "ip4:icmp", byte(8), byte(0)
+	if ip.To4() == nil {
+		network, request, reply = "ip6:ipv6-icmp", 128, 129
+	}
+
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+	}
+
+	id, seq := uint16(os.Getpid()), uint16(time.Now().UnixNano())
+	msg := make([]byte, 16)
+	msg[0] = request
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	copy(msg[8:], "dns-hc..")
+	if request == 8 {
+		// the kernel fills in the ICMPv6 checksum
+		binary.BigEndian.PutUint16(msg[2:], checksum(msg))
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		packet := buf[:n]
+		// IPv4 raw sockets return the IP header too
+		if request == 8 && n > 20 && packet[0]>>4 == 4 {
+			packet = packet[int(packet[0]&0x0f)*4:]
+		}
+		if len(packet) >= 8 && packet[0] == reply &&
+			binary.BigEndian.Uint16(packet[4:]) == id && binary.BigEndian.Uint16(packet[6:]) == seq {
+			return nil
+		}
+	}
+}
+
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,178 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"dns-server/internal/querylog"
+)
+
+const clickHouseTime = "2006-01-02 15:04:05.000"
+
+// ClickHouseStore writes history to ClickHouse over its HTTP interface.
+// Retention is enforced by a table TTL, so Prune does nothing.
+type ClickHouseStore struct {
+	endpoint string
+	table    string
+	client   *http.Client
+}
+
+type clickHouseRow struct {
+	Time       string  `json:"time"`
+	Client     string  `json:"client"`
+	ClientName string  `json:"client_name"`
+	Name       string  `json:"qname"`
+	Type       string  `json:"qtype"`
+	Rcode      string  `json:"rcode"`
+	LatencyMS  float64 `json:"latency_ms"`
+	Source     string  `json:"source"`
+}
+
+func NewClickHouseStore(endpoint, table string, retention time.Duration) (*ClickHouseStore, error) {
+	s := &ClickHouseStore{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		table:    table,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+
+	schema := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		time DateTime64(3, 'UTC'),
+		client String,
+		client_name String,
+		qname String,
+		qtype LowCardinality(String),
+		rcode LowCardinality(String),
+		latency_ms Float64,
+		source LowCardinality(String)
+	) ENGINE = MergeTree ORDER BY time`, table)
+	if retention > 0 {
+		schema += fmt.Sprintf(" TTL toDateTime(time) + INTERVAL %d SECOND", int64(retention.Seconds()))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := s.exec(ctx, nil, schema, nil); err != nil {
+		return nil, fmt.Errorf("failed to create history table: %w", err)
+	}
+	return s, nil
+}
+
+func (s *ClickHouseStore) Append(ctx context.Context, entries []querylog.Entry) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, e := range entries {
+		encoder.Encode(clickHouseRow{
+			Time:       e.Time.UTC().Format(clickHouseTime),
+			Client:     e.Client,
+			ClientName: e.ClientName,
+			Name:       e.Name,
+			Type:       e.Type,
+			Rcode:      e.Rcode,
+			LatencyMS:  e.LatencyMS,
+			Source:     e.Source,
+		})
+	}
+
+	_, err := s.exec(ctx, &body, fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", s.table), nil)
+	return err
+}
+
+func (s *ClickHouseStore) Query(ctx context.Context, filter Filter) ([]querylog.Entry, error) {
+	var where []string
+	params := url.Values{}
+	if !filter.Since.IsZero() {
+		where = append(where, "time >= {since:DateTime64(3, 'UTC')}")
+		params.Set("param_since", filter.Since.UTC().Format(clickHouseTime))
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "time <= {until:DateTime64(3, 'UTC')}")
+		params.Set("param_until", filter.Until.UTC().Format(clickHouseTime))
+	}
+	if filter.Client != "" {
+		where = append(where, "client = {client:String}")
+		params.Set("param_client", filter.Client)
+	}
+	if filter.Name != "" {
+		where = append(where, "qname = {qname:String}")
+		params.Set("param_qname", filter.Name)
+	}
+
+	query := "SELECT * FROM " + s.table
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY time DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+	query += " FORMAT JSONEachRow"
+
+	body, err := s.exec(ctx, nil, query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []querylog.Entry
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		var row clickHouseRow
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			return nil, fmt.Errorf("unexpected history row: %w", err)
+		}
+		t, _ := time.Parse(clickHouseTime, row.Time)
+		result = append(result, querylog.Entry{
+			Time:       t,
+			Client:     row.Client,
+			ClientName: row.ClientName,
+			Name:       row.Name,
+			Type:       row.Type,
+			Rcode:      row.Rcode,
+			LatencyMS:  row.LatencyMS,
+			Source:     row.Source,
+		})
+	}
+	return result, scanner.Err()
+}
+
+func (s *ClickHouseStore) Prune(context.Context, time.Time) error {
+	return nil
+}
+
+func (s *ClickHouseStore) Close() error {
+	return nil
+}
+
+// exec runs query, with body as the data for INSERT statements.
+func (s *ClickHouseStore) exec(ctx context.Context, body io.Reader, query string, params url.Values) ([]byte, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("query", query)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+"/?"+params.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("clickhouse returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
@@ -0,0 +1,173 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/metrics"
+	"dns-server/internal/querylog"
+	"dns-server/pkg/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	BackendMemory     = "memory"
+	BackendSQLite     = "sqlite"
+	BackendClickHouse = "clickhouse"
+)
+
+// Filter selects entries from a store. Zero fields match everything; results
+// are newest first and capped at Limit.
+type Filter struct {
+	Since  time.Time
+	Until  time.Time
+	Client string
+	Name   string
+	Limit  int
+}
+
+// QueryHistoryStore keeps answered queries for dashboards. Implementations
+// receive entries in batches from a Recorder.
+type QueryHistoryStore interface {
+	Append(ctx context.Context, entries []querylog.Entry) error
+	Query(ctx context.Context, filter Filter) ([]querylog.Entry, error)
+	// Prune drops entries older than before. Stores that expire entries by
+	// themselves may ignore it.
+	Prune(ctx context.Context, before time.Time) error
+	Close() error
+}
+
+// Open returns the store selected by cfg.Backend.
+func Open(cfg *config.HistoryConfig) (QueryHistoryStore, error) {
+	switch cfg.Backend {
+	case BackendMemory:
+		return NewMemoryStore(cfg.Size), nil
+	case BackendSQLite:
+		return NewSQLiteStore(cfg.Path)
+	case BackendClickHouse:
+		return NewClickHouseStore(cfg.URL, cfg.Table, cfg.Retention)
+	default:
+		return nil, fmt.Errorf("unknown history backend: %s", cfg.Backend)
+	}
+}
+
+// Recorder buffers entries from the query path and hands them to the store
+// in batches, so a slow backend never delays answers. Entries are dropped
+// when the buffer is full. Names under the logging exclude_domains are never
+// stored.
+type Recorder struct {
+	store         QueryHistoryStore
+	entries       chan querylog.Entry
+	batchSize     int
+	flushInterval time.Duration
+	retention     time.Duration
+	filter        *logger.PrivacyFilter
+	logger        *logrus.Logger
+
+	dropped atomic.Uint64
+}
+
+func NewRecorder(store QueryHistoryStore, cfg *config.HistoryConfig, filter *logger.PrivacyFilter, logger *logrus.Logger) *Recorder {
+	return &Recorder{
+		store:         store,
+		entries:       make(chan querylog.Entry, cfg.BatchSize*4),
+		batchSize:     cfg.BatchSize,
+		flushInterval: cfg.FlushInterval,
+		retention:     cfg.Retention,
+		filter:        filter,
+		logger:        logger,
+	}
+}
+
+func (r *Recorder) Add(entry querylog.Entry) {
+	if r.filter.Suppressed(entry.Name) {
+		return
+	}
+	select {
+	case r.entries <- entry:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many entries were lost because the buffer was full.
+func (r *Recorder) Dropped() uint64 {
+	return r.dropped.Load()
+}
+
+func (r *Recorder) Collect(w *metrics.Writer) {
+	w.Counter("dns_history_dropped_total", "Query history entries dropped because the buffer was full.", float64(r.dropped.Load()))
+}
+
+func (r *Recorder) Query(ctx context.Context, filter Filter) ([]querylog.Entry, error) {
+	return r.store.Query(ctx, filter)
+}
+
+func (r *Recorder) Run(ctx context.Context) {
+	flush := time.NewTicker(r.flushInterval)
+	defer flush.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	batch := make([]querylog.Entry, 0, r.batchSize)
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		// ctx is done for the last batch, written just before the store is
+		// closed, so writes get a deadline of their own
+		writeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		if err := r.store.Append(writeCtx, batch); err != nil {
+			r.logger.WithFields(logrus.Fields{
+				"entries": len(batch),
+				"error":   err,
+			}).Warn("failed to store query history")
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			write()
+			if err := r.store.Close(); err != nil {
+				r.logger.WithError(err).Warn("failed to close query history")
+			}
+			return
+		case entry := <-r.entries:
+			batch = append(batch, entry)
+			if len(batch) >= r.batchSize {
+				write()
+			}
+		case <-flush.C:
+			write()
+		case <-prune.C:
+			if r.retention > 0 {
+				if err := r.store.Prune(ctx, time.Now().Add(-r.retention)); err != nil {
+					r.logger.WithError(err).Warn("failed to prune query history")
+				}
+			}
+		}
+	}
+}
+
+func (f Filter) matches(entry querylog.Entry) bool {
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	if f.Client != "" && entry.Client != f.Client {
+		return false
+	}
+	if f.Name != "" && entry.Name != f.Name {
+		return false
+	}
+	return true
+}
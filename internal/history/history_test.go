@@ -0,0 +1,46 @@
+package history
+
+import (
+	"testing"
+
+	"dns-server/internal/config"
+	"dns-server/internal/querylog"
+	"dns-server/pkg/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRecorderAddSkipsExcludedNames(t *testing.T) {
+	cfg := &config.HistoryConfig{BatchSize: 4}
+	r := NewRecorder(NewMemoryStore(16), cfg, logger.NewPrivacyFilter([]string{"private.example"}), logrus.New())
+
+	tests := []struct {
+		name   string
+		stored bool
+	}{
+		{"example.com", true},
+		{"private.example", false},
+		{"host.private.example", false},
+		{"HOST.Private.Example", false},
+		{"notprivate.example", true},
+	}
+	for _, tt := range tests {
+		before := len(r.entries)
+		r.Add(querylog.Entry{Name: tt.name})
+		if stored := len(r.entries) > before; stored != tt.stored {
+			t.Errorf("Add(%q) stored = %v, want %v", tt.name, stored, tt.stored)
+		}
+	}
+}
+
+func TestRecorderAddCountsDrops(t *testing.T) {
+	cfg := &config.HistoryConfig{BatchSize: 1}
+	r := NewRecorder(NewMemoryStore(16), cfg, nil, logrus.New())
+
+	for range cap(r.entries) + 3 {
+		r.Add(querylog.Entry{Name: "example.com"})
+	}
+	if got := r.Dropped(); got != 3 {
+		t.Errorf("Dropped() = %d, want 3", got)
+	}
+}
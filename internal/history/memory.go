@@ -0,0 +1,67 @@
+package history
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"dns-server/internal/querylog"
+)
+
+// MemoryStore keeps the most recent entries in a fixed-size ring.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries []querylog.Entry
+	next    int
+	full    bool
+}
+
+func NewMemoryStore(size int) *MemoryStore {
+	return &MemoryStore{entries: make([]querylog.Entry, size)}
+}
+
+func (s *MemoryStore) Append(_ context.Context, entries []querylog.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range entries {
+		s.entries[s.next] = entry
+		s.next = (s.next + 1) % len(s.entries)
+		if s.next == 0 {
+			s.full = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Query(_ context.Context, filter Filter) ([]querylog.Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := s.next
+	if s.full {
+		count = len(s.entries)
+	}
+
+	var result []querylog.Entry
+	for i := range count {
+		entry := s.entries[(s.next-1-i+len(s.entries))%len(s.entries)]
+		if !filter.matches(entry) {
+			continue
+		}
+		result = append(result, entry)
+		if filter.Limit > 0 && len(result) >= filter.Limit {
+			break
+		}
+	}
+	return result, nil
+}
+
+// Prune is a no-op: the ring only ever holds the newest entries.
+func (s *MemoryStore) Prune(context.Context, time.Time) error {
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,133 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"dns-server/internal/querylog"
+)
+
+const sqliteDriver = "sqlite"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS queries (
+	time        INTEGER NOT NULL,
+	client      TEXT NOT NULL,
+	client_name TEXT NOT NULL,
+	qname       TEXT NOT NULL,
+	qtype       TEXT NOT NULL,
+	rcode       TEXT NOT NULL,
+	latency_ms  REAL NOT NULL,
+	source      TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS queries_time ON queries (time);
+CREATE INDEX IF NOT EXISTS queries_client ON queries (client, time);
+CREATE INDEX IF NOT EXISTS queries_qname ON queries (qname, time);
+`
+
+// SQLiteStore keeps history in a local SQLite database. The driver is not
+// linked by default; build with -tags sqlite to include it.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if !slices.Contains(sql.Drivers(), sqliteDriver) {
+		return nil, fmt.Errorf("sqlite history is not available in this build (rebuild with -tags sqlite)")
+	}
+
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database %s: %w", path, err)
+	}
+	// SQLite allows a single writer; serializing avoids "database is locked"
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create history schema: %w", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Append(ctx context.Context, entries []querylog.Entry) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO queries VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range entries {
+		if _, err := stmt.ExecContext(ctx, e.Time.UnixMilli(), e.Client, e.ClientName, e.Name, e.Type, e.Rcode, e.LatencyMS, e.Source); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Query(ctx context.Context, filter Filter) ([]querylog.Entry, error) {
+	var where []string
+	var args []any
+	if !filter.Since.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, filter.Since.UnixMilli())
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "time <= ?")
+		args = append(args, filter.Until.UnixMilli())
+	}
+	if filter.Client != "" {
+		where = append(where, "client = ?")
+		args = append(args, filter.Client)
+	}
+	if filter.Name != "" {
+		where = append(where, "qname = ?")
+		args = append(args, filter.Name)
+	}
+
+	query := "SELECT time, client, client_name, qname, qtype, rcode, latency_ms, source FROM queries"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY time DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []querylog.Entry
+	for rows.Next() {
+		var e querylog.Entry
+		var millis int64
+		if err := rows.Scan(&millis, &e.Client, &e.ClientName, &e.Name, &e.Type, &e.Rcode, &e.LatencyMS, &e.Source); err != nil {
+			return nil, err
+		}
+		e.Time = time.UnixMilli(millis).UTC()
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLiteStore) Prune(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM queries WHERE time < ?", before.UnixMilli())
+	return err
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
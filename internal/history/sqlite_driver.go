@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package history
+
+// The pure Go driver registers itself as "sqlite". It is kept behind a build
+// tag so default builds do not carry it.
+import _ "modernc.org/sqlite"
@@ -0,0 +1,202 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+
+	"dns-server/internal/config"
+	"dns-server/internal/metrics"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	mediaType       = "application/dns-message"
+	maxResponseSize = 65535
+)
+
+type hook struct {
+	config   config.HookConfig
+	zones    []string
+	calls    atomic.Uint64
+	failures atomic.Uint64
+}
+
+// Hooks hands queries to external resolvers, so custom logic can answer
+// names without changes to the server.
+type Hooks struct {
+	hooks  []*hook
+	client *http.Client
+	logger *logrus.Logger
+}
+
+func New(cfgs []config.HookConfig, logger *logrus.Logger) *Hooks {
+	h := &Hooks{
+		client: &http.Client{},
+		logger: logger,
+	}
+	for _, cfg := range cfgs {
+		zones := make([]string, 0, len(cfg.Zones))
+		for _, zone := range cfg.Zones {
+			zones = append(zones, config.CanonicalName(zone))
+		}
+		h.hooks = append(h.hooks, &hook{config: cfg, zones: zones})
+	}
+	return h
+}
+
+// Resolve asks the hook with the most specific zone containing the question
+// name. found is false when no hook serves the name.
+func (h *Hooks) Resolve(ctx context.Context, r *dns.Msg, client string) (response *dns.Msg, found bool, err error) {
+	name := strings.ToLower(dns.Fqdn(r.Question[0].Name))
+
+	var best *hook
+	bestLabels := -1
+	for _, hk := range h.hooks {
+		for _, zone := range hk.zones {
+			if labels := dns.CountLabel(zone); labels > bestLabels && dns.IsSubDomain(zone, name) {
+				best, bestLabels = hk, labels
+			}
+		}
+	}
+	if best == nil {
+		return nil, false, nil
+	}
+
+	response, err = h.call(ctx, best, r, client)
+	return response, true, err
+}
+
+// Fallback asks the fallback hooks in order until one answers.
+func (h *Hooks) Fallback(ctx context.Context, r *dns.Msg, client string) (*dns.Msg, bool) {
+	for _, hk := range h.hooks {
+		if !hk.config.Fallback {
+			continue
+		}
+		if response, err := h.call(ctx, hk, r, client); err == nil {
+			return response, true
+		}
+	}
+	return nil, false
+}
+
+func (h *Hooks) call(ctx context.Context, hk *hook, r *dns.Msg, client string) (*dns.Msg, error) {
+	hk.calls.Add(1)
+	ctx, cancel := context.WithTimeout(ctx, hk.config.Timeout)
+	defer cancel()
+
+	query, err := r.Pack()
+	if err != nil {
+		hk.failures.Add(1)
+		return nil, fmt.Errorf("failed to pack query: %w", err)
+	}
+
+	var wire []byte
+	if hk.config.URL != "" {
+		wire, err = h.post(ctx, hk.config.URL, query, client)
+	} else {
+		wire, err = run(ctx, hk.config.Command, query, client)
+	}
+	if err == nil {
+		var response *dns.Msg
+		if response, err = parse(wire, r); err == nil {
+			return response, nil
+		}
+	}
+
+	hk.failures.Add(1)
+	h.logger.WithFields(logrus.Fields{
+		"hook":     hk.config.Name,
+		"question": r.Question[0].Name,
+		"error":    err,
+	}).Warn("hook failed")
+	return nil, fmt.Errorf("hook %s: %w", hk.config.Name, err)
+}
+
+// run starts command with the query on stdin. The client address is passed
+// in DNS_CLIENT.
+func run(ctx context.Context, command []string, query []byte, client string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(query)
+	cmd.Env = append(os.Environ(), "DNS_CLIENT="+client)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%w: %s", err, message)
+		}
+		return nil, err
+	}
+	return output, nil
+}
+
+// post sends the query like a DNS-over-HTTPS request, with the client
+// address in X-Forwarded-For.
+func (h *Hooks) post(ctx context.Context, url string, query []byte, client string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", mediaType)
+	request.Header.Set("Accept", mediaType)
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		request.Header.Set("X-Forwarded-For", host)
+	}
+
+	response, err := h.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, maxResponseSize))
+}
+
+func parse(wire []byte, r *dns.Msg) (*dns.Msg, error) {
+	response := new(dns.Msg)
+	if err := response.Unpack(wire); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+
+	question := r.Question[0]
+	if len(response.Question) != 1 ||
+		!strings.EqualFold(response.Question[0].Name, question.Name) ||
+		response.Question[0].Qtype != question.Qtype {
+		return nil, fmt.Errorf("response does not answer the question")
+	}
+
+	// the server adds its own OPT record for the client
+	extra := response.Extra[:0]
+	for _, rr := range response.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	response.Extra = extra
+
+	response.Id = r.Id
+	response.Response = true
+	response.Question = r.Question
+	return response, nil
+}
+
+func (h *Hooks) Collect(w *metrics.Writer) {
+	for _, hk := range h.hooks {
+		w.Counter("dns_hook_calls_total", "Queries passed to external hooks.", float64(hk.calls.Load()), metrics.L("hook", hk.config.Name))
+		w.Counter("dns_hook_failures_total", "Hook calls that failed or returned an invalid response.", float64(hk.failures.Load()), metrics.L("hook", hk.config.Name))
+	}
+}
@@ -0,0 +1,263 @@
+package leases
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/metrics"
+	"dns-server/internal/resolver"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// Table answers the hostnames in a DHCP server's lease file under a local
+// domain, and their addresses in reverse. It follows the lease file as the
+// DHCP server writes it and drops leases as they expire, so like service
+// discovery records its answers come before the cache and are never stored
+// in it.
+type Table struct {
+	path   string
+	parse  func(io.Reader) ([]lease, error)
+	format string
+	domain string
+	ttl    uint32
+	logger *logrus.Logger
+
+	mu       sync.RWMutex
+	store    *resolver.Store
+	active   int
+	expires  time.Time
+	loaded   time.Time
+	reloads  atomic.Uint64
+	failures atomic.Uint64
+}
+
+type Status struct {
+	Path   string    `json:"path"`
+	Format string    `json:"format"`
+	Leases int       `json:"leases"`
+	Loaded time.Time `json:"loaded,omitzero"`
+}
+
+func New(cfg *config.LeasesConfig, logger *logrus.Logger) (*Table, error) {
+	var parse func(io.Reader) ([]lease, error)
+	switch cfg.Format {
+	case config.LeaseFormatDnsmasq:
+		parse = parseDnsmasq
+	case config.LeaseFormatISC:
+		parse = parseISC
+	case config.LeaseFormatKea:
+		parse = parseKea
+	default:
+		return nil, fmt.Errorf("unknown lease file format: %s", cfg.Format)
+	}
+
+	path, err := filepath.Abs(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &Table{
+		path:   path,
+		parse:  parse,
+		format: cfg.Format,
+		domain: dns.Fqdn(strings.ToLower(cfg.Domain)),
+		ttl:    uint32(cfg.TTL.Seconds()),
+		logger: logger,
+		store:  resolver.NewStore(),
+	}, nil
+}
+
+// Run loads the lease file and reloads it whenever the DHCP server writes
+// or replaces it, and when its earliest active lease expires, until ctx is
+// canceled. The last leases stay served while the file cannot be read.
+func (t *Table) Run(ctx context.Context) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.logger.WithError(err).Warn("failed to watch lease file")
+		return
+	}
+	defer watcher.Close()
+
+	// dnsmasq and Kea replace the file on cleanup and dhcpd rotates it to
+	// a backup, so the directory is watched rather than the file
+	if err := watcher.Add(filepath.Dir(t.path)); err != nil {
+		t.logger.WithFields(logrus.Fields{
+			"path":  t.path,
+			"error": err,
+		}).Warn("failed to watch lease file")
+	}
+
+	expiry := time.NewTimer(0)
+	defer expiry.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			t.logger.WithError(err).Warn("lease file watch error")
+			continue
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != t.path || event.Op == fsnotify.Chmod {
+				continue
+			}
+		case <-expiry.C:
+		}
+
+		if err := t.load(); err != nil {
+			t.failures.Add(1)
+			t.logger.WithFields(logrus.Fields{
+				"path":  t.path,
+				"error": err,
+			}).Warn("failed to read lease file")
+		}
+
+		expiry.Stop()
+		if next := t.nextExpiry(); !next.IsZero() {
+			expiry.Reset(time.Until(next))
+		}
+	}
+}
+
+func (t *Table) load() error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	leases, err := t.parse(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	store := resolver.NewStore()
+	active := 0
+	var expires time.Time
+	for _, l := range leases {
+		host := label(l.hostname)
+		if host == "" || !l.expires.IsZero() && !l.expires.After(now) {
+			continue
+		}
+		active++
+		if !l.expires.IsZero() && (expires.IsZero() || l.expires.Before(expires)) {
+			expires = l.expires
+		}
+
+		name := host + "." + t.domain
+		store.Remove(t.addressRecord(name, l.ip))
+		store.Add(t.addressRecord(name, l.ip))
+		if reverse, err := dns.ReverseAddr(l.ip.String()); err == nil {
+			ptr := &dns.PTR{Hdr: dns.RR_Header{Name: reverse, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: t.ttl}, Ptr: name}
+			store.Remove(ptr)
+			store.Add(ptr)
+		}
+	}
+
+	t.mu.Lock()
+	changed := active != t.active
+	t.store = store
+	t.active = active
+	t.expires = expires
+	t.loaded = now
+	t.mu.Unlock()
+
+	t.reloads.Add(1)
+	fields := logrus.Fields{
+		"path":   t.path,
+		"leases": active,
+	}
+	if changed {
+		t.logger.WithFields(fields).Info("dhcp leases updated")
+	} else {
+		t.logger.WithFields(fields).Debug("dhcp leases reloaded")
+	}
+	return nil
+}
+
+func (t *Table) nextExpiry() time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.expires
+}
+
+func (t *Table) addressRecord(name string, ip net.IP) dns.RR {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: t.ttl}, A: ip4}
+	}
+	return &dns.AAAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: t.ttl}, AAAA: ip}
+}
+
+// Resolve answers leased hostnames and addresses. A leased name asked for
+// another type, e.g. AAAA for an IPv4-only client, is answered NODATA
+// rather than sent upstream, which knows nothing of the local domain.
+func (t *Table) Resolve(question dns.Question) (*dns.Msg, bool) {
+	t.mu.RLock()
+	store := t.store
+	t.mu.RUnlock()
+
+	rrset, _ := store.Lookup(question.Name, question.Qtype)
+	if len(rrset) == 0 && !store.HasName(question.Name) {
+		return nil, false
+	}
+
+	response := &dns.Msg{}
+	response.SetReply(&dns.Msg{Question: []dns.Question{question}})
+	response.Authoritative = true
+	response.RecursionAvailable = false
+	response.Answer = rrset
+
+	t.logger.WithFields(logrus.Fields{
+		"domain":  strings.ToLower(strings.TrimSuffix(question.Name, ".")),
+		"qtype":   dns.TypeToString[question.Qtype],
+		"answers": len(rrset),
+	}).Debug("dhcp lease resolved")
+
+	return response, true
+}
+
+func (t *Table) Status() Status {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return Status{Path: t.path, Format: t.format, Leases: t.active, Loaded: t.loaded}
+}
+
+func (t *Table) Collect(w *metrics.Writer) {
+	status := t.Status()
+	format := metrics.L("format", status.Format)
+	w.Gauge("dns_dhcp_leases", "Active DHCP leases answered by hostname.", float64(status.Leases), format)
+	w.Counter("dns_dhcp_lease_reloads_total", "Times the lease file was read.", float64(t.reloads.Load()), format)
+	w.Counter("dns_dhcp_lease_failures_total", "Failed reads of the lease file.", float64(t.failures.Load()), format)
+}
+
+// label turns a client's hostname into a single DNS label. Only the first
+// label of a fully qualified hostname is kept, so the lease is answered
+// under the configured domain.
+func label(hostname string) string {
+	hostname, _, _ = strings.Cut(strings.ToLower(hostname), ".")
+	var b strings.Builder
+	for _, r := range hostname {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
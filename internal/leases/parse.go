@@ -0,0 +1,205 @@
+package leases
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lease is an address handed to a client that sent a hostname. A zero
+// expiry never expires.
+type lease struct {
+	hostname string
+	ip       net.IP
+	expires  time.Time
+}
+
+// parseDnsmasq reads dnsmasq.leases, one lease per line:
+//
+//	<expiry> <mac or iaid> <address> <hostname or *> <client id or *>
+//
+// IPv6 leases follow a "duid" line and use the IAID in place of the MAC.
+func parseDnsmasq(r io.Reader) ([]lease, error) {
+	var leases []lease
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] == "duid" || fields[3] == "*" {
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		ip := net.ParseIP(fields[2])
+		if err != nil || ip == nil {
+			continue
+		}
+
+		l := lease{hostname: fields[3], ip: ip}
+		if expiry > 0 {
+			l.expires = time.Unix(expiry, 0)
+		}
+		leases = append(leases, l)
+	}
+	return leases, scanner.Err()
+}
+
+// parseISC reads the dhcpd.leases journal of ISC dhcpd. The file is only
+// appended to between rewrites, so the last block for an address is its
+// current state; leases that are not active are left out.
+//
+//	lease 192.168.1.10 {
+//	  ends 4 2024/01/04 22:00:00;
+//	  binding state active;
+//	  client-hostname "laptop";
+//	}
+func parseISC(r io.Reader) ([]lease, error) {
+	latest := make(map[string]lease)
+	var order []string
+
+	var current *lease
+	var address string
+	active := true
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if current == nil {
+			fields := strings.Fields(line)
+			if len(fields) == 3 && fields[0] == "lease" && fields[2] == "{" {
+				if ip := net.ParseIP(fields[1]); ip != nil {
+					current, address, active = &lease{ip: ip}, fields[1], true
+				}
+			}
+			continue
+		}
+
+		if line == "}" {
+			if _, seen := latest[address]; !seen {
+				order = append(order, address)
+			}
+			if !active {
+				current.hostname = ""
+			}
+			latest[address] = *current
+			current = nil
+			continue
+		}
+
+		statement := strings.TrimSuffix(line, ";")
+		switch {
+		case strings.HasPrefix(statement, "binding state "):
+			active = strings.TrimPrefix(statement, "binding state ") == "active"
+		case strings.HasPrefix(statement, "client-hostname "):
+			current.hostname = strings.Trim(strings.TrimPrefix(statement, "client-hostname "), `"`)
+		case strings.HasPrefix(statement, "ends "):
+			current.expires = parseISCTime(strings.TrimPrefix(statement, "ends "))
+		}
+	}
+
+	var leases []lease
+	for _, address := range order {
+		if l := latest[address]; l.hostname != "" {
+			leases = append(leases, l)
+		}
+	}
+	return leases, scanner.Err()
+}
+
+// parseISCTime reads the "<weekday> yyyy/mm/dd hh:mm:ss" UTC times of
+// dhcpd.leases, the "epoch <seconds>" form of db-time-format local, and
+// "never", which is returned as the zero time.
+func parseISCTime(s string) time.Time {
+	fields := strings.Fields(s)
+	switch {
+	case len(fields) == 2 && fields[0] == "epoch":
+		if seconds, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			return time.Unix(seconds, 0)
+		}
+	case len(fields) == 3:
+		if t, err := time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2]); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseKea reads a Kea memfile lease file (kea-leases4.csv or
+// kea-leases6.csv). Columns are found by the header, which differs between
+// the two and between versions. Like dhcpd.leases the file is a journal, so
+// the last row for an address wins; rows in a state other than default (0)
+// are declined or reclaimed leases.
+func parseKea(r io.Reader) ([]lease, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	column := make(map[string]int, len(header))
+	for i, name := range header {
+		column[name] = i
+	}
+	for _, required := range []string{"address", "expire", "hostname"} {
+		if _, ok := column[required]; !ok {
+			return nil, errors.New("kea lease file has no " + required + " column")
+		}
+	}
+
+	field := func(row []string, name string) string {
+		if i, ok := column[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	latest := make(map[string]lease)
+	var order []string
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		address := field(row, "address")
+		ip := net.ParseIP(address)
+		if ip == nil {
+			continue
+		}
+		if _, seen := latest[address]; !seen {
+			order = append(order, address)
+		}
+
+		l := lease{ip: ip}
+		if state := field(row, "state"); state == "" || state == "0" {
+			// Kea writes hostnames as FQDNs and escapes commas
+			l.hostname = strings.ReplaceAll(field(row, "hostname"), "&#x2c", ",")
+		}
+		expire, _ := strconv.ParseInt(field(row, "expire"), 10, 64)
+		if lifetime := field(row, "valid_lifetime"); lifetime != "4294967295" && expire > 0 {
+			l.expires = time.Unix(expire, 0)
+		}
+		latest[address] = l
+	}
+
+	var leases []lease
+	for _, address := range order {
+		if l := latest[address]; l.hostname != "" {
+			leases = append(leases, l)
+		}
+	}
+	return leases, nil
+}
@@ -0,0 +1,96 @@
+package lifecycle
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Manager owns the server's background loops. Every loop receives the same
+// context and is waited for on shutdown, so nothing outlives the server.
+type Manager struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	running map[string]int
+	logger  *logrus.Logger
+}
+
+func New(parent context.Context, logger *logrus.Logger) *Manager {
+	ctx, cancel := context.WithCancel(parent)
+	return &Manager{
+		ctx:     ctx,
+		cancel:  cancel,
+		running: make(map[string]int),
+		logger:  logger,
+	}
+}
+
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// Go runs fn in a goroutine until ctx is canceled. name identifies the loop
+// in logs and in Running.
+func (m *Manager) Go(name string, fn func(ctx context.Context)) {
+	m.mu.Lock()
+	m.running[name]++
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer func() {
+			m.mu.Lock()
+			if m.running[name]--; m.running[name] <= 0 {
+				delete(m.running, name)
+			}
+			m.mu.Unlock()
+			m.wg.Done()
+
+			m.logger.WithField("loop", name).Debug("background loop stopped")
+		}()
+
+		m.logger.WithField("loop", name).Debug("background loop started")
+		fn(m.ctx)
+	}()
+}
+
+func (m *Manager) Running() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.running))
+	for name := range m.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stop cancels all loops and waits up to timeout for them to return. It
+// reports whether every loop finished in time.
+func (m *Manager) Stop(timeout time.Duration) bool {
+	m.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		m.logger.WithField("loops", m.Running()).Warn("background loops did not stop in time")
+		return false
+	}
+}
+
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
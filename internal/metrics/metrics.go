@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+func L(name, value string) Label {
+	return Label{Name: name, Value: value}
+}
+
+type Collector func(w *Writer)
+
+type Registry struct {
+	mu         sync.RWMutex
+	collectors []Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+func (r *Registry) Write(out io.Writer) error {
+	r.mu.RLock()
+	collectors := make([]Collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mu.RUnlock()
+
+	bw := bufio.NewWriter(out)
+	w := &Writer{w: bw, described: make(map[string]bool)}
+	for _, c := range collectors {
+		c(w)
+	}
+
+	return bw.Flush()
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.Write(w)
+	})
+}
+
+// Writer renders samples in the Prometheus text exposition format. Samples
+// of one metric family must be written contiguously.
+type Writer struct {
+	w         *bufio.Writer
+	described map[string]bool
+}
+
+func (w *Writer) Counter(name, help string, value float64, labels ...Label) {
+	w.sample(name, help, "counter", value, labels)
+}
+
+func (w *Writer) Gauge(name, help string, value float64, labels ...Label) {
+	w.sample(name, help, "gauge", value, labels)
+}
+
+func (w *Writer) sample(name, help, kind string, value float64, labels []Label) {
+	if !w.described[name] {
+		w.described[name] = true
+		fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	w.w.WriteString(name)
+	if len(labels) > 0 {
+		sorted := make([]Label, len(labels))
+		copy(sorted, labels)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+		parts := make([]string, len(sorted))
+		for i, l := range sorted {
+			parts[i] = l.Name + "=" + strconv.Quote(l.Value)
+		}
+		w.w.WriteString("{" + strings.Join(parts, ",") + "}")
+	}
+	w.w.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+func Bool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,189 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+)
+
+type Packet struct {
+	Timestamp time.Time
+	SrcIP     net.IP
+	SrcPort   uint16
+	DstIP     net.IP
+	DstPort   uint16
+	Payload   []byte
+}
+
+// Reader decodes UDP datagrams from a classic libpcap capture file. Other
+// transport protocols and pcapng files are not supported.
+type Reader struct {
+	r        *bufio.Reader
+	order    binary.ByteOrder
+	nanos    bool
+	linkType uint32
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: %w", err)
+	}
+
+	reader := &Reader{r: br}
+	switch magic := binary.LittleEndian.Uint32(header); magic {
+	case 0xa1b2c3d4:
+		reader.order = binary.LittleEndian
+	case 0xd4c3b2a1:
+		reader.order = binary.BigEndian
+	case 0xa1b23c4d:
+		reader.order, reader.nanos = binary.LittleEndian, true
+	case 0x4d3cb2a1:
+		reader.order, reader.nanos = binary.BigEndian, true
+	case 0x0a0d0d0a:
+		return nil, errors.New("pcapng files are not supported, convert with: editcap -F pcap in.pcapng out.pcap")
+	default:
+		return nil, fmt.Errorf("not a pcap file (magic %#x)", magic)
+	}
+
+	reader.linkType = reader.order.Uint32(header[20:24])
+	return reader, nil
+}
+
+// Next returns the next UDP packet, skipping frames that are not UDP over
+// IPv4/IPv6. It returns io.EOF at the end of the capture.
+func (r *Reader) Next() (*Packet, error) {
+	for {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(r.r, header); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+
+		sec := r.order.Uint32(header[0:4])
+		frac := r.order.Uint32(header[4:8])
+		capLen := r.order.Uint32(header[8:12])
+		if capLen > 1<<18 {
+			return nil, fmt.Errorf("invalid pcap record length %d", capLen)
+		}
+
+		data := make([]byte, capLen)
+		if _, err := io.ReadFull(r.r, data); err != nil {
+			return nil, io.EOF
+		}
+
+		if !r.nanos {
+			frac *= 1000
+		}
+
+		packet, ok := r.decode(data)
+		if !ok {
+			continue
+		}
+		packet.Timestamp = time.Unix(int64(sec), int64(frac))
+		return packet, nil
+	}
+}
+
+func (r *Reader) decode(data []byte) (*Packet, bool) {
+	switch r.linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		for etherType == 0x8100 && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		data = data[16:]
+	case linkTypeNull:
+		if len(data) < 4 {
+			return nil, false
+		}
+		data = data[4:]
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+	default:
+		return nil, false
+	}
+
+	return decodeIP(data)
+}
+
+func decodeIP(data []byte) (*Packet, bool) {
+	if len(data) < 1 {
+		return nil, false
+	}
+
+	var (
+		src, dst net.IP
+		proto    byte
+		payload  []byte
+	)
+
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return nil, false
+		}
+		ihl := int(data[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(data[2:4]))
+		if ihl < 20 || len(data) < ihl || total < ihl {
+			return nil, false
+		}
+		if total > len(data) {
+			total = len(data)
+		}
+		// skip non-first fragments
+		if binary.BigEndian.Uint16(data[6:8])&0x1fff != 0 {
+			return nil, false
+		}
+		proto = data[9]
+		src, dst = net.IP(data[12:16]), net.IP(data[16:20])
+		payload = data[ihl:total]
+	case 6:
+		if len(data) < 40 {
+			return nil, false
+		}
+		proto = data[6]
+		src, dst = net.IP(data[8:24]), net.IP(data[24:40])
+		payload = data[40:]
+	default:
+		return nil, false
+	}
+
+	const protoUDP = 17
+	if proto != protoUDP || len(payload) < 8 {
+		return nil, false
+	}
+
+	return &Packet{
+		SrcIP:   src,
+		SrcPort: binary.BigEndian.Uint16(payload[0:2]),
+		DstIP:   dst,
+		DstPort: binary.BigEndian.Uint16(payload[2:4]),
+		Payload: payload[8:],
+	}, true
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package pidfile
+
+import (
+	"fmt"
+	"os"
+)
+
+// Without flock the pidfile is only advisory: an existing file holding a pid
+// is treated as locked, and a stale one has to be removed by hand.
+func lock(file *os.File) error {
+	if pid := readPID(file); pid > 0 && pid != os.Getpid() {
+		return fmt.Errorf("pidfile already holds pid %d", pid)
+	}
+	return nil
+}
+
+func unlock(file *os.File) {}
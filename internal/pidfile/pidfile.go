@@ -0,0 +1,70 @@
+package pidfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ErrLocked = errors.New("pidfile is locked by another process")
+
+type File struct {
+	path string
+	file *os.File
+}
+
+// Acquire creates path, takes an exclusive lock on it and writes the current
+// pid. The lock is held until Release or process exit, so a second instance
+// using the same pidfile fails here instead of fighting over the port and
+// cache snapshot.
+func Acquire(path string) (*File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pidfile: %w", err)
+	}
+
+	if err := lock(file); err != nil {
+		owner := readPID(file)
+		file.Close()
+		if owner > 0 {
+			return nil, fmt.Errorf("%w: %s (pid %d)", ErrLocked, path, owner)
+		}
+		return nil, fmt.Errorf("%w: %s: %v", ErrLocked, path, err)
+	}
+
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to truncate pidfile: %w", err)
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write pidfile: %w", err)
+	}
+
+	return &File{path: path, file: file}, nil
+}
+
+func (f *File) Path() string {
+	return f.path
+}
+
+func (f *File) Release() error {
+	if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		f.file.Close()
+		return fmt.Errorf("failed to remove pidfile: %w", err)
+	}
+	unlock(f.file)
+	return f.file.Close()
+}
+
+func readPID(file *os.File) int {
+	buf := make([]byte, 32)
+	n, _ := file.ReadAt(buf, 0)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
@@ -0,0 +1,161 @@
+package ptr
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/metrics"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	queueSize = 256
+	maxNames  = 10000
+)
+
+type entry struct {
+	name    string
+	expires time.Time
+}
+
+// Enricher maps client addresses to their reverse DNS names for logs. Names
+// are looked up in the background through the server itself, so local
+// records and the cache answer most of them; callers never wait on a lookup.
+type Enricher struct {
+	server  string
+	client  *dns.Client
+	ttl     time.Duration
+	rate    int
+	workers int
+	logger  *logrus.Logger
+
+	mu      sync.Mutex
+	names   map[string]entry
+	pending map[string]bool
+	queue   chan string
+
+	lookups  atomic.Uint64
+	failures atomic.Uint64
+}
+
+func NewEnricher(server string, cfg *config.ClientNamesConfig, logger *logrus.Logger) *Enricher {
+	return &Enricher{
+		server:  server,
+		client:  &dns.Client{Net: "udp", Timeout: 2 * time.Second},
+		ttl:     cfg.TTL,
+		rate:    cfg.Rate,
+		workers: cfg.Workers,
+		logger:  logger,
+		names:   make(map[string]entry),
+		pending: make(map[string]bool),
+		queue:   make(chan string, queueSize),
+	}
+}
+
+// Name returns the cached name of ip, or "" while it is unknown, queueing a
+// lookup if none is in flight. Addresses without a PTR record stay "" until
+// the TTL runs out.
+func (e *Enricher) Name(ip string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if cached, exists := e.names[ip]; exists && time.Now().Before(cached.expires) {
+		return cached.name
+	}
+	if e.pending[ip] {
+		return ""
+	}
+
+	select {
+	case e.queue <- ip:
+		e.pending[ip] = true
+	default:
+	}
+	return ""
+}
+
+// Run resolves queued addresses with a fixed number of workers, sharing a
+// budget of rate lookups per second between them.
+func (e *Enricher) Run(ctx context.Context) {
+	limiter := time.NewTicker(time.Second / time.Duration(e.rate))
+	defer limiter.Stop()
+
+	var wg sync.WaitGroup
+	for range e.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case ip := <-e.queue:
+					select {
+					case <-ctx.Done():
+						return
+					case <-limiter.C:
+					}
+					e.resolve(ctx, ip)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (e *Enricher) resolve(ctx context.Context, ip string) {
+	e.lookups.Add(1)
+
+	name := ""
+	if arpa, err := dns.ReverseAddr(ip); err == nil {
+		query := new(dns.Msg)
+		query.SetQuestion(arpa, dns.TypePTR)
+		response, _, err := e.client.ExchangeContext(ctx, query, e.server)
+		if err != nil {
+			e.failures.Add(1)
+			e.logger.WithFields(logrus.Fields{
+				"client": ip,
+				"error":  err,
+			}).Debug("client name lookup failed")
+		} else {
+			for _, rr := range response.Answer {
+				if record, ok := rr.(*dns.PTR); ok {
+					name = strings.TrimSuffix(record.Ptr, ".")
+					break
+				}
+			}
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if len(e.names) >= maxNames {
+		for cached, entry := range e.names {
+			if !now.Before(entry.expires) {
+				delete(e.names, cached)
+			}
+		}
+	}
+	e.names[ip] = entry{name: name, expires: now.Add(e.ttl)}
+	delete(e.pending, ip)
+}
+
+func (e *Enricher) size() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.names)
+}
+
+func (e *Enricher) Collect(w *metrics.Writer) {
+	w.Gauge("dns_client_names_cached", "Client addresses with a cached reverse DNS name.", float64(e.size()))
+	w.Counter("dns_client_name_lookups_total", "Reverse DNS lookups of client addresses.", float64(e.lookups.Load()))
+	w.Counter("dns_client_name_failures_total", "Failed reverse DNS lookups of client addresses.", float64(e.failures.Load()))
+}
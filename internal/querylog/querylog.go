@@ -0,0 +1,225 @@
+package querylog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/pkg/logger"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+
+	backupTimeFormat = "20060102T150405.000"
+)
+
+// Entry is one answered query.
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client"`
+	ClientName string    `json:"client_name,omitempty"`
+	Name       string    `json:"qname"`
+	Type       string    `json:"qtype"`
+	Rcode      string    `json:"rcode"`
+	LatencyMS  float64   `json:"latency_ms"`
+	Source     string    `json:"source"`
+}
+
+// Log writes query entries to a file that is rotated once it reaches
+// maxSize or has been open for rotateEvery. The file is opened on the first
+// write, so a log that starts disabled can be switched on at runtime. Names
+// under the logging exclude_domains are never written.
+type Log struct {
+	path        string
+	format      string
+	maxSize     int64
+	rotateEvery time.Duration
+	maxBackups  int
+	filter      *logger.PrivacyFilter
+	enabled     atomic.Bool
+	lastError   atomic.Value
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	closed bool
+}
+
+func New(cfg *config.QueryLogConfig, excludeDomains []string) *Log {
+	l := &Log{
+		path:        cfg.Path,
+		format:      cfg.Format,
+		maxSize:     int64(cfg.MaxSize),
+		rotateEvery: cfg.RotateEvery,
+		maxBackups:  cfg.MaxBackups,
+		filter:      logger.NewPrivacyFilter(excludeDomains),
+	}
+	l.enabled.Store(cfg.Enabled)
+	return l
+}
+
+// Enabled reports whether entries are currently written. It is safe to call
+// on a nil Log.
+func (l *Log) Enabled() bool {
+	return l != nil && l.enabled.Load()
+}
+
+func (l *Log) SetEnabled(enabled bool) {
+	l.enabled.Store(enabled)
+}
+
+// Status is the runtime state of the log reported by the admin API.
+type Status struct {
+	Enabled   bool   `json:"enabled"`
+	Path      string `json:"path"`
+	Format    string `json:"format"`
+	Size      int64  `json:"size"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func (l *Log) Status() Status {
+	l.mu.Lock()
+	size := l.size
+	l.mu.Unlock()
+
+	lastError, _ := l.lastError.Load().(string)
+	return Status{
+		Enabled:   l.Enabled(),
+		Path:      l.path,
+		Format:    l.format,
+		Size:      size,
+		LastError: lastError,
+	}
+}
+
+func (l *Log) Write(entry Entry) {
+	if !l.Enabled() || l.filter.Suppressed(entry.Name) {
+		return
+	}
+
+	line, err := l.encode(entry)
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+
+	var writeErr error
+	if l.file == nil {
+		writeErr = l.open()
+	} else if l.due(len(line)) {
+		writeErr = l.rotate()
+	}
+	if writeErr != nil {
+		l.lastError.Store(writeErr.Error())
+	}
+	if l.file == nil {
+		return
+	}
+
+	n, _ := l.file.Write(line)
+	l.size += int64(n)
+}
+
+// Close flushes the file to disk and closes it. Entries written afterwards,
+// by queries that outlived the shutdown drain, are dropped.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	if l.file == nil {
+		return nil
+	}
+	err := errors.Join(l.file.Sync(), l.file.Close())
+	l.file = nil
+	return err
+}
+
+func (l *Log) encode(entry Entry) ([]byte, error) {
+	if l.format == FormatText {
+		client := entry.Client
+		if entry.ClientName != "" {
+			client += "(" + entry.ClientName + ")"
+		}
+		return fmt.Appendf(nil, "%s %s %s %s %s %.3fms %s\n",
+			entry.Time.Format("2006-01-02T15:04:05.000Z07:00"),
+			client, entry.Name, entry.Type, entry.Rcode, entry.LatencyMS, entry.Source), nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+func (l *Log) due(next int) bool {
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(next) > l.maxSize {
+		return true
+	}
+	return l.rotateEvery > 0 && time.Since(l.opened) >= l.rotateEvery
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open query log %s: %w", l.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat query log %s: %w", l.path, err)
+	}
+
+	l.file = file
+	l.size = info.Size()
+	l.opened = time.Now()
+	return nil
+}
+
+// rotate moves the current file aside with a timestamp suffix, starts a new
+// one and removes the oldest backups beyond maxBackups.
+func (l *Log) rotate() error {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+
+	backup := l.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(l.path, backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to move query log aside: %w", err)
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+
+	if l.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(l.path + ".*")
+	if err != nil {
+		return err
+	}
+	slices.Sort(backups)
+	for len(backups) > l.maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
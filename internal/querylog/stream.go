@@ -0,0 +1,96 @@
+package querylog
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"dns-server/internal/metrics"
+	"dns-server/pkg/logger"
+)
+
+// subscriberBuffer is how many entries a subscriber may fall behind by
+// before it misses some.
+const subscriberBuffer = 256
+
+// Stream hands every answered query to the clients tailing it through the
+// admin API. A subscriber that falls behind misses entries rather than
+// delaying answers. Names under the logging exclude_domains are never
+// streamed.
+type Stream struct {
+	filter *logger.PrivacyFilter
+
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	active      atomic.Int64
+
+	dropped atomic.Uint64
+}
+
+// Subscription receives the entries written to a Stream on C until it is
+// passed to Unsubscribe.
+type Subscription struct {
+	C       <-chan Entry
+	entries chan Entry
+	dropped atomic.Uint64
+}
+
+// Dropped returns how many entries were missed because the subscriber fell
+// behind.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func NewStream(excludeDomains []string) *Stream {
+	return &Stream{
+		filter:      logger.NewPrivacyFilter(excludeDomains),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Active reports whether anyone is subscribed. It is safe to call on a nil
+// Stream.
+func (s *Stream) Active() bool {
+	return s != nil && s.active.Load() > 0
+}
+
+func (s *Stream) Subscribe() *Subscription {
+	entries := make(chan Entry, subscriberBuffer)
+	sub := &Subscription{C: entries, entries: entries}
+
+	s.mu.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.active.Store(int64(len(s.subscribers)))
+	s.mu.Unlock()
+	return sub
+}
+
+func (s *Stream) Unsubscribe(sub *Subscription) {
+	s.mu.Lock()
+	delete(s.subscribers, sub)
+	s.active.Store(int64(len(s.subscribers)))
+	s.mu.Unlock()
+}
+
+// Write hands entry to every subscriber with room for it. It is safe to call
+// on a nil Stream.
+func (s *Stream) Write(entry Entry) {
+	if !s.Active() || s.filter.Suppressed(entry.Name) {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for sub := range s.subscribers {
+		select {
+		case sub.entries <- entry:
+		default:
+			sub.dropped.Add(1)
+			s.dropped.Add(1)
+		}
+	}
+}
+
+func (s *Stream) Collect(w *metrics.Writer) {
+	w.Gauge("dns_query_stream_subscribers", "Clients tailing the live query stream.", float64(s.active.Load()))
+	w.Counter("dns_query_stream_dropped_total", "Query stream entries missed by subscribers that fell behind.", float64(s.dropped.Load()))
+}
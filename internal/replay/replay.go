@@ -0,0 +1,250 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+
+	"dns-server/internal/cache"
+	"dns-server/internal/config"
+	dnshandler "dns-server/internal/dns"
+	"dns-server/internal/pcap"
+	"dns-server/internal/resolver"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+type Exchange struct {
+	Client   string
+	Query    *dns.Msg
+	Recorded *dns.Msg
+}
+
+type Difference struct {
+	Client   string   `json:"client"`
+	Question string   `json:"question"`
+	Type     string   `json:"type"`
+	OldRcode string   `json:"old_rcode"`
+	NewRcode string   `json:"new_rcode"`
+	Removed  []string `json:"removed,omitempty"`
+	Added    []string `json:"added,omitempty"`
+}
+
+type Report struct {
+	Queries     int          `json:"queries"`
+	Unanswered  int          `json:"unanswered"`
+	Identical   int          `json:"identical"`
+	Differences []Difference `json:"differences"`
+}
+
+// ReadExchanges pairs DNS queries and responses found in a pcap capture by
+// client address, port and message ID.
+func ReadExchanges(r io.Reader, port uint16) ([]Exchange, error) {
+	reader, err := pcap.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var exchanges []*Exchange
+	pending := make(map[string]*Exchange)
+
+	for {
+		packet, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if packet.DstPort != port && packet.SrcPort != port {
+			continue
+		}
+
+		msg := &dns.Msg{}
+		if err := msg.Unpack(packet.Payload); err != nil || len(msg.Question) == 0 {
+			continue
+		}
+
+		if !msg.Response && packet.DstPort == port {
+			client := net.JoinHostPort(packet.SrcIP.String(), fmt.Sprint(packet.SrcPort))
+			exchange := &Exchange{Client: client, Query: msg}
+			pending[exchangeKey(client, msg)] = exchange
+			exchanges = append(exchanges, exchange)
+			continue
+		}
+
+		if msg.Response && packet.SrcPort == port {
+			client := net.JoinHostPort(packet.DstIP.String(), fmt.Sprint(packet.DstPort))
+			if exchange, found := pending[exchangeKey(client, msg)]; found {
+				exchange.Recorded = msg
+				delete(pending, exchangeKey(client, msg))
+			}
+		}
+	}
+
+	result := make([]Exchange, len(exchanges))
+	for i, exchange := range exchanges {
+		result[i] = *exchange
+	}
+	return result, nil
+}
+
+func exchangeKey(client string, msg *dns.Msg) string {
+	q := msg.Question[0]
+	return fmt.Sprintf("%s/%d/%s/%d", client, msg.Id, strings.ToLower(q.Name), q.Qtype)
+}
+
+// Run replays the queries against a handler built from cfg. Names that are
+// not answered locally are served from the recorded responses instead of
+// real upstreams, so only configuration-driven changes show up in the report.
+func Run(cfg *config.Config, exchanges []Exchange, logger *logrus.Logger) Report {
+	stub := newStubResolver(exchanges)
+
+	dnsCache := cache.NewLRUCache(cfg.Cache.MaxEntries, cfg.Cache.DefaultTTL, cfg.Cache.CleanupInterval)
+
+	localResolver := resolver.NewLocalResolver(&cfg.Records, logger)
+	localResolver.SetProfiles(cfg.Profiles)
+
+	handler := dnshandler.NewHandler(dnsCache, localResolver, stub, logger)
+
+	report := Report{Differences: []Difference{}}
+	for _, exchange := range exchanges {
+		report.Queries++
+		if exchange.Recorded == nil {
+			report.Unanswered++
+			continue
+		}
+
+		w := &recorder{remote: exchange.Client}
+		handler.ServeDNS(w, exchange.Query.Copy())
+		if w.msg == nil {
+			w.msg = &dns.Msg{}
+			w.msg.SetRcode(exchange.Query, dns.RcodeServerFailure)
+		}
+
+		if diff, changed := compare(exchange, w.msg); changed {
+			report.Differences = append(report.Differences, diff)
+		} else {
+			report.Identical++
+		}
+	}
+
+	return report
+}
+
+func compare(exchange Exchange, replayed *dns.Msg) (Difference, bool) {
+	q := exchange.Query.Question[0]
+	diff := Difference{
+		Client:   exchange.Client,
+		Question: q.Name,
+		Type:     dns.TypeToString[q.Qtype],
+		OldRcode: dns.RcodeToString[exchange.Recorded.Rcode],
+		NewRcode: dns.RcodeToString[replayed.Rcode],
+	}
+
+	recorded := answerSet(exchange.Recorded.Answer)
+	current := answerSet(replayed.Answer)
+
+	for rr := range recorded {
+		if !current[rr] {
+			diff.Removed = append(diff.Removed, rr)
+		}
+	}
+	for rr := range current {
+		if !recorded[rr] {
+			diff.Added = append(diff.Added, rr)
+		}
+	}
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Added)
+
+	changed := diff.OldRcode != diff.NewRcode || len(diff.Removed) > 0 || len(diff.Added) > 0
+	return diff, changed
+}
+
+// answerSet renders records without TTLs, which naturally differ between a
+// capture and a replay.
+func answerSet(rrs []dns.RR) map[string]bool {
+	set := make(map[string]bool, len(rrs))
+	for _, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		rr.Header().Name = strings.ToLower(rr.Header().Name)
+		set[rr.String()] = true
+	}
+	return set
+}
+
+type stubResolver struct {
+	answers map[string]*dns.Msg
+}
+
+func newStubResolver(exchanges []Exchange) *stubResolver {
+	stub := &stubResolver{answers: make(map[string]*dns.Msg)}
+	for _, exchange := range exchanges {
+		if exchange.Recorded != nil {
+			stub.answers[cache.GenerateCacheKey(canonical(exchange.Query.Question[0]))] = exchange.Recorded
+		}
+	}
+	return stub
+}
+
+func (s *stubResolver) Resolve(ctx context.Context, question dns.Question) (*dns.Msg, error) {
+	recorded, found := s.answers[cache.GenerateCacheKey(canonical(question))]
+	if !found {
+		return nil, fmt.Errorf("no recorded answer for %s %s", question.Name, dns.TypeToString[question.Qtype])
+	}
+	if recorded.Rcode != dns.RcodeSuccess && recorded.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("recorded answer was %s", dns.RcodeToString[recorded.Rcode])
+	}
+	return recorded.Copy(), nil
+}
+
+func canonical(q dns.Question) dns.Question {
+	q.Name = strings.ToLower(q.Name)
+	return q
+}
+
+type recorder struct {
+	remote string
+	msg    *dns.Msg
+}
+
+func (r *recorder) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+
+func (r *recorder) RemoteAddr() net.Addr {
+	addr, err := net.ResolveUDPAddr("udp", r.remote)
+	if err != nil {
+		return &net.UDPAddr{}
+	}
+	return addr
+}
+
+func (r *recorder) WriteMsg(msg *dns.Msg) error {
+	r.msg = msg
+	return nil
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	msg := &dns.Msg{}
+	if err := msg.Unpack(b); err != nil {
+		return 0, err
+	}
+	r.msg = msg
+	return len(b), nil
+}
+
+func (r *recorder) Network() string     { return "udp" }
+func (r *recorder) Close() error        { return nil }
+func (r *recorder) TsigStatus() error   { return nil }
+func (r *recorder) TsigTimersOnly(bool) {}
+func (r *recorder) Hijack()             {}
+
+var _ dns.ResponseWriter = (*recorder)(nil)
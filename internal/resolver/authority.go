@@ -0,0 +1,136 @@
+package resolver
+
+import (
+	"strings"
+
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// additional returns the addresses held locally for the targets of the MX,
+// SRV and NS records in answer, as an authoritative server adds them to the
+// additional section so clients need not ask for them.
+func (v *view) additional(answer []dns.RR) []dns.RR {
+	var extra []dns.RR
+	seen := make(map[string]bool)
+	for _, rr := range answer {
+		var target string
+		switch rr := rr.(type) {
+		case *dns.MX:
+			target = rr.Mx
+		case *dns.SRV:
+			target = rr.Target
+		case *dns.NS:
+			target = rr.Ns
+		}
+		target = config.CanonicalName(target)
+		if target == "." || seen[target] {
+			continue
+		}
+		seen[target] = true
+
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			rrset, _ := v.lookup(target, qtype)
+			extra = append(extra, rrset...)
+		}
+	}
+	return extra
+}
+
+// maxChain bounds how many CNAMEs chase follows, so aliases pointing at
+// each other end.
+const maxChain = 8
+
+// chase follows the CNAME ending the answer of response through the local
+// records. A chain ending in a local zone without records of qtype gets
+// that zone's negative answer; one leaving the local zones is left for the
+// caller to resolve.
+func (v *view) chase(response *dns.Msg, qtype uint16) {
+	for range maxChain {
+		cname, ok := response.Answer[len(response.Answer)-1].(*dns.CNAME)
+		if !ok {
+			return
+		}
+		target := config.CanonicalName(cname.Target)
+		if owns(response.Answer, target) {
+			return
+		}
+
+		if rrset, _ := v.lookup(target, qtype); len(rrset) > 0 {
+			response.Answer = append(response.Answer, rrset...)
+			return
+		}
+		if rrset, _ := v.lookup(target, dns.TypeCNAME); len(rrset) > 0 {
+			response.Answer = append(response.Answer, rrset...)
+			continue
+		}
+		if soa := v.zoneSOA(target); soa != nil {
+			response.Ns = []dns.RR{soa}
+			if !v.hasName(target) {
+				response.Rcode = dns.RcodeNameError
+			}
+		}
+		return
+	}
+}
+
+func owns(rrs []dns.RR, name string) bool {
+	for _, rr := range rrs {
+		if strings.EqualFold(rr.Header().Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneSOA returns the SOA of the closest zone held locally that name is in,
+// with the TTL negative answers are cached for (RFC 2308 section 3). It
+// returns nil when no zone holds name or it is below an NS delegation,
+// since the answer is then someone else's to give.
+func (v *view) zoneSOA(name string) dns.RR {
+	labels := dns.SplitDomainName(config.CanonicalName(name))
+	for i := range labels {
+		zone := dns.Fqdn(strings.Join(labels[i:], "."))
+		if rrset := v.rrset(recordKey{name: zone, qtype: dns.TypeSOA}); len(rrset) > 0 {
+			soa := rrset[0]
+			if s, ok := soa.(*dns.SOA); ok {
+				s.Hdr.Ttl = min(s.Hdr.Ttl, s.Minttl)
+			}
+			return soa
+		}
+		if len(v.rrset(recordKey{name: zone, qtype: dns.TypeNS})) > 0 {
+			return nil
+		}
+	}
+	return nil
+}
+
+// hasName reports whether any source holds records at or below name, or a
+// wildcard that matches it, which makes the answer NODATA rather than
+// NXDOMAIN.
+func (v *view) hasName(name string) bool {
+	stores := v.stores()
+	if v.special != nil {
+		stores = append(stores, v.special)
+	}
+	for _, store := range stores {
+		if store.HasName(name) {
+			return true
+		}
+	}
+
+	labels := dns.SplitDomainName(config.CanonicalName(name))
+	for i := 1; i <= len(labels); i++ {
+		wildcard := "*." + dns.Fqdn(strings.Join(labels[i:], "."))
+		if i == len(labels) {
+			wildcard = "*."
+		}
+		for _, store := range stores {
+			if _, exists := store.names[wildcard]; exists {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package resolver
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type RecordEntry struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type RecordChange struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+type RecordDiff struct {
+	Source    string         `json:"source"`
+	Timestamp time.Time      `json:"timestamp"`
+	Added     []RecordEntry  `json:"added"`
+	Removed   []RecordEntry  `json:"removed"`
+	Changed   []RecordChange `json:"changed"`
+}
+
+func (d RecordDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+type recordKey struct {
+	name  string
+	qtype uint16
+}
+
+func (r *LocalResolver) OnChange(fn func(RecordDiff)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+// Snapshot returns the effective record set keyed by "TYPE name", with the
+// record sources combined by their precedence.
+func (r *LocalResolver) Snapshot() map[string]RecordEntry {
+	snapshot := make(map[string]RecordEntry)
+
+	v := r.view()
+	for _, key := range v.keys() {
+		qtype := dns.TypeToString[key.qtype]
+		rrset := v.rrset(key)
+		values := make([]string, 0, len(rrset))
+		for _, rr := range rrset {
+			values = append(values, strings.TrimPrefix(rr.String(), rr.Header().String()))
+		}
+
+		snapshot[qtype+" "+key.name] = RecordEntry{
+			Name:  key.name,
+			Type:  qtype,
+			Value: strings.Join(values, "; "),
+		}
+	}
+
+	return snapshot
+}
+
+// update applies fn and notifies change listeners with the resulting diff
+// of the effective record set.
+func (r *LocalResolver) update(source string, fn func()) {
+	before := r.Snapshot()
+	fn()
+	after := r.Snapshot()
+
+	diff := DiffSnapshots(before, after)
+	if diff.Empty() {
+		return
+	}
+	diff.Source = source
+	diff.Timestamp = time.Now()
+
+	r.mu.RLock()
+	listeners := make([]func(RecordDiff), len(r.listeners))
+	copy(listeners, r.listeners)
+	r.mu.RUnlock()
+
+	for _, fn := range listeners {
+		fn(diff)
+	}
+}
+
+func DiffSnapshots(before, after map[string]RecordEntry) RecordDiff {
+	diff := RecordDiff{
+		Added:   []RecordEntry{},
+		Removed: []RecordEntry{},
+		Changed: []RecordChange{},
+	}
+
+	for id, entry := range after {
+		old, existed := before[id]
+		switch {
+		case !existed:
+			diff.Added = append(diff.Added, entry)
+		case old.Value != entry.Value:
+			diff.Changed = append(diff.Changed, RecordChange{
+				Name: entry.Name,
+				Type: entry.Type,
+				Old:  old.Value,
+				New:  entry.Value,
+			})
+		}
+	}
+
+	for id, entry := range before {
+		if _, exists := after[id]; !exists {
+			diff.Removed = append(diff.Removed, entry)
+		}
+	}
+
+	sortEntries(diff.Added)
+	sortEntries(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		if diff.Changed[i].Name != diff.Changed[j].Name {
+			return diff.Changed[i].Name < diff.Changed[j].Name
+		}
+		return diff.Changed[i].Type < diff.Changed[j].Type
+	})
+
+	return diff
+}
+
+func sortEntries(entries []RecordEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Type < entries[j].Type
+	})
+}
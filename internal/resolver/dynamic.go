@@ -0,0 +1,152 @@
+package resolver
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// RecordBackend persists the dynamic records.
+type RecordBackend interface {
+	Load() ([]dns.RR, error)
+	Update(remove, add []dns.RR) error
+	Close() error
+}
+
+// SetBackend loads the dynamic records from backend and writes later changes
+// through to it. Without a backend dynamic records are kept in memory only.
+func (r *LocalResolver) SetBackend(backend RecordBackend) error {
+	r.mu.Lock()
+	r.backend = backend
+	r.mu.Unlock()
+	return r.ReloadDynamic()
+}
+
+// ReloadDynamic re-reads the dynamic records from the backend, e.g. after
+// they were edited outside the server.
+func (r *LocalResolver) ReloadDynamic() error {
+	r.mu.RLock()
+	backend := r.backend
+	r.mu.RUnlock()
+	if backend == nil {
+		return nil
+	}
+
+	records, err := backend.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load records: %w", err)
+	}
+	store := NewStore()
+	for _, rr := range records {
+		store.Add(rr)
+	}
+
+	r.update("store", func() {
+		r.mu.Lock()
+		r.dynamic = store
+		r.mu.Unlock()
+	})
+	return nil
+}
+
+// DynamicRecords returns copies of the records managed at runtime, e.g. by
+// external-dns or the admin API. They survive config reloads, and restarts
+// when a backend is set.
+func (r *LocalResolver) DynamicRecords() []dns.RR {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var records []dns.RR
+	for _, key := range r.dynamic.keys() {
+		for _, rr := range r.dynamic.names[dns.Fqdn(key.name)][key.qtype] {
+			records = append(records, dns.Copy(rr))
+		}
+	}
+	return records
+}
+
+// UpdateDynamic removes and then adds runtime-managed records in one step,
+// notifying change listeners once. Nothing changes if an added record
+// conflicts with another source under the error policy or the backend
+// rejects the update.
+func (r *LocalResolver) UpdateDynamic(source string, remove, add []dns.RR) error {
+	var err error
+	r.update(source, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		store := r.dynamic.clone()
+		for _, rr := range remove {
+			store.Remove(rr)
+		}
+		for _, rr := range add {
+			store.Remove(rr)
+			store.Add(dns.Copy(rr))
+		}
+
+		if err = r.viewLocked().checkDynamic(store, add); err != nil {
+			return
+		}
+		if r.backend != nil {
+			if err = r.backend.Update(remove, add); err != nil {
+				return
+			}
+		}
+		r.dynamic = store
+	})
+	return err
+}
+
+// Remove deletes the records equal to rr, ignoring TTL and owner case.
+func (s *Store) Remove(rr dns.RR) {
+	hdr := rr.Header()
+	name := config.CanonicalName(hdr.Name)
+	types := s.names[name]
+	if len(types[hdr.Rrtype]) == 0 {
+		return
+	}
+
+	kept := types[hdr.Rrtype][:0]
+	for _, existing := range types[hdr.Rrtype] {
+		if !sameRdata(existing, rr) {
+			kept = append(kept, existing)
+		}
+	}
+
+	switch {
+	case len(kept) > 0:
+		types[hdr.Rrtype] = kept
+	case len(types) > 1:
+		delete(types, hdr.Rrtype)
+	default:
+		delete(s.names, name)
+		forAncestors(name, func(ancestor string) {
+			if s.ancestors[ancestor]--; s.ancestors[ancestor] == 0 {
+				delete(s.ancestors, ancestor)
+			}
+		})
+	}
+}
+
+func sameRdata(a, b dns.RR) bool {
+	a, b = dns.Copy(a), dns.Copy(b)
+	a.Header().Name = strings.ToLower(a.Header().Name)
+	b.Header().Name = strings.ToLower(b.Header().Name)
+	return dns.IsDuplicate(a, b)
+}
+
+func (s *Store) clone() *Store {
+	clone := NewStore()
+	for name, types := range s.names {
+		clone.names[name] = make(map[uint16][]dns.RR, len(types))
+		for qtype, rrs := range types {
+			clone.names[name][qtype] = append([]dns.RR(nil), rrs...)
+		}
+	}
+	clone.ancestors = maps.Clone(s.ancestors)
+	return clone
+}
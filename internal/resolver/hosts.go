@@ -0,0 +1,190 @@
+package resolver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dns-server/internal/config"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// ParseHosts reads a file in /etc/hosts format into A and AAAA records.
+// Every name on a line, canonical name and aliases alike, gets the line's
+// address. Lines whose address does not parse are skipped.
+func ParseHosts(r io.Reader) ([]dns.RR, error) {
+	var records []dns.RR
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		// zone indexes like fe80::1%eth0 mean nothing to remote clients
+		addr, _, _ := strings.Cut(fields[0], "%")
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			if _, ok := dns.IsDomainName(name); !ok {
+				continue
+			}
+			hdr := dns.RR_Header{Name: config.CanonicalName(name), Class: dns.ClassINET, Ttl: defaultTTL}
+			if ip4 := ip.To4(); ip4 != nil {
+				hdr.Rrtype = dns.TypeA
+				records = append(records, &dns.A{Hdr: hdr, A: ip4})
+			} else {
+				hdr.Rrtype = dns.TypeAAAA
+				records = append(records, &dns.AAAA{Hdr: hdr, AAAA: ip})
+			}
+		}
+	}
+	return records, scanner.Err()
+}
+
+// loadHosts adds the addresses of the hosts files to store. Names and types
+// the store already holds keep their records, so [records] entries win over
+// hosts files, and earlier files win over later ones.
+func loadHosts(store *Store, paths []string) error {
+	var errs []error
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("hosts file: %w", err))
+			continue
+		}
+		records, err := ParseHosts(f)
+		f.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("hosts file %s: %w", path, err))
+			continue
+		}
+
+		held := make(map[recordKey]bool)
+		for _, rr := range records {
+			hdr := rr.Header()
+			key := recordKey{name: strings.ToLower(hdr.Name), qtype: hdr.Rrtype}
+			if _, seen := held[key]; !seen {
+				held[key] = len(store.names[key.name][key.qtype]) > 0
+			}
+			if held[key] {
+				continue
+			}
+			store.Remove(rr)
+			store.Add(rr)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReloadHosts re-reads the hosts files of the base records, keeping the
+// rest of them as last loaded.
+func (r *LocalResolver) ReloadHosts() {
+	r.mu.RLock()
+	base := r.base
+	r.mu.RUnlock()
+
+	store := r.loadStore("hosts", base)
+	r.update("hosts", func() {
+		r.mu.Lock()
+		r.records = store
+		r.mu.Unlock()
+	})
+}
+
+func (r *LocalResolver) hostsFiles() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	paths := make([]string, 0, len(r.base.HostsFiles))
+	for _, path := range r.base.HostsFiles {
+		if abs, err := filepath.Abs(path); err == nil {
+			paths = append(paths, abs)
+		}
+	}
+	return paths
+}
+
+// WatchHosts reloads the base records whenever one of their hosts files is
+// written, created, replaced or removed, until ctx is canceled. It watches
+// the directories holding the files, so files that are replaced by rename,
+// as most editors and hosts managers do, or that do not exist yet are
+// picked up too.
+func (r *LocalResolver) WatchHosts(ctx context.Context) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		r.logger.WithError(err).Warn("failed to watch hosts files")
+		return
+	}
+	defer watcher.Close()
+
+	watched := make(map[string]bool)
+	rewatch := func() map[string]bool {
+		files := make(map[string]bool)
+		dirs := make(map[string]bool)
+		for _, path := range r.hostsFiles() {
+			files[path] = true
+			dirs[filepath.Dir(path)] = true
+		}
+		for dir := range watched {
+			if !dirs[dir] {
+				watcher.Remove(dir)
+				delete(watched, dir)
+			}
+		}
+		for dir := range dirs {
+			if watched[dir] {
+				continue
+			}
+			if err := watcher.Add(dir); err != nil {
+				r.logger.WithFields(logrus.Fields{
+					"directory": dir,
+					"error":     err,
+				}).Warn("failed to watch hosts files")
+				continue
+			}
+			watched[dir] = true
+		}
+		return files
+	}
+	files := rewatch()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			r.logger.WithError(err).Warn("hosts file watch error")
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !files[filepath.Clean(event.Name)] || event.Op == fsnotify.Chmod {
+				continue
+			}
+			r.logger.WithFields(logrus.Fields{
+				"file": event.Name,
+				"op":   event.Op.String(),
+			}).Debug("hosts file changed")
+			r.ReloadHosts()
+			files = rewatch()
+		case <-r.hostsChanged:
+			files = rewatch()
+		}
+	}
+}
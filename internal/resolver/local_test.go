@@ -0,0 +1,47 @@
+package resolver
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// BenchmarkLocalResolve measures answering from a thousand local names: a
+// name held, one covered by a wildcard and a name left to the caller.
+func BenchmarkLocalResolve(b *testing.B) {
+	records := &config.RecordsConfig{
+		A: map[string]string{"*.wild.example.test": "192.0.2.2"},
+	}
+	for i := range 1000 {
+		records.A[fmt.Sprintf("host%d.example.test", i)] = fmt.Sprintf("192.0.2.%d", i%250+1)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	r := NewLocalResolver(records, logger)
+
+	tests := []struct {
+		name     string
+		qname    string
+		answered bool
+	}{
+		{"exact", "host500.example.test.", true},
+		{"wildcard", "any.wild.example.test.", true},
+		{"miss", "www.example.com.", false},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			question := dns.Question{Name: tt.qname, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, ok := r.Resolve(question); ok != tt.answered {
+					b.Fatalf("answered = %v, want %v", ok, tt.answered)
+				}
+			}
+		})
+	}
+}
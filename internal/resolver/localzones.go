@@ -0,0 +1,81 @@
+package resolver
+
+import (
+	"strings"
+
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// loopback is what localhost names resolve to (RFC 6761 section 6.3), and
+// the loopback addresses back to.
+var loopback = []string{
+	"localhost. A 127.0.0.1",
+	"localhost. AAAA ::1",
+	"*.localhost. A 127.0.0.1",
+	"*.localhost. AAAA ::1",
+	"1.0.0.127.in-addr.arpa. PTR localhost.",
+	"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa. PTR localhost.",
+}
+
+// SetLocalZones sets the policies of zones, as config.LocalZones returns
+// them, for names in them that no source holds. Static and nxdomain zones
+// get an SOA made up for them, consulted after every other source, for
+// their negative answers. Static zones holding localhost names get
+// loopback addresses.
+func (r *LocalResolver) SetLocalZones(zones map[string]string) {
+	store := NewStore()
+	for zone, policy := range zones {
+		if policy == config.LocalZoneStatic || policy == config.LocalZoneNXDomain {
+			store.Add(ownedSOA(zone))
+		}
+	}
+	for _, data := range loopback {
+		rr, _ := dns.NewRR(data)
+		rr.Header().Ttl = defaultTTL
+		if _, policy := policyOf(zones, rr.Header().Name); policy == config.LocalZoneStatic {
+			store.Add(rr)
+		}
+	}
+
+	r.mu.Lock()
+	r.zones = zones
+	r.special = store
+	r.mu.Unlock()
+}
+
+// Policy returns the local zone policy that applies to name, or "" when
+// none does. A zone with an SOA among the records that is closer to name
+// than the zone of the policy makes it static.
+func (r *LocalResolver) Policy(name string) string {
+	_, policy := r.view().policy(name)
+	return policy
+}
+
+func (v *view) policy(name string) (zone, policy string) {
+	zone, policy = policyOf(v.zones, name)
+	if policy == "" {
+		return "", ""
+	}
+	if soa := v.zoneSOA(name); soa != nil && dns.CountLabel(soa.Header().Name) > dns.CountLabel(zone) {
+		return soa.Header().Name, config.LocalZoneStatic
+	}
+	return zone, policy
+}
+
+// policyOf returns the closest zone of zones at or above name and its
+// policy.
+func policyOf(zones map[string]string, name string) (zone, policy string) {
+	if len(zones) == 0 {
+		return "", ""
+	}
+	labels := dns.SplitDomainName(config.CanonicalName(name))
+	for i := range labels {
+		zone := dns.Fqdn(strings.Join(labels[i:], "."))
+		if policy, exists := zones[zone]; exists {
+			return zone, policy
+		}
+	}
+	return "", ""
+}
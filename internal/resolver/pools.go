@@ -0,0 +1,126 @@
+package resolver
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"dns-server/internal/config"
+	"dns-server/internal/health"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// Pools answers the names of [pools] with the addresses the health checker
+// currently considers healthy.
+type Pools struct {
+	mu      sync.RWMutex
+	pools   map[string]config.PoolConfig
+	checker *health.Checker
+	logger  *logrus.Logger
+}
+
+func NewPools(pools map[string]config.PoolConfig, checker *health.Checker, logger *logrus.Logger) *Pools {
+	p := &Pools{checker: checker, logger: logger}
+	p.Reload(pools)
+	return p
+}
+
+// Reload replaces the pools and the targets the checker probes.
+func (p *Pools) Reload(pools map[string]config.PoolConfig) {
+	byName := make(map[string]config.PoolConfig, len(pools))
+	for name, pool := range pools {
+		byName[strings.TrimSuffix(config.CanonicalName(name), ".")] = pool
+	}
+	p.checker.SetPools(byName)
+
+	p.mu.Lock()
+	p.pools = byName
+	p.mu.Unlock()
+}
+
+func (p *Pools) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.pools)
+}
+
+// Resolve answers A and AAAA queries for a pool name. It leaves the query
+// to other sources when the pool has no address of the queried family.
+func (p *Pools) Resolve(question dns.Question) (*dns.Msg, bool) {
+	if question.Qtype != dns.TypeA && question.Qtype != dns.TypeAAAA {
+		return nil, false
+	}
+
+	poolName := strings.TrimSuffix(strings.ToLower(question.Name), ".")
+	p.mu.RLock()
+	pool, ok := p.pools[poolName]
+	p.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	addresses, state := p.choose(poolName, pool, question.Qtype)
+	if len(addresses) == 0 {
+		return nil, false
+	}
+
+	response := &dns.Msg{}
+	response.SetReply(&dns.Msg{Question: []dns.Question{question}})
+	response.Authoritative = true
+	response.RecursionAvailable = false
+
+	hdr := dns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: dns.ClassINET, Ttl: uint32(pool.TTL.Seconds())}
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if question.Qtype == dns.TypeA {
+			response.Answer = append(response.Answer, &dns.A{Hdr: hdr, A: ip.To4()})
+		} else {
+			response.Answer = append(response.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"pool":    poolName,
+		"qtype":   dns.TypeToString[question.Qtype],
+		"answers": len(addresses),
+		"state":   state,
+	}).Debug("pool resolved")
+
+	return response, true
+}
+
+// choose returns the healthy addresses of the queried family, the healthy
+// failover addresses when there are none, and every address when nothing
+// is healthy.
+func (p *Pools) choose(pool string, cfg config.PoolConfig, qtype uint16) ([]string, string) {
+	primary := family(cfg.Addresses, qtype)
+	if healthy := p.healthy(pool, primary); len(healthy) > 0 {
+		return healthy, "healthy"
+	}
+	if healthy := p.healthy(pool, family(cfg.Failover, qtype)); len(healthy) > 0 {
+		return healthy, "failover"
+	}
+	return primary, "all-down"
+}
+
+func (p *Pools) healthy(pool string, addresses []string) []string {
+	var healthy []string
+	for _, address := range addresses {
+		if p.checker.Healthy(health.Key(pool, address)) {
+			healthy = append(healthy, address)
+		}
+	}
+	return healthy
+}
+
+func family(addresses []string, qtype uint16) []string {
+	var out []string
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil && (ip.To4() != nil) == (qtype == dns.TypeA) {
+			out = append(out, address)
+		}
+	}
+	return out
+}
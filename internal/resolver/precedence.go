@@ -0,0 +1,277 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+var ErrConflict = errors.New("conflicting records")
+
+type source struct {
+	name  string
+	store *Store
+}
+
+// Conflict is a name and type held by more than one record source with
+// different data.
+type Conflict struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Sources []string `json:"sources"`
+	Policy  string   `json:"policy"`
+}
+
+// SetPrecedence sets the order in which record sources are consulted and how
+// conflicts between them are resolved. Without it enabled profiles shadow
+// dynamic records, which shadow the base records.
+func (r *LocalResolver) SetPrecedence(precedence *config.PrecedenceConfig) {
+	r.update("precedence", func() {
+		r.mu.Lock()
+		r.precedence = precedence
+		r.mu.Unlock()
+	})
+}
+
+// view captures the sources and precedence under one lock, so a lookup sees
+// a consistent record set.
+type view struct {
+	profiles   []source
+	dynamic    *Store
+	records    *Store
+	special    *Store
+	zones      map[string]string
+	precedence *config.PrecedenceConfig
+}
+
+func (r *LocalResolver) view() *view {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.viewLocked()
+}
+
+func (r *LocalResolver) viewLocked() *view {
+	v := &view{dynamic: r.dynamic, records: r.records, special: r.special, zones: r.zones, precedence: r.precedence}
+	for _, name := range sortedProfiles(r.profiles) {
+		if p := r.profiles[name]; p.enabled {
+			v.profiles = append(v.profiles, source{name: "profile:" + name, store: p.records})
+		}
+	}
+	return v
+}
+
+// sources returns the stores for name in precedence order and the conflict
+// policy that applies to it.
+func (v *view) sources(name string) ([]source, string) {
+	order, conflict := config.DefaultSourceOrder, config.ConflictFirstWins
+	if v.precedence != nil {
+		order, conflict = v.precedence.Policy(name)
+	}
+
+	sources := make([]source, 0, len(v.profiles)+2)
+	for _, kind := range order {
+		switch kind {
+		case config.SourceProfiles:
+			sources = append(sources, v.profiles...)
+		case config.SourceDynamic:
+			sources = append(sources, source{name: config.SourceDynamic, store: v.dynamic})
+		case config.SourceConfig:
+			sources = append(sources, source{name: config.SourceConfig, store: v.records})
+		}
+	}
+	return sources, conflict
+}
+
+// lookup answers name and qtype from the first source holding it, or with
+// the union of every source's RRset under the merge policy. Exact matches
+// are merged only with exact matches and wildcards only with the same
+// wildcard. The made-up records of local zones are consulted only when no
+// source holds it.
+func (v *view) lookup(name string, qtype uint16) (rrset []dns.RR, wildcard string) {
+	sources, conflict := v.sources(name)
+
+	found := false
+	for _, src := range sources {
+		rrs, match := src.store.Lookup(name, qtype)
+		if len(rrs) == 0 {
+			continue
+		}
+		if !found {
+			rrset, wildcard, found = rrs, match, true
+			if conflict != config.ConflictMerge {
+				break
+			}
+			continue
+		}
+		if match == wildcard {
+			rrset = mergeRRs(rrset, rrs)
+		}
+	}
+	if !found && v.special != nil {
+		return v.special.Lookup(name, qtype)
+	}
+	return rrset, wildcard
+}
+
+func mergeRRs(rrset, rrs []dns.RR) []dns.RR {
+	for _, rr := range rrs {
+		if !containsRR(rrset, rr) {
+			rrset = append(rrset, rr)
+		}
+	}
+	return rrset
+}
+
+func containsRR(rrset []dns.RR, rr dns.RR) bool {
+	for _, existing := range rrset {
+		if sameRdata(existing, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+// keys lists every name and type held by any source, sorted.
+func (v *view) keys() []recordKey {
+	seen := make(map[recordKey]bool)
+	var keys []recordKey
+	for _, store := range v.stores() {
+		for _, key := range store.keys() {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].qtype < keys[j].qtype
+	})
+	return keys
+}
+
+func (v *view) stores() []*Store {
+	stores := make([]*Store, 0, len(v.profiles)+2)
+	for _, p := range v.profiles {
+		stores = append(stores, p.store)
+	}
+	return append(stores, v.dynamic, v.records)
+}
+
+// rrset returns copies of the effective records owned by key itself,
+// ignoring wildcards.
+func (v *view) rrset(key recordKey) []dns.RR {
+	owner := dns.Fqdn(key.name)
+	sources, conflict := v.sources(owner)
+
+	var rrset []dns.RR
+	for _, src := range sources {
+		rrs := src.store.names[owner][key.qtype]
+		if len(rrs) == 0 {
+			continue
+		}
+		rrset = mergeRRs(rrset, copyRRs(rrs, owner))
+		if conflict != config.ConflictMerge {
+			break
+		}
+	}
+	if len(rrset) == 0 && v.special != nil {
+		return copyRRs(v.special.names[owner][key.qtype], owner)
+	}
+	return rrset
+}
+
+// Conflicts lists the names and types that enabled profiles, dynamic and
+// base records disagree on.
+func (r *LocalResolver) Conflicts() []Conflict {
+	v := r.view()
+
+	conflicts := []Conflict{}
+	for _, key := range v.keys() {
+		owner := dns.Fqdn(key.name)
+		sources, conflict := v.sources(owner)
+		if c, found := conflictAt(sources, owner, key.qtype, nil); found {
+			c.Policy = conflict
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts
+}
+
+// conflictAt reports whether the sources, with extra standing in for the
+// dynamic records when set, hold different RRsets for owner and qtype.
+func conflictAt(sources []source, owner string, qtype uint16, extra *Store) (Conflict, bool) {
+	var first []dns.RR
+	var holders []string
+	differ := false
+	for _, src := range sources {
+		store := src.store
+		if extra != nil && src.name == config.SourceDynamic {
+			store = extra
+		}
+		rrs := store.names[owner][qtype]
+		if len(rrs) == 0 {
+			continue
+		}
+		holders = append(holders, src.name)
+		if first == nil {
+			first = rrs
+		} else if !sameRRset(first, rrs) {
+			differ = true
+		}
+	}
+	if !differ {
+		return Conflict{}, false
+	}
+	return Conflict{
+		Name:    strings.TrimSuffix(owner, "."),
+		Type:    dns.TypeToString[qtype],
+		Sources: holders,
+	}, true
+}
+
+func sameRRset(a, b []dns.RR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, rr := range a {
+		if !containsRR(b, rr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDynamic rejects dynamic records that conflict with another source
+// for names under the error policy.
+func (v *view) checkDynamic(dynamic *Store, changed []dns.RR) error {
+	for _, rr := range changed {
+		hdr := rr.Header()
+		owner := strings.ToLower(dns.Fqdn(hdr.Name))
+		sources, conflict := v.sources(owner)
+		if conflict != config.ConflictError {
+			continue
+		}
+		if c, found := conflictAt(sources, owner, hdr.Rrtype, dynamic); found {
+			return fmt.Errorf("%w: %s %s differs between %s", ErrConflict, c.Type, c.Name, strings.Join(c.Sources, ", "))
+		}
+	}
+	return nil
+}
+
+func sortedProfiles(profiles map[string]*profile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,97 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"dns-server/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrUnknownProfile = errors.New("unknown profile")
+
+type profile struct {
+	name    string
+	enabled bool
+	records *Store
+}
+
+type ProfileStatus struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Records int    `json:"records"`
+}
+
+func (r *LocalResolver) SetProfiles(profiles map[string]config.ProfileConfig) {
+	loaded := r.loadProfiles(profiles)
+
+	r.mu.Lock()
+	r.profiles = loaded
+	r.mu.Unlock()
+}
+
+func (r *LocalResolver) loadProfiles(profiles map[string]config.ProfileConfig) map[string]*profile {
+	loaded := make(map[string]*profile, len(profiles))
+	for name, cfg := range profiles {
+		loaded[name] = &profile{
+			name:    name,
+			enabled: cfg.Enabled,
+			records: r.loadStore("profile:"+name, &cfg.Records),
+		}
+	}
+
+	return loaded
+}
+
+func (r *LocalResolver) Profiles() []ProfileStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	statuses := make([]ProfileStatus, 0, len(r.profiles))
+	for _, p := range r.profiles {
+		statuses = append(statuses, ProfileStatus{
+			Name:    p.name,
+			Enabled: p.enabled,
+			Records: p.records.Len(),
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+func (r *LocalResolver) SetProfileEnabled(name string, enabled bool) error {
+	r.mu.RLock()
+	p, exists := r.profiles[name]
+	r.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+	}
+
+	var changed bool
+	r.update("profile:"+name, func() {
+		r.mu.Lock()
+		changed = p.enabled != enabled
+		p.enabled = enabled
+		r.mu.Unlock()
+	})
+
+	if changed {
+		r.logger.WithFields(logrus.Fields{
+			"profile": name,
+			"enabled": enabled,
+		}).Info("local record profile toggled")
+	}
+
+	return nil
+}
+
+func CountRecords(records *config.RecordsConfig) int {
+	store, _ := LoadStore(records)
+	return store.Len()
+}
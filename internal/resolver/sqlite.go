@@ -0,0 +1,105 @@
+package resolver
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const sqliteDriver = "sqlite"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS records (
+	name TEXT NOT NULL,
+	type TEXT NOT NULL,
+	ttl  INTEGER NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (name, type, data)
+);
+`
+
+// SQLiteBackend keeps dynamic records in a SQLite table with one row per
+// record: owner name, type, TTL and rdata in presentation format. Rows can be
+// edited with any SQLite client and picked up with a reload. The driver is
+// not linked by default; build with -tags sqlite to include it.
+type SQLiteBackend struct {
+	db *sql.DB
+}
+
+func NewSQLiteBackend(path string) (*SQLiteBackend, error) {
+	if !slices.Contains(sql.Drivers(), sqliteDriver) {
+		return nil, fmt.Errorf("sqlite record store is not available in this build (rebuild with -tags sqlite)")
+	}
+
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open record database %s: %w", path, err)
+	}
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create record schema: %w", err)
+	}
+	return &SQLiteBackend{db: db}, nil
+}
+
+func (b *SQLiteBackend) Load() ([]dns.RR, error) {
+	rows, err := b.db.Query(`SELECT name, type, ttl, data FROM records ORDER BY name, type`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []dns.RR
+	for rows.Next() {
+		var name, typeName, data string
+		var ttl uint32
+		if err := rows.Scan(&name, &typeName, &ttl, &data); err != nil {
+			return nil, err
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(name), ttl, typeName, data))
+		if err != nil || rr == nil {
+			return nil, fmt.Errorf("invalid record %s %s %q: %w", name, typeName, data, err)
+		}
+		records = append(records, rr)
+	}
+	return records, rows.Err()
+}
+
+func (b *SQLiteBackend) Update(remove, add []dns.RR) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, rr := range remove {
+		name, typeName, _, data := row(rr)
+		if _, err := tx.Exec(`DELETE FROM records WHERE name = ? AND type = ? AND data = ?`, name, typeName, data); err != nil {
+			return err
+		}
+	}
+	for _, rr := range add {
+		name, typeName, ttl, data := row(rr)
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO records VALUES (?, ?, ?, ?)`, name, typeName, ttl, data); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (b *SQLiteBackend) Close() error {
+	return b.db.Close()
+}
+
+func row(rr dns.RR) (name, typeName string, ttl uint32, data string) {
+	hdr := rr.Header()
+	name = strings.ToLower(dns.Fqdn(hdr.Name))
+	typeName = dns.TypeToString[hdr.Rrtype]
+	data = strings.TrimPrefix(rr.String(), hdr.String())
+	return name, typeName, hdr.Ttl, data
+}
@@ -0,0 +1,5 @@
+//go:build sqlite
+
+package resolver
+
+import _ "modernc.org/sqlite"
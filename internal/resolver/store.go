@@ -0,0 +1,268 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+const defaultTTL = 300
+
+// Store indexes records by canonical owner name (see config.CanonicalName)
+// and type. Every type is
+// held as a generic RRset, so lookups, wildcards and diffs need no per-type
+// code. Names above owners, short of the root, are counted in ancestors by
+// the owners below them, so empty non-terminals are found without a scan.
+type Store struct {
+	names     map[string]map[uint16][]dns.RR
+	ancestors map[string]int
+}
+
+func NewStore() *Store {
+	return &Store{
+		names:     make(map[string]map[uint16][]dns.RR),
+		ancestors: make(map[string]int),
+	}
+}
+
+// LoadStore builds a store from the per-type maps of a records config. The
+// record type is taken from each field's toml tag and the value is rendered
+// into presentation format, so adding a type to RecordsConfig only needs an
+// rdata case for its value type. Entries that fail to parse are skipped and
+// reported in the returned error. The addresses of the hosts files are
+// added last, for names and types no map entry holds.
+func LoadStore(records *config.RecordsConfig) (*Store, error) {
+	store := NewStore()
+	var errs []error
+
+	v := reflect.ValueOf(records).Elem()
+	t := v.Type()
+	for i := range t.NumField() {
+		typeName := t.Field(i).Tag.Get("toml")
+		if _, ok := dns.StringToType[typeName]; !ok || v.Field(i).Kind() != reflect.Map {
+			continue
+		}
+
+		iter := v.Field(i).MapRange()
+		for iter.Next() {
+			name := iter.Key().String()
+			for _, data := range rdata(typeName, iter.Value().Interface()) {
+				rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", config.CanonicalName(name), defaultTTL, typeName, data))
+				if err != nil || rr == nil {
+					errs = append(errs, fmt.Errorf("%s %s: %w", typeName, name, err))
+					continue
+				}
+				store.Add(rr)
+			}
+		}
+	}
+
+	if err := loadHosts(store, records.HostsFiles); err != nil {
+		errs = append(errs, err)
+	}
+	for _, zone := range records.Zones {
+		owner := config.CanonicalName(zone)
+		if len(store.names[owner][dns.TypeSOA]) == 0 {
+			store.Add(ownedSOA(owner))
+		}
+	}
+	return store, errors.Join(errs...)
+}
+
+// ownedSOA is the SOA of a zone owned without one configured, in the form
+// RFC 6303 gives locally served zones.
+func ownedSOA(zone string) dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: defaultTTL},
+		Ns:      zone,
+		Mbox:    "nobody.invalid.",
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   1200,
+		Expire:  604800,
+		Minttl:  defaultTTL,
+	}
+}
+
+func rdata(typeName string, value any) []string {
+	switch v := value.(type) {
+	case string:
+		if typeName == "CNAME" {
+			return []string{config.CanonicalName(v)}
+		}
+		return []string{v}
+	case config.TXTRecord:
+		var strs []string
+		for _, str := range v {
+			for _, chunk := range splitTXT(str) {
+				strs = append(strs, quote(chunk))
+			}
+		}
+		return []string{strings.Join(strs, " ")}
+	case []string:
+		if typeName == "NS" {
+			names := make([]string, len(v))
+			for i, name := range v {
+				names[i] = config.CanonicalName(name)
+			}
+			return names
+		}
+		return v
+	case config.SOARecord:
+		return []string{fmt.Sprintf("%s %s %d %d %d %d %d", config.CanonicalName(v.MName), config.CanonicalName(v.RName), v.Serial, v.Refresh, v.Retry, v.Expire, v.Minimum)}
+	case config.MXRecord:
+		return []string{fmt.Sprintf("%d %s", v.Priority, config.CanonicalName(v.Target))}
+	case config.HTTPSRecord:
+		return []string{strings.TrimSpace(fmt.Sprintf("%d %s %s", v.Priority, config.CanonicalName(v.Target), v.Params))}
+	case config.SVCBRecord:
+		return []string{strings.TrimSpace(fmt.Sprintf("%d %s %s", v.Priority, config.CanonicalName(v.Target), v.Params))}
+	case config.CAARecord:
+		return []string{fmt.Sprintf("%d %s %s", v.Flag, v.Tag, quote(v.Value))}
+	case config.SRVRecord:
+		return []string{fmt.Sprintf("%d %d %d %s", v.Priority, v.Weight, v.Port, config.CanonicalName(v.Target))}
+	case config.DSRecord:
+		return []string{fmt.Sprintf("%d %d %d %s", v.KeyTag, v.Algorithm, v.DigestType, v.Digest)}
+	case config.DNSKEYRecord:
+		return []string{fmt.Sprintf("%d %d %d %s", v.Flags, v.Protocol, v.Algorithm, v.PublicKey)}
+	case config.URIRecord:
+		return []string{fmt.Sprintf("%d %d %s", v.Priority, v.Weight, quote(v.Target))}
+	case config.NAPTRRecord:
+		return []string{fmt.Sprintf("%d %d %s %s %s %s", v.Order, v.Preference, quote(v.Flags), quote(v.Service), quote(v.Regexp), config.CanonicalName(v.Replacement))}
+	case config.SSHFPRecord:
+		return []string{fmt.Sprintf("%d %d %s", v.Algorithm, v.Type, v.Fingerprint)}
+	case config.TLSARecord:
+		return []string{fmt.Sprintf("%d %d %d %s", v.Usage, v.Selector, v.MatchingType, v.Certificate)}
+	case config.SMIMEARecord:
+		return []string{fmt.Sprintf("%d %d %d %s", v.Usage, v.Selector, v.MatchingType, v.Certificate)}
+	case config.CERTRecord:
+		return []string{fmt.Sprintf("%d %d %d %s", v.Type, v.KeyTag, v.Algorithm, v.Certificate)}
+	}
+
+	return nil
+}
+
+// maxTXTString is the most a single string of a TXT record can hold.
+const maxTXTString = 255
+
+// splitTXT splits s into strings a TXT record can hold, between characters
+// where it can. Clients join them back together, as for DKIM keys and SPF
+// policies (RFC 7208 section 3.3).
+func splitTXT(s string) []string {
+	if len(s) <= maxTXTString {
+		return []string{s}
+	}
+	var chunks []string
+	for len(s) > maxTXTString {
+		end := maxTXTString
+		for end > maxTXTString-utf8.UTFMax && !utf8.RuneStart(s[end]) {
+			end--
+		}
+		chunks = append(chunks, s[:end])
+		s = s[end:]
+	}
+	return append(chunks, s)
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (s *Store) Add(rr dns.RR) {
+	hdr := rr.Header()
+	name := config.CanonicalName(hdr.Name)
+	hdr.Name = name
+
+	types, exists := s.names[name]
+	if !exists {
+		types = make(map[uint16][]dns.RR)
+		s.names[name] = types
+		forAncestors(name, func(ancestor string) {
+			s.ancestors[ancestor]++
+		})
+	}
+	types[hdr.Rrtype] = append(types[hdr.Rrtype], rr)
+}
+
+// forAncestors calls fn with each name above name, short of the root.
+func forAncestors(name string, fn func(string)) {
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		fn(name[off:])
+	}
+}
+
+// Lookup returns copies of the RRset for name and qtype, owned by name as
+// given. When there is no exact match the closest enclosing wildcard is
+// used and returned as wildcard.
+func (s *Store) Lookup(name string, qtype uint16) (rrset []dns.RR, wildcard string) {
+	owner := config.CanonicalName(name)
+
+	if rrs := s.names[owner][qtype]; len(rrs) > 0 {
+		return copyRRs(rrs, name), ""
+	}
+
+	labels := dns.SplitDomainName(owner)
+	for i := range labels {
+		candidate := "*." + dns.Fqdn(strings.Join(labels[i+1:], "."))
+		if i == len(labels)-1 {
+			candidate = "*."
+		}
+		if rrs := s.names[candidate][qtype]; len(rrs) > 0 {
+			return copyRRs(rrs, name), candidate
+		}
+	}
+
+	return nil, ""
+}
+
+func copyRRs(rrs []dns.RR, owner string) []dns.RR {
+	copies := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		copies[i] = dns.Copy(rr)
+		copies[i].Header().Name = owner
+	}
+	return copies
+}
+
+// keys lists every name and type held in the store, sorted.
+func (s *Store) keys() []recordKey {
+	var keys []recordKey
+	for name, types := range s.names {
+		for qtype := range types {
+			keys = append(keys, recordKey{name: strings.TrimSuffix(name, "."), qtype: qtype})
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].qtype < keys[j].qtype
+	})
+
+	return keys
+}
+
+func (s *Store) Len() int {
+	count := 0
+	for _, types := range s.names {
+		count += len(types)
+	}
+	return count
+}
+
+// HasName reports whether name owns records or is an empty non-terminal
+// above names that do, which separates NODATA from NXDOMAIN.
+func (s *Store) HasName(name string) bool {
+	name = config.CanonicalName(name)
+	if _, exists := s.names[name]; exists {
+		return true
+	}
+	return s.ancestors[name] > 0
+}
@@ -0,0 +1,52 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestStoreHasName(t *testing.T) {
+	s := NewStore()
+	deep, _ := dns.NewRR("a.b.c.example.test. 300 IN A 192.0.2.1")
+	other, _ := dns.NewRR("x.c.example.test. 300 IN A 192.0.2.2")
+	s.Add(deep)
+	s.Add(other)
+
+	check := func(when string, want map[string]bool) {
+		t.Helper()
+		for name, held := range want {
+			if got := s.HasName(name); got != held {
+				t.Errorf("%s: HasName(%s) = %v, want %v", when, name, got, held)
+			}
+		}
+	}
+
+	check("added", map[string]bool{
+		"a.b.c.example.test.": true,
+		"B.C.Example.Test.":   true,
+		"c.example.test.":     true,
+		"example.test.":       true,
+		"test.":               true,
+		"z.c.example.test.":   false,
+		"b.example.test.":     false,
+		".":                   false,
+	})
+
+	s.Remove(deep)
+	check("removed one", map[string]bool{
+		"a.b.c.example.test.": false,
+		"b.c.example.test.":   false,
+		"c.example.test.":     true,
+	})
+
+	clone := s.clone()
+	s.Remove(other)
+	check("removed all", map[string]bool{"c.example.test.": false, "test.": false})
+	if len(s.ancestors) != 0 {
+		t.Errorf("ancestors left after removing every owner: %v", s.ancestors)
+	}
+	if !clone.HasName("example.test.") {
+		t.Error("clone lost its empty non-terminals")
+	}
+}
@@ -0,0 +1,151 @@
+package resolver
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// Views answers from the records of the first view whose match_clients
+// holds the client's address. Names a view does not hold fall through to
+// the shared records.
+type Views struct {
+	mu     sync.RWMutex
+	views  []clientView
+	logger *logrus.Logger
+}
+
+type clientView struct {
+	name     string
+	networks []*net.IPNet
+	records  *Store
+}
+
+type ViewStatus struct {
+	Name         string   `json:"name"`
+	MatchClients []string `json:"match_clients"`
+	Records      int      `json:"records"`
+}
+
+func NewViews(views []config.ViewConfig, logger *logrus.Logger) *Views {
+	v := &Views{logger: logger}
+	v.Reload(views)
+	return v
+}
+
+// Reload replaces every view, e.g. after the config file was re-read.
+func (v *Views) Reload(views []config.ViewConfig) {
+	loaded := make([]clientView, 0, len(views))
+	for _, cfg := range views {
+		view := clientView{name: cfg.Name}
+		for _, client := range cfg.MatchClients {
+			network, err := config.ParseNetwork(client)
+			if err != nil {
+				v.logger.WithFields(logrus.Fields{
+					"view":  cfg.Name,
+					"error": err,
+				}).Warn("skipping invalid view client")
+				continue
+			}
+			view.networks = append(view.networks, network)
+		}
+
+		store, err := LoadStore(&cfg.Records)
+		if err != nil {
+			v.logger.WithFields(logrus.Fields{
+				"source": "view:" + cfg.Name,
+				"error":  err,
+			}).Warn("skipping invalid local records")
+		}
+		view.records = store
+		loaded = append(loaded, view)
+	}
+
+	v.mu.Lock()
+	v.views = loaded
+	v.mu.Unlock()
+}
+
+// Match returns the name of the view client belongs to, or "" when it is
+// in none.
+func (v *Views) Match(client net.IP) string {
+	if view := v.match(client); view != nil {
+		return view.name
+	}
+	return ""
+}
+
+func (v *Views) match(client net.IP) *clientView {
+	if client == nil {
+		return nil
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	for i := range v.views {
+		for _, network := range v.views[i].networks {
+			if network.Contains(client) {
+				return &v.views[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Resolve answers question from the view client belongs to, if that view
+// holds the name.
+func (v *Views) Resolve(client net.IP, question dns.Question) (*dns.Msg, bool) {
+	view := v.match(client)
+	if view == nil {
+		return nil, false
+	}
+
+	rrset, wildcard := view.records.Lookup(question.Name, question.Qtype)
+	if len(rrset) == 0 {
+		return nil, false
+	}
+
+	response := &dns.Msg{}
+	response.SetReply(&dns.Msg{Question: []dns.Question{question}})
+	response.Authoritative = true
+	response.RecursionAvailable = false
+	response.Answer = rrset
+
+	fields := logrus.Fields{
+		"view":    view.name,
+		"domain":  strings.ToLower(strings.TrimSuffix(question.Name, ".")),
+		"qtype":   dns.TypeToString[question.Qtype],
+		"answers": len(rrset),
+	}
+	if wildcard != "" {
+		fields["wildcard"] = strings.TrimSuffix(wildcard, ".")
+	}
+	v.logger.WithFields(fields).Debug("view record resolved")
+
+	return response, true
+}
+
+func (v *Views) Status() []ViewStatus {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	statuses := make([]ViewStatus, 0, len(v.views))
+	for _, view := range v.views {
+		clients := make([]string, 0, len(view.networks))
+		for _, network := range view.networks {
+			clients = append(clients, network.String())
+		}
+		statuses = append(statuses, ViewStatus{
+			Name:         view.name,
+			MatchClients: clients,
+			Records:      view.records.Len(),
+		})
+	}
+	return statuses
+}
@@ -0,0 +1,24 @@
+package resolver
+
+import (
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// ZoneRecords returns the effective records at or below zone, with the
+// record sources combined by their precedence. Owner names are lowercased
+// and fully qualified.
+func (r *LocalResolver) ZoneRecords(zone string) []dns.RR {
+	zone = config.CanonicalName(zone)
+	v := r.view()
+
+	var records []dns.RR
+	for _, key := range v.keys() {
+		if dns.IsSubDomain(zone, dns.Fqdn(key.name)) {
+			records = append(records, v.rrset(key)...)
+		}
+	}
+
+	return records
+}
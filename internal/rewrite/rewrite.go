@@ -0,0 +1,173 @@
+package rewrite
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/metrics"
+
+	"github.com/miekg/dns"
+)
+
+type rule struct {
+	name     string
+	wildcard bool
+	regex    *regexp.Regexp
+	qtype    uint16
+	qname    string
+	answer   string
+	minTTL   uint32
+	maxTTL   uint32
+}
+
+// Engine rewrites upstream traffic according to the configured rules: Query
+// renames a question before it is resolved and Response edits the answer
+// before it is cached and returned.
+type Engine struct {
+	rules   []rule
+	queries atomic.Uint64
+	answers atomic.Uint64
+	clamped atomic.Uint64
+}
+
+func New(cfg *config.RewriteConfig) (*Engine, error) {
+	e := &Engine{}
+	for i, r := range cfg.Rules {
+		compiled := rule{
+			qname:  strings.ToLower(r.QName),
+			answer: r.Answer,
+			minTTL: uint32(r.MinTTL / time.Second),
+			maxTTL: uint32(r.MaxTTL / time.Second),
+		}
+		if r.Regex != "" {
+			regex, err := regexp.Compile(r.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("rewrite rule %d: %w", i+1, err)
+			}
+			compiled.regex = regex
+		} else {
+			name := config.CanonicalName(r.Name)
+			compiled.wildcard = strings.HasPrefix(name, "*.")
+			compiled.name = strings.TrimPrefix(name, "*.")
+		}
+		if r.Type != "" {
+			compiled.qtype = dns.StringToType[strings.ToUpper(r.Type)]
+		}
+		if compiled.qname != "" && compiled.regex == nil {
+			compiled.qname = dns.Fqdn(compiled.qname)
+		}
+		e.rules = append(e.rules, compiled)
+	}
+	return e, nil
+}
+
+func (r *rule) matches(name string) bool {
+	switch {
+	case r.regex != nil:
+		return r.regex.MatchString(name)
+	case r.wildcard:
+		return strings.HasSuffix(name, "."+r.name)
+	default:
+		return name == r.name
+	}
+}
+
+// Query returns the question to resolve in place of question. The first
+// matching rule with a qname wins.
+func (e *Engine) Query(question dns.Question) dns.Question {
+	name := strings.ToLower(dns.Fqdn(question.Name))
+	for _, r := range e.rules {
+		if r.qname == "" || !r.matches(name) || (r.qtype != 0 && r.qtype != question.Qtype) {
+			continue
+		}
+
+		var renamed string
+		switch {
+		case r.regex != nil:
+			renamed = dns.Fqdn(r.regex.ReplaceAllString(name, r.qname))
+		case r.wildcard:
+			renamed = name[:len(name)-len(r.name)] + r.qname
+		default:
+			renamed = r.qname
+		}
+		if _, ok := dns.IsDomainName(renamed); !ok {
+			continue
+		}
+
+		e.queries.Add(1)
+		question.Name = renamed
+		return question
+	}
+	return question
+}
+
+// Response edits msg, the answer to resolved, so it answers original: names
+// changed by Query are restored, then answer and TTL rules apply.
+func (e *Engine) Response(original, resolved dns.Question, msg *dns.Msg) {
+	if !strings.EqualFold(original.Name, resolved.Name) {
+		for _, rr := range msg.Answer {
+			if hdr := rr.Header(); strings.EqualFold(hdr.Name, resolved.Name) {
+				hdr.Name = original.Name
+			}
+		}
+	}
+	msg.Question = []dns.Question{original}
+
+	name := strings.ToLower(dns.Fqdn(original.Name))
+	for _, r := range e.rules {
+		if !r.matches(name) {
+			continue
+		}
+		if r.answer != "" && r.qtype == original.Qtype {
+			e.replaceAnswers(&r, msg)
+		}
+		if r.minTTL > 0 || r.maxTTL > 0 {
+			e.clampTTLs(&r, msg)
+		}
+	}
+}
+
+func (e *Engine) replaceAnswers(r *rule, msg *dns.Msg) {
+	for i, rr := range msg.Answer {
+		hdr := rr.Header()
+		if hdr.Rrtype != r.qtype {
+			continue
+		}
+		replacement, err := dns.NewRR(fmt.Sprintf("%s %d %s %s %s",
+			hdr.Name, hdr.Ttl, dns.ClassToString[hdr.Class], dns.TypeToString[r.qtype], r.answer))
+		if err != nil || replacement == nil {
+			continue
+		}
+		msg.Answer[i] = replacement
+		e.answers.Add(1)
+	}
+}
+
+func (e *Engine) clampTTLs(r *rule, msg *dns.Msg) {
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT || (r.qtype != 0 && hdr.Rrtype != r.qtype) {
+				continue
+			}
+			ttl := max(hdr.Ttl, r.minTTL)
+			if r.maxTTL > 0 {
+				ttl = min(ttl, r.maxTTL)
+			}
+			if ttl != hdr.Ttl {
+				hdr.Ttl = ttl
+				e.clamped.Add(1)
+			}
+		}
+	}
+}
+
+func (e *Engine) Collect(w *metrics.Writer) {
+	w.Counter("dns_rewrites_total", "Rewrites applied to upstream traffic.", float64(e.queries.Load()), metrics.L("kind", "qname"))
+	w.Counter("dns_rewrites_total", "Rewrites applied to upstream traffic.", float64(e.answers.Load()), metrics.L("kind", "answer"))
+	w.Counter("dns_rewrites_total", "Rewrites applied to upstream traffic.", float64(e.clamped.Load()), metrics.L("kind", "ttl"))
+}
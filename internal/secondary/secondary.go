@@ -0,0 +1,152 @@
+package secondary
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// Manager keeps secondary zones transferred from their primaries and answers
+// for them authoritatively.
+type Manager struct {
+	zones  map[string]*zone
+	logger *logrus.Logger
+}
+
+func NewManager(secondaries map[string]config.SecondaryConfig, keys map[string]config.TSIGKey, logger *logrus.Logger) *Manager {
+	m := &Manager{
+		zones:  make(map[string]*zone),
+		logger: logger,
+	}
+
+	for name, cfg := range secondaries {
+		origin := strings.ToLower(dns.Fqdn(name))
+		z := &zone{
+			origin:    origin,
+			primaries: cfg.Primaries,
+			client:    &dns.Client{Net: "tcp", Timeout: 5 * time.Second},
+			logger:    logger,
+			notify:    make(chan struct{}, 1),
+		}
+
+		if key, exists := keys[cfg.TSIGKey]; exists && cfg.TSIGKey != "" {
+			z.keyName = strings.ToLower(dns.Fqdn(cfg.TSIGKey))
+			z.algorithm = strings.ToLower(dns.Fqdn(key.Algorithm))
+			z.secrets = map[string]string{z.keyName: key.Secret}
+		}
+
+		m.zones[origin] = z
+	}
+
+	return m
+}
+
+func (m *Manager) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, z := range m.zones {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			z.run(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// Resolve answers questions inside a transferred zone, including NXDOMAIN
+// and NODATA. Expired or not yet transferred zones are not answered.
+func (m *Manager) Resolve(question dns.Question) (*dns.Msg, bool) {
+	z := m.zoneFor(question.Name)
+	if z == nil {
+		return nil, false
+	}
+
+	response := z.resolve(question)
+	return response, response != nil
+}
+
+func (m *Manager) zoneFor(name string) *zone {
+	name = strings.ToLower(dns.Fqdn(name))
+	for {
+		if z, exists := m.zones[name]; exists {
+			return z
+		}
+		next, end := dns.NextLabel(name, 0)
+		if end {
+			return nil
+		}
+		name = name[next:]
+	}
+}
+
+// ServeDNS handles NOTIFY (RFC 1996) for secondary zones. A NOTIFY is only
+// accepted from one of the zone's primaries or when signed with its key.
+func (m *Manager) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	response := new(dns.Msg)
+	response.SetReply(r)
+	response.Authoritative = true
+
+	question := r.Question[0]
+	fields := logrus.Fields{
+		"zone":   question.Name,
+		"client": w.RemoteAddr().String(),
+	}
+
+	z := m.zones[strings.ToLower(question.Name)]
+	switch {
+	case z == nil:
+		response.Rcode = dns.RcodeNotAuth
+	case !z.notifyAllowed(w, r):
+		m.logger.WithFields(fields).Warn("NOTIFY from unknown source ignored")
+		response.Rcode = dns.RcodeRefused
+	default:
+		m.logger.WithFields(fields).Info("NOTIFY received, refreshing zone")
+		select {
+		case z.notify <- struct{}{}:
+		default:
+		}
+	}
+
+	if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() == nil {
+		response.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	}
+	if err := w.WriteMsg(response); err != nil {
+		m.logger.WithError(err).Debug("failed to write NOTIFY response")
+	}
+}
+
+func (z *zone) notifyAllowed(w dns.ResponseWriter, r *dns.Msg) bool {
+	if tsig := r.IsTsig(); tsig != nil {
+		return w.TsigStatus() == nil && strings.EqualFold(tsig.Hdr.Name, z.keyName)
+	}
+
+	host, _, err := net.SplitHostPort(w.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	for _, primary := range z.primaries {
+		if primaryHost, _, err := net.SplitHostPort(primary); err == nil && primaryHost == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) Status() []ZoneStatus {
+	statuses := make([]ZoneStatus, 0, len(m.zones))
+	for _, z := range m.zones {
+		statuses = append(statuses, z.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Zone < statuses[j].Zone
+	})
+	return statuses
+}
@@ -0,0 +1,294 @@
+package secondary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"dns-server/internal/resolver"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const minInterval = 10 * time.Second
+
+type zone struct {
+	origin    string
+	primaries []string
+	keyName   string
+	algorithm string
+	secrets   map[string]string
+	client    *dns.Client
+	logger    *logrus.Logger
+
+	mu        sync.RWMutex
+	soa       *dns.SOA
+	records   map[string]dns.RR
+	store     *resolver.Store
+	refreshed time.Time
+
+	notify chan struct{}
+}
+
+type ZoneStatus struct {
+	Zone      string    `json:"zone"`
+	Serial    uint32    `json:"serial"`
+	Records   int       `json:"records"`
+	Refreshed time.Time `json:"refreshed,omitzero"`
+	Expired   bool      `json:"expired"`
+}
+
+// run keeps the zone in sync: it checks the primary's serial every SOA
+// refresh interval, retries failures every retry interval and stops serving
+// the zone once expire has passed without a successful refresh. A NOTIFY
+// skips the wait.
+func (z *zone) run(ctx context.Context) {
+	for {
+		wait := minInterval
+		if err := z.refresh(ctx); err != nil {
+			z.logger.WithFields(logrus.Fields{
+				"zone":  z.origin,
+				"error": err,
+			}).Warn("secondary zone refresh failed")
+			if soa := z.currentSOA(); soa != nil {
+				wait = max(time.Duration(soa.Retry)*time.Second, minInterval)
+			}
+		} else if soa := z.currentSOA(); soa != nil {
+			wait = max(time.Duration(soa.Refresh)*time.Second, minInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-z.notify:
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (z *zone) refresh(ctx context.Context) error {
+	var errs []error
+	for _, primary := range z.primaries {
+		err := z.refreshFrom(ctx, primary)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", primary, err))
+	}
+	return errors.Join(errs...)
+}
+
+func (z *zone) refreshFrom(ctx context.Context, primary string) error {
+	current := z.currentSOA()
+
+	// the serial check is left unsigned; only the transfer carries TSIG
+	query := new(dns.Msg)
+	query.SetQuestion(z.origin, dns.TypeSOA)
+	response, _, err := z.client.ExchangeContext(ctx, query, primary)
+	if err != nil {
+		return fmt.Errorf("SOA query failed: %w", err)
+	}
+	if response.Rcode != dns.RcodeSuccess || len(response.Answer) == 0 {
+		return fmt.Errorf("SOA query returned %s", dns.RcodeToString[response.Rcode])
+	}
+	remote, ok := response.Answer[0].(*dns.SOA)
+	if !ok {
+		return fmt.Errorf("SOA query returned %s", dns.TypeToString[response.Answer[0].Header().Rrtype])
+	}
+
+	if current != nil && !serialGreater(remote.Serial, current.Serial) {
+		z.mu.Lock()
+		z.refreshed = time.Now()
+		z.mu.Unlock()
+		return nil
+	}
+
+	return z.transfer(primary, current)
+}
+
+func (z *zone) transfer(primary string, current *dns.SOA) error {
+	request := new(dns.Msg)
+	if current != nil {
+		request.SetIxfr(z.origin, current.Serial, current.Ns, current.Mbox)
+	} else {
+		request.SetAxfr(z.origin)
+	}
+	z.sign(request)
+
+	tr := &dns.Transfer{TsigSecret: z.secrets}
+	envelopes, err := tr.In(request, primary)
+	if err != nil {
+		return fmt.Errorf("transfer failed: %w", err)
+	}
+
+	var rrs []dns.RR
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return fmt.Errorf("transfer failed: %w", envelope.Error)
+		}
+		rrs = append(rrs, envelope.RR...)
+	}
+
+	soa, records, incremental, err := z.apply(rrs, current)
+	if err != nil {
+		return err
+	}
+
+	z.mu.Lock()
+	z.soa = soa
+	z.records = records
+	z.store = buildStore(records, soa)
+	z.refreshed = time.Now()
+	z.mu.Unlock()
+
+	z.logger.WithFields(logrus.Fields{
+		"zone":        z.origin,
+		"primary":     primary,
+		"serial":      soa.Serial,
+		"records":     len(records),
+		"incremental": incremental,
+	}).Info("secondary zone transferred")
+
+	return nil
+}
+
+// apply turns a transfer response into the new zone contents. An IXFR
+// answer is a sequence of deletion/addition blocks framed by SOAs
+// (RFC 1995); anything else is treated as a full AXFR.
+func (z *zone) apply(rrs []dns.RR, current *dns.SOA) (*dns.SOA, map[string]dns.RR, bool, error) {
+	if len(rrs) == 0 {
+		return nil, nil, false, fmt.Errorf("empty transfer")
+	}
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return nil, nil, false, fmt.Errorf("transfer does not start with SOA")
+	}
+
+	if len(rrs) == 1 {
+		z.mu.RLock()
+		records := z.records
+		z.mu.RUnlock()
+		return soa, records, true, nil
+	}
+
+	if second, ok := rrs[1].(*dns.SOA); ok && current != nil && second.Serial == current.Serial {
+		z.mu.RLock()
+		records := make(map[string]dns.RR, len(z.records))
+		for key, rr := range z.records {
+			records[key] = rr
+		}
+		z.mu.RUnlock()
+
+		// each block opens with the old SOA (deletions follow) and then the
+		// new SOA (additions follow)
+		adding := true
+		for _, rr := range rrs[1 : len(rrs)-1] {
+			if _, isSOA := rr.(*dns.SOA); isSOA {
+				adding = !adding
+				continue
+			}
+			if adding {
+				records[recordKey(rr)] = rr
+			} else {
+				delete(records, recordKey(rr))
+			}
+		}
+		return soa, records, true, nil
+	}
+
+	records := make(map[string]dns.RR, len(rrs))
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if _, isSOA := rr.(*dns.SOA); !isSOA {
+			records[recordKey(rr)] = rr
+		}
+	}
+	return soa, records, false, nil
+}
+
+func (z *zone) sign(msg *dns.Msg) {
+	if z.keyName != "" {
+		msg.SetTsig(z.keyName, z.algorithm, 300, time.Now().Unix())
+	}
+}
+
+func (z *zone) currentSOA() *dns.SOA {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.soa
+}
+
+func (z *zone) expired() bool {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.soa == nil || time.Since(z.refreshed) > time.Duration(z.soa.Expire)*time.Second
+}
+
+func (z *zone) resolve(question dns.Question) *dns.Msg {
+	if z.expired() {
+		return nil
+	}
+
+	z.mu.RLock()
+	store, soa := z.store, z.soa
+	z.mu.RUnlock()
+
+	response := new(dns.Msg)
+	response.SetReply(&dns.Msg{Question: []dns.Question{question}})
+	response.Authoritative = true
+
+	rrset, _ := store.Lookup(question.Name, question.Qtype)
+	if len(rrset) == 0 && question.Qtype != dns.TypeCNAME {
+		rrset, _ = store.Lookup(question.Name, dns.TypeCNAME)
+	}
+
+	switch {
+	case len(rrset) > 0:
+		response.Answer = rrset
+	case store.HasName(question.Name):
+		response.Ns = []dns.RR{dns.Copy(soa)}
+	default:
+		response.Rcode = dns.RcodeNameError
+		response.Ns = []dns.RR{dns.Copy(soa)}
+	}
+
+	return response
+}
+
+func (z *zone) status() ZoneStatus {
+	status := ZoneStatus{Zone: strings.TrimSuffix(z.origin, "."), Expired: z.expired()}
+
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	if z.soa != nil {
+		status.Serial = z.soa.Serial
+	}
+	status.Records = len(z.records)
+	status.Refreshed = z.refreshed
+	return status
+}
+
+func buildStore(records map[string]dns.RR, soa *dns.SOA) *resolver.Store {
+	store := resolver.NewStore()
+	for _, rr := range records {
+		store.Add(dns.Copy(rr))
+	}
+	store.Add(dns.Copy(soa))
+	return store
+}
+
+// recordKey identifies a record independent of its TTL, which IXFR
+// deletions are not required to match.
+func recordKey(rr dns.RR) string {
+	c := dns.Copy(rr)
+	c.Header().Ttl = 0
+	c.Header().Name = strings.ToLower(c.Header().Name)
+	return c.String()
+}
+
+func serialGreater(a, b uint32) bool {
+	return int32(a-b) > 0
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"math"
+	"time"
+
+	dnshandler "dns-server/internal/dns"
+	"dns-server/internal/history"
+	"dns-server/internal/querylog"
+	"dns-server/internal/upstream"
+)
+
+// dashboardRecent is how many of the latest query history entries the
+// dashboard shows.
+const dashboardRecent = 25
+
+// Dashboard is what the admin dashboard page polls for.
+type Dashboard struct {
+	Version       string                 `json:"version"`
+	UptimeSeconds int64                  `json:"uptime_seconds"`
+	QPS           float64                `json:"qps"`
+	CacheHitRate  float64                `json:"cache_hit_rate"`
+	CacheEntries  int                    `json:"cache_entries"`
+	Queries       dnshandler.Stats       `json:"queries"`
+	Top           dnshandler.DomainStats `json:"top"`
+	Upstreams     []upstream.ServerStats `json:"upstreams"`
+	History       bool                   `json:"history"`
+	Recent        []querylog.Entry       `json:"recent"`
+}
+
+func (s *Server) GetDashboard(ctx context.Context) Dashboard {
+	queries := s.handler.GetStats()
+	dashboard := Dashboard{
+		Version:       s.version,
+		UptimeSeconds: int64(time.Since(s.startedAt).Seconds()),
+		QPS:           math.Round(s.qps.value()*100) / 100,
+		CacheEntries:  s.cache.Size(),
+		Queries:       queries,
+		Top:           s.handler.DomainStats(topSummary),
+		Upstreams:     []upstream.ServerStats{},
+		History:       s.history != nil,
+		Recent:        []querylog.Entry{},
+	}
+	if queries.Queries > 0 {
+		dashboard.CacheHitRate = math.Round(float64(queries.CacheHits)/float64(queries.Queries)*10000) / 10000
+	}
+
+	if ur, ok := s.resolver.(*upstream.UpstreamResolver); ok {
+		dashboard.Upstreams = ur.GetStats().Servers
+	}
+	if s.history != nil {
+		recent, err := s.history.Query(ctx, history.Filter{Limit: dashboardRecent})
+		if err == nil && recent != nil {
+			dashboard.Recent = recent
+		}
+	}
+	return dashboard
+}
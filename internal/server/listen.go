@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"dns-server/internal/config"
+	"dns-server/internal/handoff"
+
+	"github.com/miekg/dns"
+)
+
+// newListeners builds a DNS server for every protocol of every listener
+// but dnscrypt, which dnscryptAddrs lists. Nothing listens yet; Start binds
+// them.
+func newListeners(cfg *config.Config, handler dns.Handler) ([]*dns.Server, error) {
+	var servers []*dns.Server
+	for _, listener := range config.Listeners(cfg) {
+		addr := net.JoinHostPort(listener.Address, strconv.Itoa(listener.Port))
+		for _, protocol := range listener.Protocols {
+			if protocol == config.ProtocolDNSCrypt {
+				continue
+			}
+			server := &dns.Server{
+				Addr:         addr,
+				Net:          listenerNet(listener.Address, protocol),
+				Handler:      handler,
+				ReadTimeout:  cfg.Server.ReadTimeout,
+				WriteTimeout: cfg.Server.WriteTimeout,
+			}
+			switch protocol {
+			case config.ProtocolUDP:
+				server.UDPSize = 65535
+				if cfg.Server.ReusePort && handoff.ReusePortSupported {
+					// one socket and read loop per CPU, so receiving is
+					// not serialized on a single socket lock
+					server.ReusePort = true
+					for range runtime.GOMAXPROCS(0) - 1 {
+						servers = append(servers, &dns.Server{
+							Addr:         server.Addr,
+							Net:          server.Net,
+							Handler:      handler,
+							ReadTimeout:  server.ReadTimeout,
+							WriteTimeout: server.WriteTimeout,
+							UDPSize:      server.UDPSize,
+							ReusePort:    true,
+						})
+					}
+				}
+			case config.ProtocolTLS:
+				cert, err := tls.LoadX509KeyPair(listener.CertFile, listener.KeyFile)
+				if err != nil {
+					return nil, fmt.Errorf("failed to load TLS certificate for %s: %w", addr, err)
+				}
+				server.TLSConfig = &tls.Config{
+					Certificates: []tls.Certificate{cert},
+					MinVersion:   tls.VersionTLS12,
+				}
+			}
+			servers = append(servers, server)
+		}
+	}
+	return servers, nil
+}
+
+// dnscryptAddrs lists the addresses of the listeners serving dnscrypt.
+func dnscryptAddrs(cfg *config.Config) []string {
+	var addrs []string
+	for _, listener := range config.Listeners(cfg) {
+		if slices.Contains(listener.Protocols, config.ProtocolDNSCrypt) {
+			addrs = append(addrs, net.JoinHostPort(listener.Address, strconv.Itoa(listener.Port)))
+		}
+	}
+	return addrs
+}
+
+// bind opens the socket server serves on, or takes over the one a previous
+// process handed down in an upgrade. UDP servers marked ReusePort each get
+// one of the sockets sharing their address.
+func bind(server *dns.Server) error {
+	network := strings.TrimSuffix(server.Net, "-tls")
+	if strings.HasPrefix(network, "udp") {
+		listenPacket := handoff.ListenPacket
+		if server.ReusePort {
+			listenPacket = handoff.ListenPacketReusePort
+		}
+		conn, err := listenPacket(network, server.Addr)
+		if err != nil {
+			return err
+		}
+		server.PacketConn = conn
+		return nil
+	}
+
+	listener, err := handoff.Listen(network, server.Addr)
+	if err != nil {
+		return err
+	}
+	if server.TLSConfig != nil {
+		listener = tls.NewListener(listener, server.TLSConfig)
+	}
+	server.Listener = listener
+	return nil
+}
+
+func listenError(server *dns.Server, err error) error {
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return fmt.Errorf("%s is already in use, is another instance running? %w", server.Addr, err)
+	}
+	return err
+}
+
+// listenerURLs lists the listeners of cfg the way the running server
+// reports its own.
+func listenerURLs(cfg *config.Config) []string {
+	var urls []string
+	for _, listener := range config.Listeners(cfg) {
+		addr := net.JoinHostPort(listener.Address, strconv.Itoa(listener.Port))
+		for _, protocol := range listener.Protocols {
+			if protocol == config.ProtocolDNSCrypt {
+				urls = append(urls, "dnscrypt://"+addr)
+				continue
+			}
+			urls = append(urls, listenerNet(listener.Address, protocol)+"://"+addr)
+		}
+	}
+	return urls
+}
+
+// listenerNet is the miekg/dns network for protocol on address, pinned to
+// IPv4 for IPv4 addresses as the single listener always was.
+func listenerNet(address, protocol string) string {
+	network := "tcp"
+	if protocol == config.ProtocolUDP {
+		network = "udp"
+	}
+	if ip := net.ParseIP(address); ip == nil || ip.To4() != nil {
+		network += "4"
+	}
+	if protocol == config.ProtocolTLS {
+		network += "-tls"
+	}
+	return network
+}
+
+// queryAddr is the address the server can query itself on: the first
+// listener serving UDP, or the first listener at all.
+func queryAddr(cfg *config.Config) string {
+	listeners := config.Listeners(cfg)
+	for _, listener := range listeners {
+		for _, protocol := range listener.Protocols {
+			if protocol == config.ProtocolUDP {
+				return selfAddr(listener.Address, listener.Port)
+			}
+		}
+	}
+	return selfAddr(listeners[0].Address, listeners[0].Port)
+}
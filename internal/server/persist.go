@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"dns-server/internal/cache"
+
+	"github.com/sirupsen/logrus"
+)
+
+// legacyCachePath is where versions without cache.persist_path kept the
+// cache, relative to the working directory.
+const legacyCachePath = "dns-cache.gob"
+
+func loadCache(dnsCache *cache.LRUCache, path string, logger *logrus.Logger) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) && path != legacyCachePath {
+		if _, err := os.Stat(legacyCachePath); err == nil {
+			migrateCache(dnsCache, legacyCachePath, path, logger)
+			return
+		}
+	}
+
+	var corrupt *cache.CorruptFileError
+	var unsupported *cache.UnsupportedVersionError
+	err := dnsCache.LoadFromFile(path)
+	switch {
+	case err == nil:
+		logger.WithFields(logrus.Fields{
+			"path": path,
+			"size": dnsCache.Size(),
+		}).Info("cache loaded")
+	case errors.As(err, &corrupt):
+		logger.WithFields(logrus.Fields{
+			"error":       corrupt.Err,
+			"loaded":      corrupt.Loaded,
+			"offset":      corrupt.Offset,
+			"skipped":     corrupt.Skipped,
+			"quarantined": corrupt.Quarantine,
+		}).Warn("cache file is corrupt; loaded the entries before the damage")
+	case errors.As(err, &unsupported):
+		logger.WithFields(logrus.Fields{
+			"path":    path,
+			"version": unsupported.Version,
+		}).Warn("cache file has an unsupported format version; starting with an empty cache")
+	case errors.Is(err, cache.ErrLegacyFormat):
+		migrateCache(dnsCache, path, path, logger)
+	default:
+		logger.WithError(err).Debug("no cache file found or failed to load cache")
+	}
+}
+
+// migrateCache loads the gob cache file at from, written by earlier
+// versions, and replaces it with a snapshot at path in the current format,
+// so it is decoded only once. Most such files cannot be decoded (see
+// cache.LoadLegacy); they are replaced all the same, with an empty cache.
+func migrateCache(dnsCache *cache.LRUCache, from, path string, logger *logrus.Logger) {
+	file, err := os.Open(from)
+	if err != nil {
+		logger.WithError(err).Warn("failed to open legacy cache file")
+		return
+	}
+	loaded, loadErr := dnsCache.LoadLegacy(file)
+	file.Close()
+
+	fields := logrus.Fields{
+		"from":   from,
+		"to":     path,
+		"loaded": loaded,
+	}
+	if err := dnsCache.DumpToFile(path); err != nil {
+		logger.WithFields(fields).WithError(err).Warn("failed to convert legacy cache file; it is read again at the next start")
+		return
+	}
+	if from != path {
+		if err := os.Remove(from); err != nil {
+			logger.WithFields(fields).WithError(err).Warn("failed to remove legacy cache file")
+		}
+	}
+
+	if loadErr != nil {
+		logger.WithFields(fields).WithError(loadErr).Info("legacy cache file held no readable entries; replaced it with an empty cache")
+		return
+	}
+	logger.WithFields(fields).Info("converted legacy cache file")
+}
+
+func (s *Server) persistCache() {
+	path := s.config.Cache.PersistPath
+	if err := s.cache.DumpToFile(path); err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"path":  path,
+			"error": err,
+		}).Warn("failed to dump cache to disk")
+		return
+	}
+	s.logger.WithFields(logrus.Fields{
+		"path": path,
+		"size": s.cache.Size(),
+	}).Debug("cache dumped to disk")
+}
+
+// runCachePersist snapshots the cache every persist_interval, so a crash
+// loses at most one interval of entries.
+func (s *Server) runCachePersist(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Cache.PersistInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.persistCache()
+		}
+	}
+}
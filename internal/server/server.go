@@ -2,16 +2,42 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"dns-server/internal/acl"
+	"dns-server/internal/admin"
 	"dns-server/internal/cache"
 	"dns-server/internal/config"
+	"dns-server/internal/discovery"
 	dnshandler "dns-server/internal/dns"
+	"dns-server/internal/dnscrypt"
+	"dns-server/internal/externaldns"
+	"dns-server/internal/filter"
+	"dns-server/internal/handoff"
+	"dns-server/internal/health"
+	"dns-server/internal/history"
+	"dns-server/internal/hook"
+	"dns-server/internal/leases"
+	"dns-server/internal/lifecycle"
+	"dns-server/internal/metrics"
+	"dns-server/internal/ptr"
+	"dns-server/internal/querylog"
 	"dns-server/internal/resolver"
+	"dns-server/internal/rewrite"
+	"dns-server/internal/secondary"
+	"dns-server/internal/trace"
+	"dns-server/internal/transfer"
 	"dns-server/internal/upstream"
+	"dns-server/internal/webhook"
+	"dns-server/pkg/logger"
 
 	"github.com/miekg/dns"
 	"github.com/sirupsen/logrus"
@@ -21,132 +47,766 @@ type Server struct {
 	config        *config.Config
 	cache         cache.Cache
 	localResolver *resolver.LocalResolver
+	views         *resolver.Views
+	pools         *resolver.Pools
+	targets       *health.Checker
+	services      *discovery.Catalog
+	leases        *leases.Table
 	resolver      upstream.DNSResolver
 	handler       *dnshandler.Handler
-	server        *dns.Server
+	servers       []*dns.Server
+	dnscrypt      *dnscrypt.Server
+	secondary     *secondary.Manager
+	transfer      *transfer.Server
+	admin         *admin.Server
+	status        *admin.Server
+	externalDNS   *admin.Server
+	clients       *acl.List
+	recordStore   resolver.RecordBackend
+	loops         *lifecycle.Manager
+	metrics       *metrics.Registry
+	webhooks      *webhook.Notifier
+	clientNames   *ptr.Enricher
+	queryLog      *querylog.Log
+	history       *history.Recorder
+	tracing       *trace.Exporter
+	configPath    string
+	configLoader  *config.FileConfigLoader
+	logs          *logger.Manager
 	logger        *logrus.Logger
+	version       string
+	qps           rateMeter
+	startedAt     time.Time
 	wg            sync.WaitGroup
+	stopOnce      sync.Once
 }
 
-func NewServer(cfg *config.Config, logger *logrus.Logger) (*Server, error) {
+func NewServer(cfg *config.Config, logs *logger.Manager) (*Server, error) {
+	privacy := logger.NewPrivacyFilter(cfg.Logging.ExcludeDomains)
+	logger := logs.Root()
+
 	dnsCache := cache.NewLRUCache(
 		cfg.Cache.MaxEntries,
 		cfg.Cache.DefaultTTL,
 		cfg.Cache.CleanupInterval,
 	)
+	dnsCache.SetShards(cfg.Cache.Shards)
+	dnsCache.SetMaxMemory(cfg.Cache.MaxMemoryMB << 20)
+	if cfg.Cache.ServeStale {
+		dnsCache.SetMaxStale(cfg.Cache.MaxStale)
+	}
+	if cfg.Cache.Writes.Async {
+		dnsCache.SetAsyncWrites(&cfg.Cache.Writes)
+	}
+	dnsCache.SetHardenBelowNXDomain(cfg.Cache.HardenNXDomain)
 
-	if err := dnsCache.LoadFromFile("dns-cache.gob"); err != nil {
-		logger.WithError(err).Debug("no cache file found or failed to load cache")
-	} else {
-		logger.WithField("size", dnsCache.Size()).Info("cache loaded from dns-cache.gob")
+	if !cfg.Cache.NoPersist {
+		loadCache(dnsCache, cfg.Cache.PersistPath, logger)
 	}
 
 	upstreamResolver := upstream.NewUpstreamResolver(
-		cfg.Upstream.Servers,
+		nil,
 		cfg.Upstream.Timeout,
 		cfg.Upstream.Retries,
-		logger,
+		logs.Module("upstream"),
 	)
+	if err := upstreamResolver.SetServerConfigs(cfg.Upstream.UpstreamServers()); err != nil {
+		return nil, err
+	}
+	upstreamResolver.SetStrategy(cfg.Upstream.Strategy, cfg.Upstream.RaceCount)
+	upstreamResolver.SetRequestNSID(cfg.Upstream.RequestNSID)
+	upstreamResolver.SetRandomizeCase(cfg.Upstream.RandomizeCase)
+	upstreamResolver.SetPipelining(cfg.Upstream.Pipeline)
+	upstreamResolver.SetPipelineConnections(cfg.Upstream.PipelineConns)
+	upstreamResolver.SetEncryption(cfg.Upstream.Encryption)
+	upstreamResolver.SetBootstrap(cfg.Upstream.Bootstrap)
+
+	localResolver := resolver.NewLocalResolver(&cfg.Records, logs.Module("resolver"))
+	localResolver.SetProfiles(cfg.Profiles)
+	localResolver.SetPrecedence(&cfg.Precedence)
+	localResolver.SetLocalZones(config.LocalZones(cfg))
+
+	var recordStore resolver.RecordBackend
+	if cfg.RecordStore.Backend == "sqlite" {
+		backend, err := resolver.NewSQLiteBackend(cfg.RecordStore.Path)
+		if err != nil {
+			return nil, err
+		}
+		if err := localResolver.SetBackend(backend); err != nil {
+			backend.Close()
+			return nil, err
+		}
+		recordStore = backend
+	}
+
+	var dnsResolver upstream.DNSResolver = upstreamResolver
+	if cfg.Upstream.Mode == upstream.ModeRecursive {
+		roots := cfg.Upstream.RootHints
+		if len(roots) == 0 {
+			roots = upstream.RootHints
+		}
+		iterative := upstream.NewIterativeResolver(roots, cfg.Upstream.Timeout, logs.Module("upstream"))
+		iterative.SetQNameMinimization(cfg.Upstream.QNameMinimization)
+		iterative.SetRandomizeCase(cfg.Upstream.RandomizeCase)
+		iterative.SetHardenReferralPath(cfg.Upstream.HardenReferrals)
+		dnsResolver = iterative
+	}
+
+	handlerResolver := dnsResolver
+	var stub *upstream.StubResolver
+	if len(cfg.Stub) > 0 {
+		zones := make(map[string][]string, len(cfg.Stub))
+		for zone, stubCfg := range cfg.Stub {
+			zones[zone] = stubCfg.Servers
+		}
+		stub = upstream.NewStubResolver(zones, dnsResolver, cfg.Upstream.Timeout, logs.Module("upstream"))
+		handlerResolver = stub
+	}
+
+	var mdns *upstream.MDNSResolver
+	if cfg.MDNS.Enabled {
+		mdns = upstream.NewMDNSResolver(handlerResolver, cfg.MDNS.Timeout, logs.Module("upstream"))
+		mdns.SetUnqualified(cfg.MDNS.Unqualified)
+		if err := mdns.SetInterface(cfg.MDNS.Interface); err != nil {
+			return nil, err
+		}
+		handlerResolver = mdns
+	}
+
+	handler := dnshandler.NewHandler(dnsCache, localResolver, handlerResolver, logs.Module("handler"))
+	handler.SetServeStale(cfg.Cache.ServeStale, cfg.Cache.StaleTTL)
+	handler.SetTTLMode(cfg.Cache.TTLMode)
+	handler.SetTTLPolicy(cfg.Cache.TTLPolicy)
+	handler.SetPassRcodes(cfg.Upstream.PassRcodes)
+	views := resolver.NewViews(cfg.Views, logs.Module("resolver"))
+	handler.SetViews(views)
+	targets := health.NewChecker(logs.Module("health"))
+	pools := resolver.NewPools(cfg.Pools, targets, logs.Module("resolver"))
+	handler.SetPools(pools)
+	if len(cfg.Cache.AllowFrom) > 0 {
+		var networks []*net.IPNet
+		for _, allowed := range cfg.Cache.AllowFrom {
+			network, err := config.ParseNetwork(allowed)
+			if err != nil {
+				return nil, err
+			}
+			networks = append(networks, network)
+		}
+		handler.SetCacheClients(networks)
+	}
+	handler.SetNSID(cfg.Server.NSID)
+	hostname := cfg.Server.Hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	serverID := cfg.Server.ServerID
+	if serverID == "" {
+		serverID = cfg.Server.NSID
+	}
+	handler.SetIdentity(cfg.Server.Version, hostname, serverID)
+	handler.HideIdentity(cfg.Server.HideIdentity)
+	handler.SetConcurrencyLimit(cfg.Server.MaxQueries, cfg.Server.Overload)
+	if resolver.CountRecords(&cfg.Fallback.Records) > 0 {
+		handler.SetFallback(resolver.NewLocalResolver(&cfg.Fallback.Records, logs.Module("resolver")), cfg.Fallback.TTL)
+	}
+
+	servers, err := newListeners(cfg, handler.WireHandler())
+	if err != nil {
+		return nil, err
+	}
 
-	localResolver := resolver.NewLocalResolver(&cfg.Records, logger)
+	tsigSecrets := make(map[string]string)
+	for name, key := range cfg.Transfer.TSIG {
+		tsigSecrets[dns.Fqdn(strings.ToLower(name))] = key.Secret
+	}
 
-	handler := dnshandler.NewHandler(dnsCache, localResolver, upstreamResolver, logger)
+	var zones *secondary.Manager
+	if len(cfg.Secondary) > 0 {
+		zones = secondary.NewManager(cfg.Secondary, cfg.Transfer.TSIG, logs.Module("secondary"))
+		handler.SetSecondary(zones)
+	}
 
-	addr := fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.Port)
+	var services *discovery.Catalog
+	if cfg.Discovery.Backend != "" {
+		var err error
+		if services, err = discovery.New(&cfg.Discovery, logs.Module("discovery")); err != nil {
+			return nil, err
+		}
+		handler.SetServices(services)
+	}
 
-	server := &dns.Server{
-		Addr:         addr,
-		Net:          "udp4",
-		Handler:      handler,
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
-		UDPSize:      65535,
+	var leaseTable *leases.Table
+	if cfg.Leases.Path != "" {
+		var err error
+		if leaseTable, err = leases.New(&cfg.Leases, logs.Module("leases")); err != nil {
+			return nil, err
+		}
+		handler.SetLeases(leaseTable)
 	}
 
-	return &Server{
+	var dnscryptServer *dnscrypt.Server
+	if len(dnscryptAddrs(cfg)) > 0 {
+		var err error
+		if dnscryptServer, err = dnscrypt.New(&cfg.Server, handler, logs.Module("dnscrypt")); err != nil {
+			return nil, fmt.Errorf("failed to set up dnscrypt: %w", err)
+		}
+	}
+
+	var clients *acl.List
+	if len(cfg.Server.AllowFrom) > 0 || len(cfg.Server.DenyFrom) > 0 {
+		var err error
+		if clients, err = acl.New(cfg.Server.AllowFrom, cfg.Server.DenyFrom); err != nil {
+			return nil, err
+		}
+		for _, server := range servers {
+			server.DecorateReader = clients.Decorate
+		}
+		if dnscryptServer != nil {
+			dnscryptServer.SetClients(clients.Allowed)
+		}
+	}
+	if len(tsigSecrets) > 0 {
+		for _, server := range servers {
+			server.TsigSecret = tsigSecrets
+		}
+	}
+
+	var transferServer *transfer.Server
+	if cfg.Transfer.Enabled {
+		var err error
+		transferServer, err = transfer.NewServer(&cfg.Transfer, localResolver, logs.Module("transfer"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up zone transfers: %w", err)
+		}
+		handler.SetTransfer(transferServer)
+		localResolver.OnChange(func(resolver.RecordDiff) {
+			transferServer.Refresh()
+		})
+	}
+
+	srv := &Server{
 		config:        cfg,
 		cache:         dnsCache,
 		localResolver: localResolver,
-		resolver:      upstreamResolver,
+		views:         views,
+		pools:         pools,
+		targets:       targets,
+		services:      services,
+		leases:        leaseTable,
+		resolver:      dnsResolver,
 		handler:       handler,
-		server:        server,
+		servers:       servers,
+		dnscrypt:      dnscryptServer,
+		secondary:     zones,
+		transfer:      transferServer,
+		clients:       clients,
+		recordStore:   recordStore,
+		metrics:       metrics.NewRegistry(),
+		configLoader:  config.NewConfigLoader(),
+		logs:          logs,
 		logger:        logger,
-	}, nil
+	}
+
+	localResolver.OnChange(func(diff resolver.RecordDiff) {
+		logger.WithFields(logrus.Fields{
+			"source":  diff.Source,
+			"added":   len(diff.Added),
+			"removed": len(diff.Removed),
+			"changed": len(diff.Changed),
+		}).Info("local records changed")
+	})
+
+	if len(cfg.Webhooks) > 0 {
+		srv.webhooks = webhook.NewNotifier(cfg.Webhooks, logs.Module("webhook"))
+		localResolver.OnChange(func(diff resolver.RecordDiff) {
+			srv.webhooks.Notify("records.changed", diff)
+		})
+	}
+
+	if cfg.Logging.ClientNames.Enabled {
+		srv.clientNames = ptr.NewEnricher(queryAddr(cfg), &cfg.Logging.ClientNames, logs.Module("ptr"))
+		handler.SetClientNames(srv.clientNames)
+		srv.metrics.Register(srv.clientNames.Collect)
+	}
+
+	if cfg.QueryLog.Path != "" {
+		srv.queryLog = querylog.New(&cfg.QueryLog, cfg.Logging.ExcludeDomains)
+		handler.SetQueryLog(srv.queryLog)
+	}
+
+	if cfg.History.Backend != "" {
+		store, err := history.Open(&cfg.History)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open query history: %w", err)
+		}
+		srv.history = history.NewRecorder(store, &cfg.History, privacy, logs.Module("history"))
+		handler.SetHistory(srv.history)
+		srv.metrics.Register(srv.history.Collect)
+	}
+
+	if cfg.Tracing.Enabled {
+		srv.tracing = trace.NewExporter(&cfg.Tracing, logs.Module("tracing"))
+		handler.Tracer().SetExporter(srv.tracing, cfg.Tracing.SampleRate)
+		srv.metrics.Register(srv.tracing.Collect)
+	}
+
+	if len(cfg.Hooks) > 0 {
+		hooks := hook.New(cfg.Hooks, logs.Module("hook"))
+		handler.SetHooks(hooks)
+		srv.metrics.Register(hooks.Collect)
+	}
+
+	if len(cfg.Filter.Rules) > 0 || cfg.Filter.SafeSearch.Enabled {
+		queryFilter, err := filter.New(&cfg.Filter, logs.Module("filter"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile filter rules: %w", err)
+		}
+		handler.SetFilter(queryFilter)
+		srv.metrics.Register(queryFilter.Collect)
+	}
+
+	if len(cfg.Rewrite.Rules) > 0 {
+		rewriter, err := rewrite.New(&cfg.Rewrite)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile rewrite rules: %w", err)
+		}
+		handler.SetRewriter(rewriter)
+		srv.metrics.Register(rewriter.Collect)
+	}
+
+	handler.SetDomainStats(cfg.Stats.TopDomains, cfg.Stats.ZoneLabels)
+
+	srv.metrics.Register(srv.collect)
+	srv.metrics.Register(logs.Collect)
+	srv.metrics.Register(handler.Collect)
+	srv.metrics.Register(targets.Collect)
+	if services != nil {
+		srv.metrics.Register(services.Collect)
+	}
+	if leaseTable != nil {
+		srv.metrics.Register(leaseTable.Collect)
+	}
+	if dnscryptServer != nil {
+		srv.metrics.Register(dnscryptServer.Collect)
+	}
+	if stub != nil {
+		srv.metrics.Register(stub.Collect)
+	}
+	if mdns != nil {
+		srv.metrics.Register(mdns.Collect)
+	}
+	switch r := dnsResolver.(type) {
+	case *upstream.UpstreamResolver:
+		srv.metrics.Register(r.Collect)
+	case *upstream.IterativeResolver:
+		srv.metrics.Register(r.Collect)
+	}
+
+	if cfg.Admin.Enabled {
+		srv.admin = admin.NewServer(&cfg.Admin, logs.Module("admin"))
+		srv.admin.RegisterLogging(logs)
+		srv.admin.RegisterTrace(handler.Tracer())
+		queryStream := querylog.NewStream(cfg.Logging.ExcludeDomains)
+		handler.SetQueryStream(queryStream)
+		srv.admin.RegisterQueryStream(queryStream)
+		srv.metrics.Register(queryStream.Collect)
+		if srv.queryLog != nil {
+			srv.admin.RegisterQueryLog(srv.queryLog)
+		}
+		if srv.history != nil {
+			srv.admin.RegisterHistory(srv.history)
+		}
+		srv.admin.RegisterProfiles(localResolver, dnsCache)
+		srv.admin.RegisterRecords(localResolver, dnsCache)
+		srv.admin.RegisterCache(dnsCache)
+		srv.admin.RegisterPools(targets)
+		srv.admin.RegisterStats(func() any { return srv.GetStats() })
+		srv.admin.RegisterDomainStats(func(limit int) any { return handler.DomainStats(limit) })
+		if cfg.Admin.Dashboard {
+			srv.admin.RegisterDashboard(func(ctx context.Context) any { return srv.GetDashboard(ctx) })
+		}
+		srv.admin.RegisterMetrics(srv.metrics)
+		srv.admin.RegisterReload(srv.Reload)
+		srv.admin.RegisterState(
+			func() any { return srv.RuntimeState() },
+			func() (any, error) { return srv.DiffState() },
+		)
+	}
+
+	if cfg.Status.Enabled {
+		srv.status = admin.NewServer(&config.AdminConfig{
+			Enabled:     true,
+			BindAddress: cfg.Status.BindAddress,
+			Port:        cfg.Status.Port,
+		}, logs.Module("status"))
+		srv.status.RegisterStatus(cfg.Status.Path, func() any { return srv.GetStatus() })
+	}
+
+	if cfg.ExternalDNS.Enabled {
+		srv.externalDNS = admin.NewServer(&config.AdminConfig{
+			Enabled:     true,
+			BindAddress: cfg.ExternalDNS.BindAddress,
+			Port:        cfg.ExternalDNS.Port,
+		}, logs.Module("external-dns"))
+		provider := externaldns.NewProvider(&cfg.ExternalDNS, localResolver, logs.Module("external-dns"))
+		srv.externalDNS.RegisterExternalDNS(provider, dnsCache)
+	}
+
+	return srv, nil
 }
 
 func (s *Server) Start(ctx context.Context) error {
-	s.logger.WithFields(logrus.Fields{
-		"address": s.server.Addr,
-		"network": s.server.Net,
-	}).Info("starting DNS server")
+	s.startedAt = time.Now()
 
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
-		if err := s.server.ListenAndServe(); err != nil {
-			s.logger.WithError(err).Error("DNS server stopped")
-		}
+		<-ctx.Done()
+		s.drain()
 	}()
 
-	s.wg.Add(1)
-	go func() {
-		defer s.wg.Done()
-		<-ctx.Done()
-		s.logger.Info("shutting down DNS server")
+	for _, server := range s.servers {
+		if err := s.listen(server); err != nil {
+			return fmt.Errorf("failed to start server: %w", err)
+		}
+	}
+	if err := s.listenDNSCrypt(); err != nil {
+		return fmt.Errorf("failed to start server: %w", err)
+	}
 
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	s.startLoops(ctx)
 
-		if err := s.server.ShutdownContext(shutdownCtx); err != nil {
-			s.logger.WithError(err).Error("error during server shutdown")
+	if s.admin != nil {
+		if err := s.admin.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start admin API: %w", err)
 		}
-	}()
+	}
 
-	if err := s.waitForServer(); err != nil {
-		return fmt.Errorf("failed to start server: %w", err)
+	if s.status != nil {
+		if err := s.status.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start status page: %w", err)
+		}
+	}
+
+	if s.externalDNS != nil {
+		if err := s.externalDNS.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start external-dns webhook: %w", err)
+		}
 	}
 
 	s.logger.Info("DNS server started successfully")
 	return nil
 }
 
-func (s *Server) Stop() {
-	s.logger.Info("stopping DNS server")
+// startLoops starts every background loop under one lifecycle manager. The
+// loops outlive ctx: Stop ends them after the listeners have drained, so no
+// loop touches the cache while it is dumped.
+func (s *Server) startLoops(ctx context.Context) {
+	s.loops = lifecycle.New(context.WithoutCancel(ctx), s.logger)
 
-	if s.cache != nil {
-		if lruCache, ok := s.cache.(*cache.LRUCache); ok {
-			lruCache.Close()
+	if lruCache, ok := s.cache.(*cache.LRUCache); ok {
+		s.loops.Go("cache-cleanup", lruCache.RunCleanup)
+
+		if s.config.Cache.Writes.Async {
+			s.loops.Go("cache-writes", lruCache.RunWrites)
 		}
 
-		if err := s.cache.DumpToFile("dns-cache.gob"); err != nil {
-			s.logger.WithError(err).Warn("failed to dump cache to disk")
-		} else {
-			s.logger.Info("cache dumped to dns-cache.gob")
+		if s.config.Cache.Adaptive.Enabled {
+			s.loops.Go("cache-adaptive", func(ctx context.Context) {
+				lruCache.RunAdaptiveSizing(ctx, &s.config.Cache.Adaptive, s.config.Cache.MaxEntries, s.logs.Module("cache"))
+			})
 		}
 	}
 
-	s.logger.Info("DNS server stopped")
+	if ur, ok := s.resolver.(*upstream.UpstreamResolver); ok && s.config.Upstream.HealthCheck.Enabled {
+		s.loops.Go("upstream-health", func(ctx context.Context) {
+			ur.RunHealthChecks(ctx, &s.config.Upstream.HealthCheck)
+		})
+	}
+
+	if ur, ok := s.resolver.(*upstream.UpstreamResolver); ok && s.config.Upstream.Encryption == upstream.EncryptionOpportunistic {
+		s.loops.Go("upstream-encryption", func(ctx context.Context) {
+			ur.RunEncryptionProbes(ctx, s.config.Upstream.EncryptionRecheck)
+		})
+	}
+
+	if ur, ok := s.resolver.(*upstream.UpstreamResolver); ok && s.config.Upstream.Audit.Enabled {
+		s.loops.Go("upstream-audit", func(ctx context.Context) {
+			ur.RunAudit(ctx, &s.config.Upstream.Audit)
+		})
+	}
+
+	if !s.config.Cache.NoPersist {
+		s.loops.Go("cache-persist", s.runCachePersist)
+	}
+
+	if s.config.Cache.Prefetch.Enabled {
+		s.loops.Go("cache-prefetch", func(ctx context.Context) {
+			s.handler.RunPrefetch(ctx, &s.config.Cache.Prefetch)
+		})
+	}
+
+	s.loops.Go("target-health", s.targets.Run)
+
+	if s.services != nil {
+		s.loops.Go("service-discovery", s.services.Run)
+	}
+
+	if s.leases != nil {
+		s.loops.Go("dhcp-leases", s.leases.Run)
+	}
+
+	if s.dnscrypt != nil {
+		s.loops.Go("dnscrypt-certs", s.dnscrypt.Run)
+	}
+
+	if len(s.config.Records.HostsFiles) > 0 {
+		s.loops.Go("hosts-files", s.localResolver.WatchHosts)
+	}
+
+	if s.webhooks != nil {
+		s.loops.Go("webhooks", s.webhooks.Run)
+	}
+
+	if s.clientNames != nil {
+		s.loops.Go("client-names", s.clientNames.Run)
+	}
+
+	if s.history != nil {
+		s.loops.Go("query-history", s.history.Run)
+	}
+
+	if s.tracing != nil {
+		s.loops.Go("trace-export", s.tracing.Run)
+	}
+
+	if s.secondary != nil {
+		s.loops.Go("secondary-zones", s.secondary.Run)
+	}
+
+	if s.transfer != nil && len(s.config.Transfer.Notify) > 0 {
+		s.loops.Go("zone-notify", s.transfer.RunNotify)
+	}
+
+	if s.status != nil || (s.admin != nil && s.config.Admin.Dashboard) {
+		s.loops.Go("query-rate", func(ctx context.Context) {
+			s.qps.run(ctx, func() uint64 { return s.handler.GetStats().Queries })
+		})
+	}
+}
+
+func (s *Server) SetConfigPath(path string) {
+	s.configPath = path
+}
+
+// SetConfigLoader sets the loader Reload and DiffState read the config file
+// with, so they apply the same overrides as at startup.
+func (s *Server) SetConfigLoader(loader *config.FileConfigLoader) {
+	s.configLoader = loader
+}
+
+// Reload re-reads local records, profiles and their precedence from the
+// config file and the record store. Other settings require a restart.
+func (s *Server) Reload() error {
+	if s.configPath == "" {
+		return fmt.Errorf("no config file to reload")
+	}
+
+	cfg, err := s.configLoader.Load(s.configPath)
+	if err != nil {
+		return err
+	}
+
+	s.localResolver.SetPrecedence(&cfg.Precedence)
+	s.localResolver.SetLocalZones(config.LocalZones(cfg))
+	s.localResolver.Reload(&cfg.Records, cfg.Profiles)
+	s.views.Reload(cfg.Views)
+	s.pools.Reload(cfg.Pools)
+	if err := s.localResolver.ReloadDynamic(); err != nil {
+		return err
+	}
+	s.cache.Clear()
+
+	s.logger.WithField("config_file", s.configPath).Info("configuration reloaded")
+	return nil
+}
+
+// Upgrade hands every listening socket to a new process running the same
+// binary and arguments, and returns its pid once it serves. The cache is
+// dumped first so the new process starts warm. The caller then shuts this
+// server down, which drains the queries it still has in flight.
+func (s *Server) Upgrade(timeout time.Duration) (int, error) {
+	if s.cache != nil && !s.config.Cache.NoPersist {
+		s.persistCache()
+	}
+
+	s.logger.Info("handing listeners to a new process")
+	pid, err := handoff.Upgrade(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("upgrade failed: %w", err)
+	}
+	s.logger.WithField("pid", pid).Info("new process is serving")
+	return pid, nil
+}
+
+// ReopenLogs reopens a file log output, e.g. on SIGHUP after logrotate moved it.
+func (s *Server) ReopenLogs() error {
+	if err := s.logs.Reopen(); err != nil {
+		return err
+	}
+	s.logger.Info("log output reopened")
+	return nil
+}
+
+// Stop shuts the server down once listeners have drained: background loops
+// end first, then the query log is flushed and the cache dumped, so each
+// happens exactly once and after the last query that could change it.
+// Calling it again does nothing.
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() {
+		s.logger.Info("stopping DNS server")
+
+		if s.loops != nil {
+			s.loops.Stop(5 * time.Second)
+		}
+
+		if s.queryLog != nil {
+			if err := s.queryLog.Close(); err != nil {
+				s.logger.WithError(err).Warn("failed to close query log")
+			}
+		}
+
+		if s.cache != nil && !s.config.Cache.NoPersist {
+			s.persistCache()
+		}
+
+		if s.recordStore != nil {
+			if err := s.recordStore.Close(); err != nil {
+				s.logger.WithError(err).Warn("failed to close record store")
+			}
+		}
+
+		s.logger.Info("DNS server stopped")
+	})
 }
 
 func (s *Server) Wait() {
 	s.wg.Wait()
+	if s.admin != nil {
+		s.admin.Wait()
+	}
+	if s.status != nil {
+		s.status.Wait()
+	}
+	if s.externalDNS != nil {
+		s.externalDNS.Wait()
+	}
 	s.Stop()
 }
 
-func (s *Server) waitForServer() error {
-	maxAttempts := 10
-	for i := range maxAttempts {
-		conn, err := net.DialTimeout("udp", s.server.Addr, time.Second)
-		if err == nil {
-			conn.Close()
-			return nil
-		}
+func (s *Server) listen(server *dns.Server) error {
+	s.logger.WithFields(logrus.Fields{
+		"address": server.Addr,
+		"network": server.Net,
+	}).Info("starting DNS server")
 
-		if i == maxAttempts-1 {
-			return fmt.Errorf("server failed to start after %d attempts: %w", maxAttempts, err)
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	listenErr := make(chan error, 1)
+
+	if err := bind(server); err != nil {
+		return listenError(server, err)
+	}
+
+	// not part of s.wg: ActivateAndServe returns only once every handler
+	// has, and drain bounds how long shutdown waits for those
+	go func() {
+		if err := server.ActivateAndServe(); err != nil {
+			listenErr <- err
+			s.logger.WithError(err).Error("DNS server stopped")
 		}
+	}()
+
+	return s.waitForServer(server, started, listenErr)
+}
 
-		time.Sleep(100 * time.Millisecond)
+// drain stops every listener from accepting queries and waits up to
+// server.drain_timeout for the ones in flight to be answered.
+func (s *Server) drain() {
+	s.logger.WithField("timeout", s.config.Server.DrainTimeout.String()).Info("draining DNS listeners")
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Server.DrainTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	if s.dnscrypt != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.dnscrypt.Shutdown(ctx); errors.Is(err, context.DeadlineExceeded) {
+				s.logger.Warn("dnscrypt queries still in flight after drain timeout")
+			}
+		}()
+	}
+	for _, server := range s.servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := server.ShutdownContext(ctx)
+			switch {
+			case errors.Is(err, context.DeadlineExceeded):
+				s.logger.WithFields(logrus.Fields{
+					"address": server.Addr,
+					"network": server.Net,
+				}).Warn("queries still in flight after drain timeout")
+			case err != nil:
+				s.logger.WithError(err).WithField("network", server.Net).Debug("listener was not running")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// listenDNSCrypt binds the dnscrypt listeners over UDP and TCP and logs the
+// stamp clients reach each of them with.
+func (s *Server) listenDNSCrypt() error {
+	if s.dnscrypt == nil {
+		return nil
+	}
+	for _, listener := range config.Listeners(s.config) {
+		if !slices.Contains(listener.Protocols, config.ProtocolDNSCrypt) {
+			continue
+		}
+		addr := net.JoinHostPort(listener.Address, strconv.Itoa(listener.Port))
+		for _, protocol := range []string{config.ProtocolUDP, config.ProtocolTCP} {
+			if err := s.dnscrypt.Listen(listenerNet(listener.Address, protocol), addr); err != nil {
+				return fmt.Errorf("dnscrypt on %s: %w", addr, err)
+			}
+		}
+		s.logger.WithFields(logrus.Fields{
+			"address": addr,
+			"stamp":   s.dnscrypt.Stamp(selfAddr(listener.Address, listener.Port)),
+		}).Info("dnscrypt listener ready")
 	}
 	return nil
 }
+
+func (s *Server) waitForServer(server *dns.Server, started <-chan struct{}, listenErr <-chan error) error {
+	select {
+	case <-started:
+		return nil
+	case err := <-listenErr:
+		return listenError(server, err)
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("%s listener on %s did not start within 5s", server.Net, server.Addr)
+	}
+}
+
+// selfAddr is the address the server can query itself on: the loopback
+// address when it listens on all interfaces.
+func selfAddr(bindAddress string, port int) string {
+	if ip := net.ParseIP(bindAddress); bindAddress == "" || ip != nil && ip.IsUnspecified() {
+		bindAddress = "127.0.0.1"
+	}
+	return net.JoinHostPort(bindAddress, strconv.Itoa(port))
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sort"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/resolver"
+	"dns-server/internal/upstream"
+
+	"github.com/sirupsen/logrus"
+)
+
+type State struct {
+	Records   []resolver.RecordEntry   `json:"records"`
+	Profiles  []resolver.ProfileStatus `json:"profiles"`
+	Upstreams []string                 `json:"upstreams"`
+	Healthy   []string                 `json:"healthy_upstreams,omitempty"`
+	Listeners []string                 `json:"listeners"`
+}
+
+type ListDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type ProfileDiff struct {
+	Name       string `json:"name"`
+	Configured bool   `json:"configured_enabled"`
+	Runtime    bool   `json:"runtime_enabled"`
+}
+
+// StateDiff describes how the running server drifted from its config file.
+// Added entries exist at runtime but not on disk.
+type StateDiff struct {
+	ConfigFile string              `json:"config_file"`
+	InSync     bool                `json:"in_sync"`
+	Records    resolver.RecordDiff `json:"records"`
+	Profiles   []ProfileDiff       `json:"profiles"`
+	Upstreams  ListDiff            `json:"upstreams"`
+	Listeners  ListDiff            `json:"listeners"`
+}
+
+func (s *Server) RuntimeState() State {
+	state := State{
+		Records:   sortedEntries(s.localResolver.Snapshot()),
+		Profiles:  s.localResolver.Profiles(),
+		Listeners: s.listeners(),
+	}
+
+	if ur, ok := s.resolver.(*upstream.UpstreamResolver); ok {
+		state.Upstreams = ur.GetServers()
+		for _, server := range ur.GetStats().Servers {
+			if server.Healthy {
+				state.Healthy = append(state.Healthy, server.Address)
+			}
+		}
+	}
+
+	return state
+}
+
+func (s *Server) listeners() []string {
+	var listeners []string
+	for _, server := range s.servers {
+		// reuse_port opens several sockets on one address
+		if url := server.Net + "://" + server.Addr; !slices.Contains(listeners, url) {
+			listeners = append(listeners, url)
+		}
+	}
+	if s.dnscrypt != nil {
+		for _, addr := range dnscryptAddrs(s.config) {
+			listeners = append(listeners, "dnscrypt://"+addr)
+		}
+	}
+	if s.admin != nil {
+		listeners = append(listeners, "http://"+s.admin.Addr())
+	}
+	return listeners
+}
+
+func configState(cfg *config.Config) State {
+	discard := logrus.New()
+	discard.SetOutput(io.Discard)
+
+	local := resolver.NewLocalResolver(&cfg.Records, discard)
+	local.SetProfiles(cfg.Profiles)
+
+	listeners := listenerURLs(cfg)
+	if cfg.Admin.Enabled {
+		listeners = append(listeners, "http://"+net.JoinHostPort(cfg.Admin.BindAddress, fmt.Sprint(cfg.Admin.Port)))
+	}
+
+	state := State{
+		Records:   sortedEntries(local.Snapshot()),
+		Profiles:  local.Profiles(),
+		Listeners: listeners,
+	}
+	if cfg.Upstream.Mode != upstream.ModeRecursive {
+		state.Upstreams = cfg.Upstream.UpstreamAddresses()
+	}
+	return state
+}
+
+// DiffState compares the runtime state with what the config file on disk
+// would produce if the server were restarted now.
+func (s *Server) DiffState() (StateDiff, error) {
+	if s.configPath == "" {
+		return StateDiff{}, fmt.Errorf("no config file to compare against")
+	}
+
+	cfg, err := s.configLoader.Load(s.configPath)
+	if err != nil {
+		return StateDiff{}, err
+	}
+
+	onDisk := configState(cfg)
+	runtime := s.RuntimeState()
+
+	diff := StateDiff{
+		ConfigFile: s.configPath,
+		Records:    resolver.DiffSnapshots(entryMap(onDisk.Records), entryMap(runtime.Records)),
+		Profiles:   []ProfileDiff{},
+		Upstreams:  diffLists(onDisk.Upstreams, runtime.Upstreams),
+		Listeners:  diffLists(onDisk.Listeners, runtime.Listeners),
+	}
+	diff.Records.Source = "runtime"
+	diff.Records.Timestamp = time.Now()
+
+	configured := make(map[string]bool)
+	for _, p := range onDisk.Profiles {
+		configured[p.Name] = p.Enabled
+	}
+	for _, p := range runtime.Profiles {
+		if enabled, exists := configured[p.Name]; !exists || enabled != p.Enabled {
+			diff.Profiles = append(diff.Profiles, ProfileDiff{
+				Name:       p.Name,
+				Configured: enabled,
+				Runtime:    p.Enabled,
+			})
+		}
+	}
+
+	diff.InSync = diff.Records.Empty() && len(diff.Profiles) == 0 &&
+		len(diff.Upstreams.Added)+len(diff.Upstreams.Removed) == 0 &&
+		len(diff.Listeners.Added)+len(diff.Listeners.Removed) == 0
+
+	return diff, nil
+}
+
+func sortedEntries(snapshot map[string]resolver.RecordEntry) []resolver.RecordEntry {
+	entries := make([]resolver.RecordEntry, 0, len(snapshot))
+	for _, entry := range snapshot {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Type < entries[j].Type
+	})
+	return entries
+}
+
+func entryMap(entries []resolver.RecordEntry) map[string]resolver.RecordEntry {
+	m := make(map[string]resolver.RecordEntry, len(entries))
+	for _, entry := range entries {
+		m[entry.Type+" "+entry.Name] = entry
+	}
+	return m
+}
+
+func diffLists(before, after []string) ListDiff {
+	diff := ListDiff{Added: []string{}, Removed: []string{}}
+
+	inBefore := make(map[string]bool, len(before))
+	for _, item := range before {
+		inBefore[item] = true
+	}
+	inAfter := make(map[string]bool, len(after))
+	for _, item := range after {
+		inAfter[item] = true
+		if !inBefore[item] {
+			diff.Added = append(diff.Added, item)
+		}
+	}
+	for _, item := range before {
+		if !inAfter[item] {
+			diff.Removed = append(diff.Removed, item)
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"time"
+
+	"dns-server/internal/cache"
+	dnshandler "dns-server/internal/dns"
+	"dns-server/internal/features"
+	"dns-server/internal/metrics"
+	"dns-server/internal/secondary"
+	"dns-server/internal/upstream"
+)
+
+// topSummary is how many names and zones GetStats includes; the admin API
+// serves the full lists.
+const topSummary = 10
+
+type Stats struct {
+	StartedAt time.Time              `json:"started_at"`
+	Queries   dnshandler.Stats       `json:"queries"`
+	CacheSize int                    `json:"cache_size"`
+	Upstream  upstream.Stats         `json:"upstream"`
+	Loops     []string               `json:"background_loops"`
+	Secondary []secondary.ZoneStatus `json:"secondary_zones,omitempty"`
+	Top       dnshandler.DomainStats `json:"top"`
+	Features  []features.Feature     `json:"features"`
+}
+
+func (s *Server) GetStats() Stats {
+	stats := Stats{
+		StartedAt: s.startedAt,
+		Queries:   s.handler.GetStats(),
+		CacheSize: s.cache.Size(),
+		Features:  features.List(s.config),
+		Top:       s.handler.DomainStats(topSummary),
+	}
+
+	if ur, ok := s.resolver.(*upstream.UpstreamResolver); ok {
+		stats.Upstream = ur.GetStats()
+	}
+
+	if s.secondary != nil {
+		stats.Secondary = s.secondary.Status()
+	}
+
+	if s.loops != nil {
+		stats.Loops = s.loops.Running()
+	}
+
+	return stats
+}
+
+func (s *Server) collect(w *metrics.Writer) {
+	w.Gauge("dns_cache_entries", "Number of entries in the response cache.", float64(s.cache.Size()))
+	if lruCache, ok := s.cache.(*cache.LRUCache); ok {
+		w.Gauge("dns_cache_capacity", "Current maximum number of cache entries.", float64(lruCache.Capacity()))
+		w.Gauge("dns_cache_shards", "Number of independently locked cache shards.", float64(lruCache.Shards()))
+		w.Gauge("dns_cache_bytes", "Approximate memory held by cache entries.", float64(lruCache.Bytes()))
+		w.Gauge("dns_cache_max_bytes", "Memory limit of the cache; 0 when unlimited.", float64(lruCache.MaxMemory()))
+		w.Counter("dns_cache_kept_total", "Answers not cached over a live entry of higher credibility.", float64(lruCache.Kept()))
+		lruCache.CollectWrites(w)
+	}
+	if s.clients != nil {
+		w.Counter("dns_acl_dropped_total", "Queries and connections dropped by the listener ACL.", float64(s.clients.Dropped()))
+	}
+	for _, feature := range features.List(s.config) {
+		w.Gauge("dns_feature_enabled", "Whether a compiled-in feature is enabled (1) or not (0).", metrics.Bool(feature.Enabled), metrics.L("feature", feature.Name))
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const rateInterval = 10 * time.Second
+
+type Status struct {
+	Version       string  `json:"version"`
+	UptimeSeconds int64   `json:"uptime_seconds"`
+	Queries       uint64  `json:"queries"`
+	QPS           float64 `json:"qps"`
+	CacheHitRate  float64 `json:"cache_hit_rate"`
+	CacheEntries  int     `json:"cache_entries"`
+}
+
+// rateMeter tracks queries per second over the last rateInterval.
+type rateMeter struct {
+	mu   sync.Mutex
+	last uint64
+	rate float64
+}
+
+func (m *rateMeter) run(ctx context.Context, count func() uint64) {
+	ticker := time.NewTicker(rateInterval)
+	defer ticker.Stop()
+
+	m.mu.Lock()
+	m.last = count()
+	m.mu.Unlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := count()
+			m.mu.Lock()
+			m.rate = float64(current-m.last) / rateInterval.Seconds()
+			m.last = current
+			m.mu.Unlock()
+		}
+	}
+}
+
+func (m *rateMeter) value() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rate
+}
+
+func (s *Server) SetVersion(version string) {
+	s.version = version
+	s.handler.SetBuildVersion(version)
+}
+
+func (s *Server) GetStatus() Status {
+	queries := s.handler.GetStats()
+
+	status := Status{
+		Version:       s.version,
+		UptimeSeconds: int64(time.Since(s.startedAt).Seconds()),
+		Queries:       queries.Queries,
+		QPS:           math.Round(s.qps.value()*100) / 100,
+		CacheEntries:  s.cache.Size(),
+	}
+	if queries.Queries > 0 {
+		status.CacheHitRate = math.Round(float64(queries.CacheHits)/float64(queries.Queries)*10000) / 10000
+	}
+
+	return status
+}
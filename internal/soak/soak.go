@@ -0,0 +1,252 @@
+package soak
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"dns-server/internal/config"
+	dnshandler "dns-server/internal/dns"
+	"dns-server/internal/server"
+	"dns-server/pkg/logger"
+
+	"github.com/miekg/dns"
+)
+
+// queryTimeout is how long a client waits for the server; a query without
+// an answer by then counts as an error with this latency.
+const queryTimeout = 2 * time.Second
+
+type Report struct {
+	Duration     time.Duration    `json:"duration"`
+	Queries      int              `json:"queries"`
+	Answered     int              `json:"answered"`
+	Servfail     int              `json:"servfail"`
+	Errors       int              `json:"errors"`
+	SuccessRate  float64          `json:"success_rate"`
+	ServfailRate float64          `json:"servfail_rate"`
+	P50          time.Duration    `json:"p50"`
+	P99          time.Duration    `json:"p99"`
+	Max          time.Duration    `json:"max"`
+	Server       dnshandler.Stats `json:"server"`
+	Upstreams    []UpstreamStats  `json:"upstreams"`
+	Violations   []string         `json:"violations"`
+}
+
+func (r *Report) Failed() bool {
+	return len(r.Violations) > 0
+}
+
+// ReadCorpus reads one query per line as a name optionally followed by a
+// type, A by default. Blank lines and lines starting with # are skipped.
+func ReadCorpus(path string) ([]dns.Question, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var corpus []dns.Question
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		qtype := dns.TypeA
+		if len(fields) > 1 {
+			var ok bool
+			if qtype, ok = dns.StringToType[strings.ToUpper(fields[1])]; !ok {
+				return nil, fmt.Errorf("%s:%d: unknown type %s", path, line, fields[1])
+			}
+		}
+		corpus = append(corpus, dns.Question{Name: dns.Fqdn(fields[0]), Qtype: qtype, Qclass: dns.ClassINET})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(corpus) == 0 {
+		return nil, fmt.Errorf("%s: no queries", path)
+	}
+	return corpus, nil
+}
+
+// Run starts a server built from cfg on a free loopback port, forwarding to
+// simulated upstreams that misbehave as cfg.Chaos describes, replays corpus
+// against it for the chaos duration and checks the result against the SLOs.
+// The admin API, status page, external-dns webhook and cache persistence are
+// turned off, so a soak run can share a host with a running instance.
+func Run(ctx context.Context, cfg *config.Config, corpus []dns.Question, logs *logger.Manager) (*Report, error) {
+	chaos := &cfg.Chaos
+
+	upstreams := make([]*chaosUpstream, 0, chaos.Upstreams)
+	defer func() {
+		for _, u := range upstreams {
+			u.shutdown()
+		}
+	}()
+	soakCfg := *cfg
+	soakCfg.Upstream.Mode = ""
+	soakCfg.Upstream.Servers = nil
+	soakCfg.Upstream.ServerBlocks = nil
+	for i := range chaos.Upstreams {
+		u, err := startUpstream(chaos, i == 0)
+		if err != nil {
+			return nil, err
+		}
+		upstreams = append(upstreams, u)
+		soakCfg.Upstream.Servers = append(soakCfg.Upstream.Servers, u.addr)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	soakCfg.Server.BindAddress = "127.0.0.1"
+	soakCfg.Server.Port = port
+	soakCfg.Server.Listen = nil
+	soakCfg.Server.PIDFile = ""
+	soakCfg.Admin.Enabled = false
+	soakCfg.Status.Enabled = false
+	soakCfg.ExternalDNS.Enabled = false
+	soakCfg.Cache.NoPersist = true
+
+	srv, err := server.NewServer(&soakCfg, logs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create server: %w", err)
+	}
+	serverCtx, stop := context.WithCancel(ctx)
+	if err := srv.Start(serverCtx); err != nil {
+		stop()
+		srv.Wait()
+		return nil, err
+	}
+
+	addr := net.JoinHostPort(soakCfg.Server.BindAddress, fmt.Sprint(port))
+	report := replay(ctx, addr, corpus, chaos)
+
+	report.Server = srv.GetStats().Queries
+	stop()
+	srv.Wait()
+
+	for _, u := range upstreams {
+		report.Upstreams = append(report.Upstreams, u.stats())
+	}
+	report.Violations = checkSLO(report, &chaos.SLO)
+	return report, nil
+}
+
+type result struct {
+	rcode   int
+	err     error
+	latency time.Duration
+}
+
+// replay sends the corpus round robin from chaos.Concurrency clients until
+// the duration is over or ctx is done.
+func replay(ctx context.Context, addr string, corpus []dns.Question, chaos *config.ChaosConfig) *Report {
+	ctx, cancel := context.WithTimeout(ctx, chaos.Duration)
+	defer cancel()
+
+	var mu sync.Mutex
+	var next int
+	var results []result
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range chaos.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := &dns.Client{Net: "udp", Timeout: queryTimeout}
+			var local []result
+			for ctx.Err() == nil {
+				mu.Lock()
+				question := corpus[next%len(corpus)]
+				next++
+				mu.Unlock()
+
+				query := new(dns.Msg)
+				query.SetQuestion(question.Name, question.Qtype)
+				sent := time.Now()
+				response, _, err := client.Exchange(query, addr)
+				r := result{err: err, latency: time.Since(sent)}
+				if err == nil {
+					r.rcode = response.Rcode
+				}
+				local = append(local, r)
+			}
+			mu.Lock()
+			results = append(results, local...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	report := &Report{Duration: time.Since(start), Queries: len(results), Upstreams: []UpstreamStats{}, Violations: []string{}}
+	latencies := make([]time.Duration, 0, len(results))
+	for _, r := range results {
+		latencies = append(latencies, r.latency)
+		switch {
+		case r.err != nil:
+			report.Errors++
+		case r.rcode == dns.RcodeSuccess || r.rcode == dns.RcodeNameError:
+			report.Answered++
+		case r.rcode == dns.RcodeServerFailure:
+			report.Servfail++
+		default:
+			report.Errors++
+		}
+	}
+	if report.Queries > 0 {
+		report.SuccessRate = float64(report.Answered) / float64(report.Queries)
+		report.ServfailRate = float64(report.Servfail) / float64(report.Queries)
+	}
+
+	slices.Sort(latencies)
+	report.P50 = percentile(latencies, 0.50)
+	report.P99 = percentile(latencies, 0.99)
+	if len(latencies) > 0 {
+		report.Max = latencies[len(latencies)-1]
+	}
+	return report
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[min(len(sorted)-1, int(p*float64(len(sorted))))]
+}
+
+func checkSLO(report *Report, slo *config.SLOConfig) []string {
+	violations := []string{}
+	if report.Queries == 0 {
+		return append(violations, "no queries were sent")
+	}
+	if slo.MinSuccessRate > 0 && report.SuccessRate < slo.MinSuccessRate {
+		violations = append(violations, fmt.Sprintf("success rate %.4f is below %.4f", report.SuccessRate, slo.MinSuccessRate))
+	}
+	if slo.MaxServfailRate > 0 && report.ServfailRate > slo.MaxServfailRate {
+		violations = append(violations, fmt.Sprintf("SERVFAIL rate %.4f is above %.4f", report.ServfailRate, slo.MaxServfailRate))
+	}
+	if slo.MaxP99 > 0 && report.P99 > slo.MaxP99 {
+		violations = append(violations, fmt.Sprintf("p99 latency %s is above %s", report.P99, slo.MaxP99))
+	}
+	return violations
+}
+
+func freePort() (int, error) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to find a free port: %w", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port, nil
+}
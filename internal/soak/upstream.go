@@ -0,0 +1,155 @@
+package soak
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// UpstreamStats counts what the simulated upstreams did with the queries
+// they received.
+type UpstreamStats struct {
+	Queries   uint64 `json:"queries"`
+	Answered  uint64 `json:"answered"`
+	Dropped   uint64 `json:"dropped"`
+	Servfail  uint64 `json:"servfail"`
+	Malformed uint64 `json:"malformed"`
+	Delayed   uint64 `json:"delayed"`
+	Outage    uint64 `json:"outage"`
+}
+
+// chaosUpstream answers every name with a synthetic address and applies the
+// chaos profile to each query. Names whose first label starts with "nx-" do
+// not exist.
+type chaosUpstream struct {
+	server  *dns.Server
+	addr    string
+	chaos   *config.ChaosConfig
+	outages bool
+	started time.Time
+
+	queries, answered, dropped, servfail, malformed, delayed, outage atomic.Uint64
+}
+
+// startUpstream listens on a free loopback port. The upstream with outages
+// set also goes down for the configured outage windows.
+func startUpstream(chaos *config.ChaosConfig, outages bool) (*chaosUpstream, error) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for simulated upstream: %w", err)
+	}
+
+	u := &chaosUpstream{
+		addr:    conn.LocalAddr().String(),
+		chaos:   chaos,
+		outages: outages,
+		started: time.Now(),
+	}
+	started := make(chan struct{})
+	u.server = &dns.Server{
+		PacketConn:        conn,
+		Handler:           u,
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go u.server.ActivateAndServe()
+	<-started
+	return u, nil
+}
+
+func (u *chaosUpstream) down() bool {
+	if !u.outages || u.chaos.Outage == 0 {
+		return false
+	}
+	elapsed := time.Since(u.started) % u.chaos.OutageEvery
+	return elapsed >= u.chaos.OutageEvery-u.chaos.Outage
+}
+
+func (u *chaosUpstream) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
+	u.queries.Add(1)
+	if u.down() {
+		u.outage.Add(1)
+		return
+	}
+
+	if rand.Float64() < u.chaos.LatencyRate {
+		u.delayed.Add(1)
+		time.Sleep(u.chaos.Latency)
+	}
+
+	response := u.answer(query)
+	roll := rand.Float64()
+	switch {
+	case roll < u.chaos.DropRate:
+		u.dropped.Add(1)
+		return
+	case roll < u.chaos.DropRate+u.chaos.ServfailRate:
+		u.servfail.Add(1)
+		response = new(dns.Msg)
+		response.SetRcode(query, dns.RcodeServerFailure)
+	case roll < u.chaos.DropRate+u.chaos.ServfailRate+u.chaos.MalformedRate:
+		u.malformed.Add(1)
+		wire, err := response.Pack()
+		if err == nil {
+			// keep the header, so the response matches its query, and cut
+			// the rest short
+			w.Write(wire[:max(12, len(wire)/2)])
+		}
+		return
+	default:
+		u.answered.Add(1)
+	}
+	w.WriteMsg(response)
+}
+
+func (u *chaosUpstream) answer(query *dns.Msg) *dns.Msg {
+	response := new(dns.Msg)
+	response.SetReply(query)
+	response.RecursionAvailable = true
+	if len(query.Question) == 0 {
+		return response
+	}
+
+	q := query.Question[0]
+	if strings.HasPrefix(strings.ToLower(q.Name), "nx-") {
+		response.Rcode = dns.RcodeNameError
+		return response
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(q.Name)))
+	sum := h.Sum32()
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: uint32(u.chaos.AnswerTTL.Seconds())}
+	switch q.Qtype {
+	case dns.TypeA:
+		response.Answer = append(response.Answer, &dns.A{Hdr: hdr, A: net.IPv4(198, 18, byte(sum>>8), byte(sum))})
+	case dns.TypeAAAA:
+		ip := net.ParseIP("2001:db8::")
+		ip[12], ip[13], ip[14], ip[15] = byte(sum>>24), byte(sum>>16), byte(sum>>8), byte(sum)
+		response.Answer = append(response.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+	}
+	return response
+}
+
+func (u *chaosUpstream) stats() UpstreamStats {
+	return UpstreamStats{
+		Queries:   u.queries.Load(),
+		Answered:  u.answered.Load(),
+		Dropped:   u.dropped.Load(),
+		Servfail:  u.servfail.Load(),
+		Malformed: u.malformed.Load(),
+		Delayed:   u.delayed.Load(),
+		Outage:    u.outage.Load(),
+	}
+}
+
+func (u *chaosUpstream) shutdown() {
+	u.server.Shutdown()
+}
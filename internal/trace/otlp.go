@@ -0,0 +1,289 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Span kinds and status codes of the OTLP trace protocol.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+
+	statusError = 2
+)
+
+// Exporter sends finished traces to an OpenTelemetry collector as OTLP/HTTP
+// with JSON encoding, in batches from a background loop, so a slow or
+// missing collector never delays answers. Traces are dropped when its
+// buffer is full.
+type Exporter struct {
+	endpoint      string
+	headers       map[string]string
+	service       string
+	batchSize     int
+	flushInterval time.Duration
+	client        *http.Client
+	traces        chan *Trace
+	logger        *logrus.Logger
+
+	exported, failed, dropped atomic.Uint64
+}
+
+func NewExporter(cfg *config.TracingConfig, logger *logrus.Logger) *Exporter {
+	return &Exporter{
+		endpoint:      cfg.Endpoint,
+		headers:       cfg.Headers,
+		service:       cfg.ServiceName,
+		batchSize:     cfg.BatchSize,
+		flushInterval: cfg.FlushInterval,
+		client:        &http.Client{Timeout: cfg.Timeout},
+		traces:        make(chan *Trace, cfg.BatchSize*4),
+		logger:        logger,
+	}
+}
+
+func (e *Exporter) add(t *Trace) {
+	select {
+	case e.traces <- t:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+func (e *Exporter) Run(ctx context.Context) {
+	flush := time.NewTicker(e.flushInterval)
+	defer flush.Stop()
+
+	batch := make([]*Trace, 0, e.batchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		// the client timeout, not ctx, bounds the post, so the spans sent once
+		// ctx is done still reach the collector
+		sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), e.client.Timeout)
+		defer cancel()
+		spans := e.spans(batch)
+		if err := e.post(sendCtx, spans); err != nil {
+			e.failed.Add(uint64(len(spans)))
+			e.logger.WithFields(logrus.Fields{
+				"spans": len(spans),
+				"error": err,
+			}).Warn("failed to export traces")
+		} else {
+			e.exported.Add(uint64(len(spans)))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			send()
+			return
+		case t := <-e.traces:
+			batch = append(batch, t)
+			if len(batch) >= e.batchSize {
+				send()
+			}
+		case <-flush.C:
+			send()
+		}
+	}
+}
+
+func (e *Exporter) Collect(w *metrics.Writer) {
+	w.Counter("dns_trace_spans_exported_total", "Spans accepted by the OpenTelemetry collector.", float64(e.exported.Load()))
+	w.Counter("dns_trace_spans_failed_total", "Spans that could not be sent to the OpenTelemetry collector.", float64(e.failed.Load()))
+	w.Counter("dns_traces_dropped_total", "Sampled traces dropped because the export buffer was full.", float64(e.dropped.Load()))
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func attribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: value}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func spanID() string {
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	return fmt.Sprintf("%x", id)
+}
+
+// spans turns every trace into a server span for the query with a child
+// span per stage. A stage that ran within another, as an exchange within
+// the upstream lookup, becomes a child of that one.
+func (e *Exporter) spans(traces []*Trace) []otlpSpan {
+	var spans []otlpSpan
+	for _, t := range traces {
+		root := otlpSpan{
+			TraceID: t.ID,
+			SpanID:  spanID(),
+			Name:    "dns.query",
+			Kind:    spanKindServer,
+			Start:   unixNano(t.Start),
+			End:     unixNano(t.Start.Add(time.Duration(t.Total))),
+			Attributes: []otlpAttribute{
+				attribute("dns.question.name", t.Name),
+				attribute("dns.question.type", t.Type),
+				attribute("client.address", t.Client),
+				attribute("dns.response.code", t.Rcode),
+				attribute("dns.answer.source", t.Source),
+			},
+		}
+		if t.Rcode == "" || t.Rcode == "SERVFAIL" {
+			root.Status = &otlpStatus{Code: statusError, Message: t.Rcode}
+		}
+		spans = append(spans, root)
+
+		ids := make([]string, len(t.Stages))
+		for i := range t.Stages {
+			ids[i] = spanID()
+		}
+		for i, stage := range t.Stages {
+			span := otlpSpan{
+				TraceID:      t.ID,
+				SpanID:       ids[i],
+				ParentSpanID: root.SpanID,
+				Name:         "dns." + stage.Name,
+				Kind:         spanKindInternal,
+				Start:        unixNano(t.Start.Add(time.Duration(stage.Offset))),
+				End:          unixNano(t.Start.Add(time.Duration(stage.Offset + stage.Duration))),
+			}
+			if parent := enclosing(t.Stages, i); parent >= 0 {
+				span.ParentSpanID = ids[parent]
+			}
+			if stage.Name == "exchange" {
+				span.Kind = spanKindClient
+			}
+			if stage.Detail != "" {
+				span.Attributes = []otlpAttribute{attribute("dns.detail", stage.Detail)}
+			}
+			if stage.Failed {
+				span.Status = &otlpStatus{Code: statusError, Message: stage.Detail}
+			}
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// enclosing returns the index of the shortest other stage that ran the
+// whole time stages[i] did, or -1 when none did.
+func enclosing(stages []Stage, i int) int {
+	start, end := stages[i].Offset, stages[i].Offset+stages[i].Duration
+	parent := -1
+	for j, stage := range stages {
+		if j == i || stage.Offset > start || stage.Offset+stage.Duration < end {
+			continue
+		}
+		// of two identical stages, only the later one is the child
+		if stage.Duration == stages[i].Duration && j > i {
+			continue
+		}
+		if parent < 0 || stage.Duration < stages[parent].Duration {
+			parent = j
+		}
+	}
+	return parent
+}
+
+func (e *Exporter) post(ctx context.Context, spans []otlpSpan) error {
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{attribute("service.name", e.service)}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "dns-server"},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returned %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
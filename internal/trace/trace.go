@@ -0,0 +1,255 @@
+package trace
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	mathrand "math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxCaptured is how many captured traces are kept for the admin API; the
+// oldest go first.
+const maxCaptured = 100
+
+// Trace follows one query through the server. Its ID is added to every log
+// entry about the query, from the handler down to the upstream exchanges.
+// When the query was picked for capture or sampled for export, the stages
+// it went through are recorded with their timing as well.
+type Trace struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Client  string    `json:"client"`
+	Start   time.Time `json:"start"`
+	Total   Duration  `json:"total_ms"`
+	Rcode   string    `json:"rcode"`
+	Source  string    `json:"source"`
+	Stages  []Stage   `json:"stages"`
+	capture bool
+	export  bool
+
+	mu sync.Mutex
+}
+
+// Stage is a step of a captured query: Offset after the query arrived, it
+// took Duration. Detail says what it found or whom it asked.
+type Stage struct {
+	Name     string   `json:"name"`
+	Offset   Duration `json:"offset_ms"`
+	Duration Duration `json:"duration_ms"`
+	Detail   string   `json:"detail,omitempty"`
+	Failed   bool     `json:"failed,omitempty"`
+}
+
+// Duration is reported in milliseconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return fmt.Appendf(nil, "%.3f", float64(time.Duration(d).Microseconds())/1000), nil
+}
+
+type contextKey struct{}
+
+// With returns ctx carrying t.
+func With(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// From returns the trace ctx carries, or nil.
+func From(ctx context.Context) *Trace {
+	t, _ := ctx.Value(contextKey{}).(*Trace)
+	return t
+}
+
+// ID returns the ID of the trace ctx carries, or "" without one.
+func ID(ctx context.Context) string {
+	if t := From(ctx); t != nil {
+		return t.ID
+	}
+	return ""
+}
+
+// Captured reports whether the stages of t are recorded. It is false for a
+// nil Trace.
+func (t *Trace) Captured() bool {
+	return t != nil && t.capture
+}
+
+// Recorded reports whether the stages of t are recorded, for capture or
+// export. It is false for a nil Trace.
+func (t *Trace) Recorded() bool {
+	return t != nil && (t.capture || t.export)
+}
+
+// Stage records a step that began at start and ends now. It does nothing
+// unless t is recorded, so callers need not check.
+func (t *Trace) Stage(name string, start time.Time, detail string) {
+	t.stage(name, start, detail, false)
+}
+
+// StageFailed records a step that failed, as Stage does.
+func (t *Trace) StageFailed(name string, start time.Time, detail string) {
+	t.stage(name, start, detail, true)
+}
+
+func (t *Trace) stage(name string, start time.Time, detail string, failed bool) {
+	if !t.Recorded() {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	t.Stages = append(t.Stages, Stage{
+		Name:     name,
+		Offset:   Duration(start.Sub(t.Start)),
+		Duration: Duration(now.Sub(start)),
+		Detail:   detail,
+		Failed:   failed,
+	})
+	t.mu.Unlock()
+}
+
+// Tracer hands out a trace to every query and captures the next ones whose
+// name matches while it is armed. With an exporter set, it also exports a
+// sample of them.
+type Tracer struct {
+	prefix     uint64
+	counter    atomic.Uint64
+	armed      atomic.Bool
+	exporter   *Exporter
+	sampleRate float64
+
+	mu        sync.Mutex
+	match     string
+	remaining int
+	captured  []*Trace
+}
+
+func NewTracer() *Tracer {
+	var seed [8]byte
+	rand.Read(seed[:])
+	return &Tracer{prefix: binary.BigEndian.Uint64(seed[:])}
+}
+
+// SetExporter exports the traces of sampleRate of the queries through
+// exporter.
+func (tr *Tracer) SetExporter(exporter *Exporter, sampleRate float64) {
+	tr.exporter = exporter
+	tr.sampleRate = sampleRate
+}
+
+// Arm captures the next count queries for name or a name under it; an
+// empty name matches every query. It replaces any capture still armed.
+func (tr *Tracer) Arm(name string, count int) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.match = strings.ToLower(strings.Trim(name, "."))
+	tr.remaining = count
+	tr.armed.Store(count > 0)
+}
+
+// Start begins the trace of a query for name. Its ID is unique within the
+// process and, by a random prefix, unlikely to repeat across restarts; at
+// 16 bytes it doubles as the OpenTelemetry trace ID.
+func (tr *Tracer) Start(name, qtype, client string) *Trace {
+	t := &Trace{
+		ID:     fmt.Sprintf("%016x%016x", tr.prefix, tr.counter.Add(1)),
+		Name:   strings.TrimSuffix(name, "."),
+		Type:   qtype,
+		Client: client,
+		Start:  time.Now(),
+	}
+	if tr.armed.Load() {
+		t.capture = tr.claim(t.Name)
+	}
+	t.export = tr.exporter != nil && mathrand.Float64() < tr.sampleRate
+	return t
+}
+
+func (tr *Tracer) claim(name string) bool {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if tr.remaining == 0 || !matches(strings.ToLower(name), tr.match) {
+		return false
+	}
+	tr.remaining--
+	tr.armed.Store(tr.remaining > 0)
+	return true
+}
+
+func matches(name, match string) bool {
+	return match == "" || name == match || strings.HasSuffix(name, "."+match)
+}
+
+// Finish records how the query was answered, keeps its trace if it was
+// captured and exports it if it was sampled.
+func (tr *Tracer) Finish(t *Trace, rcode, source string) {
+	if !t.Recorded() {
+		return
+	}
+	// a copy, since exchanges that lost a race may still add stages
+	t.mu.Lock()
+	finished := &Trace{
+		ID:     t.ID,
+		Name:   t.Name,
+		Type:   t.Type,
+		Client: t.Client,
+		Start:  t.Start,
+		Total:  Duration(time.Since(t.Start)),
+		Rcode:  rcode,
+		Source: source,
+		Stages: append([]Stage{}, t.Stages...),
+	}
+	t.mu.Unlock()
+	// stages are recorded as they end; list them as they began
+	slices.SortStableFunc(finished.Stages, func(a, b Stage) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	if t.export {
+		tr.exporter.add(finished)
+	}
+	if !t.capture {
+		return
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if len(tr.captured) == maxCaptured {
+		tr.captured = tr.captured[1:]
+	}
+	tr.captured = append(tr.captured, finished)
+}
+
+// Status is the capture state reported by the admin API.
+type Status struct {
+	Match     string   `json:"match"`
+	Remaining int      `json:"remaining"`
+	Traces    []*Trace `json:"traces"`
+}
+
+func (tr *Tracer) Status() Status {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return Status{
+		Match:     tr.match,
+		Remaining: tr.remaining,
+		Traces:    append([]*Trace{}, tr.captured...),
+	}
+}
+
+// Clear disarms the capture and forgets the captured traces.
+func (tr *Tracer) Clear() {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.match = ""
+	tr.remaining = 0
+	tr.captured = nil
+	tr.armed.Store(false)
+}
@@ -0,0 +1,99 @@
+package transfer
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	notifyRetries = 5
+	notifyTimeout = 2 * time.Second
+)
+
+type notification struct {
+	soa    *dns.SOA
+	target string
+}
+
+// queueNotify schedules a NOTIFY (RFC 1996) to every configured secondary
+// without blocking the caller; it runs with s.mu held.
+func (s *Server) queueNotify(soa *dns.SOA) {
+	for _, target := range s.notifyTargets {
+		select {
+		case s.notifications <- notification{soa: dns.Copy(soa).(*dns.SOA), target: target}:
+		default:
+			s.logger.WithFields(logrus.Fields{
+				"zone":   soa.Hdr.Name,
+				"target": target,
+			}).Warn("notify queue full, dropping NOTIFY")
+		}
+	}
+}
+
+// RunNotify delivers queued NOTIFY messages until ctx is done.
+func (s *Server) RunNotify(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-s.notifications:
+			go s.sendNotify(ctx, n)
+		}
+	}
+}
+
+// sendNotify retries with a doubling interval until the secondary
+// acknowledges, as an unanswered NOTIFY may simply have been lost.
+func (s *Server) sendNotify(ctx context.Context, n notification) {
+	fields := logrus.Fields{
+		"zone":   n.soa.Hdr.Name,
+		"serial": n.soa.Serial,
+		"target": n.target,
+	}
+
+	client := &dns.Client{Timeout: notifyTimeout}
+	if s.notifyKey != "" {
+		client.TsigSecret = map[string]string{s.notifyKey: s.notifySecret}
+	}
+
+	backoff := time.Second
+	for attempt := 1; attempt <= notifyRetries; attempt++ {
+		msg := new(dns.Msg)
+		msg.SetNotify(n.soa.Hdr.Name)
+		msg.Answer = []dns.RR{n.soa}
+		if s.notifyKey != "" {
+			msg.SetTsig(s.notifyKey, s.notifyAlgorithm, 300, time.Now().Unix())
+		}
+
+		response, _, err := client.ExchangeContext(ctx, msg, n.target)
+		if err == nil && response.Rcode == dns.RcodeSuccess {
+			s.logger.WithFields(fields).Info("NOTIFY acknowledged")
+			return
+		}
+
+		fields["attempt"] = attempt
+		if err != nil {
+			fields["error"] = err
+		} else {
+			fields["rcode"] = dns.RcodeToString[response.Rcode]
+		}
+		s.logger.WithFields(fields).Debug("NOTIFY not acknowledged")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	s.logger.WithFields(fields).Warn("secondary did not acknowledge NOTIFY")
+}
+
+func normalizeKey(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
@@ -0,0 +1,202 @@
+package transfer
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/resolver"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const envelopeSize = 500
+
+type Server struct {
+	mu          sync.RWMutex
+	zones       map[string]*zone
+	allowFrom   []*net.IPNet
+	journalSize int
+
+	notifyTargets   []string
+	notifyKey       string
+	notifyAlgorithm string
+	notifySecret    string
+	notifications   chan notification
+
+	local  *resolver.LocalResolver
+	logger *logrus.Logger
+}
+
+func NewServer(cfg *config.TransferConfig, local *resolver.LocalResolver, logger *logrus.Logger) (*Server, error) {
+	s := &Server{
+		zones:       make(map[string]*zone),
+		journalSize: cfg.JournalSize,
+		local:       local,
+		logger:      logger,
+
+		notifyTargets: cfg.Notify,
+		notifications: make(chan notification, 64),
+	}
+
+	if key, exists := cfg.TSIG[cfg.NotifyKey]; exists && cfg.NotifyKey != "" {
+		s.notifyKey = normalizeKey(cfg.NotifyKey)
+		s.notifyAlgorithm = normalizeKey(key.Algorithm)
+		s.notifySecret = key.Secret
+	}
+
+	for _, allowed := range cfg.AllowFrom {
+		network, err := config.ParseNetwork(allowed)
+		if err != nil {
+			return nil, err
+		}
+		s.allowFrom = append(s.allowFrom, network)
+	}
+
+	for _, name := range cfg.Zones {
+		origin := strings.ToLower(dns.Fqdn(name))
+		s.zones[origin] = &zone{origin: origin}
+	}
+
+	s.Refresh()
+	return s, nil
+}
+
+// Refresh rebuilds every zone from the local records. Zones whose content
+// changed get a new serial and a journal entry for IXFR.
+func (s *Server) Refresh() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, z := range s.zones {
+		if changed, err := z.refresh(s.local.ZoneRecords(z.origin), s.journalSize); err != nil {
+			s.logger.WithFields(logrus.Fields{
+				"zone":  z.origin,
+				"error": err,
+			}).Warn("zone cannot be transferred")
+		} else if changed {
+			s.logger.WithFields(logrus.Fields{
+				"zone":   z.origin,
+				"serial": z.serial,
+			}).Info("zone serial updated")
+			s.queueNotify(z.soa)
+		}
+	}
+}
+
+func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	question := r.Question[0]
+	fields := logrus.Fields{
+		"zone":   question.Name,
+		"qtype":  dns.TypeToString[question.Qtype],
+		"client": w.RemoteAddr().String(),
+	}
+
+	if !s.allowed(w, r) {
+		s.logger.WithFields(fields).Warn("zone transfer denied")
+		s.refuse(w, r, dns.RcodeRefused)
+		return
+	}
+
+	s.mu.RLock()
+	z, exists := s.zones[strings.ToLower(question.Name)]
+	var records []dns.RR
+	if exists {
+		records = z.transfer(r)
+	}
+	s.mu.RUnlock()
+
+	if records == nil {
+		s.logger.WithFields(fields).Debug("transfer requested for unknown zone")
+		s.refuse(w, r, dns.RcodeNotAuth)
+		return
+	}
+
+	// AXFR needs TCP; an IXFR over UDP only gets the current SOA, which tells
+	// the client to retry over TCP if it is behind (RFC 1995 section 2)
+	if strings.HasPrefix(w.Network(), "udp") {
+		if question.Qtype == dns.TypeAXFR {
+			s.refuse(w, r, dns.RcodeRefused)
+			return
+		}
+		records = records[:1]
+	}
+
+	ch := make(chan *dns.Envelope, len(records)/envelopeSize+1)
+	for start := 0; start < len(records); start += envelopeSize {
+		end := min(start+envelopeSize, len(records))
+		ch <- &dns.Envelope{RR: records[start:end]}
+	}
+	close(ch)
+
+	if err := new(dns.Transfer).Out(w, r, ch); err != nil {
+		fields["error"] = err
+		s.logger.WithFields(fields).Warn("zone transfer failed")
+		return
+	}
+
+	fields["records"] = len(records)
+	s.logger.WithFields(fields).Info("zone transferred")
+}
+
+// allowed accepts a request signed with a known TSIG key or coming from an
+// address in allow_from. A request with a bad signature is always denied.
+func (s *Server) allowed(w dns.ResponseWriter, r *dns.Msg) bool {
+	if r.IsTsig() != nil {
+		return w.TsigStatus() == nil
+	}
+
+	host, _, err := net.SplitHostPort(w.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, network := range s.allowFrom {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) refuse(w dns.ResponseWriter, r *dns.Msg, rcode int) {
+	response := new(dns.Msg)
+	response.SetRcode(r, rcode)
+	if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() == nil {
+		response.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	}
+	if err := w.WriteMsg(response); err != nil {
+		s.logger.WithError(err).Debug("failed to write transfer response")
+	}
+}
+
+// Resolve answers SOA queries at the apex of served zones with the current
+// serial, which moves ahead of the configured one as local records change.
+func (s *Server) Resolve(question dns.Question) (*dns.Msg, bool) {
+	if question.Qtype != dns.TypeSOA {
+		return nil, false
+	}
+
+	s.mu.RLock()
+	z, exists := s.zones[strings.ToLower(dns.Fqdn(question.Name))]
+	var soa *dns.SOA
+	if exists && z.soa != nil {
+		soa = dns.Copy(z.soa).(*dns.SOA)
+	}
+	s.mu.RUnlock()
+
+	if soa == nil {
+		return nil, false
+	}
+
+	soa.Hdr.Name = question.Name
+	response := new(dns.Msg)
+	response.SetReply(&dns.Msg{Question: []dns.Question{question}})
+	response.Authoritative = true
+	response.Answer = []dns.RR{soa}
+	return response, true
+}
@@ -0,0 +1,149 @@
+package transfer
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+type zone struct {
+	origin  string
+	soa     *dns.SOA
+	serial  uint32
+	records map[string]dns.RR
+	journal []delta
+}
+
+// delta is one IXFR step from serial from to serial to.
+type delta struct {
+	from    uint32
+	to      uint32
+	removed []dns.RR
+	added   []dns.RR
+}
+
+func (z *zone) refresh(rrs []dns.RR, journalSize int) (bool, error) {
+	var soa *dns.SOA
+	records := make(map[string]dns.RR, len(rrs))
+
+	for _, rr := range rrs {
+		if s, ok := rr.(*dns.SOA); ok {
+			if s.Hdr.Name == z.origin {
+				soa = s
+			}
+			continue
+		}
+		records[rr.String()] = rr
+	}
+
+	if soa == nil {
+		z.soa = nil
+		return false, fmt.Errorf("no SOA record at %s", z.origin)
+	}
+
+	if z.soa == nil && z.records == nil {
+		z.soa, z.serial, z.records = soa, soa.Serial, records
+		return true, nil
+	}
+
+	removed := missing(z.records, records)
+	added := missing(records, z.records)
+	changed := len(removed) > 0 || len(added) > 0
+
+	// local records rarely bump the configured serial, so any content change
+	// moves it forward on its own
+	serial := soa.Serial
+	if !serialGreater(serial, z.serial) {
+		serial = z.serial
+		if changed {
+			serial++
+		}
+	}
+
+	if serial != z.serial {
+		z.journal = append(z.journal, delta{from: z.serial, to: serial, removed: removed, added: added})
+		if len(z.journal) > journalSize {
+			z.journal = z.journal[len(z.journal)-journalSize:]
+		}
+	}
+
+	updated := serial != z.serial || z.soa == nil
+	soa.Serial = serial
+	z.soa, z.serial, z.records = soa, serial, records
+
+	return updated, nil
+}
+
+// transfer returns the records answering an AXFR or IXFR request, or nil if
+// the zone has no SOA. IXFR falls back to a full transfer when the journal
+// does not reach back to the client's serial.
+func (z *zone) transfer(r *dns.Msg) []dns.RR {
+	if z.soa == nil {
+		return nil
+	}
+
+	if r.Question[0].Qtype == dns.TypeIXFR && len(r.Ns) > 0 {
+		if clientSOA, ok := r.Ns[0].(*dns.SOA); ok {
+			if !serialGreater(z.serial, clientSOA.Serial) {
+				return []dns.RR{z.soa}
+			}
+			if records := z.incremental(clientSOA.Serial); records != nil {
+				return records
+			}
+		}
+	}
+
+	records := make([]dns.RR, 0, len(z.records)+2)
+	records = append(records, z.soa)
+	records = append(records, sorted(z.records)...)
+	return append(records, z.soa)
+}
+
+func (z *zone) incremental(from uint32) []dns.RR {
+	start := -1
+	for i, d := range z.journal {
+		if d.from == from {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+
+	records := []dns.RR{z.soa}
+	for _, d := range z.journal[start:] {
+		records = append(records, z.soaWithSerial(d.from))
+		records = append(records, d.removed...)
+		records = append(records, z.soaWithSerial(d.to))
+		records = append(records, d.added...)
+	}
+	return append(records, z.soa)
+}
+
+func (z *zone) soaWithSerial(serial uint32) dns.RR {
+	soa := dns.Copy(z.soa).(*dns.SOA)
+	soa.Serial = serial
+	return soa
+}
+
+func missing(from, in map[string]dns.RR) []dns.RR {
+	var rrs []dns.RR
+	for key, rr := range from {
+		if _, exists := in[key]; !exists {
+			rrs = append(rrs, rr)
+		}
+	}
+	sort.Slice(rrs, func(i, j int) bool { return rrs[i].String() < rrs[j].String() })
+	return rrs
+}
+
+func sorted(records map[string]dns.RR) []dns.RR {
+	return missing(records, nil)
+}
+
+// serialGreater compares SOA serials using RFC 1982 sequence arithmetic.
+func serialGreater(a, b uint32) bool {
+	return int32(a-b) > 0
+}
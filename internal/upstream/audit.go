@@ -0,0 +1,156 @@
+package upstream
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// inflight tracks the queries and health probes currently sent upstream and
+// when they started.
+type inflight struct {
+	mu      sync.Mutex
+	next    uint64
+	started map[uint64]time.Time
+}
+
+func (f *inflight) begin() func() {
+	f.mu.Lock()
+	if f.started == nil {
+		f.started = make(map[uint64]time.Time)
+	}
+	f.next++
+	id := f.next
+	f.started[id] = time.Now()
+	f.mu.Unlock()
+
+	return func() {
+		f.mu.Lock()
+		delete(f.started, id)
+		f.mu.Unlock()
+	}
+}
+
+func (f *inflight) snapshot(stuckAfter time.Duration) (count, stuck int, oldest time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for _, started := range f.started {
+		age := now.Sub(started)
+		oldest = max(oldest, age)
+		if stuckAfter > 0 && age >= stuckAfter {
+			stuck++
+		}
+	}
+	return len(f.started), stuck, oldest
+}
+
+type ConnectionStats struct {
+	Server      string `json:"server"`
+	Open        bool   `json:"open"`
+	Connections int    `json:"connections"`
+	Pending     int    `json:"pending"`
+}
+
+// PoolStats describes the resources held by in-flight upstream queries.
+type PoolStats struct {
+	InFlight       int               `json:"in_flight"`
+	OldestInFlight time.Duration     `json:"oldest_in_flight"`
+	RaceWorkers    int64             `json:"race_workers"`
+	MessagesInUse  int64             `json:"messages_in_use"`
+	MessagesTotal  uint64            `json:"messages_allocated"`
+	Connections    []ConnectionStats `json:"connections"`
+}
+
+func (r *UpstreamResolver) PoolStats() PoolStats {
+	count, _, oldest := r.inflight.snapshot(0)
+	stats := PoolStats{
+		InFlight:       count,
+		OldestInFlight: oldest,
+		RaceWorkers:    r.raceWorkers.Load(),
+		MessagesInUse:  r.msgsInUse.Load(),
+		MessagesTotal:  r.msgsAllocated.Load(),
+		Connections:    []ConnectionStats{},
+	}
+
+	r.pipelinesMu.Lock()
+	pools := maps.Clone(r.pipelines)
+	r.pipelinesMu.Unlock()
+
+	for key, p := range pools {
+		open, pending := p.stats()
+		stats.Connections = append(stats.Connections, ConnectionStats{Server: key, Open: open > 0, Connections: open, Pending: pending})
+	}
+
+	sort.Slice(stats.Connections, func(i, j int) bool {
+		return stats.Connections[i].Server < stats.Connections[j].Server
+	})
+	return stats
+}
+
+// RunAudit periodically checks the pool for signs of leaks: queries in flight
+// for longer than cfg.StuckAfter, and race workers, pooled messages or
+// pipelined queries still held while no query has been in flight for two
+// audits in a row.
+func (r *UpstreamResolver) RunAudit(ctx context.Context, cfg *config.UpstreamAuditConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	var idleLeaks int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		count, stuck, oldest := r.inflight.snapshot(cfg.StuckAfter)
+		if stuck > 0 {
+			r.logger.WithFields(logrus.Fields{
+				"in_flight": count,
+				"stuck":     stuck,
+				"oldest":    oldest.String(),
+			}).Warn("upstream queries stuck in flight")
+		}
+
+		stats := r.PoolStats()
+		var pending int
+		for _, conn := range stats.Connections {
+			pending += conn.Pending
+		}
+		held := stats.RaceWorkers > 0 || stats.MessagesInUse > 0 || pending > 0
+		if count > 0 || !held {
+			idleLeaks = 0
+			continue
+		}
+		if idleLeaks++; idleLeaks == 2 {
+			r.logger.WithFields(logrus.Fields{
+				"race_workers":     stats.RaceWorkers,
+				"messages_in_use":  stats.MessagesInUse,
+				"pipeline_pending": pending,
+			}).Warn("upstream resources held with no query in flight")
+		}
+	}
+}
+
+func collectPool(w *metrics.Writer, stats PoolStats) {
+	w.Gauge("dns_upstream_inflight_queries", "Queries currently being resolved upstream.", float64(stats.InFlight))
+	w.Gauge("dns_upstream_oldest_inflight_seconds", "Age of the oldest query being resolved upstream.", stats.OldestInFlight.Seconds())
+	w.Gauge("dns_upstream_race_workers", "Goroutines racing a query against an upstream server.", float64(stats.RaceWorkers))
+	w.Gauge("dns_upstream_messages_in_use", "Pooled query messages currently checked out.", float64(stats.MessagesInUse))
+	w.Counter("dns_upstream_messages_allocated_total", "Query messages allocated because the pool was empty.", float64(stats.MessagesTotal))
+	for _, conn := range stats.Connections {
+		w.Gauge("dns_upstream_open_connections", "Persistent upstream connections currently open.", float64(conn.Connections), metrics.L("server", conn.Server))
+	}
+	for _, conn := range stats.Connections {
+		w.Gauge("dns_upstream_pipeline_pending", "Queries waiting for an answer on a pipelined connection.", float64(conn.Pending), metrics.L("server", conn.Server))
+	}
+}
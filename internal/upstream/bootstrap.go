@@ -0,0 +1,132 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// bootstrapMinTTL keeps upstream addresses for at least this long, however
+// short the TTL they were answered with.
+const bootstrapMinTTL = time.Minute
+
+// bootstrapResolver looks up the host names of upstream servers, such as
+// the host of a DoH URL, over plain DNS at fixed addresses. Going through
+// the system resolver instead fails when it points back at this server,
+// which cannot answer before it reaches an upstream.
+type bootstrapResolver struct {
+	servers []string
+	client  *dns.Client
+	logger  *logrus.Logger
+
+	mu    sync.Mutex
+	cache map[string]bootstrapEntry
+}
+
+type bootstrapEntry struct {
+	ip      net.IP
+	expires time.Time
+}
+
+// SetBootstrap resolves upstream host names through servers, plain DNS
+// host:port addresses, instead of the system resolver.
+func (r *UpstreamResolver) SetBootstrap(servers []string) {
+	if len(servers) == 0 {
+		r.bootstrap = nil
+		return
+	}
+	r.bootstrap = &bootstrapResolver{
+		servers: servers,
+		client:  &dns.Client{Net: "udp", Timeout: r.timeout},
+		logger:  r.logger,
+		cache:   make(map[string]bootstrapEntry),
+	}
+}
+
+// lookupBootstrap is the Lookup of every upstream client. It returns a nil
+// IP, leaving host to the system resolver, when no bootstrap servers are
+// configured.
+func (r *UpstreamResolver) lookupBootstrap(ctx context.Context, host string) (net.IP, error) {
+	if r.bootstrap == nil {
+		return nil, nil
+	}
+	return r.bootstrap.lookup(ctx, host)
+}
+
+// lookup returns an address of host, from the cache while its TTL lasts.
+// When no bootstrap server answers, an expired address is used rather than
+// failing every query to the upstream.
+func (b *bootstrapResolver) lookup(ctx context.Context, host string) (net.IP, error) {
+	name := dns.Fqdn(strings.ToLower(host))
+
+	b.mu.Lock()
+	entry, cached := b.cache[name]
+	b.mu.Unlock()
+	if cached && time.Now().Before(entry.expires) {
+		return entry.ip, nil
+	}
+
+	ip, ttl, err := b.resolve(ctx, name)
+	if err != nil {
+		if cached {
+			b.logger.WithFields(logrus.Fields{
+				"host":  host,
+				"error": err,
+			}).Warn("bootstrap lookup failed, using expired address")
+			return entry.ip, nil
+		}
+		return nil, err
+	}
+
+	b.mu.Lock()
+	b.cache[name] = bootstrapEntry{ip: ip, expires: time.Now().Add(max(ttl, bootstrapMinTTL))}
+	b.mu.Unlock()
+
+	b.logger.WithFields(logrus.Fields{
+		"host":    host,
+		"address": ip.String(),
+		"ttl":     ttl.String(),
+	}).Debug("bootstrap lookup")
+	return ip, nil
+}
+
+// resolve asks the bootstrap servers in turn for an A record of name, and
+// an AAAA record when it has none.
+func (b *bootstrapResolver) resolve(ctx context.Context, name string) (net.IP, time.Duration, error) {
+	var lastErr error
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg := &dns.Msg{}
+		msg.SetQuestion(name, qtype)
+		msg.RecursionDesired = true
+
+		for _, server := range b.servers {
+			response, _, err := b.client.ExchangeContext(ctx, msg, server)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if response.Rcode != dns.RcodeSuccess {
+				lastErr = fmt.Errorf("%s returned %s", server, dns.RcodeToString[response.Rcode])
+				continue
+			}
+			for _, rr := range response.Answer {
+				switch rr := rr.(type) {
+				case *dns.A:
+					return rr.A, time.Duration(rr.Hdr.Ttl) * time.Second, nil
+				case *dns.AAAA:
+					return rr.AAAA, time.Duration(rr.Hdr.Ttl) * time.Second, nil
+				}
+			}
+			// no records of this type; the next type is worth asking
+			lastErr = fmt.Errorf("no address for %s", strings.TrimSuffix(name, "."))
+			break
+		}
+	}
+	return nil, 0, lastErr
+}
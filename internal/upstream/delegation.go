@@ -0,0 +1,97 @@
+package upstream
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	maxDelegationTTL = 24 * time.Hour
+	maxDelegations   = 10000
+)
+
+// delegation is a zone cut. It is authoritative when its nameservers came
+// from the zone's own servers rather than a referral from the parent, which
+// only holds a non-authoritative copy of them.
+type delegation struct {
+	servers       []string
+	expires       time.Time
+	authoritative bool
+}
+
+// delegationCache remembers the nameserver addresses of zone cuts found
+// while following referrals, so later questions below the same cut skip the
+// walk down from the root.
+type delegationCache struct {
+	mu    sync.RWMutex
+	zones map[string]delegation
+	kept  atomic.Uint64
+}
+
+func newDelegationCache() *delegationCache {
+	return &delegationCache{zones: make(map[string]delegation)}
+}
+
+// closest returns the deepest cached, unexpired zone cut enclosing name.
+func (c *delegationCache) closest(name string) (string, []string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	for offset, end := 0, false; !end; offset, end = dns.NextLabel(name, offset) {
+		if d, exists := c.zones[name[offset:]]; exists && now.Before(d.expires) {
+			return name[offset:], d.servers, true
+		}
+	}
+	return "", nil, false
+}
+
+// add caches the servers of zone, unless a live authoritative delegation
+// would be replaced by one from a referral.
+func (c *delegationCache) add(zone string, servers []string, ttl time.Duration, authoritative bool) {
+	if c.size() >= maxDelegations {
+		c.prune()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if d, exists := c.zones[zone]; exists && d.authoritative && !authoritative && now.Before(d.expires) {
+		c.kept.Add(1)
+		return
+	}
+	c.zones[zone] = delegation{
+		servers:       servers,
+		expires:       now.Add(min(ttl, maxDelegationTTL)),
+		authoritative: authoritative,
+	}
+}
+
+// forget drops the delegation of zone, whose servers stopped answering.
+func (c *delegationCache) forget(zone string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.zones, zone)
+}
+
+func (c *delegationCache) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for zone, d := range c.zones {
+		if !now.Before(d.expires) {
+			delete(c.zones, zone)
+		}
+	}
+}
+
+func (c *delegationCache) size() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.zones)
+}
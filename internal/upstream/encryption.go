@@ -0,0 +1,192 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"dns-server/internal/client"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// Upstream encryption modes.
+const (
+	EncryptionOff           = "off"
+	EncryptionOpportunistic = "opportunistic"
+)
+
+// opportunistic remembers which plain upstreams were found to also answer
+// over DNS-over-TLS or DNS-over-HTTPS. Like opportunistic DoT (RFC 7435,
+// RFC 9539) certificates are not verified: the point is to keep queries
+// from passive observers, and a server configured by IP address has no
+// name to check a certificate against.
+type opportunistic struct {
+	client *client.Client
+
+	mu         sync.RWMutex
+	transports map[string]client.Server
+}
+
+func newOpportunistic(timeout time.Duration) *opportunistic {
+	c := client.New(timeout)
+	c.TLSConfig = &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS12}
+	return &opportunistic{
+		client:     c,
+		transports: make(map[string]client.Server),
+	}
+}
+
+func (o *opportunistic) transport(server string) (client.Server, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	spec, ok := o.transports[server]
+	return spec, ok
+}
+
+// set records the encrypted transport of server, or that it has none when
+// spec is nil, and reports whether that changed.
+func (o *opportunistic) set(server string, spec *client.Server) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	old, had := o.transports[server]
+	if spec == nil {
+		delete(o.transports, server)
+		return had
+	}
+	o.transports[server] = *spec
+	return !had || old != *spec
+}
+
+// SetEncryption sends queries for plain host:port upstreams over DoT or DoH
+// once RunEncryptionProbes finds the server supports it, and over UDP until
+// then or when the encrypted transport fails.
+func (r *UpstreamResolver) SetEncryption(mode string) {
+	if mode == EncryptionOpportunistic {
+		r.encryption = newOpportunistic(r.timeout)
+	} else {
+		r.encryption = nil
+	}
+}
+
+// sendEncrypted exchanges msg with server over its encrypted transport, if
+// probing found one. A failed exchange drops back to UDP until the next
+// probe.
+func (r *UpstreamResolver) sendEncrypted(ctx context.Context, msg *dns.Msg, server string) (*dns.Msg, bool) {
+	if r.encryption == nil {
+		return nil, false
+	}
+	spec, ok := r.encryption.transport(server)
+	if !ok {
+		return nil, false
+	}
+
+	response, _, err := r.encryption.client.Exchange(ctx, msg, spec)
+	if err == nil {
+		return response, true
+	}
+	if ctx.Err() == nil && r.encryption.set(server, nil) {
+		r.logger.WithFields(logrus.Fields{
+			"server":    server,
+			"transport": spec.String(),
+			"error":     err,
+		}).Warn("encrypted upstream transport failed, falling back to udp")
+	}
+	return nil, false
+}
+
+// RunEncryptionProbes checks every plain upstream for DoT on port 853 and
+// DoH at /dns-query now and every interval, until ctx is canceled.
+func (r *UpstreamResolver) RunEncryptionProbes(ctx context.Context, interval time.Duration) {
+	for {
+		for _, server := range r.GetServers() {
+			r.probeEncryption(ctx, server)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (r *UpstreamResolver) probeEncryption(ctx context.Context, server string) {
+	spec, err := client.ParseServer(server)
+	if err != nil || spec.Proto != client.ProtoUDP {
+		return
+	}
+	host, _, err := net.SplitHostPort(spec.Address)
+	if err != nil {
+		return
+	}
+
+	candidates := []string{
+		"tls://" + net.JoinHostPort(host, "853"),
+		"https://" + net.JoinHostPort(host, "443") + "/dns-query",
+	}
+	var found *client.Server
+	var errs []string
+	for _, candidate := range candidates {
+		encrypted, err := client.ParseServer(candidate)
+		if err != nil {
+			continue
+		}
+		if err := r.probeTransport(ctx, encrypted); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		found = &encrypted
+		break
+	}
+	if ctx.Err() != nil || !r.encryption.set(server, found) {
+		return
+	}
+
+	if found != nil {
+		r.logger.WithFields(logrus.Fields{
+			"server":    server,
+			"transport": found.String(),
+		}).Info("upstream supports encryption, upgrading")
+	} else {
+		r.logger.WithFields(logrus.Fields{
+			"server": server,
+			"errors": errs,
+		}).Info("upstream does not support encryption, using udp")
+	}
+}
+
+func (r *UpstreamResolver) probeTransport(ctx context.Context, spec client.Server) error {
+	msg := &dns.Msg{}
+	msg.SetQuestion(".", dns.TypeNS)
+	msg.RecursionDesired = true
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	response, _, err := r.encryption.client.Exchange(ctx, msg, spec)
+	if err != nil {
+		return fmt.Errorf("%s: %w", spec, err)
+	}
+	if response.Rcode == dns.RcodeServerFailure || response.Rcode == dns.RcodeRefused {
+		return fmt.Errorf("%s: probe returned %s", spec, dns.RcodeToString[response.Rcode])
+	}
+	return nil
+}
+
+// encryptedTransport names the transport queries for server currently use
+// instead of UDP, or is empty.
+func (r *UpstreamResolver) encryptedTransport(server string) string {
+	if r.encryption == nil {
+		return ""
+	}
+	if spec, ok := r.encryption.transport(server); ok {
+		return spec.String()
+	}
+	return ""
+}
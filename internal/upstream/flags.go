@@ -0,0 +1,56 @@
+package upstream
+
+import (
+	"context"
+	"sync"
+)
+
+type checkingDisabledKey struct{}
+
+// WithCheckingDisabled marks upstream queries made with ctx as CD, so the
+// upstream returns data without DNSSEC validation.
+func WithCheckingDisabled(ctx context.Context, cd bool) context.Context {
+	if !cd {
+		return ctx
+	}
+	return context.WithValue(ctx, checkingDisabledKey{}, true)
+}
+
+func checkingDisabled(ctx context.Context) bool {
+	cd, _ := ctx.Value(checkingDisabledKey{}).(bool)
+	return cd
+}
+
+type originKey struct{}
+
+// Origin records which server answered a query resolved with a context made
+// by WithOrigin.
+type Origin struct {
+	mu     sync.Mutex
+	server string
+}
+
+// WithOrigin returns a context under which resolvers record the server that
+// answers in the returned Origin.
+func WithOrigin(ctx context.Context) (context.Context, *Origin) {
+	origin := &Origin{}
+	return context.WithValue(ctx, originKey{}, origin), origin
+}
+
+// Server is the address of the server that answered, or "" when none did.
+func (o *Origin) Server() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.server
+}
+
+// recordOrigin notes server as the one that answered the query resolved
+// with ctx. When one answer leads to another, as following a CNAME does,
+// the last server to answer is recorded.
+func recordOrigin(ctx context.Context, server string) {
+	if origin, ok := ctx.Value(originKey{}).(*Origin); ok {
+		origin.mu.Lock()
+		origin.server = server
+		origin.mu.Unlock()
+	}
+}
@@ -0,0 +1,212 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/metrics"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+type serverHealth struct {
+	healthy              bool
+	consecutiveFailures  int
+	consecutiveSuccesses int
+	lastCheck            time.Time
+	lastError            string
+	queries              atomic.Uint64
+	failures             atomic.Uint64
+}
+
+type ServerStats struct {
+	Address             string    `json:"address"`
+	Transport           string    `json:"transport,omitempty"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastCheck           time.Time `json:"last_check,omitzero"`
+	LastError           string    `json:"last_error,omitempty"`
+	Queries             uint64    `json:"queries"`
+	Failures            uint64    `json:"failures"`
+}
+
+type Stats struct {
+	HealthyServers int           `json:"healthy_servers"`
+	Servers        []ServerStats `json:"servers"`
+	Pool           PoolStats     `json:"pool"`
+}
+
+func newHealthMap(servers []string) map[string]*serverHealth {
+	health := make(map[string]*serverHealth, len(servers))
+	for _, server := range servers {
+		health[server] = &serverHealth{healthy: true}
+	}
+	return health
+}
+
+// activeServers returns the servers currently in rotation. When every server
+// is marked unhealthy all of them are returned, since failing open beats
+// answering SERVFAIL without trying.
+func (r *UpstreamResolver) activeServers() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	active := make([]string, 0, len(r.servers))
+	for _, server := range r.servers {
+		if state, exists := r.health[server]; !exists || state.healthy {
+			active = append(active, server)
+		}
+	}
+
+	if len(active) == 0 {
+		active = append(active, r.servers...)
+	}
+
+	return active
+}
+
+func (r *UpstreamResolver) recordQuery(server string, err error) {
+	r.mu.RLock()
+	state, exists := r.health[server]
+	r.mu.RUnlock()
+
+	if !exists {
+		return
+	}
+
+	state.queries.Add(1)
+	if err != nil {
+		state.failures.Add(1)
+	}
+}
+
+func (r *UpstreamResolver) RunHealthChecks(ctx context.Context, cfg *config.HealthCheckConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, server := range r.GetServers() {
+				err := r.probe(ctx, server, cfg.ProbeName, cfg.Timeout)
+				r.updateHealth(server, err, cfg)
+			}
+		}
+	}
+}
+
+func (r *UpstreamResolver) probe(ctx context.Context, server, name string, timeout time.Duration) error {
+	defer r.inflight.begin()()
+
+	msg := &dns.Msg{}
+	msg.SetQuestion(dns.Fqdn(name), dns.TypeNS)
+	msg.RecursionDesired = true
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	response, err := r.send(ctx, msg, server)
+	if err != nil {
+		return err
+	}
+
+	if response.Rcode == dns.RcodeServerFailure || response.Rcode == dns.RcodeRefused {
+		return fmt.Errorf("probe returned %s", dns.RcodeToString[response.Rcode])
+	}
+
+	return nil
+}
+
+func (r *UpstreamResolver) updateHealth(server string, err error, cfg *config.HealthCheckConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, exists := r.health[server]
+	if !exists {
+		return
+	}
+
+	state.lastCheck = time.Now()
+
+	if err != nil {
+		state.lastError = err.Error()
+		state.consecutiveSuccesses = 0
+		state.consecutiveFailures++
+
+		if state.healthy && state.consecutiveFailures >= cfg.FailureThreshold {
+			state.healthy = false
+			r.logger.WithFields(logrus.Fields{
+				"server":   server,
+				"failures": state.consecutiveFailures,
+				"error":    err,
+			}).Warn("upstream server marked unhealthy")
+		}
+		return
+	}
+
+	state.lastError = ""
+	state.consecutiveFailures = 0
+	state.consecutiveSuccesses++
+
+	if !state.healthy && state.consecutiveSuccesses >= cfg.RecoveryThreshold {
+		state.healthy = true
+		r.logger.WithField("server", server).Info("upstream server recovered")
+	}
+}
+
+func (r *UpstreamResolver) GetStats() Stats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stats := Stats{
+		Servers: make([]ServerStats, 0, len(r.servers)),
+		Pool:    r.PoolStats(),
+	}
+	for _, server := range r.servers {
+		state := r.health[server]
+		if state.healthy {
+			stats.HealthyServers++
+		}
+
+		stats.Servers = append(stats.Servers, ServerStats{
+			Address:             server,
+			Transport:           r.encryptedTransport(server),
+			Healthy:             state.healthy,
+			ConsecutiveFailures: state.consecutiveFailures,
+			LastCheck:           state.lastCheck,
+			LastError:           state.lastError,
+			Queries:             state.queries.Load(),
+			Failures:            state.failures.Load(),
+		})
+	}
+
+	return stats
+}
+
+func (r *UpstreamResolver) Collect(w *metrics.Writer) {
+	stats := r.GetStats()
+
+	w.Gauge("dns_upstream_healthy_servers", "Number of upstream servers currently in rotation.", float64(stats.HealthyServers))
+	for _, s := range stats.Servers {
+		w.Gauge("dns_upstream_healthy", "Whether the upstream server is healthy (1) or not (0).", metrics.Bool(s.Healthy), metrics.L("server", s.Address))
+	}
+	if r.encryption != nil {
+		for _, s := range stats.Servers {
+			w.Gauge("dns_upstream_encrypted", "Whether queries to the upstream server are upgraded to DoT or DoH (1) or not (0).", metrics.Bool(s.Transport != ""), metrics.L("server", s.Address))
+		}
+	}
+	for _, s := range stats.Servers {
+		w.Counter("dns_upstream_queries_total", "Queries sent to the upstream server.", float64(s.Queries), metrics.L("server", s.Address))
+	}
+	for _, s := range stats.Servers {
+		w.Counter("dns_upstream_failures_total", "Failed exchanges with the upstream server.", float64(s.Failures), metrics.L("server", s.Address))
+	}
+	w.Counter("dns_upstream_scrubbed_records_total", "Records removed from upstream responses for not belonging to the answer.", float64(r.scrubbed.Load()))
+	collectPool(w, stats.Pool)
+}
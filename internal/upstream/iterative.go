@@ -0,0 +1,401 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/metrics"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ModeForward   = "forward"
+	ModeRecursive = "recursive"
+
+	MinimizeOff     = "off"
+	MinimizeRelaxed = "relaxed"
+	MinimizeStrict  = "strict"
+
+	maxReferrals = 30
+	maxDepth     = 8
+)
+
+// RootHints are the IPv4 addresses of the root servers (a through m).
+var RootHints = []string{
+	"198.41.0.4:53",
+	"170.247.170.2:53",
+	"192.33.4.12:53",
+	"199.7.91.13:53",
+	"192.203.230.10:53",
+	"192.5.5.241:53",
+	"192.112.36.4:53",
+	"198.97.190.53:53",
+	"192.36.148.17:53",
+	"192.58.128.30:53",
+	"193.0.14.129:53",
+	"199.7.83.42:53",
+	"202.12.27.33:53",
+}
+
+// IterativeResolver resolves questions itself, starting at the root servers
+// and following referrals, instead of forwarding them to a recursive
+// upstream. With qname minimization (RFC 9156) each server only sees the
+// name one label below the zone it is authoritative for.
+type IterativeResolver struct {
+	roots       []string
+	minimize    string
+	delegations *delegationCache
+	randomCase  bool
+	harden      bool
+	client      *dns.Client
+	logger      *logrus.Logger
+
+	queries   atomic.Uint64
+	referrals atomic.Uint64
+	scrubbed  atomic.Uint64
+}
+
+func NewIterativeResolver(roots []string, timeout time.Duration, logger *logrus.Logger) *IterativeResolver {
+	return &IterativeResolver{
+		roots:       roots,
+		minimize:    MinimizeRelaxed,
+		delegations: newDelegationCache(),
+		client: &dns.Client{
+			Net:     "udp4",
+			Timeout: timeout,
+		},
+		logger: logger,
+	}
+}
+
+// SetQNameMinimization selects off, relaxed or strict minimization. Relaxed
+// retries with the full name when a server answers NXDOMAIN for a shortened
+// one; strict trusts the NXDOMAIN (RFC 8020) and returns it.
+func (r *IterativeResolver) SetQNameMinimization(mode string) {
+	r.minimize = mode
+}
+
+func (r *IterativeResolver) SetRandomizeCase(enabled bool) {
+	r.randomCase = enabled
+}
+
+// SetHardenReferralPath has every referral confirmed by asking the servers
+// it points to for their NS records before they are cached. Delegations so
+// confirmed are not replaced by referrals until they expire.
+func (r *IterativeResolver) SetHardenReferralPath(enabled bool) {
+	r.harden = enabled
+}
+
+func (r *IterativeResolver) Resolve(ctx context.Context, question dns.Question) (*dns.Msg, error) {
+	response, err := r.resolve(ctx, strings.ToLower(dns.Fqdn(question.Name)), question.Qtype, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", question.Name, err)
+	}
+
+	response.Question = []dns.Question{question}
+	response.Authoritative = false
+	response.RecursionAvailable = true
+	return response, nil
+}
+
+func (r *IterativeResolver) resolve(ctx context.Context, name string, qtype uint16, depth int) (*dns.Msg, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("resolution of %s nested too deeply", name)
+	}
+
+	// DS records live on the parent side of the cut
+	start := name
+	if offset, end := dns.NextLabel(name, 0); qtype == dns.TypeDS && !end {
+		start = name[offset:]
+	}
+
+	zone, servers, cached := r.delegations.closest(start)
+	if !cached {
+		zone, servers = ".", r.roots
+	}
+	labels := dns.CountLabel(zone) + 1
+	minimize := r.minimize != MinimizeOff
+
+	for range maxReferrals {
+		qname, sendType := name, qtype
+		if minimize && labels < dns.CountLabel(name) {
+			qname = lastLabels(name, labels)
+			sendType = dns.TypeA
+		}
+
+		response, err := r.query(ctx, qname, sendType, servers)
+		if err != nil && cached {
+			// the cached nameservers may have gone away; walk down again
+			r.delegations.forget(zone)
+			zone, servers, cached = ".", r.roots, false
+			labels = dns.CountLabel(zone) + 1
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if cut, nameservers, ttl := referral(response, zone); cut != "" {
+			addrs, err := r.addresses(ctx, zone, nameservers, response.Extra, depth)
+			if err != nil {
+				return nil, fmt.Errorf("no usable nameserver for %s: %w", cut, err)
+			}
+			r.referrals.Add(1)
+			authoritative := false
+			if r.harden {
+				addrs, ttl, authoritative = r.confirm(ctx, cut, addrs, ttl, depth)
+			}
+			r.delegations.add(cut, addrs, ttl, authoritative)
+			r.logger.WithFields(logrus.Fields{
+				"question": name,
+				"zone":     cut,
+				"servers":  len(addrs),
+			}).Debug("following referral")
+
+			zone, servers, cached = cut, addrs, false
+			labels = dns.CountLabel(cut) + 1
+			continue
+		}
+
+		if qname != name {
+			switch {
+			case response.Rcode != dns.RcodeNameError:
+				// no zone cut at this label
+				labels++
+			case r.minimize == MinimizeStrict:
+				return response, nil
+			default:
+				// some servers answer NXDOMAIN for empty non-terminals
+				minimize = false
+			}
+			continue
+		}
+
+		return r.chase(ctx, name, qtype, response, depth)
+	}
+
+	return nil, fmt.Errorf("too many referrals resolving %s", name)
+}
+
+// chase follows a CNAME answer to its target when the authoritative server
+// did not include the target records itself.
+func (r *IterativeResolver) chase(ctx context.Context, name string, qtype uint16, response *dns.Msg, depth int) (*dns.Msg, error) {
+	if qtype == dns.TypeCNAME || response.Rcode != dns.RcodeSuccess {
+		return response, nil
+	}
+
+	target := ""
+	for _, rr := range response.Answer {
+		switch rr := rr.(type) {
+		case *dns.CNAME:
+			if strings.EqualFold(rr.Hdr.Name, name) {
+				target = rr.Target
+			}
+		default:
+			if rr.Header().Rrtype == qtype {
+				return response, nil
+			}
+		}
+	}
+	if target == "" {
+		return response, nil
+	}
+
+	followed, err := r.resolve(ctx, target, qtype, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	followed.Answer = append(response.Answer, followed.Answer...)
+	return followed, nil
+}
+
+func (r *IterativeResolver) query(ctx context.Context, name string, qtype uint16, servers []string) (*dns.Msg, error) {
+	qname := name
+	if r.randomCase {
+		qname = randomizeCase(name)
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(qname, qtype)
+	msg.RecursionDesired = false
+	msg.SetEdns0(1232, false)
+
+	var lastErr error
+	for _, server := range servers {
+		r.queries.Add(1)
+		response, _, err := r.client.ExchangeContext(ctx, msg, server)
+		if err == nil && response.Truncated {
+			tcp := &dns.Client{Net: "tcp", Timeout: r.client.Timeout}
+			response, _, err = tcp.ExchangeContext(ctx, msg, server)
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("exchange failed with %s: %w", server, err)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		if err := verifyResponse(msg, response, r.randomCase); err != nil {
+			r.logger.WithFields(logrus.Fields{
+				"server": server,
+				"error":  err,
+			}).Warn("discarding mismatched response")
+			lastErr = fmt.Errorf("%s: %w", server, err)
+			continue
+		}
+		if response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("%s returned %s", server, dns.RcodeToString[response.Rcode])
+			continue
+		}
+
+		restoreCase(response, name)
+		stripOPT(response)
+		recordOrigin(ctx, server)
+		if removed := scrub(msg.Question[0], response); removed > 0 {
+			r.scrubbed.Add(uint64(removed))
+			r.logger.WithFields(logrus.Fields{
+				"server":  server,
+				"removed": removed,
+			}).Debug("scrubbed unrelated records from response")
+		}
+		return response, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no servers to query")
+	}
+	return nil, lastErr
+}
+
+// addresses returns the addresses of the delegated nameservers. Glue is only
+// trusted when it lies inside zone, the zone the referring server is
+// authoritative for; other nameservers are resolved from scratch.
+func (r *IterativeResolver) addresses(ctx context.Context, zone string, nameservers []string, extra []dns.RR, depth int) ([]string, error) {
+	var addrs []string
+	for _, rr := range extra {
+		a, ok := rr.(*dns.A)
+		if ok && containsName(nameservers, a.Hdr.Name) && dns.IsSubDomain(zone, strings.ToLower(a.Hdr.Name)) {
+			addrs = append(addrs, net.JoinHostPort(a.A.String(), "53"))
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs, nil
+	}
+
+	var lastErr error
+	for _, ns := range nameservers {
+		response, err := r.resolve(ctx, ns, dns.TypeA, depth+1)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, rr := range response.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				addrs = append(addrs, net.JoinHostPort(a.A.String(), "53"))
+			}
+		}
+		if len(addrs) > 0 {
+			return addrs, nil
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("nameservers have no IPv4 address")
+	}
+	return nil, lastErr
+}
+
+// confirm asks the servers a referral sent us to for the NS records of
+// their zone, cut, since the parent that referred only holds a copy of them
+// that may be stale or forged. It returns the addresses of the nameservers
+// they answer with authoritatively, or addrs and ttl of the referral when
+// they do not.
+func (r *IterativeResolver) confirm(ctx context.Context, cut string, addrs []string, ttl time.Duration, depth int) ([]string, time.Duration, bool) {
+	response, err := r.query(ctx, cut, dns.TypeNS, addrs)
+	if err != nil || !response.Authoritative {
+		return addrs, ttl, false
+	}
+
+	var nameservers []string
+	var nsTTL uint32
+	for _, rr := range response.Answer {
+		if ns, ok := rr.(*dns.NS); ok && strings.EqualFold(ns.Hdr.Name, cut) {
+			if len(nameservers) == 0 || ns.Hdr.Ttl < nsTTL {
+				nsTTL = ns.Hdr.Ttl
+			}
+			nameservers = append(nameservers, strings.ToLower(ns.Ns))
+		}
+	}
+	if len(nameservers) == 0 {
+		return addrs, ttl, false
+	}
+
+	confirmed, err := r.addresses(ctx, cut, nameservers, response.Extra, depth)
+	if err != nil {
+		r.logger.WithFields(logrus.Fields{
+			"zone":  cut,
+			"error": err,
+		}).Debug("keeping referral nameservers")
+		return addrs, ttl, false
+	}
+	return confirmed, time.Duration(nsTTL) * time.Second, true
+}
+
+// referral reports the zone cut, its nameservers and the NS TTL when
+// response delegates to a zone below the current one.
+func referral(response *dns.Msg, zone string) (string, []string, time.Duration) {
+	if response.Authoritative || len(response.Answer) > 0 {
+		return "", nil, 0
+	}
+
+	var cut string
+	var nameservers []string
+	var ttl uint32
+	for _, rr := range response.Ns {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		owner := strings.ToLower(ns.Hdr.Name)
+		if owner == zone || !dns.IsSubDomain(zone, owner) {
+			continue
+		}
+		if cut != "" && owner != cut {
+			continue
+		}
+		if cut == "" || ns.Hdr.Ttl < ttl {
+			ttl = ns.Hdr.Ttl
+		}
+		cut = owner
+		nameservers = append(nameservers, strings.ToLower(ns.Ns))
+	}
+	return cut, nameservers, time.Duration(ttl) * time.Second
+}
+
+func lastLabels(name string, n int) string {
+	offsets := dns.Split(name)
+	return name[offsets[len(offsets)-n]:]
+}
+
+func containsName(names []string, name string) bool {
+	for _, candidate := range names {
+		if strings.EqualFold(candidate, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *IterativeResolver) Collect(w *metrics.Writer) {
+	w.Gauge("dns_recursive_delegations", "Zone cuts held in the delegation cache.", float64(r.delegations.size()))
+	w.Counter("dns_recursive_queries_total", "Queries sent to authoritative servers.", float64(r.queries.Load()))
+	w.Counter("dns_recursive_referrals_total", "Referrals followed while resolving.", float64(r.referrals.Load()))
+	w.Counter("dns_recursive_delegations_kept_total", "Referrals not cached over a live delegation confirmed by the zone's own servers.", float64(r.delegations.kept.Load()))
+	w.Counter("dns_recursive_scrubbed_records_total", "Records removed from responses for not belonging to the answer.", float64(r.scrubbed.Load()))
+}
@@ -0,0 +1,196 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/metrics"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/ipv4"
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// cacheFlush is the top bit of the class of mDNS records, which marks a
+// record set as complete rather than belonging to a class.
+const cacheFlush = 1 << 15
+
+// MDNSResolver answers .local names with one-shot multicast DNS queries
+// (RFC 6762 section 5.1), which responders answer by unicast, so hosts
+// announced by Avahi or Bonjour resolve for clients that only speak
+// unicast DNS. Names under .local never reach next; single-label names do
+// when unqualified lookups are on and nobody on the LAN answers.
+type MDNSResolver struct {
+	next        DNSResolver
+	timeout     time.Duration
+	unqualified bool
+	iface       *net.Interface
+	logger      *logrus.Logger
+	queries     atomic.Uint64
+	answers     atomic.Uint64
+}
+
+func NewMDNSResolver(next DNSResolver, timeout time.Duration, logger *logrus.Logger) *MDNSResolver {
+	return &MDNSResolver{
+		next:    next,
+		timeout: timeout,
+		logger:  logger,
+	}
+}
+
+// SetUnqualified resolves single-label names as <name>.local before
+// sending them to next.
+func (r *MDNSResolver) SetUnqualified(enabled bool) {
+	r.unqualified = enabled
+}
+
+// SetInterface sends queries out on the named interface.
+func (r *MDNSResolver) SetInterface(name string) error {
+	if name == "" {
+		r.iface = nil
+		return nil
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return fmt.Errorf("mdns interface %s: %w", name, err)
+	}
+	r.iface = iface
+	return nil
+}
+
+func (r *MDNSResolver) Resolve(ctx context.Context, question dns.Question) (*dns.Msg, error) {
+	name := strings.ToLower(dns.Fqdn(question.Name))
+	local := dns.IsSubDomain("local.", name)
+	if !local && !(r.unqualified && dns.CountLabel(name) == 1) {
+		return r.next.Resolve(ctx, question)
+	}
+
+	query := name
+	if !local {
+		query = name + "local."
+	}
+	r.queries.Add(1)
+	records, exists, err := r.query(ctx, query, question.Qtype)
+	if err != nil {
+		r.logger.WithFields(logrus.Fields{
+			"question": query,
+			"error":    err,
+		}).Debug("mdns query failed")
+	}
+
+	if len(records) > 0 {
+		r.answers.Add(1)
+		response := new(dns.Msg)
+		response.SetQuestion(question.Name, question.Qtype)
+		response.Response = true
+		response.RecursionAvailable = true
+		for _, rr := range records {
+			rr.Header().Name = question.Name
+		}
+		response.Answer = records
+		recordOrigin(ctx, "mdns")
+		return response, nil
+	}
+
+	if !local {
+		return r.next.Resolve(ctx, question)
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, err
+	}
+
+	// a responder that owns the name but not the type makes this NODATA
+	response := new(dns.Msg)
+	response.SetQuestion(question.Name, question.Qtype)
+	response.Response = true
+	response.RecursionAvailable = true
+	if !exists {
+		response.Rcode = dns.RcodeNameError
+	}
+	return response, nil
+}
+
+// query asks the LAN for name and returns the first records of qtype (or a
+// CNAME) any responder sends, and whether one sent any record of name.
+func (r *MDNSResolver) query(ctx context.Context, name string, qtype uint16) ([]dns.RR, bool, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+
+	pc := ipv4.NewPacketConn(conn)
+	pc.SetMulticastTTL(255)
+	if r.iface != nil {
+		if err := pc.SetMulticastInterface(r.iface); err != nil {
+			return nil, false, err
+		}
+	}
+
+	deadline := time.Now().Add(r.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.RecursionDesired = false
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := conn.WriteTo(packed, mdnsGroup); err != nil {
+		return nil, false, err
+	}
+
+	exists := false
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, exists, err
+		}
+		response := new(dns.Msg)
+		if response.Unpack(buf[:n]) != nil || !response.Response {
+			continue
+		}
+
+		var records []dns.RR
+		denied := false
+		for _, rr := range append(response.Answer, response.Extra...) {
+			hdr := rr.Header()
+			if !strings.EqualFold(hdr.Name, name) || hdr.Rrtype == dns.TypeOPT {
+				continue
+			}
+			exists = true
+			hdr.Class &^= cacheFlush
+			if nsec, ok := rr.(*dns.NSEC); ok {
+				// the owner's negative response: it has no other types
+				denied = !slices.Contains(nsec.TypeBitMap, qtype)
+				continue
+			}
+			if hdr.Rrtype == qtype || hdr.Rrtype == dns.TypeCNAME || qtype == dns.TypeANY {
+				records = append(records, rr)
+			}
+		}
+		if len(records) > 0 {
+			return dns.Dedup(records, nil), true, nil
+		}
+		if denied {
+			return nil, true, nil
+		}
+	}
+}
+
+func (r *MDNSResolver) Collect(w *metrics.Writer) {
+	w.Counter("dns_mdns_queries_total", "Questions sent to the LAN over multicast DNS.", float64(r.queries.Load()))
+	w.Counter("dns_mdns_answers_total", "Multicast DNS questions a responder answered.", float64(r.answers.Load()))
+}
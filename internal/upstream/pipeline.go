@@ -0,0 +1,252 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var errConnectionClosed = errors.New("connection closed")
+
+// pipeline sends queries to one server over a single persistent TCP or TLS
+// connection without waiting for earlier answers. Each outstanding query
+// gets a unique ID and responses are matched back by ID in whatever order
+// the server returns them (RFC 7766 section 6.2.1.1).
+type pipeline struct {
+	network   string
+	address   string
+	resolve   func(ctx context.Context, address string) (string, error)
+	tlsConfig *tls.Config
+	timeout   time.Duration
+
+	mu      sync.Mutex
+	conn    *dns.Conn
+	pending map[uint16]chan *dns.Msg
+
+	writeMu sync.Mutex
+}
+
+func newPipeline(network, address string, resolve func(context.Context, string) (string, error), tlsConfig *tls.Config, timeout time.Duration) *pipeline {
+	return &pipeline{
+		network:   network,
+		address:   address,
+		resolve:   resolve,
+		tlsConfig: tlsConfig,
+		timeout:   timeout,
+		pending:   make(map[uint16]chan *dns.Msg),
+	}
+}
+
+func (p *pipeline) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	conn, err := p.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	id, answer := p.register()
+	query := msg.Copy()
+	query.Id = id
+	query.Compress = true
+
+	p.writeMu.Lock()
+	conn.SetWriteDeadline(time.Now().Add(p.timeout))
+	err = conn.WriteMsg(query)
+	p.writeMu.Unlock()
+	if err != nil {
+		// fail leaves pending alone once conn was replaced
+		p.unregister(id)
+		p.fail(conn)
+		return nil, fmt.Errorf("write to %s failed: %w", p.address, err)
+	}
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	select {
+	case response, ok := <-answer:
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", p.address, errConnectionClosed)
+		}
+		response.Id = msg.Id
+		return response, nil
+	case <-ctx.Done():
+		p.unregister(id)
+		return nil, ctx.Err()
+	case <-timer.C:
+		// a server that stops answering on an open connection is treated
+		// as gone; the next query dials again
+		p.unregister(id)
+		p.fail(conn)
+		return nil, fmt.Errorf("timeout waiting for %s", p.address)
+	}
+}
+
+// connection returns the open connection, dialing one if there is none.
+// Resolving and dialing happen outside p.mu, so a slow or unreachable
+// server does not hold up stats and pick; when two queries dial at once,
+// the connection installed first is kept and the other closed.
+func (p *pipeline) connection(ctx context.Context) (*dns.Conn, error) {
+	p.mu.Lock()
+	conn := p.conn
+	p.mu.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	if p.conn != nil {
+		existing := p.conn
+		p.mu.Unlock()
+		conn.Close()
+		return existing, nil
+	}
+	p.conn = conn
+	p.mu.Unlock()
+
+	go p.read(conn)
+	return conn, nil
+}
+
+func (p *pipeline) dial(ctx context.Context) (*dns.Conn, error) {
+	address, err := p.resolve(ctx, p.address)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: p.timeout}
+	var raw net.Conn
+	if p.network == "tcp-tls" {
+		raw, err = (&tls.Dialer{NetDialer: dialer, Config: p.tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		raw, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dial %s failed: %w", p.address, err)
+	}
+	return &dns.Conn{Conn: raw}, nil
+}
+
+func (p *pipeline) register() (uint16, chan *dns.Msg) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := dns.Id()
+	for _, busy := p.pending[id]; busy; _, busy = p.pending[id] {
+		id = dns.Id()
+	}
+	answer := make(chan *dns.Msg, 1)
+	p.pending[id] = answer
+	return id, answer
+}
+
+func (p *pipeline) unregister(id uint16) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, id)
+}
+
+func (p *pipeline) read(conn *dns.Conn) {
+	for {
+		response, err := conn.ReadMsg()
+		if err != nil {
+			p.fail(conn)
+			return
+		}
+
+		p.mu.Lock()
+		answer, exists := p.pending[response.Id]
+		delete(p.pending, response.Id)
+		p.mu.Unlock()
+
+		if exists {
+			answer <- response
+		}
+	}
+}
+
+// fail drops conn and wakes every query still waiting on it.
+func (p *pipeline) fail(conn *dns.Conn) {
+	p.mu.Lock()
+	if p.conn == conn {
+		p.conn = nil
+		for id, answer := range p.pending {
+			close(answer)
+			delete(p.pending, id)
+		}
+	}
+	p.mu.Unlock()
+
+	conn.Close()
+}
+
+func (p *pipeline) stats() (open bool, pending int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conn != nil, len(p.pending)
+}
+
+// pipelinePool holds the pipelined connections to one server.
+type pipelinePool struct {
+	conns []*pipeline
+}
+
+// pick returns the open connection with the fewest queries outstanding,
+// or one still to be dialed when every open connection is busy.
+func (pp *pipelinePool) pick() *pipeline {
+	var best, idle *pipeline
+	bestPending := 0
+	for _, p := range pp.conns {
+		open, pending := p.stats()
+		if !open {
+			if idle == nil {
+				idle = p
+			}
+			continue
+		}
+		if best == nil || pending < bestPending {
+			best, bestPending = p, pending
+		}
+	}
+	if best == nil || bestPending > 0 && idle != nil {
+		return idle
+	}
+	return best
+}
+
+func (pp *pipelinePool) stats() (open, pending int) {
+	for _, p := range pp.conns {
+		connected, waiting := p.stats()
+		if connected {
+			open++
+		}
+		pending += waiting
+	}
+	return open, pending
+}
+
+func (pp *pipelinePool) close() {
+	for _, p := range pp.conns {
+		p.close()
+	}
+}
+
+func (p *pipeline) close() {
+	p.mu.Lock()
+	conn := p.conn
+	p.mu.Unlock()
+
+	if conn != nil {
+		p.fail(conn)
+	}
+}
@@ -0,0 +1,83 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TestPipelineDialOutsideLock checks that a connection being dialed does not
+// hold up the load checks of pick.
+func TestPipelineDialOutsideLock(t *testing.T) {
+	resolving := make(chan struct{})
+	release := make(chan struct{})
+	resolve := func(ctx context.Context, address string) (string, error) {
+		close(resolving)
+		<-release
+		return "", errors.New("unreachable")
+	}
+	p := newPipeline("tcp", "192.0.2.1:53", resolve, nil, time.Second)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.connection(context.Background())
+		done <- err
+	}()
+	<-resolving
+
+	checked := make(chan struct{})
+	go func() {
+		p.stats()
+		close(checked)
+	}()
+	select {
+	case <-checked:
+	case <-time.After(time.Second):
+		t.Error("stats blocked by a connection being dialed")
+	}
+
+	close(release)
+	if err := <-done; err == nil {
+		t.Error("connection succeeded without an address")
+	}
+}
+
+// TestPipelineWriteFailureUnregisters fails a write on a connection that
+// was replaced meanwhile, so fail leaves the pending queries alone, and
+// checks the query is not left counted as outstanding.
+func TestPipelineWriteFailureUnregisters(t *testing.T) {
+	p := newPipeline("tcp", "192.0.2.1:53", nil, nil, time.Second)
+	replacement, peer := net.Pipe()
+	defer peer.Close()
+	p.conn = &dns.Conn{Conn: &replacedConn{Conn: replacement, p: p, replacement: &dns.Conn{Conn: replacement}}}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	if _, err := p.exchange(context.Background(), msg); err == nil {
+		t.Fatal("exchange succeeded on a failed write")
+	}
+	if _, pending := p.stats(); pending != 0 {
+		t.Errorf("%d queries left pending after a failed write", pending)
+	}
+}
+
+// replacedConn installs another connection in its pipeline and fails every
+// write, as if the connection broke while another query redialed.
+type replacedConn struct {
+	net.Conn
+	p           *pipeline
+	replacement *dns.Conn
+}
+
+func (c *replacedConn) Write(b []byte) (int, error) {
+	c.p.mu.Lock()
+	c.p.conn = c.replacement
+	c.p.mu.Unlock()
+	return 0, errors.New("connection reset")
+}
+
+func (c *replacedConn) Close() error { return nil }
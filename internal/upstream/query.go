@@ -0,0 +1,54 @@
+package upstream
+
+import (
+	"github.com/miekg/dns"
+)
+
+// Query messages are reused through a sync.Pool. A message belongs to the
+// goroutine that took it until that goroutine puts it back, and nothing may
+// keep a reference past then: exchanges that can outlive Resolve, like the
+// slower servers of a race, take a message of their own, and responses are
+// always unpacked into fresh messages.
+
+// getQuery takes an empty query message from the pool.
+func (r *UpstreamResolver) getQuery() *dns.Msg {
+	msg := r.pool.Get().(*dns.Msg)
+	r.msgsInUse.Add(1)
+	return msg
+}
+
+// putQuery returns msg to the pool, cleared so nothing of this query can
+// leak into the next one.
+func (r *UpstreamResolver) putQuery(msg *dns.Msg) {
+	resetQuery(msg)
+	r.pool.Put(msg)
+	r.msgsInUse.Add(-1)
+}
+
+// cloneQuery takes a message from the pool holding the same query as msg.
+func (r *UpstreamResolver) cloneQuery(msg *dns.Msg) *dns.Msg {
+	clone := r.getQuery()
+	clone.MsgHdr = msg.MsgHdr
+	clone.Compress = msg.Compress
+	clone.Question = append(clone.Question, msg.Question...)
+	// packing writes to the OPT record, so every clone needs its own
+	for _, rr := range msg.Extra {
+		clone.Extra = append(clone.Extra, dns.Copy(rr))
+	}
+	return clone
+}
+
+// resetQuery zeroes msg but keeps the arrays behind its sections, so the
+// next query reuses them instead of allocating. Their old entries are
+// cleared so pooled messages do not keep RRs alive.
+func resetQuery(msg *dns.Msg) {
+	clear(msg.Answer)
+	clear(msg.Ns)
+	clear(msg.Extra)
+	*msg = dns.Msg{
+		Question: msg.Question[:0],
+		Answer:   msg.Answer[:0],
+		Ns:       msg.Ns[:0],
+		Extra:    msg.Extra[:0],
+	}
+}
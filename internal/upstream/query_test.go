@@ -0,0 +1,136 @@
+package upstream
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+func TestResetQuery(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	msg.Id = 1234
+	msg.CheckingDisabled = true
+	msg.SetEdns0(1232, true)
+	msg.Answer = append(msg.Answer, &dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA}})
+	extra := msg.Extra
+
+	resetQuery(msg)
+	if msg.Id != 0 || msg.CheckingDisabled || msg.Compress {
+		t.Errorf("header kept: %+v", msg.MsgHdr)
+	}
+	if len(msg.Question) != 0 || len(msg.Answer) != 0 || len(msg.Ns) != 0 || len(msg.Extra) != 0 {
+		t.Errorf("sections kept: %v", msg)
+	}
+	if cap(msg.Question) == 0 || cap(msg.Extra) == 0 {
+		t.Error("section arrays not kept for reuse")
+	}
+	if extra[0] != nil {
+		t.Error("pooled message still references the old OPT record")
+	}
+}
+
+func TestCloneQueryOwnsOPT(t *testing.T) {
+	r := NewUpstreamResolver(nil, time.Second, 0, discardLogger())
+	msg := r.getQuery()
+	msg.SetQuestion("example.com.", dns.TypeA)
+	msg.SetEdns0(1232, false)
+
+	clone := r.cloneQuery(msg)
+	clone.IsEdns0().SetUDPSize(512)
+	if msg.IsEdns0().UDPSize() != 1232 {
+		t.Error("clone shares its OPT record with the original")
+	}
+	r.putQuery(clone)
+	r.putQuery(msg)
+	if inUse := r.msgsInUse.Load(); inUse != 0 {
+		t.Errorf("%d messages still in use", inUse)
+	}
+}
+
+// BenchmarkQuery compares building queries in pooled messages with
+// allocating one per query, as Resolve did before.
+func BenchmarkQuery(b *testing.B) {
+	build := func(msg *dns.Msg) {
+		msg.Id = dns.Id()
+		msg.RecursionDesired = true
+		msg.Question = append(msg.Question, dns.Question{Name: "www.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+		msg.SetEdns0(1232, false)
+	}
+
+	b.Run("pooled", func(b *testing.B) {
+		r := NewUpstreamResolver(nil, time.Second, 0, discardLogger())
+		b.ReportAllocs()
+		for b.Loop() {
+			msg := r.getQuery()
+			build(msg)
+			r.putQuery(msg)
+		}
+	})
+	b.Run("allocated", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			build(new(dns.Msg))
+		}
+	})
+}
+
+// BenchmarkResolve resolves through a server on the loopback interface,
+// asking it alone and racing it against itself.
+func BenchmarkResolve(b *testing.B) {
+	addr := serveLoopback(b)
+	question := dns.Question{Name: "www.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	for _, strategy := range []string{StrategySerial, StrategyRace} {
+		b.Run(strategy, func(b *testing.B) {
+			r := NewUpstreamResolver([]string{addr, addr}, time.Second, 0, discardLogger())
+			r.SetStrategy(strategy, 0)
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := r.Resolve(context.Background(), question); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// serveLoopback starts a UDP server answering every question with one
+// address and returns its address.
+func serveLoopback(tb testing.TB) string {
+	tb.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        conn,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			msg := new(dns.Msg)
+			msg.SetReply(r)
+			msg.Answer = []dns.RR{&dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+				A:   net.IPv4(192, 0, 2, 1),
+			}}
+			w.WriteMsg(msg)
+		}),
+	}
+	go server.ActivateAndServe()
+	<-started
+	tb.Cleanup(func() { server.Shutdown() })
+	return conn.LocalAddr().String()
+}
+
+func discardLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
@@ -0,0 +1,135 @@
+package upstream
+
+import (
+	"github.com/miekg/dns"
+)
+
+// scrub removes the records of response that do not belong in an answer to
+// question before it is cached, so a server cannot slip in records for names
+// it was not asked about (RFC 5452 section 6). The answer keeps the chain of
+// CNAMEs and DNAMEs from the question name and the records of the asked type
+// at its names. The authority keeps SOA, NS and DS records of zones above
+// those names, with the DNSSEC records of those zones. The additional
+// section keeps the addresses of the NS, MX and SRV targets kept. It returns
+// how many records were removed.
+func scrub(question dns.Question, response *dns.Msg) int {
+	removed := 0
+	chain := map[string]bool{dns.CanonicalName(question.Name): true}
+
+	// CNAMEs need not come in order, so the chain is followed until it
+	// stops growing
+	for grown := true; grown; {
+		grown = false
+		for _, rr := range response.Answer {
+			cname, ok := rr.(*dns.CNAME)
+			if !ok || !chain[dns.CanonicalName(cname.Hdr.Name)] {
+				continue
+			}
+			if target := dns.CanonicalName(cname.Target); !chain[target] {
+				chain[target] = true
+				grown = true
+			}
+		}
+	}
+
+	answer := response.Answer[:0]
+	for _, rr := range response.Answer {
+		hdr := rr.Header()
+		owner := dns.CanonicalName(hdr.Name)
+		var keep bool
+		switch hdr.Rrtype {
+		case dns.TypeDNAME:
+			keep = above(owner, chain, false)
+		case dns.TypeCNAME, dns.TypeRRSIG:
+			keep = chain[owner] || hdr.Rrtype == dns.TypeRRSIG && above(owner, chain, false)
+		default:
+			keep = chain[owner] && (hdr.Rrtype == question.Qtype || question.Qtype == dns.TypeANY)
+		}
+		if keep {
+			answer = append(answer, rr)
+		} else {
+			removed++
+		}
+	}
+	response.Answer = answer
+
+	var zones []string
+	for _, rr := range response.Ns {
+		switch hdr := rr.Header(); hdr.Rrtype {
+		case dns.TypeSOA, dns.TypeNS:
+			if owner := dns.CanonicalName(hdr.Name); above(owner, chain, true) {
+				zones = append(zones, owner)
+			}
+		}
+	}
+	authority := response.Ns[:0]
+	for _, rr := range response.Ns {
+		hdr := rr.Header()
+		owner := dns.CanonicalName(hdr.Name)
+		var keep bool
+		switch hdr.Rrtype {
+		case dns.TypeSOA, dns.TypeNS, dns.TypeDS:
+			keep = above(owner, chain, true)
+		case dns.TypeNSEC, dns.TypeNSEC3, dns.TypeRRSIG:
+			keep = inZones(owner, zones)
+		}
+		if keep {
+			authority = append(authority, rr)
+		} else {
+			removed++
+		}
+	}
+	response.Ns = authority
+
+	targets := make(map[string]bool)
+	for _, section := range [][]dns.RR{response.Answer, response.Ns} {
+		for _, rr := range section {
+			switch rr := rr.(type) {
+			case *dns.NS:
+				targets[dns.CanonicalName(rr.Ns)] = true
+			case *dns.MX:
+				targets[dns.CanonicalName(rr.Mx)] = true
+			case *dns.SRV:
+				targets[dns.CanonicalName(rr.Target)] = true
+			}
+		}
+	}
+	extra := response.Extra[:0]
+	for _, rr := range response.Extra {
+		hdr := rr.Header()
+		switch hdr.Rrtype {
+		case dns.TypeOPT:
+			extra = append(extra, rr)
+			continue
+		case dns.TypeA, dns.TypeAAAA, dns.TypeRRSIG:
+			if targets[dns.CanonicalName(hdr.Name)] {
+				extra = append(extra, rr)
+				continue
+			}
+		}
+		removed++
+	}
+	response.Extra = extra
+
+	return removed
+}
+
+// above reports whether zone is an ancestor of a name in names, or one of
+// them with orEqual set.
+func above(zone string, names map[string]bool, orEqual bool) bool {
+	for name := range names {
+		if dns.IsSubDomain(zone, name) && (orEqual || zone != name) {
+			return true
+		}
+	}
+	return false
+}
+
+func inZones(name string, zones []string) bool {
+	for _, zone := range zones {
+		if dns.IsSubDomain(zone, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package upstream
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+
+	"dns-server/internal/client"
+	"dns-server/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// serverConfig is how queries reach one upstream: over the shared clients,
+// or over its own when its [[upstream.server]] block sets a timeout, TLS
+// settings or a bootstrap address.
+type serverConfig struct {
+	spec    client.Server
+	weight  int
+	udp     *dns.Client
+	streams *client.Client
+}
+
+func (r *UpstreamResolver) newServerConfig(server *config.UpstreamServerConfig) (*serverConfig, error) {
+	spec, err := client.ParseServer(server.Spec())
+	if err != nil {
+		return nil, err
+	}
+	sc := &serverConfig{
+		spec:    spec,
+		weight:  max(server.Weight, 1),
+		udp:     r.client,
+		streams: r.streams,
+	}
+
+	if server.Timeout == 0 && server.Bootstrap == "" && server.TLSServerName == "" && !server.TLSInsecure && server.TLSCAFile == "" {
+		return sc, nil
+	}
+
+	timeout := r.timeout
+	if server.Timeout > 0 {
+		timeout = server.Timeout
+	}
+	sc.streams = client.New(timeout)
+	sc.streams.Bootstrap = server.Bootstrap
+	sc.streams.Lookup = r.lookupBootstrap
+	if spec.Proto == client.ProtoTLS || spec.Proto == client.ProtoHTTPS {
+		sc.streams.TLSConfig, err = serverTLSConfig(spec, server)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sc.udp = &dns.Client{
+		Net:     "udp4",
+		Timeout: timeout,
+	}
+	if ip := net.ParseIP(server.Bootstrap); ip != nil && ip.To4() == nil {
+		sc.udp.Net = "udp6"
+	}
+	return sc, nil
+}
+
+// serverTLSConfig verifies the server's certificate against the name in its
+// address, or TLSServerName, and the system roots or TLSCAFile.
+func serverTLSConfig(spec client.Server, server *config.UpstreamServerConfig) (*tls.Config, error) {
+	host, _, _ := net.SplitHostPort(spec.Address)
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: server.TLSInsecure,
+	}
+	if server.TLSServerName != "" {
+		tlsConfig.ServerName = server.TLSServerName
+	}
+	if server.TLSCAFile != "" {
+		pem, err := os.ReadFile(server.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upstream CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", server.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// serverFor returns how to reach server, which is normally one of the
+// configured servers; any other address goes over the shared clients.
+func (r *UpstreamResolver) serverFor(server string) (*serverConfig, error) {
+	r.mu.RLock()
+	sc, exists := r.configs[server]
+	r.mu.RUnlock()
+	if exists {
+		return sc, nil
+	}
+	return r.newServerConfig(&config.UpstreamServerConfig{Address: server})
+}
+
+// weightedOrder draws the order servers are tried in, each next server
+// picked with a chance in proportion to its weight. While every weight is
+// the same the configured order is kept.
+func (r *UpstreamResolver) weightedOrder(servers []string) []string {
+	r.mu.RLock()
+	weights := make([]int, len(servers))
+	total, uniform := 0, true
+	for i, server := range servers {
+		weights[i] = 1
+		if sc, exists := r.configs[server]; exists {
+			weights[i] = sc.weight
+		}
+		total += weights[i]
+		uniform = uniform && weights[i] == weights[0]
+	}
+	r.mu.RUnlock()
+	if uniform {
+		return servers
+	}
+
+	ordered := make([]string, 0, len(servers))
+	remaining := append([]string(nil), servers...)
+	for len(remaining) > 0 {
+		pick := rand.IntN(total)
+		i := 0
+		for pick >= weights[i] {
+			pick -= weights[i]
+			i++
+		}
+		ordered = append(ordered, remaining[i])
+		total -= weights[i]
+		remaining = append(remaining[:i], remaining[i+1:]...)
+		weights = append(weights[:i], weights[i+1:]...)
+	}
+	return ordered
+}
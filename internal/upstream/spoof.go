@@ -0,0 +1,60 @@
+package upstream
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// randomizeCase flips the case of each letter in name at random (the "0x20"
+// trick). Servers echo the question verbatim, so a spoofed answer has to
+// guess the pattern as well as the message ID and source port. The port is
+// already random: every exchange dials a fresh socket.
+func randomizeCase(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' {
+			if rand.IntN(2) == 0 {
+				b[i] = c ^ 0x20
+			}
+		}
+	}
+	return string(b)
+}
+
+// verifyResponse rejects a response whose question does not match the query,
+// which would otherwise let an off-path attacker plant records for a name
+// that was never asked. With exact set the case of the name must match too.
+func verifyResponse(query, response *dns.Msg, exact bool) error {
+	if len(response.Question) != 1 {
+		return fmt.Errorf("response has %d questions", len(response.Question))
+	}
+
+	asked, got := query.Question[0], response.Question[0]
+	if got.Qtype != asked.Qtype || got.Qclass != asked.Qclass {
+		return fmt.Errorf("response question %s %s does not match query", got.Name, dns.TypeToString[got.Qtype])
+	}
+	if exact && got.Name != asked.Name || !strings.EqualFold(got.Name, asked.Name) {
+		return fmt.Errorf("response question %s does not match query %s", got.Name, asked.Name)
+	}
+	return nil
+}
+
+// restoreCase puts the original spelling of name back into the question and
+// into records owned by it.
+func restoreCase(response *dns.Msg, name string) {
+	for i := range response.Question {
+		if strings.EqualFold(response.Question[i].Name, name) {
+			response.Question[i].Name = name
+		}
+	}
+	for _, section := range [][]dns.RR{response.Answer, response.Ns, response.Extra} {
+		for _, rr := range section {
+			if hdr := rr.Header(); strings.EqualFold(hdr.Name, name) {
+				hdr.Name = name
+			}
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"dns-server/internal/config"
+	"dns-server/internal/metrics"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+type stubZone struct {
+	name     string
+	servers  []string
+	queries  atomic.Uint64
+	failures atomic.Uint64
+}
+
+// StubResolver sends questions for stub zones straight to the servers
+// configured for the zone, with recursion off, and follows referrals below
+// it. Other questions go to next. Like a BIND static-stub zone, a failure
+// is returned as is and never retried with next.
+type StubResolver struct {
+	zones  []*stubZone
+	next   DNSResolver
+	client *dns.Client
+	logger *logrus.Logger
+}
+
+func NewStubResolver(zones map[string][]string, next DNSResolver, timeout time.Duration, logger *logrus.Logger) *StubResolver {
+	r := &StubResolver{
+		next: next,
+		client: &dns.Client{
+			Net:     "udp",
+			Timeout: timeout,
+		},
+		logger: logger,
+	}
+	for zone, servers := range zones {
+		r.zones = append(r.zones, &stubZone{name: config.CanonicalName(zone), servers: servers})
+	}
+	// most specific zone first
+	sort.Slice(r.zones, func(i, j int) bool {
+		return dns.CountLabel(r.zones[i].name) > dns.CountLabel(r.zones[j].name)
+	})
+	return r
+}
+
+func (r *StubResolver) zoneFor(name string) *stubZone {
+	name = strings.ToLower(dns.Fqdn(name))
+	for _, zone := range r.zones {
+		if dns.IsSubDomain(zone.name, name) {
+			return zone
+		}
+	}
+	return nil
+}
+
+func (r *StubResolver) Resolve(ctx context.Context, question dns.Question) (*dns.Msg, error) {
+	return r.resolve(ctx, question, 0)
+}
+
+func (r *StubResolver) resolve(ctx context.Context, question dns.Question, depth int) (*dns.Msg, error) {
+	zone := r.zoneFor(question.Name)
+	if zone == nil {
+		return r.next.Resolve(ctx, question)
+	}
+	if depth > maxDepth {
+		return nil, fmt.Errorf("resolution of %s nested too deeply", question.Name)
+	}
+
+	response, err := r.walk(ctx, zone, question)
+	if err != nil {
+		zone.failures.Add(1)
+		return nil, fmt.Errorf("stub zone %s: %w", zone.name, err)
+	}
+
+	response.Question = []dns.Question{question}
+	response.Authoritative = false
+	response.RecursionAvailable = true
+	return r.chase(ctx, question, response, depth)
+}
+
+// walk queries the zone's servers and follows referrals to zones below it,
+// using the glue the referring server sent.
+func (r *StubResolver) walk(ctx context.Context, zone *stubZone, question dns.Question) (*dns.Msg, error) {
+	name := strings.ToLower(dns.Fqdn(question.Name))
+	cut, servers := zone.name, zone.servers
+
+	for range maxReferrals {
+		zone.queries.Add(1)
+		response, err := r.query(ctx, name, question.Qtype, servers)
+		if err != nil {
+			return nil, err
+		}
+
+		child, nameservers, _ := referral(response, cut)
+		if child == "" {
+			return response, nil
+		}
+
+		var addrs []string
+		for _, rr := range response.Extra {
+			if a, ok := rr.(*dns.A); ok && containsName(nameservers, a.Hdr.Name) {
+				addrs = append(addrs, net.JoinHostPort(a.A.String(), "53"))
+			}
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("referral to %s without glue", child)
+		}
+
+		r.logger.WithFields(logrus.Fields{
+			"question": name,
+			"zone":     child,
+			"servers":  len(addrs),
+		}).Debug("following referral in stub zone")
+		cut, servers = child, addrs
+	}
+
+	return nil, fmt.Errorf("too many referrals resolving %s", name)
+}
+
+func (r *StubResolver) query(ctx context.Context, name string, qtype uint16, servers []string) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.RecursionDesired = false
+	msg.SetEdns0(1232, false)
+
+	var lastErr error
+	for _, server := range servers {
+		response, _, err := r.client.ExchangeContext(ctx, msg, server)
+		if err == nil && response.Truncated {
+			tcp := &dns.Client{Net: "tcp", Timeout: r.client.Timeout}
+			response, _, err = tcp.ExchangeContext(ctx, msg, server)
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("exchange failed with %s: %w", server, err)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		if err := verifyResponse(msg, response, false); err != nil {
+			lastErr = fmt.Errorf("%s: %w", server, err)
+			continue
+		}
+		if response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError {
+			lastErr = &RcodeError{Server: server, Rcode: response.Rcode}
+			continue
+		}
+
+		stripOPT(response)
+		recordOrigin(ctx, server)
+		return response, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no servers to query")
+	}
+	return nil, lastErr
+}
+
+// chase follows a CNAME the stub zone answered with when the target records
+// were not included, through whichever resolver serves the target.
+func (r *StubResolver) chase(ctx context.Context, question dns.Question, response *dns.Msg, depth int) (*dns.Msg, error) {
+	if question.Qtype == dns.TypeCNAME || response.Rcode != dns.RcodeSuccess {
+		return response, nil
+	}
+
+	target := ""
+	for _, rr := range response.Answer {
+		switch rr := rr.(type) {
+		case *dns.CNAME:
+			if strings.EqualFold(rr.Hdr.Name, question.Name) {
+				target = rr.Target
+			}
+		default:
+			if rr.Header().Rrtype == question.Qtype {
+				return response, nil
+			}
+		}
+	}
+	if target == "" {
+		return response, nil
+	}
+
+	followed, err := r.resolve(ctx, dns.Question{Name: target, Qtype: question.Qtype, Qclass: question.Qclass}, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	followed.Question = []dns.Question{question}
+	followed.Answer = append(response.Answer, followed.Answer...)
+	return followed, nil
+}
+
+func (r *StubResolver) Collect(w *metrics.Writer) {
+	for _, zone := range r.zones {
+		w.Counter("dns_stub_queries_total", "Queries sent to the servers of a stub zone.", float64(zone.queries.Load()), metrics.L("zone", zone.name))
+	}
+	for _, zone := range r.zones {
+		w.Counter("dns_stub_failures_total", "Questions in a stub zone that could not be resolved.", float64(zone.failures.Load()), metrics.L("zone", zone.name))
+	}
+}
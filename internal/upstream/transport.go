@@ -0,0 +1,96 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+
+	"dns-server/internal/client"
+
+	"github.com/miekg/dns"
+)
+
+// send exchanges msg with server over the transport its address names:
+// host:port is UDP, tcp:// and tls:// are streams and https:// is DoH.
+// Stream servers share a pool of pipelined connections when pipelining is
+// on, and UDP servers found to support DoT or DoH use it under
+// opportunistic encryption.
+func (r *UpstreamResolver) send(ctx context.Context, msg *dns.Msg, server string) (*dns.Msg, error) {
+	sc, err := r.serverFor(server)
+	if err != nil {
+		return nil, err
+	}
+
+	switch sc.spec.Proto {
+	case client.ProtoUDP:
+		if response, ok := r.sendEncrypted(ctx, msg, server); ok {
+			return response, nil
+		}
+		address, err := sc.streams.DialAddress(ctx, sc.spec.Address)
+		if err != nil {
+			return nil, err
+		}
+		response, _, err := sc.udp.ExchangeContext(ctx, msg, address)
+		return response, err
+	case client.ProtoTCP, client.ProtoTLS:
+		if r.pipelining {
+			return r.pipelineFor(sc).exchange(ctx, msg)
+		}
+	}
+
+	response, _, err := sc.streams.Exchange(ctx, msg, sc.spec)
+	return response, err
+}
+
+func (r *UpstreamResolver) pipelineFor(sc *serverConfig) *pipeline {
+	// pick waits on the lock of each connection, which a query may hold
+	// briefly, so it runs after pipelinesMu is released
+	return r.pipelinePool(sc).pick()
+}
+
+// pipelinePool returns the pool of connections to the server of sc,
+// creating it on first use.
+func (r *UpstreamResolver) pipelinePool(sc *serverConfig) *pipelinePool {
+	spec := sc.spec
+	r.pipelinesMu.Lock()
+	defer r.pipelinesMu.Unlock()
+
+	key := spec.String()
+	if pool, exists := r.pipelines[key]; exists {
+		return pool
+	}
+
+	network := "tcp"
+	var tlsConfig *tls.Config
+	if spec.Proto == client.ProtoTLS {
+		network = "tcp-tls"
+		// reconnects resume the TLS session instead of a full handshake
+		tlsConfig = sc.streams.TLSConfigFor(spec).Clone()
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(r.pipeConns)
+	}
+	pool := &pipelinePool{}
+	for range r.pipeConns {
+		pool.conns = append(pool.conns, newPipeline(network, spec.Address, sc.streams.DialAddress, tlsConfig, sc.streams.Timeout))
+	}
+	r.pipelines[key] = pool
+	return pool
+}
+
+// closePipelines drops the connections of servers no longer configured.
+func (r *UpstreamResolver) closePipelines(servers []string) {
+	keep := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		if spec, err := client.ParseServer(server); err == nil {
+			keep[spec.String()] = true
+		}
+	}
+
+	r.pipelinesMu.Lock()
+	defer r.pipelinesMu.Unlock()
+
+	for key, p := range r.pipelines {
+		if !keep[key] {
+			p.close()
+			delete(r.pipelines, key)
+		}
+	}
+}
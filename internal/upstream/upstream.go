@@ -2,10 +2,17 @@ package upstream
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"dns-server/internal/client"
+	"dns-server/internal/config"
+	"dns-server/internal/trace"
+
 	"github.com/miekg/dns"
 	"github.com/sirupsen/logrus"
 )
@@ -14,76 +21,124 @@ type DNSResolver interface {
 	Resolve(ctx context.Context, question dns.Question) (*dns.Msg, error)
 }
 
+const (
+	StrategySerial = "serial"
+	StrategyRace   = "race"
+)
+
 type UpstreamResolver struct {
-	servers []string
-	timeout time.Duration
-	retries int
-	client  *dns.Client
-	logger  *logrus.Logger
-	pool    sync.Pool
+	mu          sync.RWMutex
+	servers     []string
+	configs     map[string]*serverConfig
+	health      map[string]*serverHealth
+	timeout     time.Duration
+	retries     int
+	strategy    string
+	raceCount   int
+	requestNSID bool
+	randomCase  bool
+	pipelining  bool
+	pipeConns   int
+	encryption  *opportunistic
+	bootstrap   *bootstrapResolver
+	client      *dns.Client
+	streams     *client.Client
+	logger      *logrus.Logger
+	pool        sync.Pool
+
+	inflight      inflight
+	raceWorkers   atomic.Int64
+	msgsInUse     atomic.Int64
+	msgsAllocated atomic.Uint64
+	scrubbed      atomic.Uint64
+
+	pipelinesMu sync.Mutex
+	pipelines   map[string]*pipelinePool
 }
 
 func NewUpstreamResolver(servers []string, timeout time.Duration, retries int, logger *logrus.Logger) *UpstreamResolver {
 	resolver := &UpstreamResolver{
-		servers: servers,
-		timeout: timeout,
-		retries: retries,
+		configs:  make(map[string]*serverConfig),
+		health:   make(map[string]*serverHealth),
+		timeout:  timeout,
+		retries:  retries,
+		strategy: StrategySerial,
 		client: &dns.Client{
 			Net:     "udp4",
 			Timeout: timeout,
 		},
-		logger: logger,
+		streams:   client.New(timeout),
+		pipeConns: 1,
+		pipelines: make(map[string]*pipelinePool),
+		logger:    logger,
 	}
 
 	resolver.pool = sync.Pool{
 		New: func() any {
+			resolver.msgsAllocated.Add(1)
 			return &dns.Msg{}
 		},
 	}
+	resolver.streams.Lookup = resolver.lookupBootstrap
+	resolver.SetServers(servers)
 
 	return resolver
 }
 
 func (r *UpstreamResolver) Resolve(ctx context.Context, question dns.Question) (*dns.Msg, error) {
-	msg := r.pool.Get().(*dns.Msg)
-	defer r.pool.Put(msg)
+	defer r.inflight.begin()()
+
+	msg := r.getQuery()
+	defer r.putQuery(msg)
+
+	qname := question.Name
+	if r.randomCase {
+		qname = randomizeCase(qname)
+	}
 
 	msg.Id = dns.Id()
-	msg.SetQuestion(question.Name, question.Qtype)
 	msg.RecursionDesired = true
+	msg.AuthenticatedData = true
+	msg.CheckingDisabled = checkingDisabled(ctx)
+	msg.Question = append(msg.Question, dns.Question{Name: qname, Qtype: question.Qtype, Qclass: dns.ClassINET})
+	if r.requestNSID {
+		msg.SetEdns0(1232, false)
+		opt := msg.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+	}
 
+	servers := r.weightedOrder(r.activeServers())
 	var lastErr error
 
+	attempts := 0
 	for attempt := 0; attempt <= r.retries; attempt++ {
-		for _, server := range r.servers {
-			select {
-			case <-ctx.Done():
-				return nil, ctx.Err()
-			default:
-			}
+		attempts++
+		var (
+			response *dns.Msg
+			server   string
+			err      error
+		)
 
-			response, err := r.queryServer(ctx, msg, server)
-			if err != nil {
-				lastErr = err
-				r.logger.WithFields(logrus.Fields{
-					"server":  server,
-					"attempt": attempt + 1,
-					"error":   err,
-				}).Debug("upstream query failed")
-				continue
-			}
+		if r.strategy == StrategyRace {
+			response, server, err = r.raceAttempt(ctx, msg, servers, attempt)
+		} else {
+			response, server, err = r.serialAttempt(ctx, msg, servers, attempt)
+		}
 
-			if response.Rcode == dns.RcodeSuccess || response.Rcode == dns.RcodeNameError {
-				r.logger.WithFields(logrus.Fields{
-					"server":   server,
-					"question": question.Name,
-					"qtype":    dns.TypeToString[question.Qtype],
-					"rcode":    dns.RcodeToString[response.Rcode],
-				}).Debug("upstream query successful")
-				return response, nil
-			}
+		if err == nil {
+			restoreCase(response, question.Name)
+			recordOrigin(ctx, server)
+			return response, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
 
-			lastErr = fmt.Errorf("server returned error code: %s", dns.RcodeToString[response.Rcode])
+		// every server answered the query the way it will answer it again
+		var rcodeErr *RcodeError
+		if errors.As(err, &rcodeErr) && !rcodeErr.Retryable() {
+			break
 		}
 
 		if attempt < r.retries {
@@ -100,11 +155,178 @@ func (r *UpstreamResolver) Resolve(ctx context.Context, question dns.Question) (
 		lastErr = fmt.Errorf("all upstream servers failed")
 	}
 
-	return nil, fmt.Errorf("failed to resolve %s after %d attempts: %w", question.Name, r.retries+1, lastErr)
+	return nil, fmt.Errorf("failed to resolve %s after %d attempts: %w", question.Name, attempts, lastErr)
+}
+
+// RcodeError is an answer from an upstream server with an RCODE other than
+// NOERROR and NXDOMAIN. Resolve returns one when every server of an attempt
+// answered that way and none of them failed in a way that asking again
+// could change.
+type RcodeError struct {
+	Server string
+	Rcode  int
+}
+
+func (e *RcodeError) Error() string {
+	return fmt.Sprintf("%s returned %s", e.Server, dns.RcodeToString[e.Rcode])
+}
+
+// Retryable reports whether asking again might get an answer: a SERVFAIL
+// can pass, while REFUSED, NOTIMP, FORMERR and the rest are how the server
+// treats the query.
+func (e *RcodeError) Retryable() bool {
+	return e.Rcode == dns.RcodeServerFailure
+}
+
+// failures collects why the servers of one attempt gave no answer.
+type failures struct {
+	last      error
+	rcode     *RcodeError
+	transient bool
+}
+
+func (f *failures) add(err error) {
+	f.last = err
+	var rcodeErr *RcodeError
+	if errors.As(err, &rcodeErr) && !rcodeErr.Retryable() {
+		f.rcode = rcodeErr
+		return
+	}
+	f.transient = true
+}
+
+// err is the RCODE the servers answered with when every failure was one,
+// and otherwise the last failure.
+func (f *failures) err() error {
+	switch {
+	case f.rcode != nil && !f.transient:
+		return f.rcode
+	case f.last != nil:
+		return f.last
+	}
+	return fmt.Errorf("all upstream servers failed")
+}
+
+func (r *UpstreamResolver) serialAttempt(ctx context.Context, msg *dns.Msg, servers []string, attempt int) (*dns.Msg, string, error) {
+	var failed failures
+
+	for _, server := range servers {
+		select {
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		default:
+		}
+
+		response, err := r.exchange(ctx, msg, server, attempt)
+		if err == nil {
+			return response, server, nil
+		}
+		failed.add(err)
+	}
+
+	return nil, "", failed.err()
+}
+
+// raceAttempt sends the query to the first raceCount servers (all when zero)
+// concurrently and returns the first acceptable answer, canceling the rest.
+func (r *UpstreamResolver) raceAttempt(ctx context.Context, msg *dns.Msg, servers []string, attempt int) (*dns.Msg, string, error) {
+	if r.raceCount > 0 && r.raceCount < len(servers) {
+		servers = servers[:r.raceCount]
+	}
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		response *dns.Msg
+		server   string
+		err      error
+	}
+
+	// each worker owns its query, since the losers are still sending when
+	// the winner returns and msg goes back to the pool
+	results := make(chan result, len(servers))
+	for _, server := range servers {
+		r.raceWorkers.Add(1)
+		go func(server string, query *dns.Msg) {
+			defer r.raceWorkers.Add(-1)
+			defer r.putQuery(query)
+			response, err := r.exchange(raceCtx, query, server, attempt)
+			results <- result{response: response, server: server, err: err}
+		}(server, r.cloneQuery(msg))
+	}
+
+	var failed failures
+	for range servers {
+		res := <-results
+		if res.err == nil {
+			return res.response, res.server, nil
+		}
+		failed.add(res.err)
+	}
+
+	return nil, "", failed.err()
+}
+
+func (r *UpstreamResolver) exchange(ctx context.Context, msg *dns.Msg, server string, attempt int) (*dns.Msg, error) {
+	question := msg.Question[0]
+	t := trace.From(ctx)
+	log := logrus.NewEntry(r.logger)
+	if t != nil {
+		// prefetches and probes are not answering a query and have none
+		log = log.WithField("trace_id", t.ID)
+	}
+
+	start := time.Now()
+	response, err := r.queryServer(ctx, msg, server)
+	if err != nil {
+		t.StageFailed("exchange", start, server+": "+err.Error())
+		log.WithFields(logrus.Fields{
+			"server":  server,
+			"attempt": attempt + 1,
+			"error":   err,
+		}).Debug("upstream query failed")
+		return nil, err
+	}
+	t.Stage("exchange", start, server+": "+dns.RcodeToString[response.Rcode])
+
+	if err := verifyResponse(msg, response, r.randomCase); err != nil {
+		log.WithFields(logrus.Fields{
+			"server": server,
+			"error":  err,
+		}).Warn("discarding mismatched upstream response")
+		return nil, err
+	}
+
+	fields := logrus.Fields{
+		"server":   server,
+		"question": question.Name,
+		"qtype":    dns.TypeToString[question.Qtype],
+		"rcode":    dns.RcodeToString[response.Rcode],
+	}
+	if nsid := responseNSID(response); nsid != "" {
+		fields["nsid"] = nsid
+	}
+	stripOPT(response)
+	if removed := scrub(question, response); removed > 0 {
+		r.scrubbed.Add(uint64(removed))
+		log.WithFields(fields).WithField("removed", removed).Debug("scrubbed unrelated records from upstream response")
+	}
+
+	if response.Rcode == dns.RcodeSuccess || response.Rcode == dns.RcodeNameError {
+		log.WithFields(fields).Debug("upstream query successful")
+		return response, nil
+	}
+
+	log.WithFields(fields).Debug("upstream query answered with an error")
+	return nil, &RcodeError{Server: server, Rcode: response.Rcode}
 }
 
 func (r *UpstreamResolver) queryServer(ctx context.Context, msg *dns.Msg, server string) (*dns.Msg, error) {
-	response, _, err := r.client.ExchangeContext(ctx, msg, server)
+	response, err := r.send(ctx, msg, server)
+	if ctx.Err() == nil {
+		r.recordQuery(server, err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("exchange failed with %s: %w", server, err)
 	}
@@ -113,14 +335,59 @@ func (r *UpstreamResolver) queryServer(ctx context.Context, msg *dns.Msg, server
 }
 
 func (r *UpstreamResolver) SetServers(servers []string) {
+	configs := make([]config.UpstreamServerConfig, len(servers))
+	for i, server := range servers {
+		configs[i] = config.UpstreamServerConfig{Address: server}
+	}
+	// plain addresses have no TLS files to fail on
+	r.SetServerConfigs(configs)
+}
+
+// SetServerConfigs replaces the servers with the [[upstream.server]]
+// blocks given, keeping the health of servers that stay.
+func (r *UpstreamResolver) SetServerConfigs(servers []config.UpstreamServerConfig) error {
 	if len(servers) == 0 {
-		return
+		return nil
+	}
+
+	addresses := make([]string, 0, len(servers))
+	configs := make(map[string]*serverConfig, len(servers))
+	for i := range servers {
+		address := servers[i].Spec()
+		addresses = append(addresses, address)
+		if _, err := client.ParseServer(address); err != nil {
+			// reported by every query sent to it, as before
+			continue
+		}
+		sc, err := r.newServerConfig(&servers[i])
+		if err != nil {
+			return fmt.Errorf("upstream server %s: %w", address, err)
+		}
+		configs[address] = sc
 	}
-	r.servers = make([]string, len(servers))
-	copy(r.servers, servers)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.servers = addresses
+	r.configs = configs
+
+	health := newHealthMap(addresses)
+	for server, state := range r.health {
+		if _, exists := health[server]; exists {
+			health[server] = state
+		}
+	}
+	r.health = health
+
+	r.closePipelines(addresses)
+	return nil
 }
 
 func (r *UpstreamResolver) GetServers() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	servers := make([]string, len(r.servers))
 	copy(servers, r.servers)
 	return servers
@@ -129,6 +396,7 @@ func (r *UpstreamResolver) GetServers() []string {
 func (r *UpstreamResolver) SetTimeout(timeout time.Duration) {
 	r.timeout = timeout
 	r.client.Timeout = timeout
+	r.streams.Timeout = timeout
 }
 
 func (r *UpstreamResolver) SetRetries(retries int) {
@@ -136,3 +404,70 @@ func (r *UpstreamResolver) SetRetries(retries int) {
 		r.retries = retries
 	}
 }
+
+func (r *UpstreamResolver) SetStrategy(strategy string, raceCount int) {
+	r.strategy = strategy
+	r.raceCount = raceCount
+}
+
+func (r *UpstreamResolver) SetRequestNSID(enabled bool) {
+	r.requestNSID = enabled
+}
+
+// SetPipelining keeps persistent connections to tcp:// and tls:// servers
+// and sends queries over them without waiting for earlier answers.
+func (r *UpstreamResolver) SetPipelining(enabled bool) {
+	r.pipelining = enabled
+}
+
+// SetPipelineConnections caps the pipelined connections per server. More
+// than one are only opened while every open one has queries outstanding.
+func (r *UpstreamResolver) SetPipelineConnections(n int) {
+	if n > 0 {
+		r.pipeConns = n
+	}
+}
+
+func (r *UpstreamResolver) SetRandomizeCase(enabled bool) {
+	r.randomCase = enabled
+}
+
+// responseNSID returns the NSID an upstream attached to its response, decoded
+// from hex when it is printable.
+func responseNSID(msg *dns.Msg) string {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+
+	for _, option := range opt.Option {
+		if nsid, ok := option.(*dns.EDNS0_NSID); ok {
+			if decoded, err := hex.DecodeString(nsid.Nsid); err == nil && isPrintable(decoded) {
+				return string(decoded)
+			}
+			return nsid.Nsid
+		}
+	}
+	return ""
+}
+
+func isPrintable(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// stripOPT removes the upstream's OPT record so hop-by-hop EDNS data never
+// reaches the cache or clients.
+func stripOPT(msg *dns.Msg) {
+	extra := msg.Extra[:0]
+	for _, rr := range msg.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	msg.Extra = extra
+}
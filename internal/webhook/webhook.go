@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"dns-server/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Event struct {
+	Event string `json:"event"`
+	Data  any    `json:"data"`
+}
+
+type Notifier struct {
+	hooks  []config.WebhookConfig
+	client *http.Client
+	queue  chan Event
+	logger *logrus.Logger
+}
+
+func NewNotifier(hooks []config.WebhookConfig, logger *logrus.Logger) *Notifier {
+	return &Notifier{
+		hooks:  hooks,
+		client: &http.Client{},
+		queue:  make(chan Event, 64),
+		logger: logger,
+	}
+}
+
+// Notify queues an event for delivery without blocking the caller; events are
+// dropped when the queue is full.
+func (n *Notifier) Notify(event string, data any) {
+	select {
+	case n.queue <- Event{Event: event, Data: data}:
+	default:
+		n.logger.WithField("event", event).Warn("webhook queue full, dropping event")
+	}
+}
+
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-n.queue:
+			for _, hook := range n.hooks {
+				n.deliver(ctx, hook, event)
+			}
+		}
+	}
+}
+
+func (n *Notifier) deliver(ctx context.Context, hook config.WebhookConfig, event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		n.logger.WithError(err).Error("failed to encode webhook payload")
+		return
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= hook.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+
+		if lastErr = n.post(ctx, hook, payload); lastErr == nil {
+			n.logger.WithFields(logrus.Fields{
+				"url":   hook.URL,
+				"event": event.Event,
+			}).Debug("webhook delivered")
+			return
+		}
+	}
+
+	n.logger.WithFields(logrus.Fields{
+		"url":   hook.URL,
+		"event": event.Event,
+		"error": lastErr,
+	}).Warn("webhook delivery failed")
+}
+
+func (n *Notifier) post(ctx context.Context, hook config.WebhookConfig, payload []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(payload)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
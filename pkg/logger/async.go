@@ -0,0 +1,97 @@
+package logger
+
+import (
+	"io"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// asyncWriter hands formatted entries to a goroutine that writes them to
+// out, so a slow output, such as a terminal or a disk under load, does not
+// hold up whoever logs. When the buffer is full, entries are dropped and
+// counted, or with block set the caller waits for room.
+type asyncWriter struct {
+	out     io.Writer
+	entries chan []byte
+	block   bool
+	done    chan struct{}
+	dropped atomic.Uint64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newAsyncWriter(out io.Writer, buffer int, block bool) *asyncWriter {
+	w := &asyncWriter{
+		out:     out,
+		entries: make(chan []byte, buffer),
+		block:   block,
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *asyncWriter) run() {
+	defer close(w.done)
+	for entry := range w.entries {
+		w.out.Write(entry)
+	}
+}
+
+func (w *asyncWriter) Write(p []byte) (int, error) {
+	// formatters hand over nothing for entries they suppress
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return w.out.Write(p)
+	}
+
+	// logrus reuses the buffer p is formatted into
+	entry := append([]byte(nil), p...)
+	if w.block {
+		w.entries <- entry
+		return len(p), nil
+	}
+	select {
+	case w.entries <- entry:
+	default:
+		w.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Close writes out the entries still buffered. Entries logged afterwards
+// are written directly.
+func (w *asyncWriter) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.entries)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+// samplingFormatter keeps only a fraction of the entries of some levels,
+// deciding before the entry is formatted so the rest cost next to nothing.
+type samplingFormatter struct {
+	logrus.Formatter
+	rates   map[logrus.Level]float64
+	sampled [logrus.TraceLevel + 1]atomic.Uint64
+}
+
+func (f *samplingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if rate, exists := f.rates[entry.Level]; exists && rand.Float64() >= rate {
+		f.sampled[entry.Level].Add(1)
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
+}
@@ -16,23 +16,36 @@ func NewLogger(cfg *config.LoggingConfig) *logrus.Logger {
 		level = logrus.InfoLevel
 	}
 	logger.SetLevel(level)
+	logger.SetFormatter(newFormatter(cfg))
+	logger.SetOutput(os.Stdout)
+	return logger
+}
+
+func newFormatter(cfg *config.LoggingConfig) logrus.Formatter {
+	var formatter logrus.Formatter
 
 	switch cfg.Format {
 	case "json":
-		logger.SetFormatter(&logrus.JSONFormatter{
+		formatter = &logrus.JSONFormatter{
 			TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
-		})
+		}
 	case "text":
-		logger.SetFormatter(&logrus.TextFormatter{
+		formatter = &logrus.TextFormatter{
 			FullTimestamp:   true,
 			TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
-		})
+		}
 	default:
-		logger.SetFormatter(&logrus.JSONFormatter{
+		formatter = &logrus.JSONFormatter{
 			TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
-		})
+		}
 	}
 
-	logger.SetOutput(os.Stdout)
-	return logger
+	if len(cfg.ExcludeDomains) > 0 {
+		formatter = &privacyFormatter{
+			Formatter: formatter,
+			filter:    NewPrivacyFilter(cfg.ExcludeDomains),
+		}
+	}
+
+	return formatter
 }
@@ -0,0 +1,251 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"dns-server/internal/config"
+	"dns-server/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+const RootModule = "main"
+
+// Manager hands out one logger per module so levels can be changed
+// independently at runtime. All module loggers share a formatter and an
+// output that can be reopened after log rotation, written to in the
+// background when logging is async.
+type Manager struct {
+	mu        sync.RWMutex
+	output    *reopenWriter
+	async     *asyncWriter
+	sampler   *samplingFormatter
+	formatter logrus.Formatter
+	level     logrus.Level
+	modules   map[string]*logrus.Logger
+	overrides map[string]logrus.Level
+}
+
+func NewManager(cfg *config.LoggingConfig) (*Manager, error) {
+	level, err := logrus.ParseLevel(cfg.Level)
+	if err != nil {
+		level = logrus.InfoLevel
+	}
+
+	output, err := newReopenWriter(cfg.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		output:    output,
+		formatter: newFormatter(cfg),
+		level:     level,
+		modules:   make(map[string]*logrus.Logger),
+		overrides: make(map[string]logrus.Level),
+	}
+
+	for module, levelName := range cfg.Modules {
+		moduleLevel, err := logrus.ParseLevel(levelName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level for module %s: %w", module, err)
+		}
+		m.overrides[module] = moduleLevel
+	}
+
+	if len(cfg.Sample) > 0 {
+		m.sampler = &samplingFormatter{Formatter: m.formatter, rates: make(map[logrus.Level]float64)}
+		for levelName, rate := range cfg.Sample {
+			sampleLevel, err := logrus.ParseLevel(levelName)
+			if err != nil {
+				return nil, fmt.Errorf("invalid log level to sample: %w", err)
+			}
+			m.sampler.rates[sampleLevel] = rate
+		}
+		m.formatter = m.sampler
+	}
+	if cfg.Async {
+		m.async = newAsyncWriter(output, max(cfg.Buffer, 1), cfg.Overflow == config.LogOverflowBlock)
+	}
+
+	return m, nil
+}
+
+func (m *Manager) Root() *logrus.Logger {
+	return m.Module(RootModule)
+}
+
+func (m *Manager) Module(name string) *logrus.Logger {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if logger, exists := m.modules[name]; exists {
+		return logger
+	}
+
+	logger := logrus.New()
+	logger.SetFormatter(m.formatter)
+	if m.async != nil {
+		logger.SetOutput(m.async)
+		// Fatal exits right after logging, and must not lose the entry
+		logger.ExitFunc = func(code int) {
+			m.async.Close()
+			os.Exit(code)
+		}
+	} else {
+		logger.SetOutput(m.output)
+	}
+	logger.SetLevel(m.levelFor(name))
+	if name != RootModule {
+		logger.AddHook(moduleHook(name))
+	}
+
+	m.modules[name] = logger
+	return logger
+}
+
+func (m *Manager) levelFor(module string) logrus.Level {
+	if level, exists := m.overrides[module]; exists {
+		return level
+	}
+	return m.level
+}
+
+// SetLevel changes the level of one module, or of every module without an
+// explicit override when module is empty.
+func (m *Manager) SetLevel(module, levelName string) error {
+	level, err := logrus.ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if module == "" {
+		m.level = level
+	} else {
+		m.overrides[module] = level
+	}
+
+	for name, logger := range m.modules {
+		logger.SetLevel(m.levelFor(name))
+	}
+	return nil
+}
+
+func (m *Manager) Levels() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	levels := map[string]string{"default": m.level.String()}
+	names := make([]string, 0, len(m.modules))
+	for name := range m.modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		levels[name] = m.modules[name].GetLevel().String()
+	}
+	return levels
+}
+
+// Reopen closes and reopens a file output, for logrotate's copy-less rotation.
+func (m *Manager) Reopen() error {
+	return m.output.Reopen()
+}
+
+// Close writes out the entries an async log still buffers.
+func (m *Manager) Close() {
+	if m.async != nil {
+		m.async.Close()
+	}
+}
+
+// Collect reports the entries that sampling and a full async buffer kept
+// from the output.
+func (m *Manager) Collect(w *metrics.Writer) {
+	if m.sampler != nil {
+		for _, level := range logrus.AllLevels {
+			if _, exists := m.sampler.rates[level]; !exists {
+				continue
+			}
+			w.Counter("dns_log_entries_sampled_total", "Log entries left out by sampling.", float64(m.sampler.sampled[level].Load()), metrics.L("level", level.String()))
+		}
+	}
+	if m.async != nil {
+		w.Counter("dns_log_entries_dropped_total", "Log entries dropped because the async buffer was full.", float64(m.async.dropped.Load()))
+		w.Gauge("dns_log_buffer_entries", "Log entries waiting to be written.", float64(len(m.async.entries)))
+	}
+}
+
+type moduleHook string
+
+func (h moduleHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h moduleHook) Fire(entry *logrus.Entry) error {
+	if _, exists := entry.Data["module"]; !exists {
+		entry.Data["module"] = string(h)
+	}
+	return nil
+}
+
+type reopenWriter struct {
+	mu   sync.Mutex
+	path string
+	w    io.Writer
+	file *os.File
+}
+
+func newReopenWriter(path string) (*reopenWriter, error) {
+	w := &reopenWriter{path: path}
+	switch path {
+	case "", "stdout":
+		w.w = os.Stdout
+	case "stderr":
+		w.w = os.Stderr
+	default:
+		if err := w.open(); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *reopenWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s: %w", w.path, err)
+	}
+	w.file = file
+	w.w = file
+	return nil
+}
+
+func (w *reopenWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func (w *reopenWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	old := w.file
+	if err := w.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
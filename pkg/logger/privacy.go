@@ -0,0 +1,61 @@
+package logger
+
+import (
+	"strings"
+
+	"dns-server/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// nameFields are the entry fields that may carry a queried domain name,
+// including the name and suffix the admin API logs for cache purges and
+// traces.
+var nameFields = []string{"question", "domain", "qname", "wildcard", "name", "suffix"}
+
+type PrivacyFilter struct {
+	suffixes []string
+}
+
+func NewPrivacyFilter(domains []string) *PrivacyFilter {
+	suffixes := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(config.CanonicalName(strings.Trim(domain, ".")), ".")
+		if domain != "" {
+			suffixes = append(suffixes, domain)
+		}
+	}
+	return &PrivacyFilter{suffixes: suffixes}
+}
+
+// Suppressed reports whether name equals or falls under one of the excluded
+// domains and must therefore never be logged.
+func (f *PrivacyFilter) Suppressed(name string) bool {
+	if f == nil || len(f.suffixes) == 0 {
+		return false
+	}
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, suffix := range f.suffixes {
+		if name == suffix || strings.HasSuffix(name, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// privacyFormatter drops entries that mention an excluded domain before they
+// reach the output, whichever component produced them.
+type privacyFormatter struct {
+	logrus.Formatter
+	filter *PrivacyFilter
+}
+
+func (f *privacyFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	for _, field := range nameFields {
+		if name, ok := entry.Data[field].(string); ok && f.filter.Suppressed(name) {
+			return nil, nil
+		}
+	}
+	return f.Formatter.Format(entry)
+}